@@ -5,7 +5,7 @@ import { ServePanelProvider } from './serve-panel-provider';
 import { ADMIN_CONSOLE, KB_DOCS_URL as KB_DOCS_URL } from './utils/url';
 import { Tailscale } from './tailscale';
 import { Logger } from './logger';
-import { errorForType } from './tailscale/error';
+import { errorFor, isHealthError } from './tailscale/error';
 import {
   FileExplorer,
   NodeExplorerProvider,
@@ -60,7 +60,10 @@ export async function activate(context: vscode.ExtensionContext) {
     tailscaleInstance
   );
 
-  let fileSystemProvider: FileSystemProvider = new FileSystemProviderSFTP(configManager);
+  let fileSystemProvider: FileSystemProvider = new FileSystemProviderSFTP(
+    configManager,
+    tailscaleInstance
+  );
   fileSystemProvider = new WithFSTiming(fileSystemProvider);
 
   context.subscriptions.push(
@@ -118,6 +121,54 @@ export async function activate(context: vscode.ExtensionContext) {
     })
   );
 
+  context.subscriptions.push(
+    vscode.commands.registerCommand('tailscale.health', async () => {
+      try {
+        const health = await tailscaleInstance.getHealth();
+        const items = (health.Errors ?? []).map((err) => ({
+          label: err.Message || errorFor(err).title,
+          detail: err.Hint,
+          url: err.URL,
+        }));
+        if (!items.length) {
+          vscode.window.showInformationMessage('Tailscale reports no problems with this machine.');
+          return;
+        }
+        const pick = await vscode.window.showQuickPick(items, {
+          placeHolder: 'Select a problem to learn how to fix it',
+        });
+        if (pick?.url) {
+          vscode.env.openExternal(vscode.Uri.parse(pick.url));
+        }
+      } catch (e) {
+        vscode.window.showErrorMessage(`Unable to get the health of Tailscale: ${e}`);
+      }
+    })
+  );
+
+  context.subscriptions.push(
+    vscode.commands.registerCommand('tailscale.whois', async () => {
+      const addr = await vscode.window.showInputBox({
+        prompt: 'Enter a Tailscale IP, optionally with a port, to find out who it belongs to',
+        placeHolder: '100.101.102.103:51234',
+      });
+      if (!addr) {
+        return;
+      }
+      try {
+        const w = await tailscaleInstance.whois(addr.trim());
+        if (w.Errors?.[0]?.Type === 'PEER_NOT_FOUND') {
+          vscode.window.showInformationMessage(`${addr} is not in your tailnet.`);
+          return;
+        }
+        const owner = w.Tags?.length ? w.Tags.join(', ') : `${w.DisplayName} (${w.LoginName})`;
+        vscode.window.showInformationMessage(`${addr} is ${w.NodeName}, owned by ${owner}.`);
+      } catch (e) {
+        vscode.window.showErrorMessage(`Unable to look up ${addr}: ${e}`);
+      }
+    })
+  );
+
   context.subscriptions.push(
     vscode.commands.registerCommand('tailscale.openAdminConsole', () => {
       vscode.env.openExternal(vscode.Uri.parse(ADMIN_CONSOLE));
@@ -205,9 +256,10 @@ export async function activate(context: vscode.ExtensionContext) {
       }
 
       const status = await tailscaleInstance.serveStatus();
-      if (status?.Errors?.length) {
-        status.Errors.map((err) => {
-          const e = errorForType(err.Type);
+      const errors = status?.Errors?.filter((err) => !isHealthError(err.Type));
+      if (errors?.length) {
+        errors.map((err) => {
+          const e = errorFor(err);
 
           vscode.window
             .showErrorMessage(
@@ -5,12 +5,13 @@ import { parseTsUri } from './utils/uri';
 import { SshConnectionManager } from './ssh-connection-manager';
 import { fileSorter } from './filesystem-provider';
 import { getErrorMessage } from './utils/error';
+import { Tailscale } from './tailscale/cli';
 
 export class FileSystemProviderSFTP implements vscode.FileSystemProvider {
   public manager: SshConnectionManager;
 
-  constructor(configManager: ConfigManager) {
-    this.manager = new SshConnectionManager(configManager);
+  constructor(configManager: ConfigManager, ts?: Tailscale) {
+    this.manager = new SshConnectionManager(configManager, ts);
   }
 
   // Implementation of the `onDidChangeFile` event
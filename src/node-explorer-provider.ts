@@ -1,7 +1,19 @@
 /* eslint-disable @typescript-eslint/naming-convention */
 import * as vscode from 'vscode';
 import * as path from 'path';
-import { Peer, PeerGroup, PeersResponse } from './types';
+import {
+  Peer,
+  PeerDetails,
+  PeerGroup,
+  PeerService,
+  PeersQuery,
+  PeersResponse,
+  ExitNode,
+  PingMessage,
+  TaildropMessage,
+  TailnetEvent,
+  WithErrors,
+} from './types';
 import { Utils } from 'vscode-uri';
 import { Tailscale } from './tailscale/cli';
 import { ConfigManager } from './config-manager';
@@ -11,13 +23,21 @@ import { getUsername } from './utils/host';
 import { FileSystemProvider } from './filesystem-provider';
 import { trimSuffix } from './utils';
 import { EXTENSION_NS } from './constants';
-import { addToSSHConfig, syncSSHConfig } from './utils/sshconfig';
+import { errorFor, isHealthError } from './tailscale/error';
+import {
+  addToSSHConfig,
+  removeManagedSSHConfig,
+  syncSSHConfig,
+  writeManagedSSHConfig,
+} from './utils/sshconfig';
 import { Uri } from 'vscode';
 
 /**
  * Anatomy of the TreeView
  *
  * ├── PeerRoot
+ * │   ├── PeerServicesItem
+ * │   │   ├── PeerServiceItem
  * │   ├── PeerFileExplorer
  */
 export class NodeExplorerProvider
@@ -36,6 +56,10 @@ export class NodeExplorerProvider
   // eslint-disable-next-line @typescript-eslint/no-explicit-any
   public onDidChangeTreeData: vscode.Event<any> = this._onDidChangeTreeData.event;
   private previousStatus: PeersResponse | undefined = undefined;
+  // streamingEvents is whether peer changes are pushed over
+  // the events stream, which makes polling unnecessary.
+  private streamingEvents = false;
+  private eventRefresh: NodeJS.Timeout | undefined;
   private currentStatus: PeersResponse | undefined = undefined;
 
   constructor(
@@ -58,9 +82,19 @@ export class NodeExplorerProvider
     this.registerOpenRemoteCodeCommand();
     this.registerAddToSSHConfigCommand();
     this.registerOpenTerminalCommand();
+    this.registerPingCommand();
+    this.registerTaildropCommands();
+    this.registerOpenServiceCommand();
+    this.registerForwardCommands();
+    this.registerExitNodeCommand();
+    this.registerAccountCommands();
     this.registerRefresh();
     this.registerOpenDocsLink();
     this.registerDownloadCommand();
+    this.ts.watchEvents(
+      (e) => this.onEvent(e),
+      (connected) => (this.streamingEvents = connected)
+    );
     this.pollForUpdates();
   }
 
@@ -68,9 +102,31 @@ export class NodeExplorerProvider
     return element;
   }
 
+  async resolveTreeItem(item: vscode.TreeItem, element: PeerBaseTreeItem) {
+    if (!(element instanceof PeerRoot)) {
+      return item;
+    }
+    const displayDNSName = trimSuffix(element.DNSName, '.');
+    item.tooltip = `${displayDNSName} is ${element.Online ? 'online' : 'offline'}`;
+    try {
+      const details = await this.ts.getPeer(element.ID);
+      if (!details.Errors?.some((e) => e.Type === 'PEER_NOT_FOUND')) {
+        item.tooltip = peerTooltip(details);
+      }
+    } catch (e) {
+      Logger.error(`error getting peer details: ${e}`);
+    }
+    return item;
+  }
+
   private async getPeers() {
     this.previousStatus = this.currentStatus;
-    this.currentStatus = await this.ts.getPeers();
+    const config = vscode.workspace.getConfiguration(EXTENSION_NS);
+    this.currentStatus = await this.ts.getPeers({
+      group: config.get<PeersQuery['group']>('nodeExplorer.groupBy'),
+      sort: config.get<string>('nodeExplorer.sortBy'),
+      q: config.get<string>('nodeExplorer.filter'),
+    });
     return this.currentStatus;
   }
 
@@ -82,10 +138,13 @@ export class NodeExplorerProvider
       return false;
     }
 
-    if (status.Errors) {
-      if (!prevStatus.Errors || !status.Errors.every((e, i) => e === prevStatus.Errors?.[i])) {
-        return true;
-      }
+    const errs = status.Errors ?? [];
+    const prevErrs = prevStatus.Errors ?? [];
+    if (
+      errs.length !== prevErrs.length ||
+      !errs.every((e, i) => e.Type === prevErrs[i].Type && e.Message === prevErrs[i].Message)
+    ) {
+      return true;
     }
 
     if (status.CurrentTailnet.Name !== prevStatus.CurrentTailnet.Name) {
@@ -115,13 +174,24 @@ export class NodeExplorerProvider
     return false;
   }
 
+  private onEvent(e: TailnetEvent) {
+    if (e.type === 'serveConfig' || e.type.startsWith('healthWarning')) {
+      return;
+    }
+    // events come in bursts, such as a peer being added for
+    // every peer when connecting, so refresh once they settle.
+    clearTimeout(this.eventRefresh);
+    this.eventRefresh = setTimeout(() => this.refresh(), 250);
+  }
+
   async pollForUpdates() {
     const interval = vscode.workspace
       .getConfiguration(EXTENSION_NS)
       .get<number>('nodeExplorer.refreshInterval');
     if (interval) {
       try {
-        if (await this.diffRelay()) {
+        // changes are pushed while the events stream is up
+        if (!this.streamingEvents && (await this.diffRelay())) {
           this.refresh();
         }
       } catch (e) {
@@ -140,10 +210,14 @@ export class NodeExplorerProvider
   }
 
   async getChildren(element?: PeerBaseTreeItem): Promise<PeerBaseTreeItem[]> {
-    if (element instanceof PeerErrorItem) {
+    if (element instanceof PeerErrorItem || element instanceof PeerServiceItem) {
       return [];
     }
 
+    if (element instanceof PeerServicesItem) {
+      return this.getPeerServices(element);
+    }
+
     if (element instanceof PeerGroupItem) {
       return element.peerGroup.Peers.map((p) => {
         return new PeerRoot({ ...p }, element.tailnetName);
@@ -166,8 +240,10 @@ export class NodeExplorerProvider
 
     // Node root
     if (element instanceof PeerRoot) {
+      const services = new PeerServicesItem(element);
       if (!element.SSHEnabled) {
         return [
+          services,
           new PeerErrorItem({
             label: 'Enable Tailscale SSH',
             link: 'https://tailscale.com/kb/1193/tailscale-ssh/#prerequisites',
@@ -204,6 +280,7 @@ export class NodeExplorerProvider
       });
 
       return [
+        services,
         new FileExplorer(
           'File explorer',
           uri,
@@ -217,12 +294,28 @@ export class NodeExplorerProvider
       // Peer List
 
       const groups: PeerGroupItem[] = [];
+      // health problems are listed above the machines
+      const warnings: PeerErrorItem[] = [];
       let hasErr = false;
       try {
         const status = await this.getPeers();
         if (status.Errors && status.Errors.length) {
+          let fatal = false;
           for (let index = 0; index < status.Errors.length; index++) {
             const err = status.Errors[index];
+            if (isHealthError(err.Type)) {
+              const e = errorFor(err);
+              warnings.push(
+                new PeerErrorItem({
+                  label: err.Message || e.title,
+                  iconPath: err.Type === 'HEALTH_WARNING' ? 'warning' : 'alert',
+                  tooltip: e.message,
+                  link: err.URL,
+                })
+              );
+              continue;
+            }
+            fatal = true;
             switch (err.Type) {
               case 'NOT_RUNNING':
                 return [
@@ -235,13 +328,15 @@ export class NodeExplorerProvider
                 return [
                   new PeerErrorItem({
                     label: 'Tailscale offline. Log in and try again',
-                    tooltip: 'Make sure that Tailscale is installed and running',
-                    link: 'https://tailscale.com/download',
+                    tooltip: 'Click to log in',
+                    command: { command: 'tailscale.login', title: 'Log In' },
                   }),
                 ];
             }
           }
-          return [];
+          if (fatal) {
+            return [];
+          }
         }
 
         // displayName is the name that shows up at the top of
@@ -271,9 +366,12 @@ export class NodeExplorerProvider
         // Just directly render all nodes. Trust TSRelay to
         // not send a group with empty peers.
         if (status.PeerGroups?.length == 1) {
-          return status.PeerGroups[0].Peers.map((p) => {
-            return new PeerRoot({ ...p }, status.CurrentTailnet.Name);
-          });
+          return [
+            ...warnings,
+            ...status.PeerGroups[0].Peers.map((p) => {
+              return new PeerRoot({ ...p }, status.CurrentTailnet.Name);
+            }),
+          ];
           // Otherwise, go through each group (could be zero) and
           // create each category.
         } else {
@@ -291,6 +389,7 @@ export class NodeExplorerProvider
       // If there are no groups at all, render an onboarding item.
       if (!hasErr && !groups.length) {
         return [
+          ...warnings,
           new PeerErrorItem({
             label: 'Add your first node',
             tooltip: 'Click to read the docs to learn how to add your first node',
@@ -299,7 +398,7 @@ export class NodeExplorerProvider
         ];
       }
 
-      return groups;
+      return [...warnings, ...groups];
     }
   }
 
@@ -698,6 +797,415 @@ export class NodeExplorerProvider
     );
   }
 
+  registerPingCommand() {
+    let output: vscode.OutputChannel | undefined;
+    vscode.commands.registerCommand('tailscale.node.ping', async (node: PeerRoot) => {
+      output ??= vscode.window.createOutputChannel('Tailscale Ping');
+      output.show(true);
+      output.appendLine(`pinging ${node.ServerName}...`);
+      await vscode.window.withProgress(
+        {
+          location: vscode.ProgressLocation.Notification,
+          title: `Pinging ${node.ServerName}`,
+          cancellable: true,
+        },
+        async (progress, token) => {
+          try {
+            await this.ts.ping(
+              node.ID,
+              { type: 'disco', count: 10 },
+              (m) => {
+                const line = formatPing(m);
+                output?.appendLine(line);
+                progress.report({ message: line });
+              },
+              token
+            );
+          } catch (e) {
+            output?.appendLine(`${e}`);
+          }
+        }
+      );
+    });
+  }
+
+  registerExitNodeCommand() {
+    vscode.commands.registerCommand('tailscale.exitNode.select', async () => {
+      const [nodes, suggested] = await Promise.all([
+        this.ts.getExitNodes(),
+        this.ts.getSuggestedExitNode(),
+      ]);
+      if (nodes.Errors?.length) {
+        vscode.window.showErrorMessage(`Unable to list exit nodes: ${nodes.Errors[0].Type}`);
+        return;
+      }
+
+      type ExitNodeItem = vscode.QuickPickItem & { node?: ExitNode };
+      const item = (n: ExitNode, description?: string): ExitNodeItem => ({
+        label: `${n.Active ? '$(check) ' : ''}${n.ServerName}`,
+        description: description ?? (n.Online ? n.TailscaleIPs?.[0] : 'offline'),
+        node: n,
+      });
+      const items: ExitNodeItem[] = [
+        { label: 'None', description: nodes.Current ? undefined : 'current' },
+      ];
+      if (suggested) {
+        items.push(item(suggested, 'suggested'));
+      }
+      if (nodes.Nodes.length) {
+        items.push({ label: 'Tailnet', kind: vscode.QuickPickItemKind.Separator });
+        items.push(...nodes.Nodes.map((n) => item(n)));
+      }
+      for (const country of nodes.Mullvad) {
+        items.push({ label: `Mullvad: ${country.Name}`, kind: vscode.QuickPickItemKind.Separator });
+        for (const city of country.Cities) {
+          items.push(...city.Nodes.map((n) => item(n, city.Name)));
+        }
+      }
+
+      const picked = await vscode.window.showQuickPick(items, {
+        placeHolder: nodes.Current
+          ? `Using ${nodes.Current.ServerName || nodes.Current.ID} as exit node`
+          : 'Not using an exit node',
+        matchOnDescription: true,
+      });
+      if (!picked) {
+        return;
+      }
+      const resp = await this.ts.setExitNode(picked.node?.ID);
+      if (!(await showRelayError(resp, 'Changing the exit node'))) {
+        this.refresh();
+      }
+    });
+  }
+
+  registerAccountCommands() {
+    // refreshAll reloads everything that depends on the tailnet.
+    const refreshAll = () => {
+      this.refresh();
+      vscode.commands.executeCommand('tailscale.refreshServe');
+    };
+
+    const login = async () => {
+      await vscode.window.withProgress(
+        {
+          location: vscode.ProgressLocation.Notification,
+          title: 'Logging in to Tailscale',
+          cancellable: true,
+        },
+        async (progress, token) => {
+          try {
+            const result = await this.ts.login((url) => {
+              progress.report({ message: `continue in your browser: ${url}` });
+              vscode.env.openExternal(vscode.Uri.parse(url));
+            }, token);
+            if (result?.type === 'error') {
+              if (!(await showRelayError({ Errors: result.errors }, 'Logging in'))) {
+                vscode.window.showErrorMessage(`Unable to log in: ${result.message}`);
+              }
+              return;
+            }
+            if (result?.type === 'loginFinished') {
+              refreshAll();
+            }
+          } catch (e) {
+            vscode.window.showErrorMessage(`Unable to log in: ${e}`);
+          }
+        }
+      );
+    };
+    vscode.commands.registerCommand('tailscale.login', login);
+
+    vscode.commands.registerCommand('tailscale.logout', async () => {
+      const ok = await vscode.window.showWarningMessage(
+        'Log out of Tailscale? This machine will disconnect from the tailnet.',
+        { modal: true },
+        'Log Out'
+      );
+      if (!ok) {
+        return;
+      }
+      if (!(await showRelayError(await this.ts.logout(), 'Logging out'))) {
+        refreshAll();
+      }
+    });
+
+    vscode.commands.registerCommand('tailscale.switchAccount', async () => {
+      const { Profiles, Errors } = await this.ts.getProfiles();
+      if (Errors?.length) {
+        vscode.window.showErrorMessage(`Unable to list accounts: ${Errors[0].Type}`);
+        return;
+      }
+      const remove: vscode.QuickInputButton = {
+        iconPath: new vscode.ThemeIcon('trash'),
+        tooltip: 'Remove account',
+      };
+      type AccountItem = vscode.QuickPickItem & { id?: string; name?: string };
+      const qp = vscode.window.createQuickPick<AccountItem>();
+      qp.placeholder = 'Switch to account';
+      qp.items = [
+        ...Profiles.map((p) => ({
+          label: `${p.Current ? '$(check) ' : ''}${p.Name}`,
+          description: p.Tailnet,
+          id: p.ID,
+          name: p.Name,
+          buttons: [remove],
+        })),
+        { label: '$(add) Add account...' },
+      ];
+      qp.onDidTriggerItemButton(async ({ item }) => {
+        qp.hide();
+        if (!item.id) {
+          return;
+        }
+        const ok = await vscode.window.showWarningMessage(
+          `Remove ${item.name} from this machine?`,
+          { modal: true },
+          'Remove'
+        );
+        if (!ok) {
+          return;
+        }
+        if (!(await showRelayError(await this.ts.deleteProfile(item.id), 'Removing the account'))) {
+          refreshAll();
+        }
+      });
+      qp.onDidAccept(async () => {
+        const [item] = qp.selectedItems;
+        qp.hide();
+        if (!item) {
+          return;
+        }
+        const id = item.id;
+        if (!id) {
+          if (!(await showRelayError(await this.ts.addProfile(), 'Adding an account'))) {
+            refreshAll();
+            await login();
+          }
+          return;
+        }
+        await vscode.window.withProgress(
+          { location: vscode.ProgressLocation.Notification, title: `Switching to ${item.name}` },
+          async () => {
+            if (!(await showRelayError(await this.ts.switchProfile(id), 'Switching accounts'))) {
+              refreshAll();
+            }
+          }
+        );
+      });
+      qp.onDidHide(() => qp.dispose());
+      qp.show();
+    });
+  }
+
+  async getPeerServices(element: PeerServicesItem): Promise<PeerBaseTreeItem[]> {
+    const ports = vscode.workspace
+      .getConfiguration(EXTENSION_NS)
+      .get<string>('nodeExplorer.scanPorts');
+    try {
+      const resp = await this.ts.getPeerServices(element.peer.ID, ports);
+      if (resp.Errors?.some((e) => e.Type === 'PEER_OFFLINE')) {
+        return [new PeerErrorItem({ label: `${element.peer.ServerName} is offline` })];
+      }
+      if (!resp.Services?.length) {
+        return [
+          new PeerErrorItem({
+            label: 'No services found',
+            iconPath: 'info',
+            tooltip: `None of the ${resp.Scanned} scanned ports are open.`,
+          }),
+        ];
+      }
+      return resp.Services.map((s) => new PeerServiceItem(element.peer, s));
+    } catch (e) {
+      Logger.error(`error scanning peer: ${e}`);
+      return [new PeerErrorItem({ label: `Scanning ports failed: ${e}` })];
+    }
+  }
+
+  registerOpenServiceCommand() {
+    vscode.commands.registerCommand('tailscale.node.openService', (item: PeerServiceItem) => {
+      if (item.service.URL) {
+        vscode.env.openExternal(vscode.Uri.parse(item.service.URL));
+      }
+    });
+  }
+
+  registerForwardCommands() {
+    vscode.commands.registerCommand(
+      'tailscale.node.forwardPort',
+      async (node: PeerRoot | PeerServiceItem) => {
+        const peer = node instanceof PeerServiceItem ? node.peer : node;
+        let port = node instanceof PeerServiceItem ? node.service.Port : undefined;
+        if (!port) {
+          const input = await vscode.window.showInputBox({
+            prompt: `Port on ${peer.ServerName} to forward`,
+            placeHolder: '3000',
+            validateInput: (v) => (validPort(v) ? undefined : 'Enter a port between 1 and 65535'),
+          });
+          if (!input) {
+            return;
+          }
+          port = Number(input);
+        }
+        try {
+          const f = await this.ts.createForward(peer.ID, port);
+          if (await showRelayError(f, 'Forwarding the port')) {
+            return;
+          }
+          const action = await vscode.window.showInformationMessage(
+            `Forwarding localhost:${f.LocalPort} to ${f.PeerName}:${f.Port}`,
+            'Open in Browser',
+            'Copy Address'
+          );
+          if (action === 'Open in Browser') {
+            vscode.env.openExternal(vscode.Uri.parse(`http://localhost:${f.LocalPort}/`));
+          } else if (action === 'Copy Address') {
+            vscode.env.clipboard.writeText(`localhost:${f.LocalPort}`);
+          }
+        } catch (e) {
+          vscode.window.showErrorMessage(`Unable to forward the port: ${e}`);
+        }
+      }
+    );
+
+    vscode.commands.registerCommand('tailscale.forwards.manage', async () => {
+      const { Forwards } = await this.ts.getForwards();
+      if (!Forwards.length) {
+        vscode.window.showInformationMessage(
+          'No ports are forwarded. Use "Forward Port..." on a machine to forward one.'
+        );
+        return;
+      }
+      const stop: vscode.QuickInputButton = {
+        iconPath: new vscode.ThemeIcon('debug-stop'),
+        tooltip: 'Stop forwarding',
+      };
+      type ForwardItem = vscode.QuickPickItem & { id: string; localPort: number };
+      const qp = vscode.window.createQuickPick<ForwardItem>();
+      qp.placeholder = 'Select a forward to copy its local address';
+      qp.items = Forwards.map((f) => ({
+        label: `localhost:${f.LocalPort} → ${f.PeerName}:${f.Port}`,
+        description: `${f.Connections} open, ${f.TotalConnections} total`,
+        detail: `↓ ${formatBytes(f.BytesIn)} ↑ ${formatBytes(f.BytesOut)}`,
+        id: f.ID,
+        localPort: f.LocalPort,
+        buttons: [stop],
+      }));
+      qp.onDidTriggerItemButton(async ({ item }) => {
+        qp.hide();
+        await showRelayError(await this.ts.deleteForward(item.id), 'Stopping the forward');
+      });
+      qp.onDidAccept(() => {
+        const [item] = qp.selectedItems;
+        qp.hide();
+        if (item) {
+          vscode.env.clipboard.writeText(`localhost:${item.localPort}`);
+        }
+      });
+      qp.onDidHide(() => qp.dispose());
+      qp.show();
+    });
+  }
+
+  registerTaildropCommands() {
+    vscode.commands.registerCommand('tailscale.node.sendFile', async (node?: PeerRoot) => {
+      let target = node && { ID: node.ID, ServerName: node.ServerName };
+      if (!target) {
+        const targets = await this.ts.getFileTargets();
+        if (!targets.length) {
+          vscode.window.showInformationMessage('No machines can receive files right now.');
+          return;
+        }
+        const picked = await vscode.window.showQuickPick(
+          targets.map((p) => ({ label: p.ServerName, description: p.TailscaleIPs?.[0], peer: p })),
+          { placeHolder: 'Send to' }
+        );
+        if (!picked) {
+          return;
+        }
+        target = picked.peer;
+      }
+      const uris = await vscode.window.showOpenDialog({
+        canSelectFiles: true,
+        canSelectFolders: true,
+        canSelectMany: false,
+        openLabel: 'Send',
+      });
+      if (!uris?.length) {
+        return;
+      }
+      const { ID, ServerName } = target;
+      await vscode.window.withProgress(
+        {
+          location: vscode.ProgressLocation.Notification,
+          title: `Sending to ${ServerName}`,
+          cancellable: true,
+        },
+        async (progress, token) => {
+          let reported = 0;
+          try {
+            const result = await this.ts.pushFile(
+              ID,
+              uris[0].fsPath,
+              (m) => {
+                const increment = m.size > 0 ? ((m.sent - reported) / m.size) * 100 : undefined;
+                reported = m.sent;
+                progress.report({ message: formatTaildrop(m), increment });
+              },
+              token
+            );
+            if (result?.type === 'done') {
+              vscode.window.showInformationMessage(`Sent ${result.name} to ${ServerName}`);
+            } else if (result?.type === 'error') {
+              vscode.window.showErrorMessage(`Unable to send ${result.name}: ${result.message}`);
+            }
+          } catch (e) {
+            vscode.window.showErrorMessage(`${e}`);
+          }
+        }
+      );
+    });
+
+    vscode.commands.registerCommand('tailscale.receiveFiles', async () => {
+      const files = await this.ts.getWaitingFiles();
+      if (!files.length) {
+        vscode.window.showInformationMessage('No files were sent to this machine.');
+        return;
+      }
+      const picked = await vscode.window.showQuickPick(
+        files.map((f) => ({ label: f.Name, description: formatBytes(f.Size), file: f })),
+        { placeHolder: 'Files to receive', canPickMany: true }
+      );
+      if (!picked?.length) {
+        return;
+      }
+      const dirs = await vscode.window.showOpenDialog({
+        canSelectFiles: false,
+        canSelectFolders: true,
+        canSelectMany: false,
+        defaultUri: vscode.workspace.workspaceFolders?.[0]?.uri,
+        openLabel: 'Save',
+      });
+      if (!dirs?.length) {
+        return;
+      }
+      for (const { file } of picked) {
+        try {
+          const content = await this.ts.getWaitingFile(file.Name);
+          await vscode.workspace.fs.writeFile(Utils.joinPath(dirs[0], file.Name), content);
+          await this.ts.deleteWaitingFile(file.Name);
+        } catch (e) {
+          vscode.window.showErrorMessage(`Unable to receive ${file.Name}: ${e}`);
+          return;
+        }
+      }
+      vscode.window.showInformationMessage(
+        `Saved ${picked.length === 1 ? picked[0].file.Name : `${picked.length} files`}`
+      );
+    });
+  }
+
   registerOpenRemoteCodeCommand() {
     vscode.commands.registerCommand(
       'tailscale.node.openRemoteCode',
@@ -736,6 +1244,38 @@ export class NodeExplorerProvider
         getUsername(this.configManager, node.Address)
       );
     });
+
+    vscode.commands.registerCommand('tailscale.ssh.writeConfig', async () => {
+      try {
+        const resp = await writeManagedSSHConfig(this.ts, this.configManager);
+        if (await showRelayError(resp, 'Writing the SSH config')) {
+          return;
+        }
+        vscode.window.showInformationMessage(
+          resp.Changed
+            ? `Added ${resp.Hosts} machines with pinned host keys to ${resp.ConfigFile}.`
+            : `${resp.ConfigFile} is up to date.`
+        );
+      } catch (e) {
+        vscode.window.showErrorMessage(`Unable to write the SSH config: ${e}`);
+      }
+    });
+
+    vscode.commands.registerCommand('tailscale.ssh.removeConfig', async () => {
+      try {
+        const resp = await removeManagedSSHConfig(this.ts);
+        if (await showRelayError(resp, 'Removing the SSH config')) {
+          return;
+        }
+        vscode.window.showInformationMessage(
+          resp.Changed
+            ? `Removed the Tailscale machines from ${resp.ConfigFile}.`
+            : `${resp.ConfigFile} has no Tailscale machines.`
+        );
+      } catch (e) {
+        vscode.window.showErrorMessage(`Unable to remove the SSH config: ${e}`);
+      }
+    });
   }
 
   registerOpenNodeDetailsCommand() {
@@ -824,6 +1364,7 @@ export class PeerRoot extends PeerBaseTreeItem {
   public tailnetName: string;
   public Address: string;
   public ServerName: string;
+  public Online: boolean;
 
   public constructor(p: Peer, tailnetName: string) {
     super(p.ServerName);
@@ -867,14 +1408,12 @@ export class PeerRoot extends PeerBaseTreeItem {
       ),
     };
 
-    const displayDNSName = trimSuffix(this.DNSName, '.');
-
+    this.Online = p.Online === true;
     if (p.Online) {
       this.collapsibleState = vscode.TreeItemCollapsibleState.Collapsed;
-      this.tooltip = `${displayDNSName} is online`;
-    } else {
-      this.tooltip = `${displayDNSName} is offline`;
     }
+    // the tooltip is left undefined so that it is
+    // resolved with the peer's details on hover.
   }
 
   contextValue = 'peer-root';
@@ -902,13 +1441,70 @@ export class PeerDetailTreeItem extends PeerBaseTreeItem {
   }
 }
 
+export class PeerServicesItem extends PeerBaseTreeItem {
+  public constructor(public readonly peer: PeerRoot) {
+    super('Services');
+    // the label alone isn't unique across peers
+    this.id = `${peer.ID}/services`;
+    this.iconPath = new vscode.ThemeIcon('plug');
+    this.collapsibleState = vscode.TreeItemCollapsibleState.Collapsed;
+  }
+  contextValue = 'peer-services';
+}
+
+export class PeerServiceItem extends PeerBaseTreeItem {
+  public constructor(
+    public readonly peer: PeerRoot,
+    public readonly service: PeerService
+  ) {
+    super(`${service.Port}`);
+    this.id = `${peer.ID}/services/${service.Port}`;
+    this.description = [service.Service || 'tcp', service.Title].filter(Boolean).join(' · ');
+    this.tooltip = service.URL || service.Banner;
+    this.iconPath = new vscode.ThemeIcon(serviceIcon(service));
+    if (service.URL) {
+      this.contextValue = 'peer-service-http';
+      this.command = {
+        command: 'tailscale.node.openService',
+        title: 'Open in Browser',
+        arguments: [this],
+      };
+    }
+  }
+  contextValue = 'peer-service';
+}
+
+function serviceIcon(s: PeerService): string {
+  switch (s.Service) {
+    case 'http':
+    case 'https':
+      return 'globe';
+    case 'ssh':
+      return 'terminal';
+    case 'mysql':
+    case 'postgres':
+    case 'redis':
+    case 'mongodb':
+      return 'database';
+    default:
+      return 'plug';
+  }
+}
+
 export class PeerErrorItem extends vscode.TreeItem {
   public link?: string;
 
-  constructor(opts: { label: string; iconPath?: string; link?: string; tooltip?: string }) {
+  constructor(opts: {
+    label: string;
+    iconPath?: string;
+    link?: string;
+    tooltip?: string;
+    command?: vscode.Command;
+  }) {
     super(opts.label);
 
     this.link = opts.link;
+    this.command = opts.command;
 
     this.iconPath = new vscode.ThemeIcon(opts?.iconPath || 'alert');
 
@@ -920,6 +1516,123 @@ export class PeerErrorItem extends vscode.TreeItem {
 
 // trimPathPrefix is the same as a string trim prefix, but
 // prepends ~ to trimmed paths.
+function peerTooltip(p: PeerDetails): vscode.MarkdownString {
+  const md = new vscode.MarkdownString();
+  md.appendMarkdown(`**${trimSuffix(p.DNSName, '.')}** is ${p.Online ? 'online' : 'offline'}\n\n`);
+  const rows: [string, string | undefined][] = [
+    ['OS', p.OS],
+    ['Owner', p.Owner],
+    ['Tags', p.Tags?.join(', ')],
+    ['Addresses', p.TailscaleIPs?.join(', ')],
+    ['Roles', peerRoles(p)],
+    [
+      'Connection',
+      p.Connection === 'direct'
+        ? `direct (${p.CurAddr})`
+        : p.Connection === 'relay'
+        ? `relayed via DERP (${p.Relay})`
+        : undefined,
+    ],
+    ['Traffic', p.Online ? `↓ ${formatBytes(p.RxBytes)} ↑ ${formatBytes(p.TxBytes)}` : undefined],
+    ['Created', formatDate(p.Created)],
+    ['Last seen', p.Online ? undefined : formatDate(p.LastSeen)],
+    ['Key expiry', formatDate(p.KeyExpiry)],
+  ];
+  for (const [name, value] of rows) {
+    if (value) {
+      md.appendMarkdown(`${name}: `);
+      md.appendText(value);
+      md.appendMarkdown('  \n');
+    }
+  }
+  return md;
+}
+
+function peerRoles(p: PeerDetails): string | undefined {
+  const roles = [];
+  if (p.ExitNode) {
+    roles.push('current exit node');
+  } else if (p.ExitNodeOption) {
+    roles.push('exit node');
+  }
+  if (p.PrimaryRoutes?.length) {
+    roles.push(`subnet router for ${p.PrimaryRoutes.join(', ')}`);
+  }
+  return roles.length ? roles.join(', ') : undefined;
+}
+
+function formatPing(m: PingMessage): string {
+  if (m.type === 'done') {
+    return `${m.received ?? 0}/${m.sent ?? 0} replies`;
+  }
+  if (m.timeout) {
+    return `#${m.seq} timed out`;
+  }
+  if (m.error) {
+    return `#${m.seq} ${m.error}`;
+  }
+  const via = m.direct
+    ? `direct via ${m.endpoint}`
+    : m.peerRelay
+    ? `via peer relay ${m.peerRelay}`
+    : m.derpRegion
+    ? `via DERP(${m.derpRegion})`
+    : '';
+  return `#${m.seq} pong from ${m.nodeName ?? ''} ${via} in ${m.latency?.toFixed(1)}ms`;
+}
+
+function formatDate(s: string | undefined): string | undefined {
+  if (!s || s.startsWith('0001-')) {
+    return undefined;
+  }
+  return new Date(s).toLocaleString();
+}
+
+// showRelayError tells the user about the first error of resp, offering
+// to run the command that needs elevated permissions if there is one.
+// It returns whether there was an error.
+async function showRelayError(resp: WithErrors, action: string): Promise<boolean> {
+  const err = resp.Errors?.[0];
+  if (err?.Type === 'REQUIRES_SUDO' && err.Command) {
+    const run = await vscode.window.showWarningMessage(
+      `${action} requires elevated permissions.`,
+      'Run in Terminal'
+    );
+    if (run) {
+      const t = vscode.window.createTerminal('Tailscale');
+      t.sendText(err.Command);
+      t.show();
+    }
+    return true;
+  } else if (err) {
+    vscode.window.showErrorMessage(`${action} failed: ${err.Type}`);
+    return true;
+  }
+  return false;
+}
+
+function validPort(v: string): boolean {
+  const n = Number(v);
+  return Number.isInteger(n) && n >= 1 && n <= 65535;
+}
+
+function formatTaildrop(m: TaildropMessage): string {
+  if (m.size < 0) {
+    return `${m.name}: ${formatBytes(m.sent)}`;
+  }
+  return `${m.name}: ${formatBytes(m.sent)} of ${formatBytes(m.size)}`;
+}
+
+function formatBytes(n: number): string {
+  const units = ['B', 'KB', 'MB', 'GB', 'TB'];
+  let i = 0;
+  while (n >= 1024 && i < units.length - 1) {
+    n /= 1024;
+    i++;
+  }
+  return `${i === 0 ? n : n.toFixed(1)} ${units[i]}`;
+}
+
 function trimPathPrefix(s: string, prefix: string): string {
   if (s.startsWith(prefix)) {
     return `~${s.slice(prefix.length)}`;
@@ -6,12 +6,16 @@ import { getUsername } from './utils/host';
 import { Sftp } from './sftp';
 import { EXTENSION_NS } from './constants';
 import { Logger } from './logger';
+import { Tailscale } from './tailscale/cli';
 
 export class SshConnectionManager {
   private connections: Map<string, ssh2.Client>;
   private configManager: ConfigManager;
 
-  constructor(configManager: ConfigManager) {
+  constructor(
+    configManager: ConfigManager,
+    private readonly ts?: Tailscale
+  ) {
     this.connections = new Map();
     this.configManager = configManager;
   }
@@ -24,7 +28,10 @@ export class SshConnectionManager {
     }
 
     const conn = new ssh2.Client();
-    const config = { host, username };
+    // with userspace networking, the tailnet is
+    // only reachable through the relay's proxy
+    const sock = await this.ts?.proxyConnect(host, 22);
+    const config = { host, username, sock };
 
     try {
       await Promise.race([
@@ -1,8 +1,36 @@
 import * as cp from 'child_process';
+import * as http from 'http';
+import type * as net from 'net';
 import * as vscode from 'vscode';
 import fetch from 'node-fetch';
 import * as WebSocket from 'ws';
-import type { ServeParams, ServeStatus, TSRelayDetails, PeersResponse } from '../types';
+import type {
+  ServeParams,
+  ServeStatus,
+  TSRelayDetails,
+  PeersResponse,
+  PeersQuery,
+  PeerDetails,
+  PingMessage,
+  TailnetEvent,
+  TaildropMessage,
+  WaitingFile,
+  Peer,
+  RelayError,
+  ExitNode,
+  ExitNodesResponse,
+  WithErrors,
+  LoginMessage,
+  ProfilesResponse,
+  PeerServicesResponse,
+  Forward,
+  ForwardsResponse,
+  KnownHostsResponse,
+  SSHConfigResponse,
+  WhoIs,
+  WhoIsBatchResponse,
+  HealthResponse,
+} from '../types';
 import { Logger } from '../logger';
 import * as path from 'node:path';
 import { LogLevel } from 'vscode';
@@ -34,7 +62,13 @@ export class Tailscale {
   private childProcess?: cp.ChildProcess;
   private notifyExit?: () => void;
   private socket?: string;
+  private session?: string;
+  // proxy is the address of the relay's proxy to the tailnet, if enabled
+  private proxy?: string;
   private ws?: WebSocket;
+  // portSources are the suggested serve sources of new ports, from
+  // the fingerprints that follow newPort messages once probed
+  private portSources = new Map<number, string>();
 
   constructor(vscode: vscodeModule) {
     this._vscode = vscode;
@@ -44,6 +78,9 @@ export class Tailscale {
     const ts = new Tailscale(vscode);
     await ts.init();
     vscode.workspace.onDidChangeConfiguration((event) => {
+      if (event.affectsConfiguration('tailscale.portDiscovery.rules')) {
+        ts.sendPortRules();
+      }
       if (event.affectsConfiguration('tailscale.portDiscovery.enabled')) {
         if (ts.portDiscoOn() && !ts.ws) {
           Logger.debug('running port disco');
@@ -72,6 +109,12 @@ export class Tailscale {
     if (this.socket) {
       args.push(`-socket=${this.socket}`);
     }
+    if (vscode.workspace.getConfiguration(EXTENSION_NS).get<boolean>('portDiscovery.docker')) {
+      args.push('-docker');
+    }
+    if (vscode.workspace.getConfiguration(EXTENSION_NS).get<boolean>('relay.proxy')) {
+      args.push('-proxy');
+    }
     return args;
   }
 
@@ -80,6 +123,9 @@ export class Tailscale {
       this.socket = vscode.workspace.getConfiguration(EXTENSION_NS).get<string>('socketPath');
       let binPath = this.tsrelayPath();
       let args = this.defaultArgs();
+      if (vscode.workspace.getConfiguration(EXTENSION_NS).get<boolean>('relay.shared')) {
+        args.push('-shared');
+      }
       let cwd = __dirname;
       if (process.env.NODE_ENV === 'development') {
         binPath = '../tool/go';
@@ -108,6 +154,8 @@ export class Tailscale {
           this.url = details.address;
           this.nonce = details.nonce;
           this.port = details.port;
+          this.session = details.session;
+          this.proxy = details.proxy;
           this.authkey = Buffer.from(`${this.nonce}:`).toString('base64');
           Logger.info(`url: ${this.url}`, LOG_COMPONENT);
 
@@ -260,12 +308,18 @@ export class Tailscale {
     }
   }
 
-  async getPeers(): Promise<PeersResponse> {
+  async getPeers(query: PeersQuery = {}): Promise<PeersResponse> {
     if (!this.url) {
       throw new Error('uninitialized client');
     }
+    const params = new URLSearchParams();
+    for (const [k, v] of Object.entries(query)) {
+      if (v !== undefined && v !== '') {
+        params.set(k, String(v));
+      }
+    }
     try {
-      const resp = await fetch(`${this.url}/peers`, {
+      const resp = await fetch(`${this.url}/peers?${params}`, {
         headers: {
           Authorization: 'Basic ' + this.authkey,
         },
@@ -278,6 +332,279 @@ export class Tailscale {
     }
   }
 
+  async getPeer(id: string): Promise<PeerDetails> {
+    if (!this.url) {
+      throw new Error('uninitialized client');
+    }
+    try {
+      const resp = await fetch(`${this.url}/peers/${encodeURIComponent(id)}`, {
+        headers: {
+          Authorization: 'Basic ' + this.authkey,
+        },
+      });
+      return (await resp.json()) as PeerDetails;
+    } catch (e) {
+      Logger.error(`error getting peer: ${JSON.stringify(e, null, 2)}`);
+      throw e;
+    }
+  }
+
+  // getPeerServices scans the given ports of a peer, for
+  // example "22,8000-8100", or the default ports if empty.
+  async getPeerServices(id: string, ports?: string): Promise<PeerServicesResponse> {
+    if (!this.url) {
+      throw new Error('uninitialized client');
+    }
+    const params = new URLSearchParams();
+    if (ports) {
+      params.set('ports', ports);
+    }
+    const resp = await fetch(
+      `${this.url}/peers/${encodeURIComponent(id)}/services?${params.toString()}`,
+      {
+        headers: {
+          Authorization: 'Basic ' + this.authkey,
+        },
+      }
+    );
+    if (resp.status === 400) {
+      throw new Error(await resp.text());
+    }
+    return (await resp.json()) as PeerServicesResponse;
+  }
+
+  // proxyConnect opens a connection to host:port through the relay's
+  // proxy, which reaches the tailnet even when tailscaled runs with
+  // userspace networking. It resolves to undefined if the proxy is off.
+  proxyConnect(host: string, port: number): Promise<net.Socket | undefined> {
+    if (!this.proxy) {
+      return Promise.resolve(undefined);
+    }
+    const [proxyHost, proxyPort] = this.proxy.split(/:(?=\d+$)/);
+    const target = `${host}:${port}`;
+    return new Promise((resolve, reject) => {
+      const req = http.request({
+        host: proxyHost,
+        port: Number(proxyPort),
+        method: 'CONNECT',
+        path: target,
+        headers: {
+          Host: target,
+          'Proxy-Authorization':
+            'Basic ' + Buffer.from(`tsrelay:${this.nonce}`).toString('base64'),
+        },
+      });
+      req.on('connect', (res, socket) => {
+        if (res.statusCode !== 200) {
+          socket.destroy();
+          reject(new Error(`proxy could not connect to ${target}: ${res.statusCode}`));
+          return;
+        }
+        resolve(socket);
+      });
+      req.on('error', reject);
+      req.end();
+    });
+  }
+
+  // ping pings a peer and calls onMessage for every result
+  // until all pings were sent or the token is cancelled.
+  ping(
+    peer: string,
+    opts: { type?: 'disco' | 'tsmp' | 'icmp'; count?: number },
+    onMessage: (m: PingMessage) => void,
+    token?: vscode.CancellationToken
+  ): Promise<void> {
+    if (!this.url) {
+      throw new Error('uninitialized client');
+    }
+    const params = new URLSearchParams({ peer, type: opts.type ?? 'disco' });
+    if (opts.count) {
+      params.set('count', String(opts.count));
+    }
+    const ws = new WebSocket(`ws://${this.url.slice('http://'.length)}/ping?${params}`, {
+      headers: {
+        Authorization: 'Basic ' + this.authkey,
+      },
+    });
+    token?.onCancellationRequested(() => ws.close());
+    return new Promise((resolve, reject) => {
+      ws.on('message', (data) => {
+        const m = JSON.parse(data.toString()) as PingMessage;
+        onMessage(m);
+        if (m.type === 'done') {
+          ws.close();
+        }
+      });
+      ws.on('unexpected-response', (_, resp) => {
+        reject(new Error(`error pinging ${peer}: ${resp.statusCode}`));
+      });
+      ws.on('error', reject);
+      ws.on('close', () => resolve());
+    });
+  }
+
+  // getFileTargets returns the peers that can receive files with Taildrop.
+  async getFileTargets(): Promise<Peer[]> {
+    if (!this.url) {
+      throw new Error('uninitialized client');
+    }
+    try {
+      const resp = await fetch(`${this.url}/taildrop/targets`, {
+        headers: {
+          Authorization: 'Basic ' + this.authkey,
+        },
+      });
+      if (!resp.ok) {
+        throw new Error(await resp.text());
+      }
+      return (await resp.json()) as Peer[];
+    } catch (e) {
+      Logger.error(`error getting file targets: ${JSON.stringify(e, null, 2)}`);
+      throw e;
+    }
+  }
+
+  // pushFile sends the local file or directory at path to the peer and
+  // calls onMessage with its progress until it is sent or the token is
+  // cancelled. Directories are sent as tar archives.
+  pushFile(
+    peer: string,
+    path: string,
+    onMessage: (m: TaildropMessage) => void,
+    token?: vscode.CancellationToken
+  ): Promise<TaildropMessage | undefined> {
+    if (!this.url) {
+      throw new Error('uninitialized client');
+    }
+    const params = new URLSearchParams({ peer, path });
+    const ws = new WebSocket(`ws://${this.url.slice('http://'.length)}/taildrop/push?${params}`, {
+      headers: {
+        Authorization: 'Basic ' + this.authkey,
+      },
+    });
+    token?.onCancellationRequested(() => ws.close());
+    return new Promise((resolve, reject) => {
+      let result: TaildropMessage | undefined;
+      ws.on('message', (data) => {
+        const m = JSON.parse(data.toString()) as TaildropMessage;
+        onMessage(m);
+        if (m.type !== 'progress') {
+          result = m;
+          ws.close();
+        }
+      });
+      ws.on('unexpected-response', (_, resp) => {
+        let body = '';
+        resp.on('data', (chunk) => (body += chunk));
+        resp.on('end', () => {
+          try {
+            const { Errors } = JSON.parse(body) as { Errors?: RelayError[] };
+            if (Errors?.some((e) => e.Type === 'NO_FILE_TARGET')) {
+              reject(new Error(`${peer} can't receive files`));
+              return;
+            }
+          } catch {
+            // not a RelayError
+          }
+          reject(new Error(`error sending file: ${body.trim() || resp.statusCode}`));
+        });
+      });
+      ws.on('error', reject);
+      ws.on('close', () => resolve(result));
+    });
+  }
+
+  // getWaitingFiles returns the files received with Taildrop.
+  async getWaitingFiles(): Promise<WaitingFile[]> {
+    if (!this.url) {
+      throw new Error('uninitialized client');
+    }
+    try {
+      const resp = await fetch(`${this.url}/taildrop/files`, {
+        headers: {
+          Authorization: 'Basic ' + this.authkey,
+        },
+      });
+      if (!resp.ok) {
+        throw new Error(await resp.text());
+      }
+      return (await resp.json()) as WaitingFile[];
+    } catch (e) {
+      Logger.error(`error getting waiting files: ${JSON.stringify(e, null, 2)}`);
+      throw e;
+    }
+  }
+
+  async getWaitingFile(name: string): Promise<Uint8Array> {
+    if (!this.url) {
+      throw new Error('uninitialized client');
+    }
+    const resp = await fetch(`${this.url}/taildrop/files/${encodeURIComponent(name)}`, {
+      headers: {
+        Authorization: 'Basic ' + this.authkey,
+      },
+    });
+    if (!resp.ok) {
+      throw new Error(`error getting ${name}: ${(await resp.text()).trim()}`);
+    }
+    return new Uint8Array(await resp.arrayBuffer());
+  }
+
+  async deleteWaitingFile(name: string) {
+    if (!this.url) {
+      throw new Error('uninitialized client');
+    }
+    const resp = await fetch(`${this.url}/taildrop/files/${encodeURIComponent(name)}`, {
+      method: 'DELETE',
+      headers: {
+        Authorization: 'Basic ' + this.authkey,
+      },
+    });
+    if (!resp.ok) {
+      throw new Error(`error deleting ${name}: ${(await resp.text()).trim()}`);
+    }
+  }
+
+  // watchEvents streams changes of the tailnet state to onEvent,
+  // reconnecting whenever the connection drops, until disposed.
+  // onConnection is told whether events are currently streaming.
+  watchEvents(
+    onEvent: (e: TailnetEvent) => void,
+    onConnection?: (connected: boolean) => void
+  ): vscode.Disposable {
+    let ws: WebSocket | undefined;
+    let disposed = false;
+    let retry: NodeJS.Timeout | undefined;
+    const connect = () => {
+      if (disposed) {
+        return;
+      }
+      if (!this.url) {
+        retry = setTimeout(connect, 5000);
+        return;
+      }
+      ws = new WebSocket(`ws://${this.url.slice('http://'.length)}/events`, {
+        headers: {
+          Authorization: 'Basic ' + this.authkey,
+        },
+      });
+      ws.on('open', () => onConnection?.(true));
+      ws.on('message', (data) => onEvent(JSON.parse(data.toString()) as TailnetEvent));
+      ws.on('error', (e) => Logger.debug(`events websocket error: ${e}`, LOG_COMPONENT));
+      ws.on('close', () => {
+        onConnection?.(false);
+        retry = setTimeout(connect, 5000);
+      });
+    };
+    connect();
+    return new vscode.Disposable(() => {
+      disposed = true;
+      clearTimeout(retry);
+      ws?.close();
+    });
+  }
+
   async serveAdd(p: ServeParams) {
     if (!this.url) {
       throw new Error('uninitialized client');
@@ -320,6 +647,206 @@ export class Tailscale {
     }
   }
 
+  async getExitNodes(): Promise<ExitNodesResponse> {
+    if (!this.url) {
+      throw new Error('uninitialized client');
+    }
+    try {
+      const resp = await fetch(`${this.url}/exit-nodes`, {
+        headers: {
+          Authorization: 'Basic ' + this.authkey,
+        },
+      });
+      return (await resp.json()) as ExitNodesResponse;
+    } catch (e) {
+      Logger.error(`error getting exit nodes: ${JSON.stringify(e, null, 2)}`);
+      throw e;
+    }
+  }
+
+  // getSuggestedExitNode returns the exit node tailscaled
+  // suggests, or undefined if it has none to suggest.
+  async getSuggestedExitNode(): Promise<ExitNode | undefined> {
+    if (!this.url) {
+      throw new Error('uninitialized client');
+    }
+    const resp = await fetch(`${this.url}/exit-nodes/suggested`, {
+      headers: {
+        Authorization: 'Basic ' + this.authkey,
+      },
+    });
+    if (!resp.ok) {
+      return undefined;
+    }
+    return (await resp.json()) as ExitNode;
+  }
+
+  // setExitNode routes internet traffic through the given
+  // exit node, or stops using an exit node if id is undefined.
+  async setExitNode(id?: string, allowLANAccess?: boolean): Promise<WithErrors> {
+    if (!this.url) {
+      throw new Error('uninitialized client');
+    }
+    try {
+      const resp = await fetch(`${this.url}/exit-node`, {
+        method: id ? 'PUT' : 'DELETE',
+        headers: {
+          Authorization: 'Basic ' + this.authkey,
+        },
+        body: id ? JSON.stringify({ ID: id, AllowLANAccess: allowLANAccess }) : undefined,
+      });
+      // bad requests and unexpected errors aren't RelayErrors
+      if (resp.status === 400 || resp.status >= 500) {
+        throw new Error(await resp.text());
+      }
+      return (await resp.json()) as WithErrors;
+    } catch (e) {
+      Logger.error(`error setting exit node: ${e}`);
+      throw e;
+    }
+  }
+
+  // login starts an interactive login and calls onURL with every URL
+  // the user has to visit. It resolves once the login finished and
+  // stops waiting for it when the token is cancelled.
+  login(
+    onURL: (url: string) => void,
+    token?: vscode.CancellationToken
+  ): Promise<LoginMessage | undefined> {
+    if (!this.url) {
+      throw new Error('uninitialized client');
+    }
+    const ws = new WebSocket(`ws://${this.url.slice('http://'.length)}/login`, {
+      headers: {
+        Authorization: 'Basic ' + this.authkey,
+      },
+    });
+    token?.onCancellationRequested(() => ws.close());
+    return new Promise((resolve, reject) => {
+      let result: LoginMessage | undefined;
+      ws.on('message', (data) => {
+        const m = JSON.parse(data.toString()) as LoginMessage;
+        if (m.type === 'browseToURL' && m.url) {
+          onURL(m.url);
+          return;
+        }
+        result = m;
+        ws.close();
+      });
+      ws.on('unexpected-response', (_, resp) => {
+        reject(new Error(`error logging in: ${resp.statusCode}`));
+      });
+      ws.on('error', reject);
+      ws.on('close', () => resolve(result));
+    });
+  }
+
+  async logout(): Promise<WithErrors> {
+    return this.relayRequest('POST', '/logout');
+  }
+
+  async getProfiles(): Promise<ProfilesResponse> {
+    return this.relayRequest('GET', '/profiles');
+  }
+
+  // switchProfile resolves once tailscaled switched to the
+  // profile, so what is loaded next is of the new tailnet.
+  async switchProfile(id: string): Promise<ProfilesResponse> {
+    return this.relayRequest('PUT', '/profiles/current', { ID: id });
+  }
+
+  // addProfile switches to a new profile that needs a login.
+  async addProfile(): Promise<ProfilesResponse> {
+    return this.relayRequest('POST', '/profiles');
+  }
+
+  async deleteProfile(id: string): Promise<ProfilesResponse> {
+    return this.relayRequest('DELETE', `/profiles/${encodeURIComponent(id)}`);
+  }
+
+  async getForwards(): Promise<ForwardsResponse> {
+    return this.relayRequest('GET', '/forwards');
+  }
+
+  // createForward forwards a port of a peer to a local port, the
+  // same port if it is free. Forwarding a port again returns the
+  // existing forward.
+  async createForward(
+    peerID: string,
+    port: number,
+    localPort?: number
+  ): Promise<Forward & WithErrors> {
+    return this.relayRequest('POST', '/forwards', {
+      PeerID: peerID,
+      Port: port,
+      LocalPort: localPort,
+    });
+  }
+
+  async deleteForward(id: string): Promise<WithErrors> {
+    return this.relayRequest('DELETE', `/forwards/${encodeURIComponent(id)}`);
+  }
+
+  // getHealth returns the health warnings of tailscaled and whether the
+  // key of this machine expires within keyExpiryThreshold, such as "72h".
+  async getHealth(keyExpiryThreshold?: string): Promise<HealthResponse> {
+    const q = keyExpiryThreshold
+      ? `?keyExpiryThreshold=${encodeURIComponent(keyExpiryThreshold)}`
+      : '';
+    return this.relayRequest('GET', `/health${q}`);
+  }
+
+  // whois returns who is behind addr, an IP or IP:port of the tailnet.
+  // Lookups are cached by tsrelay for a few seconds.
+  async whois(addr: string): Promise<WhoIs> {
+    return this.relayRequest('GET', `/whois?addr=${encodeURIComponent(addr)}`);
+  }
+
+  async whoisBatch(addrs: string[]): Promise<WhoIsBatchResponse> {
+    return this.relayRequest('POST', '/whois', { Addrs: addrs });
+  }
+
+  async getKnownHosts(id?: string): Promise<KnownHostsResponse & WithErrors> {
+    return this.relayRequest('GET', `/ssh/known-hosts${id ? `?id=${encodeURIComponent(id)}` : ''}`);
+  }
+
+  // writeSSHConfig pins the host keys of the peers and writes a Host
+  // entry for each of them to a file included from configFile. users
+  // are the users to log in as by peer ID.
+  async writeSSHConfig(
+    configFile: string,
+    users: Record<string, string>
+  ): Promise<SSHConfigResponse & WithErrors> {
+    return this.relayRequest('PUT', '/ssh/config', { ConfigFile: configFile, Users: users });
+  }
+
+  async removeSSHConfig(configFile: string): Promise<SSHConfigResponse & WithErrors> {
+    return this.relayRequest('DELETE', `/ssh/config?configFile=${encodeURIComponent(configFile)}`);
+  }
+
+  private async relayRequest(method: string, path: string, body?: unknown) {
+    if (!this.url) {
+      throw new Error('uninitialized client');
+    }
+    try {
+      const resp = await fetch(`${this.url}${path}`, {
+        method,
+        headers: {
+          Authorization: 'Basic ' + this.authkey,
+        },
+        body: body && JSON.stringify(body),
+      });
+      // bad requests and unexpected errors aren't RelayErrors
+      if (resp.status === 400 || resp.status >= 500) {
+        throw new Error(await resp.text());
+      }
+      return await resp.json();
+    } catch (e) {
+      Logger.error(`error calling ${path}: ${e}`);
+      throw e;
+    }
+  }
+
   async setFunnel(port: number, on: boolean) {
     if (!this.url) {
       throw new Error('uninitialized client');
@@ -350,7 +877,11 @@ export class Tailscale {
       return;
     }
 
-    this.ws = new WebSocket(`ws://${this.url.slice('http://'.length)}/portdisco`, {
+    let wsURL = `ws://${this.url.slice('http://'.length)}/portdisco`;
+    if (this.session) {
+      wsURL += `?session=${this.session}`;
+    }
+    this.ws = new WebSocket(wsURL, {
       headers: {
         Authorization: 'Basic ' + this.authkey,
       },
@@ -360,6 +891,8 @@ export class Tailscale {
     });
     this.ws.on('open', () => {
       Logger.info('websocket is open');
+      this.sendWorkspaceFolders();
+      this.sendPortRules();
       this._vscode.window.terminals.forEach(async (t) => {
         const pid = await t.processId;
         if (!pid) {
@@ -381,18 +914,56 @@ export class Tailscale {
       Logger.info('got message');
       const msg = JSON.parse(data.toString());
       Logger.info(`msg is ${msg.type}`);
+      if (msg.type == 'invalidRules') {
+        this._vscode.window.showWarningMessage(msg.message);
+        return;
+      }
+      if (msg.type == 'servedPort') {
+        const selection = await this._vscode.window.showInformationMessage(msg.message, 'Copy URL');
+        if (selection === 'Copy URL') {
+          this._vscode.env.clipboard.writeText(msg.url);
+        }
+        return;
+      }
+      if (msg.type == 'portFingerprint') {
+        if (msg.fingerprint?.suggested?.source) {
+          this.portSources.set(msg.port, msg.fingerprint.suggested.source);
+        }
+        return;
+      }
+      if (msg.type == 'closedPort') {
+        this.portSources.delete(msg.port);
+        return;
+      }
       if (msg.type != 'newPort') {
         return;
       }
-      const shouldServe = await this._vscode.window.showInformationMessage(
+      const actions = msg.exposure && !msg.exposure.serve ? [] : ['Serve'];
+      const selection = await this._vscode.window.showInformationMessage(
         msg.message,
         { modal: false },
-        'Serve'
+        ...actions,
+        "Don't Ask Again"
       );
-      if (shouldServe) {
-        await this.runFunnel(msg.port);
+      if (selection === 'Serve') {
+        await this.runFunnel(
+          msg.port,
+          this.portSources.get(msg.port) ?? msg.exposure?.source,
+          msg.exposure?.funnel ?? true
+        );
+      } else if (selection === "Don't Ask Again") {
+        this.ws?.send(
+          JSON.stringify({
+            type: 'snooze',
+            port: msg.port,
+            proto: msg.proto,
+          })
+        );
       }
     });
+    this._vscode.workspace.onDidChangeWorkspaceFolders(() => {
+      this.sendWorkspaceFolders();
+    });
     this._vscode.window.onDidOpenTerminal(async (e: vscode.Terminal) => {
       Logger.info('terminal opened');
       const pid = await e.processId;
@@ -422,13 +993,42 @@ export class Tailscale {
     });
   }
 
-  async runFunnel(port: number) {
+  sendWorkspaceFolders() {
+    if (!this.ws || this.ws.readyState !== WebSocket.OPEN) {
+      return;
+    }
+    const folders = (this._vscode.workspace.workspaceFolders ?? [])
+      .filter((f) => f.uri.scheme === 'file')
+      .map((f) => f.uri.fsPath);
+    this.ws.send(
+      JSON.stringify({
+        type: 'setWorkspaceFolders',
+        folders: folders,
+      })
+    );
+  }
+
+  sendPortRules() {
+    if (!this.ws || this.ws.readyState !== WebSocket.OPEN) {
+      return;
+    }
+    const rules = vscode.workspace.getConfiguration(EXTENSION_NS).get('portDiscovery.rules') ?? [];
+    this.ws.send(
+      JSON.stringify({
+        type: 'setRules',
+        rules: rules,
+        workspace: this._vscode.workspace.workspaceFolders?.[0]?.uri.toString(),
+      })
+    );
+  }
+
+  async runFunnel(port: number, source?: string, funnel = true) {
     await this.serveAdd({
       protocol: 'https',
       port: 443,
       mountPoint: '/',
-      source: `http://127.0.0.1:${port}`,
-      funnel: true,
+      source: source ?? `http://127.0.0.1:${port}`,
+      funnel,
     });
 
     const selection = await this._vscode.window.showInformationMessage(
@@ -1,3 +1,5 @@
+import type { RelayError } from '../types';
+
 interface TailscaleError {
   title: string;
   message: string;
@@ -48,6 +50,32 @@ export function errorForType(type: string): TailscaleError {
         title: 'Restart Flatpak Container',
         message: 'Please quit VSCode and restart the container to finish setting up Tailscale',
       };
+    case 'HEALTH_WARNING':
+      return {
+        title: 'Tailscale health warning',
+        message: 'Tailscale reported a problem with this machine.',
+      };
+    case 'KEY_EXPIRING':
+      return {
+        title: 'Key expiring soon',
+        message: 'Log in again to renew the key of this machine.',
+      };
+    case 'KEY_EXPIRED':
+      return {
+        title: 'Key expired',
+        message: 'Log in again to reconnect this machine.',
+      };
+    case 'DNS_MISCONFIGURED':
+      return {
+        title: 'DNS misconfigured',
+        message: 'Tailscale is unable to manage the DNS settings of this machine.',
+      };
+    case 'LOCKED_OUT':
+      return {
+        title: 'Locked out by tailnet lock',
+        message: 'This machine has no connectivity until its key is signed.',
+        links: [{ url: 'https://tailscale.com/s/locked-out', title: 'Learn More' }],
+      };
     default:
       return {
         title: 'Unknown error',
@@ -55,3 +83,30 @@ export function errorForType(type: string): TailscaleError {
       };
   }
 }
+
+// errorFor is like errorForType but prefers the message,
+// hint and link tsrelay sent along with the error.
+export function errorFor(err: RelayError): TailscaleError {
+  const e = errorForType(err.Type);
+  const message = [err.Message, err.Hint].filter(Boolean).join(' ');
+  const title = err.URL?.includes('/admin') ? 'Open Admin Console' : 'Learn More';
+  return {
+    title: e.title,
+    message: message || e.message,
+    links: err.URL ? [{ url: err.URL, title }] : e.links,
+  };
+}
+
+// HEALTH_ERRORS are health problems reported alongside a
+// status, which is still usable, rather than instead of it.
+const HEALTH_ERRORS = [
+  'HEALTH_WARNING',
+  'KEY_EXPIRING',
+  'KEY_EXPIRED',
+  'DNS_MISCONFIGURED',
+  'LOCKED_OUT',
+];
+
+export function isHealthError(type: string): boolean {
+  return HEALTH_ERRORS.includes(type);
+}
@@ -33,6 +33,219 @@ export interface Peer {
   Address: string;
 }
 
+export interface PeerDetails extends Peer, WithErrors {
+  OS: string;
+  Owner?: string;
+  Tags?: string[];
+  Created?: string;
+  LastSeen?: string;
+  KeyExpiry?: string;
+  ExitNode: boolean;
+  ExitNodeOption: boolean;
+  PrimaryRoutes?: string[];
+  RxBytes: number;
+  TxBytes: number;
+  Connection?: 'direct' | 'relay';
+  CurAddr?: string;
+  Relay?: string;
+}
+
+export interface PingMessage {
+  type: 'pong' | 'done';
+  seq?: number;
+  // latency is the round trip time in milliseconds
+  latency?: number;
+  direct?: boolean;
+  endpoint?: string;
+  peerRelay?: string;
+  derpRegion?: string;
+  nodeName?: string;
+  timeout?: boolean;
+  error?: string;
+  sent?: number;
+  received?: number;
+}
+
+// PeerService is an open port of a peer found by a port scan.
+export interface PeerService {
+  Port: number;
+  Service?:
+    | 'http'
+    | 'https'
+    | 'tls'
+    | 'ssh'
+    | 'ftp'
+    | 'smtp'
+    | 'mysql'
+    | 'postgres'
+    | 'redis'
+    | 'mongodb';
+  Banner?: string;
+  Title?: string;
+  // URL opens HTTP services in a browser
+  URL?: string;
+}
+
+export interface PeerServicesResponse extends WithErrors {
+  Services: PeerService[];
+  Scanned: number;
+}
+
+// Forward is a local port forwarded to a port of a peer.
+export interface Forward {
+  ID: string;
+  PeerID: string;
+  PeerName: string;
+  Host: string;
+  Port: number;
+  LocalAddress: string;
+  LocalPort: number;
+  Created: string;
+  Connections: number;
+  TotalConnections: number;
+  // BytesIn were received from the peer and BytesOut sent to it
+  BytesIn: number;
+  BytesOut: number;
+}
+
+export interface ForwardsResponse {
+  Forwards: Forward[];
+}
+
+// WhoIs is the user and device behind a tailnet address.
+export interface WhoIs extends WithErrors {
+  Addr: string;
+  NodeID?: string;
+  NodeName?: string;
+  DNSName?: string;
+  Tags?: string[];
+  LoginName?: string;
+  DisplayName?: string;
+  ProfilePicURL?: string;
+  Capabilities?: string[];
+}
+
+export interface WhoIsBatchResponse {
+  // Results are in the order of the addresses looked up
+  Results: WhoIs[];
+}
+
+// SSHHostKeys are the host keys of a peer that runs Tailscale SSH.
+export interface SSHHostKeys {
+  ID: string;
+  ServerName: string;
+  DNSName: string;
+  // KnownHosts are lines in the known_hosts format
+  KnownHosts: string[];
+}
+
+export interface KnownHostsResponse {
+  Peers: SSHHostKeys[];
+}
+
+// SSHConfigResponse describes the ssh config managed by tsrelay,
+// a file included from ConfigFile with a Host entry per peer.
+export interface SSHConfigResponse {
+  ConfigFile: string;
+  IncludeFile: string;
+  KnownHostsFile: string;
+  Hosts: number;
+  Changed: boolean;
+}
+
+// TaildropMessage reports the progress of a file pushed with Taildrop.
+export interface TaildropMessage {
+  type: 'progress' | 'done' | 'error';
+  name: string;
+  sent: number;
+  // size is -1 for directories, which are sent as tar archives
+  size: number;
+  message?: string;
+}
+
+// WaitingFile is a file received with Taildrop.
+export interface WaitingFile {
+  Name: string;
+  Size: number;
+}
+
+export interface ExitNode {
+  ID: string;
+  DNSName: string;
+  ServerName: string;
+  TailscaleIPs: string[];
+  Online: boolean;
+  Active: boolean;
+  Mullvad: boolean;
+  Location?: {
+    Country?: string;
+    CountryCode?: string;
+    City?: string;
+    CityCode?: string;
+    Priority?: number;
+  };
+}
+
+export interface ExitNodeCountry {
+  Name: string;
+  Code: string;
+  Cities: { Name: string; Code: string; Nodes: ExitNode[] }[];
+}
+
+export interface ExitNodesResponse extends WithErrors {
+  Current?: ExitNode;
+  AllowLANAccess: boolean;
+  Nodes: ExitNode[];
+  Mullvad: ExitNodeCountry[];
+}
+
+// LoginMessage reports the progress of an interactive login.
+export interface LoginMessage {
+  type: 'browseToURL' | 'loginFinished' | 'error';
+  url?: string;
+  errors?: RelayError[];
+  message?: string;
+}
+
+export interface LoginProfile {
+  ID: string;
+  Name: string;
+  DisplayName: string;
+  ProfilePicURL?: string;
+  Tailnet?: string;
+  Current: boolean;
+}
+
+export interface ProfilesResponse extends WithErrors {
+  Current?: LoginProfile;
+  Profiles: LoginProfile[];
+}
+
+// TailnetEvent is a change of the tailnet state streamed from /events.
+export interface TailnetEvent {
+  type:
+    | 'backendState'
+    | 'peerAdded'
+    | 'peerChanged'
+    | 'peerRemoved'
+    | 'serveConfig'
+    | 'healthWarning'
+    | 'healthWarningCleared'
+    | 'error';
+  state?: string;
+  peer?: Peer;
+  id?: string;
+  serveConfig?: ServeConfig | null;
+  warning?: {
+    WarnableCode: string;
+    Severity: string;
+    Title: string;
+    Text: string;
+  };
+  code?: string;
+  errors?: RelayError[];
+}
+
 export interface CurrentTailnet {
   Name: string;
   MagicDNSEnabled: boolean;
@@ -42,6 +255,17 @@ export interface CurrentTailnet {
 export interface PeersResponse extends WithErrors {
   CurrentTailnet: CurrentTailnet;
   PeerGroups: PeerGroup[];
+  Total: number;
+}
+
+export interface PeersQuery {
+  group?: 'tag' | 'owner' | 'os' | 'online' | 'none';
+  // q is a search expression such as "tag:prod -is:offline"
+  q?: string;
+  // sort is a field to sort by, prefixed with "-" for descending order
+  sort?: string;
+  offset?: number;
+  limit?: number;
 }
 
 export interface PeerGroup {
@@ -49,12 +273,24 @@ export interface PeerGroup {
   Peers: Peer[];
 }
 
+export interface ContainerInfo {
+  id: string;
+  name: string;
+  image: string;
+  composeProject?: string;
+  composeService?: string;
+  workingDir?: string;
+}
+
 export interface ServeStatus extends WithErrors {
   ServeConfig?: ServeConfig;
   FunnelPorts?: number[];
   Services: {
     [port: number]: string;
   };
+  Containers?: {
+    [port: number]: ContainerInfo;
+  };
   BackendState: string;
   // TODO: Self is optional in the API, which might not be
   // correct. We need to settle on it being optional or always present.
@@ -72,7 +308,46 @@ export interface RelayError {
     | 'OFFLINE'
     | 'REQUIRES_SUDO'
     | 'NOT_RUNNING'
-    | 'FLATPAK_REQUIRES_RESTART';
+    | 'FLATPAK_REQUIRES_RESTART'
+    | 'PEER_NOT_FOUND'
+    | 'NO_FILE_TARGET'
+    | 'FILE_NOT_FOUND'
+    | 'EXIT_NODE_NOT_FOUND'
+    | 'NO_EXIT_NODE_SUGGESTION'
+    | 'PROFILE_NOT_FOUND'
+    | 'PEER_OFFLINE'
+    | 'FORWARD_NOT_FOUND'
+    | 'LOCAL_PORT_IN_USE'
+    | 'INVALID_SSH_CONFIG_PATH'
+    | 'HEALTH_WARNING'
+    | 'KEY_EXPIRING'
+    | 'KEY_EXPIRED'
+    | 'DNS_MISCONFIGURED'
+    | 'LOCKED_OUT';
+  Command?: string;
+  // Code is the warnable code of health warnings
+  Code?: string;
+  // Message describes the problem, Hint how to fix it and URL where
+  Message?: string;
+  Hint?: string;
+  URL?: string;
+}
+
+// HealthWarning is a health problem reported by tailscaled.
+export interface HealthWarning {
+  WarnableCode: string;
+  Severity: 'high' | 'medium' | 'low';
+  Title: string;
+  Text: string;
+  ImpactsConnectivity?: boolean;
+  PrimaryAction?: { URL: string; Label: string };
+}
+
+export interface HealthResponse extends WithErrors {
+  BackendState: string;
+  KeyExpiry?: string;
+  // Warnings are sorted with the most severe first
+  Warnings: HealthWarning[];
 }
 
 interface PeerStatus {
@@ -205,6 +480,9 @@ export interface TSRelayDetails {
   address: string;
   nonce: string;
   port: string;
+  session?: string;
+  // proxy is the address of the SOCKS5 and HTTP proxy to the tailnet
+  proxy?: string;
 }
 
 export interface FileInfo {
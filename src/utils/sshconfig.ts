@@ -3,6 +3,7 @@ import * as SSHConfig from 'ssh-config';
 import * as os from 'os';
 import { ConfigManager } from '../config-manager';
 import { getUsername } from './host';
+import type { Tailscale } from '../tailscale/cli';
 
 function sshConfigFilePath() {
   const filePath = vscode.workspace.getConfiguration('remote').get<string>('SSH.configFile');
@@ -120,3 +121,19 @@ export async function syncSSHConfig(addr: string, configManager: ConfigManager)
     }
   }
 }
+
+// writeManagedSSHConfig has tsrelay write a Host entry for every peer
+// that runs Tailscale SSH to a file included from the ssh config, with
+// their host keys pinned. Peers log in as the user set for them.
+export async function writeManagedSSHConfig(ts: Tailscale, configManager: ConfigManager) {
+  const { Peers } = await ts.getKnownHosts();
+  const users: Record<string, string> = {};
+  for (const p of Peers ?? []) {
+    users[p.ID] = getUsername(configManager, p.DNSName);
+  }
+  return ts.writeSSHConfig(sshConfigFilePath().fsPath, users);
+}
+
+export async function removeManagedSSHConfig(ts: Tailscale) {
+  return ts.removeSSHConfig(sshConfigFilePath().fsPath);
+}
@@ -1,10 +1,10 @@
 import React, { Fragment } from 'react';
 import { vsCodeAPI } from '../../../vscode-api';
 import { VSCodeButton } from '@vscode/webview-ui-toolkit/react';
-import { errorForType } from '../../../tailscale/error';
+import { errorFor } from '../../../tailscale/error';
 
-export const Error = ({ type }) => {
-  const { title, links, message } = errorForType(type);
+export const Error = ({ error }) => {
+  const { title, links, message } = errorFor(error);
 
   return (
     <div className="flex mt-2 bg-bannerBackground p-3">
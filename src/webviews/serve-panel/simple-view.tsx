@@ -7,7 +7,7 @@ import { Error } from './components/error';
 import { KB_FUNNEL_USE_CASES } from '../../utils/url';
 import { useServe, useServeMutation, fetchWithUser } from './data';
 import { Tooltip } from './components/tooltip';
-import { errorForType } from '../../tailscale/error';
+import { errorForType, isHealthError } from '../../tailscale/error';
 import { ServeParams, WithErrors } from '../../types';
 
 export const SimpleView = () => {
@@ -23,8 +23,10 @@ export const SimpleView = () => {
     data?.ServeConfig?.Web?.[`${DNSName}:443`]?.Handlers['/']?.Proxy.split(':')[2];
 
   useEffect(() => {
-    if (data?.Errors && data.Errors.length > 0) {
-      const e = errorForType(data.Errors[0].Type);
+    // health problems are shown but don't keep the form from being used
+    const err = data?.Errors?.find((e) => !isHealthError(e.Type));
+    if (err) {
+      const e = errorForType(err.Type);
       setDisabledText(e.title);
       return;
     }
@@ -64,7 +66,7 @@ export const SimpleView = () => {
   return (
     <div>
       {data?.Errors?.map((error, index) => (
-        <Error key={index} type={error.Type} />
+        <Error key={index} error={error} />
       ))}
 
       <div className="pt-2 pb-4">
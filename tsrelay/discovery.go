@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/tailscale-dev/vscode-tailscale/tsrelay/logger"
+)
+
+// discovery is the content of the discovery file that
+// a shared relay writes so that other editor windows
+// can attach to it instead of starting their own.
+type discovery struct {
+	serverDetails
+	relayOptions
+	PID int `json:"pid"`
+}
+
+// relayOptions are the flags a shared relay was started with. A
+// window only attaches to a relay that behaves like the one it would
+// start itself, save for logging, which is up to the first window.
+type relayOptions struct {
+	Socket string `json:"socket,omitempty"`
+	// Docker is the Docker Engine socket, or empty
+	// if Docker discovery is off.
+	Docker  string `json:"docker,omitempty"`
+	Record  string `json:"record,omitempty"`
+	Verbose bool   `json:"verbose,omitempty"`
+	Logfile string `json:"logfile,omitempty"`
+}
+
+// mismatch describes the first option of o that keeps a window
+// that wants the options of want from attaching, or is empty.
+func (o relayOptions) mismatch(want relayOptions) string {
+	switch {
+	case o.Socket != want.Socket:
+		return fmt.Sprintf("uses socket %q instead of %q", o.Socket, want.Socket)
+	case o.Docker != want.Docker:
+		return fmt.Sprintf("uses Docker socket %q instead of %q", o.Docker, want.Docker)
+	case o.Record != want.Record:
+		return fmt.Sprintf("records to %q instead of %q", o.Record, want.Record)
+	}
+	return ""
+}
+
+// discoveryPath returns the location of the discovery file.
+// It lives in the user's runtime dir when there is one and
+// falls back to a per-user dir in the temp dir otherwise.
+func discoveryPath() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "vscode-tailscale", "tsrelay.json")
+	}
+	return filepath.Join(os.TempDir(), "vscode-tailscale-"+strconv.Itoa(os.Getuid()), "tsrelay.json")
+}
+
+// ensureDiscoveryDir creates the dir of the discovery file
+// unless it exists, and makes sure that it is private.
+func ensureDiscoveryDir(path string) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("error creating discovery dir: %w", err)
+	}
+	fi, err := os.Lstat(dir)
+	if err != nil {
+		return fmt.Errorf("error checking discovery dir: %w", err)
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	return checkPrivate(dir, fi)
+}
+
+// readDiscovery reads the discovery file, which
+// along with its dir has to be private to the user.
+func readDiscovery(path string) (*discovery, error) {
+	dir := filepath.Dir(path)
+	fi, err := os.Lstat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPrivate(dir, fi); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err = f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPrivate(path, fi); err != nil {
+		return nil, err
+	}
+	bts, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	var d discovery
+	if err := json.Unmarshal(bts, &d); err != nil {
+		return nil, fmt.Errorf("error decoding discovery file: %w", err)
+	}
+	return &d, nil
+}
+
+// errRelayExists is returned by writeDiscovery when another
+// live relay has published the discovery file.
+var errRelayExists = errors.New("another shared relay is running")
+
+// writeDiscovery atomically creates the discovery file. If the file
+// exists and the relay in it is reachable, it fails with
+// errRelayExists so that only one of several windows starting at
+// once becomes the owner. Files of relays that are gone are
+// replaced. The file contains the nonce so it is only readable by
+// the user.
+func writeDiscovery(ctx context.Context, path string, d *discovery) error {
+	if err := ensureDiscoveryDir(path); err != nil {
+		return err
+	}
+	bts, err := json.Marshal(d)
+	if err != nil {
+		return fmt.Errorf("error encoding discovery file: %w", err)
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".tsrelay-*")
+	if err != nil {
+		return fmt.Errorf("error creating discovery file: %w", err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(bts); err != nil {
+		f.Close()
+		return fmt.Errorf("error writing discovery file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("error closing discovery file: %w", err)
+	}
+	// unlike a rename, a link fails if the file exists
+	err = os.Link(f.Name(), path)
+	if !errors.Is(err, os.ErrExist) {
+		return err
+	}
+	old, rerr := readDiscovery(path)
+	if rerr == nil {
+		rc := &relayClient{d: old, c: &http.Client{Timeout: 5 * time.Second}}
+		if rc.do(ctx, http.MethodGet, "/sessions", nil) == nil {
+			return errRelayExists
+		}
+		removeDiscovery(path, old.PID)
+	} else {
+		os.Remove(path)
+	}
+	err = os.Link(f.Name(), path)
+	if errors.Is(err, os.ErrExist) {
+		// another window has replaced it first
+		return errRelayExists
+	}
+	return err
+}
+
+// removeDiscovery deletes the discovery file unless
+// another relay has taken it over in the meantime.
+func removeDiscovery(path string, pid int) {
+	d, err := readDiscovery(path)
+	if err != nil || d.PID != pid {
+		return
+	}
+	os.Remove(path)
+}
+
+// relayClient talks to the session endpoints of
+// an already running relay.
+type relayClient struct {
+	d *discovery
+	c *http.Client
+}
+
+func (rc *relayClient) do(ctx context.Context, method, path string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, method, rc.d.Address+path, nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(rc.d.Nonce, "")
+	resp, err := rc.c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code %d from %s %s", resp.StatusCode, method, path)
+	}
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+type sessionResponse struct {
+	ID       string `json:"id"`
+	Sessions int    `json:"sessions"`
+}
+
+// attachRelay looks for a live shared relay and, if there is one,
+// registers a session with it and holds that session until ctx is
+// done. It reports false if there was no relay to attach to, in which
+// case the caller should start its own. Relays with other options
+// are passed over, as are relays without the proxy when proxy is set,
+// since it can't be added later, and the proxy of a relay is left out
+// of the details when it isn't.
+func attachRelay(ctx context.Context, lggr logger.Logger, path string, opts relayOptions, proxy bool) (bool, error) {
+	d, err := readDiscovery(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			lggr.Printf("ignoring discovery file: %v", err)
+		}
+		return false, nil
+	}
+	if m := d.mismatch(opts); m != "" {
+		lggr.Printf("shared relay %s", m)
+		return false, nil
+	}
+	if proxy && d.Proxy == "" {
+		lggr.Printf("shared relay at %s was started without the proxy", d.Address)
+		return false, nil
+	}
+	rc := &relayClient{d: d, c: &http.Client{Timeout: 5 * time.Second}}
+	var sr sessionResponse
+	if err := rc.do(ctx, http.MethodPost, "/sessions", &sr); err != nil {
+		lggr.Printf("shared relay at %s is not reachable: %v", d.Address, err)
+		return false, nil
+	}
+	lggr.Printf("attached to shared relay %d at %s with session %s", d.PID, d.Address, sr.ID)
+	if d.Verbose != opts.Verbose || d.Logfile != opts.Logfile {
+		lggr.Printf("shared relay logs to %q with verbose %v, which the flags of this window don't change", d.Logfile, d.Verbose)
+	}
+	sd := d.serverDetails
+	sd.Session = sr.ID
+	if !proxy {
+		sd.Proxy = ""
+	}
+	json.NewEncoder(os.Stdout).Encode(sd)
+
+	ticker := time.NewTicker(sessionPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			if err := rc.do(ctx, http.MethodDelete, "/sessions/"+sr.ID, nil); err != nil {
+				lggr.Printf("error releasing session: %v", err)
+			}
+			return true, nil
+		case <-ticker.C:
+			// Exit when the shared relay goes away so the
+			// extension notices and starts a new one.
+			if err := rc.do(ctx, http.MethodGet, "/sessions/"+sr.ID, nil); err != nil && ctx.Err() == nil {
+				return true, fmt.Errorf("lost shared relay: %w", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,28 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+// checkPrivate makes sure that the discovery dir or file described
+// by fi is owned by the current user and not accessible by others,
+// who could otherwise plant a relay of their own for windows to
+// attach to.
+func checkPrivate(path string, fi fs.FileInfo) error {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fmt.Errorf("can't tell the owner of %s", path)
+	}
+	if int(st.Uid) != os.Getuid() {
+		return fmt.Errorf("%s is owned by uid %d", path, st.Uid)
+	}
+	if fi.Mode().Perm()&0o077 != 0 {
+		return fmt.Errorf("%s is accessible by other users (mode %v)", path, fi.Mode().Perm())
+	}
+	return nil
+}
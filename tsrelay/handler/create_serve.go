@@ -14,11 +14,14 @@ import (
 )
 
 type serveRequest struct {
-	Protocol   string
-	Source     string
-	Port       uint16
-	MountPoint string
-	Funnel     bool
+	Protocol   string `json:"protocol,omitempty"`
+	Source     string `json:"source,omitempty"`
+	Port       uint16 `json:"port,omitempty"`
+	MountPoint string `json:"mountPoint,omitempty"`
+	Funnel     bool   `json:"funnel,omitempty"`
+	// LocalPort is the port, or host:port, that
+	// tcp requests forward connections to.
+	LocalPort string `json:"localPort,omitempty"`
 }
 
 func (h *handler) createServeHandler(w http.ResponseWriter, r *http.Request) {
@@ -44,7 +47,12 @@ func (h *handler) createServe(ctx context.Context, body io.Reader) error {
 	if err != nil {
 		return fmt.Errorf("error decoding request body: %w", err)
 	}
-	if req.Protocol != "https" {
+	return h.addServe(ctx, req)
+}
+
+// addServe adds the given handler to the serve config.
+func (h *handler) addServe(ctx context.Context, req serveRequest) error {
+	if req.Protocol != "https" && req.Protocol != "tcp" {
 		return fmt.Errorf("unsupported protocol: %q", req.Protocol)
 	}
 	sc, dns, err := h.serveConfigDNS(ctx)
@@ -52,7 +60,11 @@ func (h *handler) createServe(ctx context.Context, body io.Reader) error {
 		return fmt.Errorf("error getting config: %w", err)
 	}
 	hostPort := ipn.HostPort(fmt.Sprintf("%s:%d", dns, req.Port))
-	setHandler(sc, hostPort, req)
+	if req.Protocol == "tcp" {
+		setTCPForward(sc, req)
+	} else {
+		setHandler(sc, hostPort, req)
+	}
 	if req.Funnel {
 		if sc.AllowFunnel == nil {
 			sc.AllowFunnel = make(map[ipn.HostPort]bool)
@@ -121,3 +133,16 @@ func setHandler(sc *ipn.ServeConfig, newHP ipn.HostPort, req serveRequest) {
 		Proxy: req.Source,
 	}
 }
+
+func setTCPForward(sc *ipn.ServeConfig, req serveRequest) {
+	if sc.TCP == nil {
+		sc.TCP = make(map[uint16]*ipn.TCPPortHandler)
+	}
+	target := req.LocalPort
+	if !strings.Contains(target, ":") {
+		target = "127.0.0.1:" + target
+	}
+	sc.TCP[req.Port] = &ipn.TCPPortHandler{
+		TCPForward: target,
+	}
+}
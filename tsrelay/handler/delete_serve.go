@@ -45,7 +45,7 @@ func (h *handler) deleteServe(ctx context.Context, body io.Reader) error {
 		return nil
 	}
 
-	if req.Protocol != "https" {
+	if req.Protocol != "https" && req.Protocol != "tcp" {
 		return fmt.Errorf("unsupported protocol: %q", req.Protocol)
 	}
 	sc, dns, err := h.serveConfigDNS(ctx)
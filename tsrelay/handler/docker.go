@@ -0,0 +1,191 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/portlist"
+)
+
+// Compose labels set on containers started by docker compose.
+const (
+	composeProjectLabel    = "com.docker.compose.project"
+	composeServiceLabel    = "com.docker.compose.service"
+	composeWorkingDirLabel = "com.docker.compose.project.working_dir"
+)
+
+const (
+	// dockerCacheTTL is how long the list of containers
+	// is reused before the Docker Engine is asked again.
+	dockerCacheTTL = 2 * time.Second
+	// dockerPollInterval is how often the Docker Engine is
+	// asked for published ports, which don't show up as
+	// listening ports when the engine runs in a VM.
+	dockerPollInterval = 3 * time.Second
+)
+
+// containerInfo describes the container
+// a published port belongs to.
+type containerInfo struct {
+	ID             string `json:"id"`
+	Name           string `json:"name"`
+	Image          string `json:"image"`
+	ComposeProject string `json:"composeProject,omitempty"`
+	ComposeService string `json:"composeService,omitempty"`
+	// WorkingDir is the directory docker compose was run from.
+	WorkingDir string `json:"workingDir,omitempty"`
+}
+
+// String returns a short description of the container for the UI.
+func (ci *containerInfo) String() string {
+	if ci.ComposeProject != "" && ci.ComposeService != "" {
+		return fmt.Sprintf("%s (%s/%s)", ci.Name, ci.ComposeProject, ci.ComposeService)
+	}
+	return fmt.Sprintf("%s (%s)", ci.Name, ci.Image)
+}
+
+// dockerClient lists published container ports through the
+// Docker Engine API on its unix socket.
+type dockerClient struct {
+	c *http.Client
+
+	mu      sync.Mutex
+	ports   map[portKey]*containerInfo
+	fetched time.Time
+}
+
+// newDockerClient returns a client for the Docker Engine
+// listening on the given unix socket.
+func newDockerClient(socket string) *dockerClient {
+	return &dockerClient{
+		c: &http.Client{
+			Timeout: 2 * time.Second,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", socket)
+				},
+			},
+		},
+	}
+}
+
+// DockerSocketPath returns the socket of the Docker Engine of
+// the current user: DOCKER_HOST if it's a unix socket, the
+// rootless socket if it exists and the system socket otherwise.
+func DockerSocketPath() string {
+	if host, ok := strings.CutPrefix(os.Getenv("DOCKER_HOST"), "unix://"); ok {
+		return host
+	}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		rootless := filepath.Join(dir, "docker.sock")
+		if _, err := os.Stat(rootless); err == nil {
+			return rootless
+		}
+	}
+	return "/var/run/docker.sock"
+}
+
+type dockerContainer struct {
+	ID     string            `json:"Id"`
+	Names  []string          `json:"Names"`
+	Image  string            `json:"Image"`
+	Labels map[string]string `json:"Labels"`
+	Ports  []struct {
+		IP          string `json:"IP"`
+		PrivatePort uint16 `json:"PrivatePort"`
+		PublicPort  uint16 `json:"PublicPort"`
+		Type        string `json:"Type"`
+	} `json:"Ports"`
+}
+
+// publishedPorts returns the containers by the host ports they publish.
+func (d *dockerClient) publishedPorts(ctx context.Context) (map[portKey]*containerInfo, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.ports != nil && time.Since(d.fetched) < dockerCacheTTL {
+		return d.ports, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://docker/containers/json", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := d.c.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error listing containers: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error listing containers: unexpected status code %d", resp.StatusCode)
+	}
+	var containers []dockerContainer
+	if err := json.NewDecoder(resp.Body).Decode(&containers); err != nil {
+		return nil, fmt.Errorf("error decoding containers: %w", err)
+	}
+	ports := make(map[portKey]*containerInfo)
+	for _, c := range containers {
+		ci := &containerInfo{
+			ID:             c.ID,
+			Image:          c.Image,
+			ComposeProject: c.Labels[composeProjectLabel],
+			ComposeService: c.Labels[composeServiceLabel],
+			WorkingDir:     c.Labels[composeWorkingDirLabel],
+		}
+		if len(c.Names) > 0 {
+			ci.Name = strings.TrimPrefix(c.Names[0], "/")
+		}
+		for _, p := range c.Ports {
+			if p.PublicPort == 0 {
+				continue
+			}
+			ports[portKey{p.Type, p.PublicPort}] = ci
+		}
+	}
+	d.ports = ports
+	d.fetched = time.Now()
+	return ports, nil
+}
+
+// sameContainers reports whether two lists of
+// published ports belong to the same containers.
+func sameContainers(a, b map[portKey]*containerInfo) bool {
+	return maps.EqualFunc(a, b, func(x, y *containerInfo) bool {
+		return x.ID == y.ID
+	})
+}
+
+// withContainers adds the ports published by containers that are
+// missing from the given list, which is the case when the Docker
+// Engine runs in a VM. It returns the containers by port, or nil
+// if Docker discovery is off or the engine can't be reached.
+func (h *handler) withContainers(ctx context.Context, ports []portlist.Port) ([]portlist.Port, map[portKey]*containerInfo) {
+	if h.docker == nil {
+		return ports, nil
+	}
+	containers, err := h.docker.publishedPorts(ctx)
+	if err != nil {
+		h.l.VPrintf("error getting container ports: %v", err)
+		return ports, nil
+	}
+	seen := make(map[portKey]bool, len(ports))
+	for _, p := range ports {
+		seen[portKey{p.Proto, p.Port}] = true
+	}
+	// the list may be shared with other subscribers
+	ports = ports[:len(ports):len(ports)]
+	for k, ci := range containers {
+		if !seen[k] {
+			ports = append(ports, portlist.Port{Proto: k.proto, Port: k.port, Process: ci.Name})
+		}
+	}
+	return ports, containers
+}
@@ -0,0 +1,188 @@
+package handler
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tailscale-dev/vscode-tailscale/tsrelay/logger"
+	"tailscale.com/portlist"
+)
+
+const fakeContainers = `[
+	{
+		"Id": "abc123",
+		"Names": ["/app-web-1"],
+		"Image": "nginx:latest",
+		"Labels": {
+			"com.docker.compose.project": "app",
+			"com.docker.compose.service": "web",
+			"com.docker.compose.project.working_dir": "/work/app"
+		},
+		"Ports": [
+			{"IP": "0.0.0.0", "PrivatePort": 80, "PublicPort": 8080, "Type": "tcp"},
+			{"PrivatePort": 443, "Type": "tcp"}
+		]
+	},
+	{
+		"Id": "def456",
+		"Names": ["/dns"],
+		"Image": "coredns",
+		"Labels": {},
+		"Ports": [
+			{"IP": "127.0.0.1", "PrivatePort": 53, "PublicPort": 5353, "Type": "udp"}
+		]
+	}
+]`
+
+// fakeDocker serves a canned container list on a unix socket
+// and returns the path of the socket.
+func fakeDocker(t *testing.T) string {
+	return fakeDockerFunc(t, func() string { return fakeContainers })
+}
+
+// fakeDockerFunc serves the container list returned by list
+// on a unix socket and returns the path of the socket.
+func fakeDockerFunc(t *testing.T, list func() string) string {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a unix socket")
+	}
+	// t.TempDir can exceed the maximum length of a socket path
+	dir, err := os.MkdirTemp("", "docker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	socket := filepath.Join(dir, "docker.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /containers/json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(list()))
+	})
+	srv := &http.Server{Handler: mux}
+	go srv.Serve(l)
+	t.Cleanup(func() { srv.Close() })
+	return socket
+}
+
+func TestDockerPublishedPorts(t *testing.T) {
+	d := newDockerClient(fakeDocker(t))
+	ports, err := d.publishedPorts(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ports) != 2 {
+		t.Fatalf("expected 2 published ports but got %d", len(ports))
+	}
+	web := ports[portKey{"tcp", 8080}]
+	if web == nil {
+		t.Fatal("expected tcp port 8080 to be published")
+	}
+	want := containerInfo{
+		ID:             "abc123",
+		Name:           "app-web-1",
+		Image:          "nginx:latest",
+		ComposeProject: "app",
+		ComposeService: "web",
+		WorkingDir:     "/work/app",
+	}
+	if *web != want {
+		t.Fatalf("expected %+v but got %+v", want, *web)
+	}
+	if got := web.String(); got != "app-web-1 (app/web)" {
+		t.Fatalf("unexpected description %q", got)
+	}
+	if dns := ports[portKey{"udp", 5353}]; dns == nil || dns.String() != "dns (coredns)" {
+		t.Fatalf("unexpected udp container %v", dns)
+	}
+}
+
+func TestWithContainers(t *testing.T) {
+	h := &handler{l: logger.Nop, docker: newDockerClient(fakeDocker(t))}
+	// tcp 8080 is forwarded by docker-proxy while
+	// udp 5353 is only known to the engine.
+	shared := make([]portlist.Port, 1, 4)
+	shared[0] = portlist.Port{Proto: "tcp", Port: 8080, Process: "docker-proxy", Pid: 42}
+	ports, containers := h.withContainers(context.Background(), shared)
+	if len(ports) != 2 {
+		t.Fatalf("expected 2 ports but got %v", ports)
+	}
+	if ports[0].Process != "docker-proxy" {
+		t.Fatalf("expected listening port to be kept but got %v", ports[0])
+	}
+	if ports[1].Proto != "udp" || ports[1].Port != 5353 || ports[1].Process != "dns" {
+		t.Fatalf("expected udp port 5353 of dns to be added but got %v", ports[1])
+	}
+	if shared[:2][1] != (portlist.Port{}) {
+		t.Fatal("shared port list was modified")
+	}
+	if containers[portKey{"tcp", 8080}].Name != "app-web-1" {
+		t.Fatalf("expected tcp port 8080 to map to app-web-1")
+	}
+
+	h.docker = nil
+	ports, containers = h.withContainers(context.Background(), shared)
+	if len(ports) != 1 || containers != nil {
+		t.Fatalf("expected ports to be left alone without docker but got %v", ports)
+	}
+}
+
+func TestPortDiscoPollsContainers(t *testing.T) {
+	var list atomic.Value
+	list.Store("[]")
+	portCh := make(chan struct{}, 2)
+	relay := serveTestHandler(t, &handler{
+		sessions:     NewSessions(),
+		ports:        newPortWatcher(logger.Nop, newMockPorts(nil)),
+		procs:        fakeProcesses,
+		docker:       newDockerClient(fakeDockerFunc(t, func() string { return list.Load().(string) })),
+		onPortUpdate: func() { portCh <- struct{}{} },
+	})
+	conn, _, err := relay.dial("/portdisco")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	err = conn.WriteJSON(&wsMessage{Type: "setWorkspaceFolders", Folders: []string{"/work/app"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-portCh
+	<-portCh
+
+	// the container starts without any change of the
+	// listening ports, as with an engine in a VM.
+	list.Store(fakeContainers)
+	conn.SetReadDeadline(time.Now().Add(dockerPollInterval + dockerCacheTTL + 2*time.Second))
+	var msg wsMessage
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != "newPort" || msg.Port != 8080 || msg.Container == nil || msg.Container.Name != "app-web-1" {
+		t.Fatalf("expected a new port of app-web-1 but got %+v", msg)
+	}
+}
+
+func TestAttributeContainer(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses unix paths")
+	}
+	ci := &containerInfo{Name: "app-web-1", WorkingDir: "/work/app"}
+	a := attributeContainer([]string{"/work/app"}, ci)
+	if a == nil || a.Reason != attributedContainer || a.Folder != "/work/app" || a.Detail != "app-web-1" {
+		t.Fatalf("unexpected attribution %+v", a)
+	}
+	if a := attributeContainer([]string{"/work/other"}, ci); a != nil {
+		t.Fatalf("expected no attribution but got %+v", a)
+	}
+}
@@ -1,5 +1,12 @@
 package handler
 
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+)
+
 // ErrorTypes for signaling
 // invalid states to the VSCode
 // extension.
@@ -22,6 +29,51 @@ const (
 	// FlatpakRequiresRestart indicates that the flatpak
 	// container needs to be fully restarted
 	FlatpakRequiresRestart = "FLATPAK_REQUIRES_RESTART"
+	// PeerNotFound means the requested peer
+	// is not in the network map
+	PeerNotFound = "PEER_NOT_FOUND"
+	// NoFileTarget means the peer can't
+	// receive files with Taildrop
+	NoFileTarget = "NO_FILE_TARGET"
+	// FileNotFound means the requested
+	// received file doesn't exist
+	FileNotFound = "FILE_NOT_FOUND"
+	// ExitNodeNotFound means the requested peer
+	// can't be used as an exit node
+	ExitNodeNotFound = "EXIT_NODE_NOT_FOUND"
+	// NoExitNodeSuggestion means tailscaled has
+	// no exit node to suggest
+	NoExitNodeSuggestion = "NO_EXIT_NODE_SUGGESTION"
+	// ProfileNotFound means the requested
+	// login profile doesn't exist
+	ProfileNotFound = "PROFILE_NOT_FOUND"
+	// PeerOffline means the requested peer
+	// is offline and can't be connected to
+	PeerOffline = "PEER_OFFLINE"
+	// ForwardNotFound means the requested
+	// port forward doesn't exist
+	ForwardNotFound = "FORWARD_NOT_FOUND"
+	// LocalPortInUse means the local port of
+	// a port forward is taken
+	LocalPortInUse = "LOCAL_PORT_IN_USE"
+	// InvalidSSHConfigPath means the ssh config
+	// to write to is not an absolute path
+	InvalidSSHConfigPath = "INVALID_SSH_CONFIG_PATH"
+	// HealthWarning is a health problem
+	// reported by tailscaled
+	HealthWarning = "HEALTH_WARNING"
+	// KeyExpiring means the key of this
+	// machine expires soon
+	KeyExpiring = "KEY_EXPIRING"
+	// KeyExpired means the key of this machine
+	// expired and it needs to log in again
+	KeyExpired = "KEY_EXPIRED"
+	// DNSMisconfigured means tailscaled can't
+	// manage the DNS settings of this machine
+	DNSMisconfigured = "DNS_MISCONFIGURED"
+	// LockedOut means this machine is locked out
+	// by tailnet lock until its key is signed
+	LockedOut = "LOCKED_OUT"
 )
 
 // RelayError is a wrapper for Error
@@ -42,4 +94,23 @@ func (RelayError) Error() string {
 type Error struct {
 	Type    string `json:",omitempty"`
 	Command string `json:",omitempty"`
+	// Code is the warnable code of health warnings.
+	Code string `json:",omitempty"`
+	// Message describes the problem, Hint how to fix
+	// it and URL where, such as the admin console.
+	Message string `json:",omitempty"`
+	Hint    string `json:",omitempty"`
+	URL     string `json:",omitempty"`
+}
+
+// tailscaledError maps tailscaled not running to a RelayError.
+func tailscaledError(err error) error {
+	var oe *net.OpError
+	if errors.As(err, &oe) && oe.Op == "dial" {
+		return RelayError{
+			statusCode: http.StatusServiceUnavailable,
+			Errors:     []Error{{Type: NotRunning}},
+		}
+	}
+	return fmt.Errorf("error talking to tailscaled: %w", err)
 }
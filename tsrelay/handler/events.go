@@ -0,0 +1,359 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"tailscale.com/client/local"
+	"tailscale.com/health"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/netmap"
+)
+
+const (
+	// eventsRetryInterval is how often to try to watch the
+	// IPN bus again while tailscaled is unreachable.
+	eventsRetryInterval = 5 * time.Second
+	// serveConfigCheckInterval is how often to check for serve
+	// config changes, which are not announced on the IPN bus.
+	serveConfigCheckInterval = 5 * time.Second
+)
+
+// Types of event messages.
+const (
+	eventBackendState = "backendState"
+	eventPeerAdded    = "peerAdded"
+	eventPeerChanged  = "peerChanged"
+	eventPeerRemoved  = "peerRemoved"
+	eventServeConfig  = "serveConfig"
+	eventHealth       = "healthWarning"
+	eventHealthOK     = "healthWarningCleared"
+	eventError        = "error"
+)
+
+// eventMessage is a change of the tailnet state sent
+// over the /events websocket. Which fields are set
+// depends on the Type of the message.
+type eventMessage struct {
+	Type string `json:"type"`
+	// State is the new backend state in backendState messages.
+	State string `json:"state,omitempty"`
+	// Peer is the new or changed peer in peerAdded
+	// and peerChanged messages.
+	Peer *peerStatus `json:"peer,omitempty"`
+	// ID is the peer in peerRemoved messages.
+	ID tailcfg.StableNodeID `json:"id,omitempty"`
+	// ServeConfig is the new config in serveConfig messages,
+	// or null if the config was removed.
+	ServeConfig *ipn.ServeConfig `json:"serveConfig,omitempty"`
+	// Warning is the new or changed warning in healthWarning
+	// messages and Code the cleared one in healthWarningCleared
+	// messages.
+	Warning *health.UnhealthyState `json:"warning,omitempty"`
+	Code    health.WarnableCode    `json:"code,omitempty"`
+	// Errors explain error messages, such as tailscaled not running.
+	Errors []Error `json:"errors,omitempty"`
+}
+
+// ipnBusWatcher is a stream of IPN bus notifications,
+// as implemented by local.IPNBusWatcher.
+type ipnBusWatcher interface {
+	Next() (ipn.Notify, error)
+	Close() error
+}
+
+// ipnBusSource is implemented by LocalClients that can't return a
+// local.IPNBusWatcher, which can only be made by a local.Client.
+type ipnBusSource interface {
+	watchIPNBus(ctx context.Context, mask ipn.NotifyWatchOpt) (ipnBusWatcher, error)
+}
+
+// watchIPNBus watches the IPN bus of the given LocalClient.
+func watchIPNBus(ctx context.Context, lc LocalClient, mask ipn.NotifyWatchOpt) (ipnBusWatcher, error) {
+	switch lc := lc.(type) {
+	case *local.Client:
+		return lc.WatchIPNBus(ctx, mask)
+	case ipnBusSource:
+		return lc.watchIPNBus(ctx, mask)
+	}
+	return nil, fmt.Errorf("%T can't watch the IPN bus", lc)
+}
+
+func (h *handler) eventsHandler(w http.ResponseWriter, r *http.Request) {
+	c, err := h.u.Upgrade(w, r, nil)
+	if err != nil {
+		h.l.Printf("error upgrading to websocket: %v", err)
+		return
+	}
+	defer c.Close()
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go func() {
+		// the client doesn't send anything, reading
+		// only notices when the connection is closed.
+		defer cancel()
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	if err := h.runEvents(ctx, newEventStream(c)); err != nil && ctx.Err() == nil {
+		h.l.Printf("error streaming events: %v", err)
+	}
+}
+
+// eventStream is the state of a single /events connection.
+// It remembers what the client was last told so that only
+// changes are sent.
+type eventStream struct {
+	mu sync.Mutex
+	c  *websocket.Conn
+
+	state    string
+	peers    map[tailcfg.StableNodeID]*peerStatus
+	etag     string
+	sent     bool // whether a serve config was sent yet
+	warnings map[health.WarnableCode]string
+	offline  bool // whether the client was told tailscaled isn't running
+}
+
+func newEventStream(c *websocket.Conn) *eventStream {
+	return &eventStream{c: c}
+}
+
+func (es *eventStream) send(msgs ...eventMessage) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	for _, m := range msgs {
+		if err := es.c.WriteJSON(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *handler) runEvents(ctx context.Context, es *eventStream) error {
+	checkServe := make(chan struct{}, 1)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- h.watchServeConfig(ctx, es, checkServe)
+	}()
+
+	const mask = ipn.NotifyInitialState | ipn.NotifyInitialNetMap |
+		ipn.NotifyInitialHealthState | ipn.NotifyRateLimit | ipn.NotifyNoPrivateKeys
+	for {
+		err := h.watchEvents(ctx, es, mask, checkServe)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		var oe *net.OpError
+		if !errors.As(err, &oe) || oe.Op != "dial" {
+			h.l.VPrintf("error watching the IPN bus: %v", err)
+		}
+		es.mu.Lock()
+		notify := !es.offline
+		es.offline = true
+		es.mu.Unlock()
+		if notify {
+			err := es.send(eventMessage{Type: eventError, Errors: []Error{{Type: NotRunning}}})
+			if err != nil {
+				return err
+			}
+		}
+		t := time.NewTimer(eventsRetryInterval)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case err := <-errc:
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+	}
+}
+
+// watchEvents sends the changes announced on the IPN bus
+// until watching fails.
+func (h *handler) watchEvents(ctx context.Context, es *eventStream, mask ipn.NotifyWatchOpt, checkServe chan<- struct{}) error {
+	w, err := watchIPNBus(ctx, h.lc, mask)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	es.mu.Lock()
+	es.offline = false
+	es.mu.Unlock()
+	for {
+		n, err := w.Next()
+		if err != nil {
+			return err
+		}
+		if err := es.send(es.diff(n)...); err != nil {
+			return err
+		}
+		if n.State != nil || n.NetMap != nil {
+			select {
+			case checkServe <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// watchServeConfig sends serve config changes. The config is
+// checked periodically and whenever the IPN bus was active.
+func (h *handler) watchServeConfig(ctx context.Context, es *eventStream, check <-chan struct{}) error {
+	t := time.NewTicker(serveConfigCheckInterval)
+	defer t.Stop()
+	for {
+		sc, err := h.lc.GetServeConfig(ctx)
+		if err == nil {
+			if msg, ok := es.diffServeConfig(sc); ok {
+				if err := es.send(msg); err != nil {
+					return err
+				}
+			}
+		} else if ctx.Err() == nil {
+			h.l.VPrintf("error getting serve config: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		case <-check:
+		}
+	}
+}
+
+// diff returns the messages describing what
+// changed with the given notification.
+func (es *eventStream) diff(n ipn.Notify) []eventMessage {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	var msgs []eventMessage
+	if n.State != nil && n.State.String() != es.state {
+		es.state = n.State.String()
+		msgs = append(msgs, eventMessage{Type: eventBackendState, State: es.state})
+	}
+	if n.NetMap != nil {
+		msgs = append(msgs, es.diffPeersLocked(peersOfNetMap(n.NetMap))...)
+	}
+	if n.Health != nil {
+		msgs = append(msgs, es.diffHealthLocked(n.Health)...)
+	}
+	return msgs
+}
+
+func (es *eventStream) diffPeersLocked(peers map[tailcfg.StableNodeID]*peerStatus) []eventMessage {
+	var msgs []eventMessage
+	for _, id := range slices.Sorted(maps.Keys(peers)) {
+		p := peers[id]
+		old, ok := es.peers[id]
+		switch {
+		case !ok:
+			msgs = append(msgs, eventMessage{Type: eventPeerAdded, Peer: p})
+		case !old.equal(p):
+			msgs = append(msgs, eventMessage{Type: eventPeerChanged, Peer: p})
+		}
+	}
+	for _, id := range slices.Sorted(maps.Keys(es.peers)) {
+		if _, ok := peers[id]; !ok {
+			msgs = append(msgs, eventMessage{Type: eventPeerRemoved, ID: id})
+		}
+	}
+	es.peers = peers
+	return msgs
+}
+
+func (es *eventStream) diffHealthLocked(st *health.State) []eventMessage {
+	var msgs []eventMessage
+	warnings := make(map[health.WarnableCode]string, len(st.Warnings))
+	for _, code := range slices.Sorted(maps.Keys(st.Warnings)) {
+		w := st.Warnings[code]
+		warnings[code] = w.ETag
+		if etag, ok := es.warnings[code]; !ok || etag != w.ETag {
+			msgs = append(msgs, eventMessage{Type: eventHealth, Warning: &w})
+		}
+	}
+	for _, code := range slices.Sorted(maps.Keys(es.warnings)) {
+		if _, ok := warnings[code]; !ok {
+			msgs = append(msgs, eventMessage{Type: eventHealthOK, Code: code})
+		}
+	}
+	es.warnings = warnings
+	return msgs
+}
+
+// diffServeConfig returns a message if the serve
+// config changed since it was last sent.
+func (es *eventStream) diffServeConfig(sc *ipn.ServeConfig) (eventMessage, bool) {
+	var etag string
+	if sc != nil {
+		etag = sc.ETag
+		if etag == "" {
+			// older versions of tailscaled don't send an ETag
+			var err error
+			if etag, err = serveConfigETag(sc); err != nil {
+				return eventMessage{}, false
+			}
+		}
+	}
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if es.sent && etag == es.etag {
+		return eventMessage{}, false
+	}
+	es.sent = true
+	es.etag = etag
+	return eventMessage{Type: eventServeConfig, ServeConfig: sc}, true
+}
+
+// peersOfNetMap returns the peers of a netmap
+// the same way GET /peers lists them.
+func peersOfNetMap(nm *netmap.NetworkMap) map[tailcfg.StableNodeID]*peerStatus {
+	suffix := nm.MagicDNSSuffix()
+	peers := make(map[tailcfg.StableNodeID]*peerStatus, len(nm.Peers))
+	for _, n := range nm.Peers {
+		if n.Hostinfo().ShareeNode() || strings.Contains(n.Name(), "mullvad.ts.net") {
+			continue
+		}
+		peers[n.StableID()] = peerStatusOfNode(n, suffix)
+	}
+	return peers
+}
+
+// peerStatusOfNode is like newPeerStatus for a node of a netmap.
+func peerStatusOfNode(n tailcfg.NodeView, magicDNSSuffix string) *peerStatus {
+	ps := &ipnstate.PeerStatus{
+		ID:           n.StableID(),
+		HostName:     n.Hostinfo().Hostname(),
+		DNSName:      n.Name(),
+		Online:       n.Online().Get(),
+		SSH_HostKeys: n.Hostinfo().SSH_HostKeys().AsSlice(),
+	}
+	for _, pfx := range n.Addresses().All() {
+		if pfx.IsSingleIP() {
+			ps.TailscaleIPs = append(ps.TailscaleIPs, pfx.Addr())
+		}
+	}
+	return newPeerStatus(ps, magicDNSSuffix)
+}
+
+func (p *peerStatus) equal(o *peerStatus) bool {
+	return p.DNSName == o.DNSName && p.ServerName == o.ServerName && p.Online == o.Online &&
+		p.HostName == o.HostName && slices.Equal(p.TailscaleIPs, o.TailscaleIPs) &&
+		p.IsExternal == o.IsExternal && p.SSHEnabled == o.SSHEnabled && p.Address == o.Address
+}
@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"tailscale.com/ipn"
+)
+
+const eventsScenario = `{
+	"Status": {
+		"BackendState": "Running",
+		"Self": {"ID": "nSelf", "DNSName": "self.example.ts.net.", "Online": true},
+		"Peer": {
+			"nodekey:0000000000000000000000000000000000000000000000000000000000000001": {
+				"ID": "nLaptop", "DNSName": "laptop.example.ts.net.", "Online": true, "TailscaleIPs": ["100.64.0.1"]
+			},
+			"nodekey:0000000000000000000000000000000000000000000000000000000000000002": {
+				"ID": "nServer", "DNSName": "server.example.ts.net.", "Online": true, "TailscaleIPs": ["100.64.0.2"]
+			}
+		}
+	},
+	"ServeConfig": {"TCP": {"443": {"HTTPS": true}}},
+	"States": [{
+		"Name": "changed",
+		"Status": {
+			"BackendState": "Running",
+			"Self": {"ID": "nSelf", "DNSName": "self.example.ts.net.", "Online": true},
+			"Peer": {
+				"nodekey:0000000000000000000000000000000000000000000000000000000000000001": {
+					"ID": "nLaptop", "DNSName": "laptop.example.ts.net.", "Online": false, "TailscaleIPs": ["100.64.0.1"]
+				},
+				"nodekey:0000000000000000000000000000000000000000000000000000000000000003": {
+					"ID": "nPhone", "DNSName": "phone.example.ts.net.", "Online": true, "TailscaleIPs": ["100.64.0.3"]
+				}
+			}
+		},
+		"Health": {"Warnings": {"update-available": {"WarnableCode": "update-available", "Title": "Update available", "ETag": "1"}}}
+	}, {
+		"Name": "down",
+		"MockOffline": true
+	}]
+}`
+
+func TestEvents(t *testing.T) {
+	m, relay := newTestRelay(t, eventsScenario)
+	conn, _, err := relay.dial("/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	// expect reads messages until all wanted ones were
+	// seen, in any order as the serve config is checked
+	// concurrently with the IPN bus.
+	expect := func(want ...string) {
+		t.Helper()
+		conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		missing := make(map[string]bool)
+		for _, w := range want {
+			missing[w] = true
+		}
+		for len(missing) > 0 {
+			var msg eventMessage
+			if err := conn.ReadJSON(&msg); err != nil {
+				t.Fatalf("error waiting for %v: %v", missing, err)
+			}
+			key := msg.Type
+			switch {
+			case msg.Peer != nil:
+				key += ":" + string(msg.Peer.ID)
+				if msg.Type == eventPeerChanged && msg.Peer.Online {
+					key += ":online"
+				}
+			case msg.ID != "":
+				key += ":" + string(msg.ID)
+			case msg.State != "":
+				key += ":" + msg.State
+			case msg.Warning != nil:
+				key += ":" + string(msg.Warning.WarnableCode)
+			case len(msg.Errors) > 0:
+				key += ":" + msg.Errors[0].Type
+			}
+			if !missing[key] {
+				t.Fatalf("unexpected message %q while waiting for %v", key, missing)
+			}
+			delete(missing, key)
+		}
+	}
+
+	expect("backendState:Running", "peerAdded:nLaptop", "peerAdded:nServer", eventServeConfig)
+
+	if err := m.SetServeConfig(context.Background(), &ipn.ServeConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.jump(mockJump{State: "changed"}); err != nil {
+		t.Fatal(err)
+	}
+	expect("peerChanged:nLaptop", "peerAdded:nPhone", "peerRemoved:nServer", "healthWarning:update-available", eventServeConfig)
+
+	if _, err := m.jump(mockJump{Next: true}); err != nil {
+		t.Fatal(err)
+	}
+	expect("error:" + NotRunning)
+}
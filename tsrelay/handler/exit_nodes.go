@@ -0,0 +1,369 @@
+package handler
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"tailscale.com/client/tailscale"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+)
+
+// exitNode is a peer that can be used as an exit node.
+type exitNode struct {
+	ID           tailcfg.StableNodeID
+	DNSName      string
+	ServerName   string
+	TailscaleIPs []netip.Addr
+	Online       bool
+	// Active reports whether this is the current exit node.
+	Active  bool
+	Mullvad bool
+	// Location is where the exit node is, which is
+	// always known for Mullvad exit nodes.
+	Location *tailcfg.Location `json:",omitempty"`
+}
+
+// exitNodeCountry groups the Mullvad exit nodes of a country.
+type exitNodeCountry struct {
+	Name   string
+	Code   string
+	Cities []*exitNodeCity
+}
+
+// exitNodeCity groups the Mullvad exit nodes of a city.
+type exitNodeCity struct {
+	Name  string
+	Code  string
+	Nodes []*exitNode
+}
+
+type getExitNodesResponse struct {
+	// Current is the exit node in use, if any. It may not be
+	// one of the listed nodes if it went out of the netmap.
+	Current        *exitNode `json:",omitempty"`
+	AllowLANAccess bool
+	// Nodes are the exit nodes of the tailnet
+	// and Mullvad those of Mullvad by location.
+	Nodes   []*exitNode
+	Mullvad []*exitNodeCountry
+	Errors  []Error `json:",omitempty"`
+}
+
+// setExitNodeRequest selects the exit node by its stable ID.
+// AllowLANAccess is left as is when omitted.
+type setExitNodeRequest struct {
+	ID             tailcfg.StableNodeID
+	AllowLANAccess *bool
+}
+
+func (h *handler) getExitNodesHandler(w http.ResponseWriter, r *http.Request) {
+	s, err := h.getExitNodes(r.Context())
+	if err != nil {
+		var re RelayError
+		if errors.As(err, &re) {
+			w.WriteHeader(re.statusCode)
+			json.NewEncoder(w).Encode(re)
+			return
+		}
+		h.l.Println("error getting exit nodes:", err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	json.NewEncoder(w).Encode(s)
+}
+
+func (h *handler) getExitNodes(ctx context.Context) (*getExitNodesResponse, error) {
+	if h.requiresRestart {
+		return nil, RelayError{
+			statusCode: http.StatusPreconditionFailed,
+			Errors:     []Error{{Type: FlatpakRequiresRestart}},
+		}
+	}
+	st, err := h.lc.Status(ctx)
+	if err != nil {
+		return nil, tailscaledError(err)
+	}
+	prefs, err := h.lc.GetPrefs(ctx)
+	if err != nil {
+		return nil, tailscaledError(err)
+	}
+
+	s := &getExitNodesResponse{
+		AllowLANAccess: prefs.ExitNodeAllowLANAccess,
+		Nodes:          []*exitNode{},
+		Mullvad:        []*exitNodeCountry{},
+	}
+	if st.BackendState == "NeedsLogin" || (st.Self != nil && !st.Self.Online) {
+		s.Errors = append(s.Errors, Error{Type: Offline})
+	}
+
+	var mullvad []*exitNode
+	for _, p := range st.Peer {
+		if p.ShareeNode {
+			continue
+		}
+		active := isCurrentExitNode(p, prefs)
+		if !p.ExitNodeOption && !active {
+			continue
+		}
+		n := newExitNode(p, active)
+		if active {
+			s.Current = n
+		}
+		if n.Mullvad {
+			mullvad = append(mullvad, n)
+		} else {
+			s.Nodes = append(s.Nodes, n)
+		}
+	}
+	if s.Current == nil && !prefs.ExitNodeID.IsZero() {
+		s.Current = &exitNode{ID: prefs.ExitNodeID, Active: true}
+	}
+
+	slices.SortFunc(s.Nodes, func(a, b *exitNode) int {
+		if a.Online != b.Online {
+			if a.Online {
+				return -1
+			}
+			return 1
+		}
+		return cmp.Compare(a.ServerName, b.ServerName)
+	})
+	s.Mullvad = append(s.Mullvad, groupByLocation(mullvad)...)
+	return s, nil
+}
+
+// isCurrentExitNode reports whether the prefs select p as the exit node.
+func isCurrentExitNode(p *ipnstate.PeerStatus, prefs *ipn.Prefs) bool {
+	if !prefs.ExitNodeID.IsZero() {
+		return p.ID == prefs.ExitNodeID
+	}
+	return prefs.ExitNodeIP.IsValid() && slices.Contains(p.TailscaleIPs, prefs.ExitNodeIP)
+}
+
+func newExitNode(p *ipnstate.PeerStatus, active bool) *exitNode {
+	ps := newPeerStatus(p, "")
+	return &exitNode{
+		ID:           p.ID,
+		DNSName:      ps.DNSName,
+		ServerName:   ps.ServerName,
+		TailscaleIPs: p.TailscaleIPs,
+		Online:       p.Online,
+		Active:       active,
+		Mullvad:      strings.Contains(p.DNSName, "mullvad.ts.net"),
+		Location:     p.Location,
+	}
+}
+
+// groupByLocation groups exit nodes by country and city. Countries
+// and cities are sorted by name and the nodes of a city by priority.
+func groupByLocation(nodes []*exitNode) []*exitNodeCountry {
+	var countries []*exitNodeCountry
+	for _, n := range nodes {
+		var loc tailcfg.Location
+		if n.Location != nil {
+			loc = *n.Location
+		}
+		i := slices.IndexFunc(countries, func(c *exitNodeCountry) bool { return c.Code == loc.CountryCode })
+		if i < 0 {
+			countries = append(countries, &exitNodeCountry{Name: loc.Country, Code: loc.CountryCode})
+			i = len(countries) - 1
+		}
+		country := countries[i]
+		j := slices.IndexFunc(country.Cities, func(c *exitNodeCity) bool { return c.Code == loc.CityCode })
+		if j < 0 {
+			country.Cities = append(country.Cities, &exitNodeCity{Name: loc.City, Code: loc.CityCode})
+			j = len(country.Cities) - 1
+		}
+		country.Cities[j].Nodes = append(country.Cities[j].Nodes, n)
+	}
+
+	slices.SortFunc(countries, func(a, b *exitNodeCountry) int { return cmp.Compare(a.Name, b.Name) })
+	for _, country := range countries {
+		slices.SortFunc(country.Cities, func(a, b *exitNodeCity) int { return cmp.Compare(a.Name, b.Name) })
+		for _, city := range country.Cities {
+			slices.SortFunc(city.Nodes, func(a, b *exitNode) int {
+				if c := cmp.Compare(b.priority(), a.priority()); c != 0 {
+					return c
+				}
+				return cmp.Compare(a.ServerName, b.ServerName)
+			})
+		}
+	}
+	return countries
+}
+
+func (n *exitNode) priority() int {
+	if n.Location == nil {
+		return 0
+	}
+	return n.Location.Priority
+}
+
+func (h *handler) getSuggestedExitNodeHandler(w http.ResponseWriter, r *http.Request) {
+	n, err := h.getSuggestedExitNode(r.Context())
+	if err != nil {
+		var re RelayError
+		if errors.As(err, &re) {
+			w.WriteHeader(re.statusCode)
+			json.NewEncoder(w).Encode(re)
+			return
+		}
+		h.l.Println("error suggesting exit node:", err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	json.NewEncoder(w).Encode(n)
+}
+
+// getSuggestedExitNode returns the exit node LocalAPI suggests,
+// which is usually the one with the lowest latency.
+func (h *handler) getSuggestedExitNode(ctx context.Context) (*exitNode, error) {
+	res, err := h.lc.SuggestExitNode(ctx)
+	if err != nil {
+		return nil, tailscaledError(err)
+	}
+	if res.ID.IsZero() {
+		return nil, RelayError{
+			statusCode: http.StatusNotFound,
+			Errors:     []Error{{Type: NoExitNodeSuggestion}},
+		}
+	}
+	st, err := h.lc.Status(ctx)
+	if err != nil {
+		return nil, tailscaledError(err)
+	}
+	prefs, err := h.lc.GetPrefs(ctx)
+	if err != nil {
+		return nil, tailscaledError(err)
+	}
+	for _, p := range st.Peer {
+		if p.ID == res.ID {
+			return newExitNode(p, isCurrentExitNode(p, prefs)), nil
+		}
+	}
+	// the suggestion is based on a newer netmap than the status
+	n := &exitNode{
+		ID:         res.ID,
+		DNSName:    strings.TrimSuffix(res.Name, "."),
+		ServerName: strings.Split(res.Name, ".")[0],
+		Mullvad:    strings.Contains(res.Name, "mullvad.ts.net"),
+		Active:     res.ID == prefs.ExitNodeID,
+	}
+	if res.Location.Valid() {
+		n.Location = res.Location.AsStruct()
+	}
+	return n, nil
+}
+
+func (h *handler) setExitNodeHandler(w http.ResponseWriter, r *http.Request) {
+	var req setExitNodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("error decoding request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	if req.ID.IsZero() {
+		http.Error(w, "ID is required, use DELETE to clear the exit node", http.StatusBadRequest)
+		return
+	}
+	n, err := h.setExitNode(r.Context(), req)
+	if err != nil {
+		var re RelayError
+		if errors.As(err, &re) {
+			w.WriteHeader(re.statusCode)
+			json.NewEncoder(w).Encode(re)
+			return
+		}
+		h.l.Println("error setting exit node:", err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	json.NewEncoder(w).Encode(n)
+}
+
+// setExitNode routes internet traffic through the given peer,
+// which must be offered and approved as an exit node.
+func (h *handler) setExitNode(ctx context.Context, req setExitNodeRequest) (*exitNode, error) {
+	st, err := h.lc.Status(ctx)
+	if err != nil {
+		return nil, tailscaledError(err)
+	}
+	var p *ipnstate.PeerStatus
+	for _, ps := range st.Peer {
+		if ps.ID == req.ID && ps.ExitNodeOption {
+			p = ps
+			break
+		}
+	}
+	if p == nil {
+		return nil, RelayError{
+			statusCode: http.StatusNotFound,
+			Errors:     []Error{{Type: ExitNodeNotFound}},
+		}
+	}
+
+	mp := &ipn.MaskedPrefs{
+		Prefs:         ipn.Prefs{ExitNodeID: req.ID},
+		ExitNodeIDSet: true,
+		ExitNodeIPSet: true,
+	}
+	// the CLI takes the IP or name rather than the stable ID
+	arg := strings.TrimSuffix(p.DNSName, ".")
+	if len(p.TailscaleIPs) > 0 {
+		arg = p.TailscaleIPs[0].String()
+	}
+	cmd := fmt.Sprintf("sudo tailscale set --exit-node=%s", arg)
+	if req.AllowLANAccess != nil {
+		mp.ExitNodeAllowLANAccess = *req.AllowLANAccess
+		mp.ExitNodeAllowLANAccessSet = true
+		cmd += fmt.Sprintf(" --exit-node-allow-lan-access=%t", *req.AllowLANAccess)
+	}
+	if err := h.editPrefs(ctx, mp, cmd); err != nil {
+		return nil, err
+	}
+	return newExitNode(p, true), nil
+}
+
+func (h *handler) clearExitNodeHandler(w http.ResponseWriter, r *http.Request) {
+	mp := &ipn.MaskedPrefs{ExitNodeIDSet: true, ExitNodeIPSet: true}
+	if err := h.editPrefs(r.Context(), mp, "sudo tailscale set --exit-node="); err != nil {
+		var re RelayError
+		if errors.As(err, &re) {
+			w.WriteHeader(re.statusCode)
+			json.NewEncoder(w).Encode(re)
+			return
+		}
+		h.l.Println("error clearing exit node:", err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.Write([]byte(`{}`))
+}
+
+// editPrefs edits the prefs, asking the user to run
+// cmd when tailscaled denies the change.
+func (h *handler) editPrefs(ctx context.Context, mp *ipn.MaskedPrefs, cmd string) error {
+	_, err := h.lc.EditPrefs(ctx, mp)
+	if err == nil {
+		return nil
+	}
+	if tailscale.IsAccessDeniedError(err) {
+		return RelayError{
+			statusCode: http.StatusForbidden,
+			Errors:     []Error{{Type: RequiresSudo, Command: cmd}},
+		}
+	}
+	return tailscaledError(err)
+}
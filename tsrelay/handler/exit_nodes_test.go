@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+const exitNodeProfile = `{
+	"Status": {
+		"BackendState": "Running",
+		"CurrentTailnet": {"MagicDNSSuffix": "example.ts.net"},
+		"Self": {"ID": "nSelf", "Online": true},
+		"Peer": {
+			"nodekey:0000000000000000000000000000000000000000000000000000000000000001": {
+				"ID": "nRouter", "DNSName": "router.example.ts.net.", "Online": true, "ExitNodeOption": true,
+				"TailscaleIPs": ["100.64.0.1"]
+			},
+			"nodekey:0000000000000000000000000000000000000000000000000000000000000002": {
+				"ID": "nLaptop", "DNSName": "laptop.example.ts.net.", "Online": true
+			},
+			"nodekey:0000000000000000000000000000000000000000000000000000000000000003": {
+				"ID": "nBerlin1", "DNSName": "de-ber-wg-001.mullvad.ts.net.", "Online": true, "ExitNodeOption": true,
+				"Location": {"Country": "Germany", "CountryCode": "DE", "City": "Berlin", "CityCode": "BER", "Priority": 10}
+			},
+			"nodekey:0000000000000000000000000000000000000000000000000000000000000004": {
+				"ID": "nBerlin2", "DNSName": "de-ber-wg-002.mullvad.ts.net.", "Online": true, "ExitNodeOption": true,
+				"Location": {"Country": "Germany", "CountryCode": "DE", "City": "Berlin", "CityCode": "BER", "Priority": 20}
+			},
+			"nodekey:0000000000000000000000000000000000000000000000000000000000000005": {
+				"ID": "nParis", "DNSName": "fr-par-wg-001.mullvad.ts.net.", "Online": true, "ExitNodeOption": true,
+				"Location": {"Country": "France", "CountryCode": "FR", "City": "Paris", "CityCode": "PAR"}
+			}
+		}
+	}
+}`
+
+func TestExitNodes(t *testing.T) {
+	m, relay := newTestRelay(t, exitNodeProfile)
+	list := func() getExitNodesResponse {
+		var s getExitNodesResponse
+		if err := json.NewDecoder(relay.do(http.MethodGet, "/exit-nodes", "").Body).Decode(&s); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	s := list()
+	if s.Current != nil || len(s.Nodes) != 1 || s.Nodes[0].ID != "nRouter" {
+		t.Fatalf("expected only the router as a tailnet exit node but got %+v", s)
+	}
+	if len(s.Mullvad) != 2 || s.Mullvad[0].Code != "FR" || s.Mullvad[1].Code != "DE" {
+		t.Fatalf("expected Mullvad exit nodes by country name but got %+v", s.Mullvad)
+	}
+	berlin := s.Mullvad[1].Cities[0]
+	if berlin.Name != "Berlin" || len(berlin.Nodes) != 2 || berlin.Nodes[0].ID != "nBerlin2" {
+		t.Fatalf("expected the Berlin exit nodes by priority but got %+v", berlin)
+	}
+
+	var suggested exitNode
+	json.NewDecoder(relay.do(http.MethodGet, "/exit-nodes/suggested", "").Body).Decode(&suggested)
+	if suggested.ID != "nRouter" || suggested.Mullvad {
+		t.Fatalf("expected the tailnet exit node to be suggested but got %+v", suggested)
+	}
+
+	resp := relay.do(http.MethodPut, "/exit-node", `{"ID": "nLaptop"}`)
+	var re RelayError
+	json.NewDecoder(resp.Body).Decode(&re)
+	if resp.StatusCode != http.StatusNotFound || len(re.Errors) != 1 || re.Errors[0].Type != ExitNodeNotFound {
+		t.Fatalf("expected peers without the exit node option to be rejected but got %d %+v", resp.StatusCode, re)
+	}
+
+	if resp := relay.do(http.MethodPut, "/exit-node", `{"ID": "nBerlin1", "AllowLANAccess": true}`); resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status %d", resp.StatusCode)
+	}
+	s = list()
+	if s.Current == nil || s.Current.ID != "nBerlin1" || !s.Current.Active || !s.AllowLANAccess {
+		t.Fatalf("expected the Berlin exit node to be used but got %+v", s)
+	}
+	st, _ := m.Status(t.Context())
+	if st.ExitNodeStatus == nil || st.ExitNodeStatus.ID != "nBerlin1" {
+		t.Fatalf("expected the status to reflect the exit node but got %+v", st.ExitNodeStatus)
+	}
+
+	if resp := relay.do(http.MethodDelete, "/exit-node", ""); resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status %d", resp.StatusCode)
+	}
+	if s := list(); s.Current != nil || !s.AllowLANAccess {
+		t.Fatalf("expected no exit node but got %+v", s.Current)
+	}
+}
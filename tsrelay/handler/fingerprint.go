@@ -0,0 +1,241 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// probeTimeout bounds how long a new port is probed for.
+	probeTimeout = 3 * time.Second
+	// tlsProbeTimeout bounds the TLS handshake. Servers that don't
+	// react to a ClientHello within it are assumed to be silent
+	// and not probed any further.
+	tlsProbeTimeout = time.Second
+	// maxProbeBody is how much of the response body is
+	// read when looking for framework markers.
+	maxProbeBody = 64 << 10
+)
+
+// Frameworks that can be recognized by probing.
+const (
+	frameworkVite    = "vite"
+	frameworkNext    = "nextjs"
+	frameworkWebpack = "webpack-dev-server"
+	frameworkDjango  = "django"
+	frameworkRails   = "rails"
+)
+
+// debuggerProcesses and debuggerArgs recognize debug adapters and
+// runtimes listening for a debugger. Their ports often take a single
+// client and may be held up by a probe, so they aren't probed.
+var (
+	debuggerProcesses = []string{"dlv", "dlv-dap", "gdbserver", "lldb-server", "rdbg"}
+	debuggerArgs      = []string{"--inspect", "--remote-debugging-port", "-agentlib:jdwp", "debugpy"}
+)
+
+// isDebugger reports whether the port belongs to a debugger.
+func isDebugger(kp *knownPort) bool {
+	if slices.Contains(debuggerProcesses, kp.Process) {
+		return true
+	}
+	for _, arg := range debuggerArgs {
+		if strings.Contains(kp.Cmdline, arg) {
+			return true
+		}
+	}
+	return false
+}
+
+// serviceFingerprint describes what is running on a port.
+type serviceFingerprint struct {
+	// HTTP reports whether the port speaks HTTP.
+	HTTP bool `json:"http"`
+	// TLS reports whether the port expects TLS.
+	TLS bool `json:"tls"`
+	// WebSocket reports whether the port accepted a websocket
+	// upgrade on the HMR path of the framework, or on / if
+	// the framework is unknown.
+	WebSocket bool `json:"websocket"`
+	// Framework is the detected dev framework, if any.
+	Framework string `json:"framework,omitempty"`
+	// Server is the Server header of the response.
+	Server string `json:"server,omitempty"`
+	// HMRPath is the path the framework serves hot
+	// module reloading on, if it uses websockets.
+	HMRPath string `json:"hmrPath,omitempty"`
+	// Suggested are the serve defaults for the port.
+	Suggested *serveRequest `json:"suggested,omitempty"`
+}
+
+// probePort connects to the given address and fingerprints the
+// service behind it. Ports that don't accept connections or time
+// out are reported as non-HTTP.
+func probePort(ctx context.Context, hostPort string) *serviceFingerprint {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	fp := &serviceFingerprint{}
+	var responsive bool
+	fp.TLS, responsive = probeTLS(ctx, hostPort)
+	if !responsive {
+		fp.Suggested = suggestServe(fp, hostPort)
+		return fp
+	}
+	scheme := "http"
+	if fp.TLS {
+		scheme = "https"
+	}
+	c := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			DisableKeepAlives: true,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, scheme+"://"+hostPort+"/", nil)
+	if err != nil {
+		return fp
+	}
+	req.Header.Set("Accept", "text/html,*/*")
+	resp, err := c.Do(req)
+	if err == nil {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxProbeBody))
+		fp.HTTP = true
+		fp.Server = resp.Header.Get("Server")
+		fp.Framework, fp.HMRPath = detectFramework(resp.Header, body)
+		wsPath := fp.HMRPath
+		if wsPath == "" {
+			wsPath = "/"
+		}
+		fp.WebSocket = probeWebSocket(ctx, hostPort, fp.TLS, wsPath, fp.Framework)
+	}
+	fp.Suggested = suggestServe(fp, hostPort)
+	return fp
+}
+
+// probeTLS reports whether the port completes a TLS handshake
+// and whether it responded at all. Plain HTTP servers and most
+// other protocols reject a ClientHello right away.
+func probeTLS(ctx context.Context, hostPort string) (ok, responsive bool) {
+	ctx, cancel := context.WithTimeout(ctx, tlsProbeTimeout)
+	defer cancel()
+	d := &tls.Dialer{Config: &tls.Config{InsecureSkipVerify: true}}
+	conn, err := d.DialContext(ctx, "tcp", hostPort)
+	if err != nil {
+		return false, ctx.Err() == nil
+	}
+	conn.Close()
+	return true, true
+}
+
+// detectFramework recognizes common dev servers from response headers
+// and markers in the body and returns the framework and its HMR path.
+func detectFramework(hdr http.Header, body []byte) (framework, hmrPath string) {
+	poweredBy := hdr.Get("X-Powered-By")
+	server := hdr.Get("Server")
+	switch {
+	case bytes.Contains(body, []byte("/@vite/client")):
+		// vite serves HMR on the root path
+		return frameworkVite, "/"
+	case strings.Contains(poweredBy, "Next.js") ||
+		bytes.Contains(body, []byte("__NEXT_DATA__")) ||
+		bytes.Contains(body, []byte("/_next/static")):
+		return frameworkNext, "/_next/webpack-hmr"
+	case bytes.Contains(body, []byte("webpack-dev-server")) ||
+		bytes.Contains(body, []byte("/sockjs-node")):
+		return frameworkWebpack, "/ws"
+	case strings.HasPrefix(server, "WSGIServer") && bytes.Contains(body, []byte("Django")),
+		bytes.Contains(body, []byte("csrfmiddlewaretoken")),
+		strings.Contains(hdr.Get("Set-Cookie"), "csrftoken="):
+		return frameworkDjango, ""
+	case hdr.Get("X-Runtime") != "" && hdr.Get("X-Request-Id") != "",
+		bytes.Contains(body, []byte("Ruby on Rails")),
+		strings.Contains(hdr.Get("Set-Cookie"), "_session") && strings.Contains(server, "Puma"):
+		return frameworkRails, ""
+	}
+	return "", ""
+}
+
+// probeWebSocket reports whether the given path accepts a websocket
+// upgrade. It only completes the handshake and closes the connection.
+func probeWebSocket(ctx context.Context, hostPort string, useTLS bool, path, framework string) bool {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", hostPort)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if useTLS {
+		tc := tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
+		if err := tc.HandshakeContext(ctx); err != nil {
+			return false
+		}
+		conn = tc
+	}
+	key := make([]byte, 16)
+	rand.Read(key)
+	req, err := http.NewRequest(http.MethodGet, "http://"+hostPort+path, nil)
+	if err != nil {
+		return false
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", base64.StdEncoding.EncodeToString(key))
+	if framework == frameworkVite {
+		req.Header.Set("Sec-WebSocket-Protocol", "vite-hmr")
+	}
+	if err := req.Write(conn); err != nil {
+		return false
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusSwitchingProtocols
+}
+
+// suggestServe returns the serve defaults for a fingerprinted port.
+// HTTP services are proxied over https and anything else is forwarded
+// as raw TCP. The serve port is filled in by the caller.
+func suggestServe(fp *serviceFingerprint, hostPort string) *serveRequest {
+	if !fp.HTTP {
+		return &serveRequest{
+			Protocol:  "tcp",
+			LocalPort: hostPort,
+		}
+	}
+	source := "http://" + hostPort
+	if fp.TLS {
+		// dev servers use self-signed certificates
+		source = "https+insecure://" + hostPort
+	}
+	return &serveRequest{
+		Protocol:   "https",
+		Source:     source,
+		MountPoint: "/",
+	}
+}
+
+// String implements fmt.Stringer for logging.
+func (fp *serviceFingerprint) String() string {
+	return fmt.Sprintf("http=%v tls=%v websocket=%v framework=%q", fp.HTTP, fp.TLS, fp.WebSocket, fp.Framework)
+}
@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestProbePort(t *testing.T) {
+	vite := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if websocket.IsWebSocketUpgrade(r) {
+			u := websocket.Upgrader{Subprotocols: []string{"vite-hmr"}}
+			c, err := u.Upgrade(w, r, nil)
+			if err == nil {
+				c.Close()
+			}
+			return
+		}
+		w.Write([]byte(`<script type="module" src="/@vite/client"></script>`))
+	}))
+	t.Cleanup(vite.Close)
+
+	next := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Powered-By", "Next.js")
+		w.Write([]byte(`<html></html>`))
+	}))
+	t.Cleanup(next.Close)
+
+	silent, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { silent.Close() })
+
+	for _, tc := range []struct {
+		name          string
+		addr          string
+		want          serviceFingerprint
+		wantProtocol  string
+		wantSrcPrefix string
+	}{
+		{
+			name:          "vite",
+			addr:          strings.TrimPrefix(vite.URL, "http://"),
+			want:          serviceFingerprint{HTTP: true, WebSocket: true, Framework: frameworkVite, HMRPath: "/"},
+			wantProtocol:  "https",
+			wantSrcPrefix: "http://",
+		},
+		{
+			name:          "next over tls",
+			addr:          strings.TrimPrefix(next.URL, "https://"),
+			want:          serviceFingerprint{HTTP: true, TLS: true, Framework: frameworkNext, HMRPath: "/_next/webpack-hmr"},
+			wantProtocol:  "https",
+			wantSrcPrefix: "https+insecure://",
+		},
+		{
+			name:         "silent tcp",
+			addr:         silent.Addr().String(),
+			wantProtocol: "tcp",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fp := probePort(context.Background(), tc.addr)
+			if fp.HTTP != tc.want.HTTP || fp.TLS != tc.want.TLS || fp.WebSocket != tc.want.WebSocket ||
+				fp.Framework != tc.want.Framework || fp.HMRPath != tc.want.HMRPath {
+				t.Fatalf("expected %v but got %v", &tc.want, fp)
+			}
+			if fp.Suggested == nil || fp.Suggested.Protocol != tc.wantProtocol {
+				t.Fatalf("expected suggested protocol %q but got %+v", tc.wantProtocol, fp.Suggested)
+			}
+			if !strings.HasPrefix(fp.Suggested.Source, tc.wantSrcPrefix) {
+				t.Fatalf("expected suggested source to start with %q but got %q", tc.wantSrcPrefix, fp.Suggested.Source)
+			}
+		})
+	}
+}
@@ -0,0 +1,383 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/tailscale-dev/vscode-tailscale/tsrelay/logger"
+	"tailscale.com/tailcfg"
+)
+
+// forwardDialTimeout bounds how long connecting
+// to the peer of a forward may take.
+const forwardDialTimeout = 10 * time.Second
+
+// Forwards keeps track of the local port forwards to tailnet
+// peers. Forwards live as long as the relay and are torn down
+// with Close when it shuts down.
+type Forwards struct {
+	l        logger.Logger
+	mu       sync.Mutex
+	forwards map[string]*forward
+	closed   bool
+}
+
+// NewForwards returns an empty forward registry.
+func NewForwards(l logger.Logger) *Forwards {
+	return &Forwards{l: l, forwards: make(map[string]*forward)}
+}
+
+// forward listens on a loopback port and pipes
+// every connection to a port of a peer.
+type forward struct {
+	forwardStatus
+	ln   net.Listener
+	dial func(context.Context) (net.Conn, error)
+	// ctx is canceled when the forward is deleted.
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu sync.Mutex
+	// conns are both ends of the open connections.
+	conns map[net.Conn]struct{}
+
+	active, total     atomic.Int64
+	bytesIn, bytesOut atomic.Int64
+}
+
+// forwardStatus is a forward as reported by the API.
+type forwardStatus struct {
+	ID       string
+	PeerID   tailcfg.StableNodeID
+	PeerName string
+	// Host and Port are the address of the peer
+	// connections are forwarded to.
+	Host string
+	Port uint16
+	// LocalAddress is where the forward listens.
+	LocalAddress string
+	LocalPort    uint16
+	Created      time.Time
+	// Connections is the number of open connections
+	// and TotalConnections the number ever accepted.
+	Connections      int64
+	TotalConnections int64
+	// BytesIn were received from the peer
+	// and BytesOut were sent to it.
+	BytesIn  int64
+	BytesOut int64
+}
+
+type getForwardsResponse struct {
+	Forwards []forwardStatus
+}
+
+// createForwardRequest forwards a port of a peer. LocalPort
+// defaults to the same port as on the peer if it is free and
+// to a random one otherwise.
+type createForwardRequest struct {
+	PeerID    tailcfg.StableNodeID
+	Port      uint16
+	LocalPort uint16
+}
+
+func (h *handler) getForwardsHandler(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(getForwardsResponse{Forwards: h.forwards.list()})
+}
+
+func (h *handler) createForwardHandler(w http.ResponseWriter, r *http.Request) {
+	var req createForwardRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("error decoding request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	if req.PeerID == "" || req.Port == 0 {
+		http.Error(w, "PeerID and Port are required", http.StatusBadRequest)
+		return
+	}
+
+	f, err := h.createForward(r.Context(), req)
+	if err != nil {
+		var re RelayError
+		if errors.As(err, &re) {
+			w.WriteHeader(re.statusCode)
+			json.NewEncoder(w).Encode(re)
+			return
+		}
+		h.l.Println("error creating forward:", err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	json.NewEncoder(w).Encode(f)
+}
+
+func (h *handler) createForward(ctx context.Context, req createForwardRequest) (*forwardStatus, error) {
+	peer, err := h.findPeer(ctx, req.PeerID)
+	if err != nil {
+		return nil, err
+	}
+	// dial by IP so that forwards work without MagicDNS
+	host := peer.Address
+	if len(peer.TailscaleIPs) > 0 {
+		host = peer.TailscaleIPs[0].String()
+	}
+
+	lc := h.lc
+	return h.forwards.create(forwardStatus{
+		PeerID:   peer.ID,
+		PeerName: peer.ServerName,
+		Host:     peer.Address,
+		Port:     req.Port,
+	}, req.LocalPort, func(ctx context.Context) (net.Conn, error) {
+		return lc.DialTCP(ctx, host, req.Port)
+	})
+}
+
+func (h *handler) deleteForwardHandler(w http.ResponseWriter, r *http.Request) {
+	if !h.forwards.delete(chi.URLParam(r, "id")) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(RelayError{Errors: []Error{{Type: ForwardNotFound}}})
+		return
+	}
+	w.Write([]byte(`{}`))
+}
+
+// create starts a forward. The same port of a peer is forwarded
+// only once, creating it again returns the existing forward.
+func (fs *Forwards) create(fst forwardStatus, localPort uint16, dial func(context.Context) (net.Conn, error)) (*forwardStatus, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.closed {
+		return nil, errors.New("relay is shutting down")
+	}
+	for _, f := range fs.forwards {
+		if f.PeerID == fst.PeerID && f.Port == fst.Port && (localPort == 0 || localPort == f.LocalPort) {
+			s := f.status()
+			return &s, nil
+		}
+	}
+
+	var ln net.Listener
+	var err error
+	if localPort == 0 {
+		// like VS Code, prefer the port of the peer so
+		// that URLs look the same locally and remotely.
+		ln, err = net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(int(fst.Port))))
+		if err != nil {
+			ln, err = net.Listen("tcp", "127.0.0.1:0")
+		}
+	} else {
+		ln, err = net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(int(localPort))))
+		if err != nil {
+			return nil, RelayError{
+				statusCode: http.StatusConflict,
+				Errors:     []Error{{Type: LocalPortInUse}},
+			}
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error listening: %w", err)
+	}
+
+	addr := ln.Addr().(*net.TCPAddr)
+	fst.ID = newForwardID()
+	fst.LocalAddress = addr.String()
+	fst.LocalPort = uint16(addr.Port)
+	fst.Created = time.Now()
+	ctx, cancel := context.WithCancel(context.Background())
+	f := &forward{
+		forwardStatus: fst,
+		ln:            ln,
+		dial:          dial,
+		ctx:           ctx,
+		cancel:        cancel,
+		conns:         make(map[net.Conn]struct{}),
+	}
+	fs.forwards[f.ID] = f
+	f.wg.Add(1)
+	go f.serve(fs.l)
+	fs.l.Printf("forwarding %s to %s:%d", f.LocalAddress, f.Host, f.Port)
+	s := f.status()
+	return &s, nil
+}
+
+// list returns the forwards by creation time.
+func (fs *Forwards) list() []forwardStatus {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	list := []forwardStatus{}
+	for _, f := range fs.forwards {
+		list = append(list, f.status())
+	}
+	slices.SortFunc(list, func(a, b forwardStatus) int { return a.Created.Compare(b.Created) })
+	return list
+}
+
+// delete stops the given forward and closes its connections.
+// It reports false if the forward did not exist.
+func (fs *Forwards) delete(id string) bool {
+	fs.mu.Lock()
+	f, ok := fs.forwards[id]
+	delete(fs.forwards, id)
+	fs.mu.Unlock()
+	if ok {
+		f.close()
+		fs.l.Printf("stopped forwarding %s to %s:%d", f.LocalAddress, f.Host, f.Port)
+	}
+	return ok
+}
+
+// Close stops every forward and waits for their connections
+// to be closed. Forwards can't be created afterwards.
+func (fs *Forwards) Close() {
+	fs.mu.Lock()
+	fs.closed = true
+	forwards := fs.forwards
+	fs.forwards = make(map[string]*forward)
+	fs.mu.Unlock()
+	for _, f := range forwards {
+		f.close()
+	}
+}
+
+func (f *forward) status() forwardStatus {
+	s := f.forwardStatus
+	s.Connections = f.active.Load()
+	s.TotalConnections = f.total.Load()
+	s.BytesIn = f.bytesIn.Load()
+	s.BytesOut = f.bytesOut.Load()
+	return s
+}
+
+// close stops accepting connections, closes
+// the open ones and waits for them to finish.
+func (f *forward) close() {
+	f.cancel()
+	f.ln.Close()
+	f.mu.Lock()
+	for c := range f.conns {
+		c.Close()
+	}
+	f.mu.Unlock()
+	f.wg.Wait()
+}
+
+func (f *forward) serve(l logger.Logger) {
+	defer f.wg.Done()
+	for {
+		c, err := f.ln.Accept()
+		if err != nil {
+			if f.ctx.Err() == nil {
+				l.Printf("error accepting on %s: %v", f.LocalAddress, err)
+			}
+			return
+		}
+		f.wg.Add(1)
+		go func() {
+			defer f.wg.Done()
+			f.pipe(l, c)
+		}()
+	}
+}
+
+// pipe connects c to the peer and copies in both directions
+// until both sides are done or the forward is closed.
+func (f *forward) pipe(l logger.Logger, c net.Conn) {
+	defer c.Close()
+	if !f.track(c) {
+		return
+	}
+	defer f.untrack(c)
+	f.total.Add(1)
+	f.active.Add(1)
+	defer f.active.Add(-1)
+
+	ctx, cancel := context.WithTimeout(f.ctx, forwardDialTimeout)
+	pc, err := f.dial(ctx)
+	cancel()
+	if err != nil {
+		if f.ctx.Err() == nil {
+			l.Printf("error dialing %s:%d: %v", f.Host, f.Port, err)
+		}
+		return
+	}
+	defer pc.Close()
+	if !f.track(pc) {
+		return
+	}
+	defer f.untrack(pc)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		copyHalf(pc, c, &f.bytesOut)
+	}()
+	go func() {
+		defer wg.Done()
+		copyHalf(c, pc, &f.bytesIn)
+	}()
+	wg.Wait()
+}
+
+// track adds c to the connections closed with the forward.
+// It reports false if the forward was closed already.
+func (f *forward) track(c net.Conn) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.ctx.Err() != nil {
+		return false
+	}
+	f.conns[c] = struct{}{}
+	return true
+}
+
+func (f *forward) untrack(c net.Conn) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.conns, c)
+}
+
+// copyHalf copies src to dst and counts the bytes in n. Once src is
+// done, the write side of dst is closed so that the other direction
+// can finish, or all of dst if it can't be half closed.
+func copyHalf(dst, src net.Conn, n *atomic.Int64) {
+	io.Copy(&countingWriter{w: dst, n: n}, src)
+	if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+	} else {
+		dst.Close()
+	}
+}
+
+type countingWriter struct {
+	w io.Writer
+	n *atomic.Int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n.Add(int64(n))
+	return n, err
+}
+
+func newForwardID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,125 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/tailscale-dev/vscode-tailscale/tsrelay/logger"
+)
+
+func TestForwards(t *testing.T) {
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { echo.Close() })
+	go func() {
+		for {
+			c, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.Copy(c, c)
+			}()
+		}
+	}()
+
+	m, _ := newTestMockClient(t, fmt.Sprintf(`{
+		"Status": {
+			"BackendState": "Running",
+			"CurrentTailnet": {"MagicDNSSuffix": "example.ts.net"},
+			"Peer": {
+				"nodekey:0000000000000000000000000000000000000000000000000000000000000001": {
+					"ID": "nDev", "DNSName": "dev.example.ts.net.", "Online": true, "TailscaleIPs": ["100.64.0.1"]
+				}
+			}
+		},
+		"PeerServices": [{"Peer": "nDev", "Port": 7, "Forward": %q}]
+	}`, echo.Addr()))
+	forwards := NewForwards(logger.Nop)
+	relay := serveTestHandler(t, &handler{lc: m, forwards: forwards})
+	list := func() []forwardStatus {
+		var s getForwardsResponse
+		if err := json.NewDecoder(relay.do(http.MethodGet, "/forwards", "").Body).Decode(&s); err != nil {
+			t.Fatal(err)
+		}
+		return s.Forwards
+	}
+
+	var f forwardStatus
+	if err := json.NewDecoder(relay.do(http.MethodPost, "/forwards", `{"PeerID": "nDev", "Port": 7}`).Body).Decode(&f); err != nil {
+		t.Fatal(err)
+	}
+	if f.ID == "" || f.Host != "dev.example.ts.net" || f.LocalPort == 0 {
+		t.Fatalf("unexpected forward %+v", f)
+	}
+	var again forwardStatus
+	json.NewDecoder(relay.do(http.MethodPost, "/forwards", `{"PeerID": "nDev", "Port": 7}`).Body).Decode(&again)
+	if again.ID != f.ID {
+		t.Fatalf("expected the existing forward to be returned but got %+v", again)
+	}
+
+	c, err := net.Dial("tcp", f.LocalAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(c, "hello")
+	c.(*net.TCPConn).CloseWrite()
+	if b, err := io.ReadAll(c); err != nil || string(b) != "hello" {
+		t.Fatalf("expected the peer to echo but got %q, %v", b, err)
+	}
+	c.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		fs := list()
+		if len(fs) != 1 {
+			t.Fatalf("expected one forward but got %+v", fs)
+		}
+		if fs[0].Connections == 0 && fs[0].TotalConnections == 1 && fs[0].BytesIn == 5 && fs[0].BytesOut == 5 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected counts %+v", fs[0])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	resp := relay.do(http.MethodPost, "/forwards", fmt.Sprintf(`{"PeerID": "nDev", "Port": 8, "LocalPort": %d}`, f.LocalPort))
+	var re RelayError
+	json.NewDecoder(resp.Body).Decode(&re)
+	if resp.StatusCode != http.StatusConflict || len(re.Errors) != 1 || re.Errors[0].Type != LocalPortInUse {
+		t.Fatalf("expected a taken local port to be rejected but got %d %+v", resp.StatusCode, re)
+	}
+
+	// closing tears down open connections
+	c, err = net.Dial("tcp", f.LocalAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	io.WriteString(c, "ping")
+	if _, err := io.ReadFull(c, make([]byte, 4)); err != nil {
+		t.Fatal(err)
+	}
+	forwards.Close()
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := c.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected the connection to be closed but got %v", err)
+	}
+	if _, err := net.Dial("tcp", f.LocalAddress); err == nil {
+		t.Fatal("expected the forward to stop listening")
+	}
+	if len(list()) != 0 {
+		t.Fatal("expected no forwards once closed")
+	}
+	if resp := relay.do(http.MethodDelete, "/forwards/"+f.ID, ""); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected deleting a closed forward to fail but got %d", resp.StatusCode)
+	}
+}
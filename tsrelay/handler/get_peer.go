@@ -0,0 +1,161 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"tailscale.com/tailcfg"
+)
+
+// Paths traffic to a peer can take.
+const (
+	// connDirect means traffic flows directly between the nodes.
+	connDirect = "direct"
+	// connRelay means traffic is relayed through a DERP server.
+	connRelay = "relay"
+)
+
+// peerDetails is everything the node explorer
+// shows about a single peer.
+type peerDetails struct {
+	peerStatus
+	OS string
+	// Owner is the login name of the user owning the node.
+	Owner    string    `json:",omitempty"`
+	Tags     []string  `json:",omitempty"`
+	Created  time.Time `json:",omitzero"`
+	LastSeen time.Time `json:",omitzero"`
+	// KeyExpiry is when the node key expires or has expired.
+	KeyExpiry *time.Time `json:",omitempty"`
+	// ExitNode reports whether the peer is the current exit
+	// node and ExitNodeOption whether it can be one.
+	ExitNode       bool
+	ExitNodeOption bool
+	// PrimaryRoutes are the subnet routes the peer is
+	// currently the primary router for.
+	PrimaryRoutes []netip.Prefix `json:",omitempty"`
+	RxBytes       int64
+	TxBytes       int64
+	// Connection is "direct" or "relay", or empty
+	// if there is no active connection to the peer.
+	Connection string `json:",omitempty"`
+	// CurAddr is the endpoint of a direct connection
+	// and Relay the DERP region of the peer.
+	CurAddr string  `json:",omitempty"`
+	Relay   string  `json:",omitempty"`
+	Errors  []Error `json:",omitempty"`
+}
+
+func (h *handler) getPeerHandler(w http.ResponseWriter, r *http.Request) {
+	p, err := h.getPeer(r.Context(), tailcfg.StableNodeID(chi.URLParam(r, "id")))
+	if err != nil {
+		var re RelayError
+		if errors.As(err, &re) {
+			w.WriteHeader(re.statusCode)
+			json.NewEncoder(w).Encode(re)
+			return
+		}
+		h.l.Println("error getting peer:", err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	json.NewEncoder(w).Encode(p)
+}
+
+func (h *handler) getPeer(ctx context.Context, id tailcfg.StableNodeID) (*peerDetails, error) {
+	if h.requiresRestart {
+		return nil, RelayError{
+			statusCode: http.StatusPreconditionFailed,
+			Errors:     []Error{{Type: FlatpakRequiresRestart}},
+		}
+	}
+
+	st, err := h.lc.Status(ctx)
+	if err != nil {
+		var oe *net.OpError
+		if errors.As(err, &oe) && oe.Op == "dial" {
+			return nil, RelayError{
+				statusCode: http.StatusServiceUnavailable,
+				Errors:     []Error{{Type: NotRunning}},
+			}
+		}
+		return nil, err
+	}
+
+	for _, p := range st.Peer {
+		if p.ID != id || p.ShareeNode {
+			continue
+		}
+		var suffix string
+		if st.CurrentTailnet != nil {
+			suffix = st.CurrentTailnet.MagicDNSSuffix
+		}
+		d := &peerDetails{
+			peerStatus:     *newPeerStatus(p, suffix),
+			OS:             p.OS,
+			Created:        p.Created,
+			LastSeen:       p.LastSeen,
+			KeyExpiry:      p.KeyExpiry,
+			ExitNode:       p.ExitNode,
+			ExitNodeOption: p.ExitNodeOption,
+			RxBytes:        p.RxBytes,
+			TxBytes:        p.TxBytes,
+			CurAddr:        p.CurAddr,
+			Relay:          p.Relay,
+		}
+		if u, ok := st.User[p.UserID]; ok {
+			d.Owner = u.LoginName
+		}
+		if p.Tags != nil {
+			d.Tags = p.Tags.AsSlice()
+		}
+		if p.PrimaryRoutes != nil {
+			d.PrimaryRoutes = p.PrimaryRoutes.AsSlice()
+		}
+		switch {
+		case !p.Online:
+		case p.CurAddr != "":
+			d.Connection = connDirect
+		case p.Relay != "" && p.Active:
+			d.Connection = connRelay
+		}
+		if st.BackendState == "NeedsLogin" || (st.Self != nil && !st.Self.Online) {
+			d.Errors = append(d.Errors, Error{Type: Offline})
+		}
+		return d, nil
+	}
+
+	return nil, RelayError{
+		statusCode: http.StatusNotFound,
+		Errors:     []Error{{Type: PeerNotFound}},
+	}
+}
+
+// findPeer returns the peer with the given ID, which
+// must not be a node shared into the tailnet.
+func (h *handler) findPeer(ctx context.Context, id tailcfg.StableNodeID) (*peerStatus, error) {
+	st, err := h.lc.Status(ctx)
+	if err != nil {
+		return nil, tailscaledError(err)
+	}
+	var suffix string
+	if st.CurrentTailnet != nil {
+		suffix = st.CurrentTailnet.MagicDNSSuffix
+	}
+	for _, p := range st.Peer {
+		if p.ID == id && !p.ShareeNode {
+			return newPeerStatus(p, suffix), nil
+		}
+	}
+	return nil, RelayError{
+		statusCode: http.StatusNotFound,
+		Errors:     []Error{{Type: PeerNotFound}},
+	}
+}
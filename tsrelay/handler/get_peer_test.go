@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+const peerProfile = `{
+	"Status": {
+		"BackendState": "Running",
+		"CurrentTailnet": {"MagicDNSSuffix": "example.ts.net"},
+		"Self": {"ID": "nSelf", "DNSName": "self.example.ts.net.", "Online": true},
+		"Peer": {
+			"nodekey:0000000000000000000000000000000000000000000000000000000000000002": {
+				"ID": "nRouter",
+				"DNSName": "router.example.ts.net.",
+				"OS": "linux",
+				"UserID": 1,
+				"Online": true,
+				"Active": true,
+				"Tags": ["tag:router"],
+				"PrimaryRoutes": ["10.0.0.0/24"],
+				"ExitNodeOption": true,
+				"Relay": "fra",
+				"RxBytes": 10,
+				"TxBytes": 20
+			}
+		},
+		"User": {"1": {"ID": 1, "LoginName": "alice@example.org"}}
+	}
+}`
+
+func TestGetPeer(t *testing.T) {
+	_, relay := newTestRelay(t, peerProfile)
+	get := func(id string) *http.Response {
+		return relay.do(http.MethodGet, "/peers/"+id, "")
+	}
+
+	resp := get("nRouter")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status %d", resp.StatusCode)
+	}
+	var d peerDetails
+	if err := json.NewDecoder(resp.Body).Decode(&d); err != nil {
+		t.Fatal(err)
+	}
+	if d.ServerName != "router" || d.OS != "linux" || d.Owner != "alice@example.org" {
+		t.Fatalf("unexpected peer %+v", d)
+	}
+	if len(d.Tags) != 1 || len(d.PrimaryRoutes) != 1 || !d.ExitNodeOption {
+		t.Fatalf("expected the peer's roles but got %v %v %v", d.Tags, d.PrimaryRoutes, d.ExitNodeOption)
+	}
+	if d.Connection != connRelay || d.RxBytes != 10 || d.TxBytes != 20 {
+		t.Fatalf("expected a relayed connection but got %q %d %d", d.Connection, d.RxBytes, d.TxBytes)
+	}
+
+	resp = get("nMissing")
+	var re RelayError
+	json.NewDecoder(resp.Body).Decode(&re)
+	if resp.StatusCode != http.StatusNotFound || len(re.Errors) != 1 || re.Errors[0].Type != PeerNotFound {
+		t.Fatalf("expected a missing peer error but got %d %+v", resp.StatusCode, re)
+	}
+}
@@ -4,11 +4,12 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"io"
 	"net"
 	"net/http"
-	"sort"
+	"slices"
 	"strings"
+
+	"tailscale.com/ipn/ipnstate"
 )
 
 // getPeersResponse is a subset of ipnstate.Status
@@ -18,7 +19,12 @@ import (
 type getPeersResponse struct {
 	PeerGroups     []*peerGroup
 	CurrentTailnet *currentTailnet
-	Errors         []Error `json:",omitempty"`
+	// Total is the number of peers across all groups before
+	// paginating. A peer in several groups, such as one with
+	// several tags when grouping by tag, counts once per group
+	// as offset and limit do.
+	Total  int
+	Errors []Error `json:",omitempty"`
 }
 
 type currentTailnet struct {
@@ -33,7 +39,12 @@ type peerGroup struct {
 }
 
 func (h *handler) getPeersHandler(w http.ResponseWriter, r *http.Request) {
-	s, err := h.getPeers(r.Context(), r.Body)
+	q, err := parsePeersQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s, err := h.getPeers(r.Context(), q)
 	if err != nil {
 		var re RelayError
 		if errors.As(err, &re) {
@@ -49,7 +60,7 @@ func (h *handler) getPeersHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(s)
 }
 
-func (h *handler) getPeers(ctx context.Context, body io.Reader) (*getPeersResponse, error) {
+func (h *handler) getPeers(ctx context.Context, q peersQuery) (*getPeersResponse, error) {
 	if h.requiresRestart {
 		return nil, RelayError{
 			statusCode: http.StatusPreconditionFailed,
@@ -70,20 +81,18 @@ func (h *handler) getPeers(ctx context.Context, body io.Reader) (*getPeersRespon
 	}
 
 	s := getPeersResponse{PeerGroups: []*peerGroup{}}
-	peerGroups := [...]*peerGroup{
-		{Name: "Managed by you"},
-		{Name: "All machines"},
-		{Name: "Offline machines"},
-	}
 
 	if st.BackendState == "NeedsLogin" || (st.Self != nil && !st.Self.Online) {
 		s.Errors = append(s.Errors, Error{
 			Type: Offline,
 		})
 	}
+	s.Errors = append(s.Errors, h.statusHealthErrors(ctx, st)...)
 
 	// CurrentTailnet can be offline when you are logged out
+	var magicDNSSuffix string
 	if st.CurrentTailnet != nil {
+		magicDNSSuffix = st.CurrentTailnet.MagicDNSSuffix
 		s.CurrentTailnet = &currentTailnet{
 			Name:            st.CurrentTailnet.Name,
 			MagicDNSSuffix:  st.CurrentTailnet.MagicDNSSuffix,
@@ -91,6 +100,7 @@ func (h *handler) getPeers(ctx context.Context, body io.Reader) (*getPeersRespon
 		}
 	}
 
+	var peers []peerEntry
 	for _, p := range st.Peer {
 		// ShareeNode indicates this node exists in the netmap because
 		// it's owned by a shared-to user and that node might connect
@@ -104,58 +114,79 @@ func (h *handler) getPeers(ctx context.Context, body io.Reader) (*getPeersRespon
 			continue
 		}
 
-		serverName := p.HostName
-		if p.DNSName != "" {
-			parts := strings.SplitN(p.DNSName, ".", 2)
-			if len(parts) > 0 {
-				serverName = parts[0]
-			}
+		e := peerEntry{peerStatus: newPeerStatus(p, magicDNSSuffix), ps: p}
+		if u, ok := st.User[p.UserID]; ok {
+			e.owner = u.LoginName
 		}
-
-		// removes the root label/trailing period from the DNSName
-		// before: "amalie.foo.ts.net.", after: "amalie.foo.ts.net"
-		dnsNameNoRootLabel := strings.TrimSuffix(p.DNSName, ".")
-
-		// if the DNSName does not end with the magic DNS suffix, it is an external peer
-		isExternal := !strings.HasSuffix(dnsNameNoRootLabel, st.CurrentTailnet.MagicDNSSuffix)
-
-		addr := dnsNameNoRootLabel
-		if addr == "" && len(p.TailscaleIPs) > 0 {
-			addr = p.TailscaleIPs[0].String()
+		if matchPeer(e, q.Search) {
+			peers = append(peers, e)
 		}
-		peer := &peerStatus{
-			DNSName:      dnsNameNoRootLabel,
-			ServerName:   serverName,
-			Online:       p.Online,
-			ID:           p.ID,
-			HostName:     p.HostName,
-			TailscaleIPs: p.TailscaleIPs,
-			IsExternal:   isExternal,
-			SSHEnabled:   len(p.SSH_HostKeys) > 0,
-			Address:      addr,
+	}
+	slices.SortFunc(peers, func(a, b peerEntry) int { return comparePeers(a, b, q) })
+
+	var groups []*peerGroup
+	if q.Group == groupDefault {
+		peerGroups := [...]*peerGroup{
+			{Name: "Managed by you"},
+			{Name: "All machines"},
+			{Name: "Offline machines"},
 		}
-
-		if !p.Online {
-			peerGroups[2].Peers = append(peerGroups[2].Peers, peer)
-		} else if p.UserID == st.Self.UserID {
-			peerGroups[0].Peers = append(peerGroups[0].Peers, peer)
-		} else {
-			peerGroups[1].Peers = append(peerGroups[1].Peers, peer)
+		for _, e := range peers {
+			if !e.Online {
+				peerGroups[2].Peers = append(peerGroups[2].Peers, e.peerStatus)
+			} else if st.Self != nil && e.ps.UserID == st.Self.UserID {
+				peerGroups[0].Peers = append(peerGroups[0].Peers, e.peerStatus)
+			} else {
+				peerGroups[1].Peers = append(peerGroups[1].Peers, e.peerStatus)
+			}
 		}
-	}
-
-	for _, pg := range peerGroups {
-		if len(pg.Peers) > 0 {
-			s.PeerGroups = append(s.PeerGroups, pg)
+		for _, pg := range peerGroups {
+			if len(pg.Peers) > 0 {
+				groups = append(groups, pg)
+			}
 		}
+	} else {
+		groups = groupPeers(peers, q.Group)
 	}
 
-	for _, pg := range s.PeerGroups {
-		peers := pg.Peers
-		sort.Slice(peers, func(i, j int) bool {
-			return peers[i].ServerName < peers[j].ServerName
-		})
+	for _, pg := range groups {
+		s.Total += len(pg.Peers)
 	}
+	s.PeerGroups = append(s.PeerGroups, paginate(groups, q.Offset, q.Limit)...)
 
 	return &s, nil
 }
+
+// newPeerStatus returns the parts of a peer the node explorer shows.
+func newPeerStatus(p *ipnstate.PeerStatus, magicDNSSuffix string) *peerStatus {
+	serverName := p.HostName
+	if p.DNSName != "" {
+		parts := strings.SplitN(p.DNSName, ".", 2)
+		if len(parts) > 0 {
+			serverName = parts[0]
+		}
+	}
+
+	// removes the root label/trailing period from the DNSName
+	// before: "amalie.foo.ts.net.", after: "amalie.foo.ts.net"
+	dnsNameNoRootLabel := strings.TrimSuffix(p.DNSName, ".")
+
+	// if the DNSName does not end with the magic DNS suffix, it is an external peer
+	isExternal := !strings.HasSuffix(dnsNameNoRootLabel, magicDNSSuffix)
+
+	addr := dnsNameNoRootLabel
+	if addr == "" && len(p.TailscaleIPs) > 0 {
+		addr = p.TailscaleIPs[0].String()
+	}
+	return &peerStatus{
+		DNSName:      dnsNameNoRootLabel,
+		ServerName:   serverName,
+		Online:       p.Online,
+		ID:           p.ID,
+		HostName:     p.HostName,
+		TailscaleIPs: p.TailscaleIPs,
+		IsExternal:   isExternal,
+		SSHEnabled:   len(p.SSH_HostKeys) > 0,
+		Address:      addr,
+	}
+}
@@ -15,7 +15,7 @@ import (
 
 	"golang.org/x/exp/slices"
 	"tailscale.com/ipn"
-	"tailscale.com/portlist"
+	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/tailcfg"
 )
 
@@ -24,8 +24,11 @@ import (
 // to reduce serialization size in addition
 // to some helper fields for the typescript frontend
 type serveStatus struct {
-	ServeConfig  *ipn.ServeConfig
-	Services     map[uint16]string
+	ServeConfig *ipn.ServeConfig
+	Services    map[uint16]string
+	// Containers are the Docker containers behind
+	// Services, if Docker discovery is on.
+	Containers   map[uint16]*containerInfo `json:",omitempty"`
 	BackendState string
 	Self         *peerStatus
 	FunnelPorts  []int
@@ -77,17 +80,21 @@ func (h *handler) getServe(ctx context.Context, body io.Reader) (*serveStatus, e
 	var wg sync.WaitGroup
 	wg.Add(1)
 	portMap := map[uint16]string{}
+	containerMap := map[uint16]*containerInfo{}
 	go func() {
 		defer wg.Done()
-		p := &portlist.Poller{IncludeLocalhost: true}
-		defer p.Close()
-		ports, _, err := p.Poll()
+		ports, err := h.ports.Ports()
 		if err != nil {
 			h.l.Printf("error polling for serve: %v", err)
 			return
 		}
+		ports, containers := h.withContainers(ctx, ports)
 		for _, p := range ports {
 			portMap[p.Port] = p.Process
+			if ci, ok := containers[portKey{p.Proto, p.Port}]; ok {
+				portMap[p.Port] = ci.String()
+				containerMap[p.Port] = ci
+			}
 		}
 	}()
 
@@ -131,6 +138,12 @@ func (h *handler) getServe(ctx context.Context, body io.Reader) (*serveStatus, e
 				if process, ok := portMap[port]; ok {
 					s.Services[port] = process
 				}
+				if ci, ok := containerMap[port]; ok {
+					if s.Containers == nil {
+						s.Containers = make(map[uint16]*containerInfo)
+					}
+					s.Containers[port] = ci
+				}
 			}
 		}
 	}
@@ -144,8 +157,7 @@ func (h *handler) getServe(ctx context.Context, body io.Reader) (*serveStatus, e
 			TailscaleIPs: st.Self.TailscaleIPs,
 		}
 
-		if st.Self.HasCap(tailcfg.CapabilityWarnFunnelNoInvite) ||
-			!st.Self.HasCap(tailcfg.NodeAttrFunnel) {
+		if !funnelAllowed(st.Self) {
 			s.Errors = append(s.Errors, Error{
 				Type: FunnelOff,
 			})
@@ -160,21 +172,42 @@ func (h *handler) getServe(ctx context.Context, body io.Reader) (*serveStatus, e
 				Type: Offline,
 			})
 		}
+		s.Errors = append(s.Errors, h.statusHealthErrors(ctx, st)...)
 	}
 
+	if st.Self != nil {
+		s.FunnelPorts, err = funnelPorts(st.Self)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &s, nil
+}
+
+// funnelAllowed reports whether the given
+// node has the capability to use Funnel.
+func funnelAllowed(self *ipnstate.PeerStatus) bool {
+	return self.HasCap(tailcfg.NodeAttrFunnel) && !self.HasCap(tailcfg.CapabilityWarnFunnelNoInvite)
+}
+
+// funnelPorts returns the ports the given node
+// is allowed to expose over Funnel.
+func funnelPorts(self *ipnstate.PeerStatus) ([]int, error) {
 	var u *url.URL
+	var err error
 
-	idx := slices.IndexFunc(st.Self.Capabilities, func(s tailcfg.NodeCapability) bool {
+	idx := slices.IndexFunc(self.Capabilities, func(s tailcfg.NodeCapability) bool {
 		return strings.HasPrefix(string(s), string(tailcfg.CapabilityFunnelPorts))
 	})
 
 	if idx >= 0 {
-		u, err = url.Parse(string(st.Self.Capabilities[idx]))
+		u, err = url.Parse(string(self.Capabilities[idx]))
 		if err != nil {
 			return nil, err
 		}
-	} else if st.Self.CapMap != nil {
-		for c := range st.Self.CapMap {
+	} else if self.CapMap != nil {
+		for c := range self.CapMap {
 			if strings.HasPrefix(string(c), string(tailcfg.CapabilityFunnelPorts)) {
 				u, err = url.Parse(string(c))
 				if err != nil {
@@ -185,18 +218,15 @@ func (h *handler) getServe(ctx context.Context, body io.Reader) (*serveStatus, e
 		}
 	}
 
+	ports := []int{}
 	if u != nil {
-		ports := strings.Split(strings.TrimSpace(u.Query().Get("ports")), ",")
-
-		for _, ps := range ports {
+		for _, ps := range strings.Split(strings.TrimSpace(u.Query().Get("ports")), ",") {
 			p, err := strconv.Atoi(ps)
 			if err != nil {
 				return nil, err
 			}
-
-			s.FunnelPorts = append(s.FunnelPorts, p)
+			ports = append(ports, p)
 		}
 	}
-
-	return &s, nil
+	return ports, nil
 }
@@ -7,43 +7,116 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/gorilla/websocket"
 	"github.com/tailscale-dev/vscode-tailscale/tsrelay/logger"
-	"tailscale.com/portlist"
 )
 
+// Config configures the handler returned by NewHandler.
+type Config struct {
+	LocalClient LocalClient
+	// Ports and Procs find open ports and the
+	// processes that own them for port discovery.
+	Ports PortLister
+	Procs ProcessTable
+	// Nonce is the password clients authenticate with.
+	Nonce  string
+	Logger logger.Logger
+	// RequiresRestart is set when the flatpak container
+	// needs to be restarted to reach tailscaled.
+	RequiresRestart bool
+	Sessions        *Sessions
+	// Forwards keeps the port forwards.
+	Forwards *Forwards
+	// DockerSocket is the Docker Engine socket to report ports
+	// published by containers from, or empty to not report them.
+	DockerSocket string
+}
+
 // NewHandler returns a new http handler for interactions between
 // the typescript extension and the Go tsrelay server.
-func NewHandler(lc LocalClient, nonce string, l logger.Logger, requiresRestart bool) http.Handler {
+func NewHandler(c Config) http.Handler {
+	var docker *dockerClient
+	if c.DockerSocket != "" {
+		docker = newDockerClient(c.DockerSocket)
+	}
 	return newHandler(&handler{
-		nonce:           nonce,
-		lc:              lc,
-		l:               l,
-		pids:            make(map[int]struct{}),
-		prev:            make(map[uint16]portlist.Port),
+		nonce:           c.Nonce,
+		lc:              c.LocalClient,
+		l:               c.Logger,
+		sessions:        c.Sessions,
+		forwards:        c.Forwards,
+		ports:           newPortWatcher(c.Logger, c.Ports),
+		procs:           c.Procs,
+		snoozes:         &snoozeStore{path: defaultSnoozePath()},
+		docker:          docker,
 		onPortUpdate:    func() {},
-		requiresRestart: requiresRestart,
+		requiresRestart: c.RequiresRestart,
 	})
 }
 
 type handler struct {
-	sync.Mutex
 	nonce           string
 	lc              LocalClient
 	l               logger.Logger
 	u               websocket.Upgrader
-	pids            map[int]struct{}
-	prev            map[uint16]portlist.Port
-	onPortUpdate    func() // callback for async testing
+	sessions        *Sessions
+	forwards        *Forwards
+	ports           *portWatcher
+	procs           ProcessTable
+	snoozes         *snoozeStore
+	docker          *dockerClient // nil unless Docker discovery is on
+	onPortUpdate    func()        // callback for async testing
 	requiresRestart bool
+	// profileMu serializes changes of the login profile.
+	profileMu  sync.Mutex
+	whoisCache whoisCache
+	// healthCache saves polls of /peers and
+	// /serve from watching the IPN bus each time.
+	healthCache healthCache
 }
 
 func newHandler(h *handler) http.Handler {
 	r := chi.NewRouter()
 	r.Use(h.authMiddleware)
 	r.Get("/peers", h.getPeersHandler)
+	r.Get("/peers/{id}", h.getPeerHandler)
+	r.Get("/peers/{id}/services", h.getPeerServicesHandler)
+	r.Get("/forwards", h.getForwardsHandler)
+	r.Post("/forwards", h.createForwardHandler)
+	r.Delete("/forwards/{id}", h.deleteForwardHandler)
+	r.Get("/ssh/known-hosts", h.getKnownHostsHandler)
+	r.Put("/ssh/config", h.setSSHConfigHandler)
+	r.Delete("/ssh/config", h.deleteSSHConfigHandler)
 	r.Get("/serve", h.getServeHandler)
 	r.Post("/serve", h.createServeHandler)
 	r.Delete("/serve", h.deleteServeHandler)
 	r.Post("/funnel", h.setFunnelHandler)
 	r.Get("/portdisco", h.portDiscoHandler)
+	r.Get("/ping", h.pingHandler)
+	r.Get("/health", h.healthHandler)
+	r.Get("/whois", h.whoisHandler)
+	r.Post("/whois", h.whoisBatchHandler)
+	r.Get("/events", h.eventsHandler)
+	r.Get("/taildrop/targets", h.getFileTargetsHandler)
+	r.Get("/taildrop/push", h.pushFileHandler)
+	r.Get("/taildrop/files", h.getWaitingFilesHandler)
+	r.Get("/taildrop/files/{name}", h.getWaitingFileHandler)
+	r.Delete("/taildrop/files/{name}", h.deleteWaitingFileHandler)
+	r.Get("/exit-nodes", h.getExitNodesHandler)
+	r.Get("/exit-nodes/suggested", h.getSuggestedExitNodeHandler)
+	r.Put("/exit-node", h.setExitNodeHandler)
+	r.Delete("/exit-node", h.clearExitNodeHandler)
+	r.Get("/login", h.loginHandler)
+	r.Post("/logout", h.logoutHandler)
+	r.Get("/profiles", h.getProfilesHandler)
+	r.Post("/profiles", h.addProfileHandler)
+	r.Put("/profiles/current", h.switchProfileHandler)
+	r.Delete("/profiles/{id}", h.deleteProfileHandler)
+	r.Get("/sessions", h.getSessionsHandler)
+	r.Post("/sessions", h.createSessionHandler)
+	r.Get("/sessions/{id}", h.getSessionHandler)
+	r.Delete("/sessions/{id}", h.deleteSessionHandler)
+	if _, ok := mockClientOf(h.lc); ok {
+		r.Get("/mock", h.getMockHandler)
+		r.Post("/mock", h.setMockHandler)
+	}
 	return r
 }
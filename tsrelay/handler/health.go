@@ -0,0 +1,326 @@
+package handler
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/health"
+	"tailscale.com/health/healthmsg"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+)
+
+const (
+	// defaultKeyExpiryThreshold is how long before the key
+	// of this machine expires that it is reported.
+	defaultKeyExpiryThreshold = 7 * 24 * time.Hour
+	// healthTimeout bounds waiting for the health state, which
+	// Errors arrays of other endpoints go without if it's slow.
+	healthTimeout = 2 * time.Second
+	// healthCacheTTL is how long the health state is reused for the
+	// Errors arrays of other endpoints, which the extension polls.
+	healthCacheTTL = 5 * time.Second
+
+	adminMachinesURL = "https://login.tailscale.com/admin/machines"
+	adminDNSURL      = "https://login.tailscale.com/admin/dns"
+	lockedOutURL     = "https://tailscale.com/s/locked-out"
+)
+
+// offlineMessages are the texts of offlineWarnables in the health
+// messages of the status, which come without their codes.
+var offlineMessages = map[string]health.WarnableCode{
+	"You are logged out.":                 "login-state",
+	"Tailscale is stopped.":               "wantrunning-false",
+	"Tailscale is starting. Please wait.": "warming-up",
+}
+
+// dnsWarnables are the health warnings
+// reported as DNSMisconfigured.
+var dnsWarnables = []health.WarnableCode{
+	"dns",
+	"dns-manager",
+	"dns-forward-failing",
+	"dns-read-os-config-failed",
+	"dns-set-os-config-failed",
+	"resolv-conf-overwritten",
+}
+
+// offlineWarnables are the health warnings that
+// Offline already covers in the Errors arrays.
+var offlineWarnables = []health.WarnableCode{
+	"login-state",
+	"wantrunning-false",
+	"warming-up",
+}
+
+type healthResponse struct {
+	BackendState string
+	// KeyExpiry is when the key of this machine
+	// expires, or nil if key expiry is disabled.
+	KeyExpiry *time.Time `json:",omitempty"`
+	// Warnings are the health warnings of tailscaled,
+	// the most severe first.
+	Warnings []health.UnhealthyState
+	Errors   []Error `json:",omitempty"`
+}
+
+func (h *handler) healthHandler(w http.ResponseWriter, r *http.Request) {
+	threshold := defaultKeyExpiryThreshold
+	if v := r.URL.Query().Get("keyExpiryThreshold"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			http.Error(w, fmt.Sprintf("invalid keyExpiryThreshold %q", v), http.StatusBadRequest)
+			return
+		}
+		threshold = d
+	}
+	resp, err := h.getHealth(r.Context(), threshold)
+	if err != nil {
+		var re RelayError
+		if errors.As(err, &re) {
+			w.WriteHeader(re.statusCode)
+			json.NewEncoder(w).Encode(re)
+			return
+		}
+		h.l.Println("error getting health:", err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (h *handler) getHealth(ctx context.Context, threshold time.Duration) (*healthResponse, error) {
+	st, err := h.lc.Status(ctx)
+	if err != nil {
+		return nil, tailscaledError(err)
+	}
+	hctx, cancel := context.WithTimeout(ctx, healthTimeout)
+	defer cancel()
+	hs, err := h.healthState(hctx)
+	switch {
+	case err == nil:
+		h.healthCache.set(hs, nil)
+	case hctx.Err() != nil && ctx.Err() == nil:
+		// make do with the health messages of the status
+		h.l.VPrintf("error getting health state: %v", err)
+	default:
+		return nil, err
+	}
+	resp := &healthResponse{
+		BackendState: st.BackendState,
+		Warnings:     []health.UnhealthyState{},
+		Errors:       healthErrors(st, hs, threshold, time.Now()),
+	}
+	if st.Self != nil {
+		resp.KeyExpiry = st.Self.KeyExpiry
+	}
+	if hs != nil {
+		for _, w := range hs.Warnings {
+			resp.Warnings = append(resp.Warnings, w)
+		}
+	}
+	slices.SortFunc(resp.Warnings, func(a, b health.UnhealthyState) int {
+		return cmp.Or(
+			cmp.Compare(severityRank(b.Severity), severityRank(a.Severity)),
+			cmp.Compare(a.WarnableCode, b.WarnableCode),
+		)
+	})
+	return resp, nil
+}
+
+// healthState returns the health of tailscaled
+// as announced first on the IPN bus.
+func (h *handler) healthState(ctx context.Context) (*health.State, error) {
+	w, err := watchIPNBus(ctx, h.lc, ipn.NotifyInitialHealthState)
+	if err != nil {
+		return nil, tailscaledError(err)
+	}
+	defer w.Close()
+	for {
+		n, err := w.Next()
+		if err != nil {
+			return nil, tailscaledError(err)
+		}
+		if n.Health != nil {
+			return n.Health, nil
+		}
+	}
+}
+
+// cachedHealthState returns the health state of tailscaled, or the
+// error getting it, from at most healthCacheTTL ago. Concurrent
+// callers wait for the same IPN bus watch rather than each opening one.
+func (h *handler) cachedHealthState(ctx context.Context) (*health.State, error) {
+	c := &h.healthCache
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Now().Before(c.expires) {
+		return c.state, c.err
+	}
+	wctx, cancel := context.WithTimeout(ctx, healthTimeout)
+	defer cancel()
+	hs, err := h.healthState(wctx)
+	if ctx.Err() != nil {
+		// the caller went away, which says
+		// nothing about the health state
+		return nil, ctx.Err()
+	}
+	c.setLocked(hs, err)
+	return hs, err
+}
+
+// healthCache is the health state last had from tailscaled.
+type healthCache struct {
+	mu      sync.Mutex
+	state   *health.State
+	err     error
+	expires time.Time
+}
+
+func (c *healthCache) set(hs *health.State, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.setLocked(hs, err)
+}
+
+func (c *healthCache) setLocked(hs *health.State, err error) {
+	c.state, c.err = hs, err
+	c.expires = time.Now().Add(healthCacheTTL)
+}
+
+// statusHealthErrors returns the health errors for the Errors arrays
+// of other endpoints, without warnings that Offline covers or that
+// are of low severity. They do without the health state if it can't
+// be had quickly and fall back to the messages of the status.
+func (h *handler) statusHealthErrors(ctx context.Context, st *ipnstate.Status) []Error {
+	hs, err := h.cachedHealthState(ctx)
+	if err != nil {
+		h.l.VPrintf("error getting health state: %v", err)
+	}
+	errs := healthErrors(st, hs, defaultKeyExpiryThreshold, time.Now())
+	return slices.DeleteFunc(errs, func(e Error) bool {
+		if e.Type != HealthWarning {
+			return false
+		}
+		code := health.WarnableCode(e.Code)
+		return slices.Contains(offlineWarnables, code) || (hs != nil && hs.Warnings[code].Severity == health.SeverityLow)
+	})
+}
+
+// healthErrors turns the health state and the key expiry of the
+// self node into Errors with a hint or a link to fix them. Without
+// a health state, the health messages of the status are used.
+func healthErrors(st *ipnstate.Status, hs *health.State, threshold time.Duration, now time.Time) []Error {
+	var errs []Error
+	if self := st.Self; self != nil && self.KeyExpiry != nil {
+		machineURL := adminMachinesURL
+		if len(self.TailscaleIPs) > 0 {
+			machineURL += "/" + self.TailscaleIPs[0].String()
+		}
+		switch left := self.KeyExpiry.Sub(now); {
+		case left <= 0:
+			errs = append(errs, Error{
+				Type:    KeyExpired,
+				Message: "The key of this machine has expired.",
+				Hint:    "Log in again to reconnect.",
+				URL:     machineURL,
+			})
+		case left <= threshold:
+			errs = append(errs, Error{
+				Type:    KeyExpiring,
+				Message: fmt.Sprintf("The key of this machine expires in %s.", formatDuration(left)),
+				Hint:    "Log in again to renew the key, or disable key expiry for this machine in the admin console.",
+				URL:     machineURL,
+			})
+		}
+	}
+
+	if hs == nil {
+		for _, msg := range st.Health {
+			if strings.Contains(msg, healthmsg.LockedOut) {
+				errs = append(errs, lockedOutError(st, msg))
+				continue
+			}
+			e := Error{Type: HealthWarning, Message: msg}
+			for text, code := range offlineMessages {
+				if strings.HasPrefix(msg, text) {
+					e.Code = string(code)
+				}
+			}
+			errs = append(errs, e)
+		}
+		return errs
+	}
+
+	var warnings []health.UnhealthyState
+	for _, w := range hs.Warnings {
+		warnings = append(warnings, w)
+	}
+	slices.SortFunc(warnings, func(a, b health.UnhealthyState) int {
+		return cmp.Compare(a.WarnableCode, b.WarnableCode)
+	})
+	for _, w := range warnings {
+		msg := w.Text
+		if w.Title != "" && !strings.HasPrefix(msg, w.Title) {
+			msg = w.Title + ": " + msg
+		}
+		switch {
+		case w.WarnableCode == "tailnet-lock" && strings.Contains(w.Text, healthmsg.LockedOut):
+			errs = append(errs, lockedOutError(st, msg))
+		case slices.Contains(dnsWarnables, w.WarnableCode):
+			errs = append(errs, Error{
+				Type:    DNSMisconfigured,
+				Code:    string(w.WarnableCode),
+				Message: msg,
+				Hint:    "MagicDNS names may not resolve. Make sure no other program manages the DNS settings of this machine, then restart Tailscale.",
+				URL:     adminDNSURL,
+			})
+		default:
+			e := Error{Type: HealthWarning, Code: string(w.WarnableCode), Message: msg}
+			if a := w.PrimaryAction; a != nil {
+				e.Hint = a.Label
+				e.URL = a.URL
+			}
+			errs = append(errs, e)
+		}
+	}
+	return errs
+}
+
+func lockedOutError(st *ipnstate.Status, msg string) Error {
+	hint := "Ask an admin to sign the key of this machine from a trusted machine with `tailscale lock sign`."
+	if st.Self != nil && !st.Self.PublicKey.IsZero() {
+		hint = fmt.Sprintf("Ask an admin to sign this machine from a trusted machine with `tailscale lock sign %s`.", st.Self.PublicKey)
+	}
+	return Error{Type: LockedOut, Message: msg, Hint: hint, URL: lockedOutURL}
+}
+
+func severityRank(s health.Severity) int {
+	switch s {
+	case health.SeverityHigh:
+		return 2
+	case health.SeverityMedium:
+		return 1
+	}
+	return 0
+}
+
+// formatDuration formats d in days or hours, rounded up.
+func formatDuration(d time.Duration) string {
+	if d >= 48*time.Hour {
+		return fmt.Sprintf("%d days", int(math.Ceil(d.Hours()/24)))
+	}
+	if h := int(math.Ceil(d.Hours())); h > 1 {
+		return fmt.Sprintf("%d hours", h)
+	}
+	return "less than an hour"
+}
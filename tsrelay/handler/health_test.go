@@ -0,0 +1,198 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tailscale-dev/vscode-tailscale/tsrelay/logger"
+	"tailscale.com/health/healthmsg"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+)
+
+func TestHealth(t *testing.T) {
+	expiry := time.Now().Add(50 * time.Hour).UTC().Format(time.RFC3339)
+	_, relay := newTestRelay(t, fmt.Sprintf(`{
+		"Status": {
+			"BackendState": "Running",
+			"Self": {"ID": "nSelf", "DNSName": "laptop.example.ts.net.", "Online": true, "TailscaleIPs": ["100.64.0.10"], "KeyExpiry": %q},
+			"CurrentTailnet": {"Name": "example.com", "MagicDNSSuffix": "example.ts.net"}
+		},
+		"Health": {
+			"Warnings": {
+				"update-available": {
+					"WarnableCode": "update-available", "Severity": "low", "Title": "Update available",
+					"Text": "An update from 1.86 to 1.88 is available.",
+					"PrimaryAction": {"URL": "https://tailscale.com/download", "Label": "Update"}
+				},
+				"dns-forward-failing": {
+					"WarnableCode": "dns-forward-failing", "Severity": "medium", "Title": "DNS unavailable",
+					"Text": "Tailscale can't reach the configured DNS servers."
+				},
+				"tailnet-lock": {"WarnableCode": "tailnet-lock", "Severity": "medium", "Text": %q},
+				"login-state": {"WarnableCode": "login-state", "Severity": "high", "Title": "Logged out", "Text": "You are logged out."}
+			}
+		}
+	}`, expiry, healthmsg.LockedOut))
+	types := func(errs []Error) []string {
+		var ts []string
+		for _, e := range errs {
+			ts = append(ts, e.Type)
+		}
+		return ts
+	}
+
+	var h healthResponse
+	if err := json.NewDecoder(relay.do(http.MethodGet, "/health", "").Body).Decode(&h); err != nil {
+		t.Fatal(err)
+	}
+	if len(h.Warnings) != 4 || h.Warnings[0].WarnableCode != "login-state" || h.Warnings[3].WarnableCode != "update-available" {
+		t.Fatalf("expected the warnings by severity but got %+v", h.Warnings)
+	}
+	want := []string{KeyExpiring, DNSMisconfigured, HealthWarning, LockedOut, HealthWarning}
+	if got := types(h.Errors); !slices.Equal(got, want) {
+		t.Fatalf("got errors %v, want %v", got, want)
+	}
+	if e := h.Errors[0]; e.Message != "The key of this machine expires in 3 days." || e.URL != adminMachinesURL+"/100.64.0.10" {
+		t.Fatalf("unexpected key expiry error %+v", e)
+	}
+	if e := h.Errors[1]; e.Code != "dns-forward-failing" || e.URL != adminDNSURL ||
+		e.Message != "DNS unavailable: Tailscale can't reach the configured DNS servers." {
+		t.Fatalf("unexpected DNS error %+v", e)
+	}
+	if e := h.Errors[4]; e.Code != "update-available" || e.Hint != "Update" || e.URL != "https://tailscale.com/download" {
+		t.Fatalf("expected the primary action as the remedy but got %+v", e)
+	}
+
+	if err := json.NewDecoder(relay.do(http.MethodGet, "/health?keyExpiryThreshold=24h", "").Body).Decode(&h); err != nil {
+		t.Fatal(err)
+	}
+	if h.Errors[0].Type == KeyExpiring {
+		t.Fatal("expected the key not to expire within the threshold")
+	}
+	if resp := relay.do(http.MethodGet, "/health?keyExpiryThreshold=soon", ""); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected an invalid threshold to be rejected but got %d", resp.StatusCode)
+	}
+
+	// the errors of the peers leave out what Offline covers and minor warnings
+	var p getPeersResponse
+	if err := json.NewDecoder(relay.do(http.MethodGet, "/peers", "").Body).Decode(&p); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := types(p.Errors), []string{KeyExpiring, DNSMisconfigured, LockedOut}; !slices.Equal(got, want) {
+		t.Fatalf("got peer errors %v, want %v", got, want)
+	}
+}
+
+func TestHealthErrorsFromStatus(t *testing.T) {
+	now := time.Now()
+	expired := now.Add(-time.Minute)
+	st := &ipnstate.Status{
+		Self:   &ipnstate.PeerStatus{KeyExpiry: &expired},
+		Health: []string{"tailnet-lock: " + healthmsg.LockedOut, "some other problem"},
+	}
+	errs := healthErrors(st, nil, defaultKeyExpiryThreshold, now)
+	if len(errs) != 3 || errs[0].Type != KeyExpired || errs[1].Type != LockedOut ||
+		errs[2].Type != HealthWarning || errs[2].Message != "some other problem" {
+		t.Fatalf("unexpected errors %+v", errs)
+	}
+	if !strings.Contains(errs[1].Hint, "tailscale lock sign") {
+		t.Fatalf("expected a hint to sign the node but got %q", errs[1].Hint)
+	}
+
+	// without the health state, the messages of what
+	// Offline covers are left out of other endpoints
+	h := &handler{l: logger.Nop}
+	h.healthCache.set(nil, errors.New("no health state"))
+	st.Health = append(st.Health, "You are logged out. The last login error was: denied")
+	errs = h.statusHealthErrors(context.Background(), st)
+	if len(errs) != 3 || slices.ContainsFunc(errs, func(e Error) bool { return strings.HasPrefix(e.Message, "You are logged out.") }) {
+		t.Fatalf("expected the logged out message to be left out but got %+v", errs)
+	}
+
+	for d, want := range map[time.Duration]string{
+		30 * time.Minute:   "less than an hour",
+		5*time.Hour + 1:    "6 hours",
+		49 * time.Hour:     "3 days",
+		7 * 24 * time.Hour: "7 days",
+	} {
+		if got := formatDuration(d); got != want {
+			t.Errorf("formatDuration(%v) = %q, want %q", d, got, want)
+		}
+	}
+}
+
+// watchCountingClient counts the IPN bus watches of a mockClient.
+type watchCountingClient struct {
+	*mockClient
+	watches atomic.Int32
+}
+
+func (c *watchCountingClient) watchIPNBus(ctx context.Context, mask ipn.NotifyWatchOpt) (ipnBusWatcher, error) {
+	c.watches.Add(1)
+	return c.mockClient.watchIPNBus(ctx, mask)
+}
+
+func TestHealthStateIsCached(t *testing.T) {
+	m, _ := newTestMockClient(t, `{
+		"Status": {
+			"BackendState": "Running",
+			"Self": {"ID": "nSelf", "DNSName": "laptop.example.ts.net.", "Online": true, "TailscaleIPs": ["100.64.0.10"]},
+			"CurrentTailnet": {"Name": "example.com", "MagicDNSSuffix": "example.ts.net"}
+		},
+		"Health": {
+			"Warnings": {
+				"dns-forward-failing": {"WarnableCode": "dns-forward-failing", "Severity": "medium", "Text": "DNS unavailable."}
+			}
+		}
+	}`)
+	lc := &watchCountingClient{mockClient: m}
+	relay := serveTestHandler(t, &handler{
+		lc:    lc,
+		ports: newPortWatcher(logger.Nop, newMockPorts(nil)),
+		procs: fakeProcesses,
+	})
+
+	for _, path := range []string{"/peers", "/serve", "/peers", "/serve"} {
+		var body struct{ Errors []Error }
+		if err := json.NewDecoder(relay.do(http.MethodGet, path, "").Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		if !slices.ContainsFunc(body.Errors, func(e Error) bool { return e.Type == DNSMisconfigured }) {
+			t.Fatalf("expected the DNS warning in the errors of %s but got %+v", path, body.Errors)
+		}
+	}
+	if n := lc.watches.Load(); n != 1 {
+		t.Fatalf("expected one IPN bus watch for all polls but got %d", n)
+	}
+}
+
+func TestHealthWithoutHealthState(t *testing.T) {
+	_, relay := newTestRelay(t, `{
+		"Status": {
+			"BackendState": "Running",
+			"Self": {"ID": "nSelf", "Online": true},
+			"Health": ["some problem"]
+		},
+		"Faults": {"WatchIPNBus": {"Latency": "1m"}}
+	}`)
+	start := time.Now()
+	var h healthResponse
+	if err := json.NewDecoder(relay.do(http.MethodGet, "/health", "").Body).Decode(&h); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > healthTimeout+time.Second {
+		t.Fatalf("expected the health state to be given up on but took %v", d)
+	}
+	if len(h.Errors) != 1 || h.Errors[0].Message != "some problem" {
+		t.Fatalf("expected the health messages of the status but got %+v", h.Errors)
+	}
+}
@@ -2,15 +2,15 @@ package handler
 
 import (
 	"context"
-	"encoding/json"
+	"io"
 	"net"
-	"os"
-	"sync"
+	"net/netip"
 
-	"tailscale.com/client/local"
 	"tailscale.com/client/tailscale"
+	"tailscale.com/client/tailscale/apitype"
 	"tailscale.com/ipn"
 	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
 )
 
 // static check for local client interface implementation
@@ -22,66 +22,21 @@ type LocalClient interface {
 	GetServeConfig(ctx context.Context) (*ipn.ServeConfig, error)
 	StatusWithoutPeers(ctx context.Context) (*ipnstate.Status, error)
 	SetServeConfig(ctx context.Context, config *ipn.ServeConfig) error
-}
-
-type profile struct {
-	Status           *ipnstate.Status
-	ServeConfig      *ipn.ServeConfig
-	MockOffline      bool
-	MockAccessDenied bool
-}
-
-// NewMockClient returns a mock localClient
-// based on the given json file. The format of the file
-// is described in the profile struct. Note that SET
-// operations update the given input in memory.
-func NewMockClient(file string) (LocalClient, error) {
-	bts, err := os.ReadFile(file)
-	if err != nil {
-		return nil, err
-	}
-	var p profile
-	return &mockClient{p: &p}, json.Unmarshal(bts, &p)
-}
-
-type mockClient struct {
-	sync.Mutex
-	p *profile
-}
-
-// GetServeConfig implements localClient.
-func (m *mockClient) GetServeConfig(ctx context.Context) (*ipn.ServeConfig, error) {
-	if m.p.MockOffline {
-		return nil, &net.OpError{Op: "dial"}
-	}
-	return m.p.ServeConfig, nil
-}
-
-// SetServeConfig implements localClient.
-func (m *mockClient) SetServeConfig(ctx context.Context, config *ipn.ServeConfig) error {
-	if m.p.MockAccessDenied {
-		return &local.AccessDeniedError{}
-	}
-	m.Lock()
-	defer m.Unlock()
-	m.p.ServeConfig = config
-	return nil
-}
-
-// Status implements localClient.
-func (m *mockClient) Status(ctx context.Context) (*ipnstate.Status, error) {
-	if m.p.MockOffline || m.p.Status == nil {
-		return nil, &net.OpError{Op: "dial"}
-	}
-	return m.p.Status, nil
-}
-
-// StatusWithoutPeers implements localClient.
-func (m *mockClient) StatusWithoutPeers(ctx context.Context) (*ipnstate.Status, error) {
-	if m.p.MockOffline || m.p.Status == nil {
-		return nil, &net.OpError{Op: "dial"}
-	}
-	copy := *(m.p.Status)
-	copy.Peer = nil
-	return &copy, nil
+	Ping(ctx context.Context, ip netip.Addr, pingtype tailcfg.PingType) (*ipnstate.PingResult, error)
+	FileTargets(ctx context.Context) ([]apitype.FileTarget, error)
+	PushFile(ctx context.Context, target tailcfg.StableNodeID, size int64, name string, r io.Reader) error
+	WaitingFiles(ctx context.Context) ([]apitype.WaitingFile, error)
+	GetWaitingFile(ctx context.Context, baseName string) (rc io.ReadCloser, size int64, err error)
+	DeleteWaitingFile(ctx context.Context, baseName string) error
+	GetPrefs(ctx context.Context) (*ipn.Prefs, error)
+	EditPrefs(ctx context.Context, mp *ipn.MaskedPrefs) (*ipn.Prefs, error)
+	SuggestExitNode(ctx context.Context) (apitype.ExitNodeSuggestionResponse, error)
+	StartLoginInteractive(ctx context.Context) error
+	Logout(ctx context.Context) error
+	ProfileStatus(ctx context.Context) (current ipn.LoginProfile, all []ipn.LoginProfile, err error)
+	SwitchProfile(ctx context.Context, profile ipn.ProfileID) error
+	SwitchToEmptyProfile(ctx context.Context) error
+	DeleteProfile(ctx context.Context, profile ipn.ProfileID) error
+	DialTCP(ctx context.Context, host string, port uint16) (net.Conn, error)
+	WhoIs(ctx context.Context, remoteAddr string) (*apitype.WhoIsResponse, error)
 }
@@ -0,0 +1,151 @@
+package handler
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/netip"
+	"time"
+
+	"tailscale.com/health"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/empty"
+	"tailscale.com/types/netmap"
+	"tailscale.com/types/ptr"
+)
+
+// mockBusInterval is how often a mock IPN bus
+// watcher checks the scenario for changes.
+const mockBusInterval = 100 * time.Millisecond
+
+// watchIPNBus implements ipnBusSource. The watcher announces
+// the state, netmap and health of the scenario whenever
+// a state with a different status or health is entered, and
+// the login URL and finished logins of interactive logins.
+func (m *mockClient) watchIPNBus(ctx context.Context, mask ipn.NotifyWatchOpt) (ipnBusWatcher, error) {
+	snap, err := m.call(ctx, "WatchIPNBus")
+	if err != nil {
+		return nil, err
+	}
+	if snap.offline || snap.status == nil {
+		return nil, &net.OpError{Op: "dial"}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	return &mockBusWatcher{m: m, ctx: ctx, cancel: cancel, mask: mask}, nil
+}
+
+type mockBusWatcher struct {
+	m      *mockClient
+	ctx    context.Context
+	cancel context.CancelFunc
+	mask   ipn.NotifyWatchOpt
+
+	started bool
+	status  *ipnstate.Status
+	health  *health.State
+	url     string
+}
+
+// current returns the current state without counting as a call.
+func (m *mockClient) current() mockSnapshot {
+	m.Lock()
+	defer m.Unlock()
+	m.reloadLocked()
+	m.advanceLocked()
+	return m.snapshotLocked()
+}
+
+// Next implements ipnBusWatcher. It fails like a real
+// watcher does when the scenario takes tailscaled down.
+func (w *mockBusWatcher) Next() (ipn.Notify, error) {
+	t := time.NewTicker(mockBusInterval)
+	defer t.Stop()
+	for {
+		snap := w.m.current()
+		if snap.offline || snap.status == nil {
+			return ipn.Notify{}, io.ErrUnexpectedEOF
+		}
+		var n ipn.Notify
+		initial := !w.started
+		if snap.status != w.status {
+			if !initial || w.mask&ipn.NotifyInitialState != 0 {
+				n.State = ptr.To(parseIPNState(snap.status.BackendState))
+			}
+			if !initial || w.mask&ipn.NotifyInitialNetMap != 0 {
+				n.NetMap = netMapOfStatus(snap.status)
+			}
+		}
+		if initial || snap.health != w.health {
+			if !initial || w.mask&ipn.NotifyInitialHealthState != 0 {
+				n.Health = snap.health
+				if n.Health == nil {
+					n.Health = new(health.State)
+				}
+			}
+		}
+		if snap.browseToURL != w.url && snap.browseToURL != "" &&
+			(!initial || w.mask&ipn.NotifyInitialState != 0) {
+			n.BrowseToURL = ptr.To(snap.browseToURL)
+		}
+		if w.url != "" && w.status != nil && w.status.BackendState != ipn.Running.String() &&
+			snap.status.BackendState == ipn.Running.String() {
+			n.LoginFinished = &empty.Message{}
+		}
+		w.started = true
+		w.status = snap.status
+		w.health = snap.health
+		w.url = snap.browseToURL
+		if n.State != nil || n.NetMap != nil || n.Health != nil || n.BrowseToURL != nil || n.LoginFinished != nil {
+			return n, nil
+		}
+		select {
+		case <-w.ctx.Done():
+			return ipn.Notify{}, w.ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// Close implements ipnBusWatcher.
+func (w *mockBusWatcher) Close() error {
+	w.cancel()
+	return nil
+}
+
+// parseIPNState returns the ipn.State with the given name.
+func parseIPNState(s string) ipn.State {
+	for st := ipn.NoState; st <= ipn.Running; st++ {
+		if st.String() == s {
+			return st
+		}
+	}
+	return ipn.NoState
+}
+
+// netMapOfStatus returns a netmap with the peers of the
+// status, holding the fields that /events looks at.
+func netMapOfStatus(st *ipnstate.Status) *netmap.NetworkMap {
+	nm := new(netmap.NetworkMap)
+	if st.Self != nil {
+		nm.Name = st.Self.DNSName
+	}
+	for _, p := range st.Peer {
+		n := &tailcfg.Node{
+			StableID: p.ID,
+			Name:     p.DNSName,
+			Online:   ptr.To(p.Online),
+			Hostinfo: (&tailcfg.Hostinfo{
+				Hostname:     p.HostName,
+				SSH_HostKeys: p.SSH_HostKeys,
+				ShareeNode:   p.ShareeNode,
+			}).View(),
+		}
+		for _, ip := range p.TailscaleIPs {
+			n.Addresses = append(n.Addresses, netip.PrefixFrom(ip, ip.BitLen()))
+		}
+		nm.Peers = append(nm.Peers, n.View())
+	}
+	return nm
+}
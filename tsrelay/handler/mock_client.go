@@ -0,0 +1,584 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/client/local"
+	"tailscale.com/health"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+)
+
+// mockReloadInterval is how often the profile
+// file is checked for changes.
+const mockReloadInterval = time.Second
+
+// Errors a mockFault can inject.
+const (
+	// faultOffline fails as if tailscaled wasn't running.
+	faultOffline = "offline"
+	// faultAccessDenied fails as if the user lacked permissions.
+	faultAccessDenied = "accessDenied"
+	// faultConflict fails as if the serve config
+	// was changed by someone else in the meantime.
+	faultConflict = "conflict"
+	// faultPeerNotFound fails as if WhoIs
+	// didn't know the address.
+	faultPeerNotFound = "peerNotFound"
+)
+
+// profile describes the responses of the mock LocalClient. The
+// top level fields are the initial state, which can be followed
+// by more States.
+type profile struct {
+	Status           *ipnstate.Status
+	ServeConfig      *ipn.ServeConfig
+	MockOffline      bool
+	MockAccessDenied bool
+	// Health is announced on the IPN bus.
+	Health *health.State `json:",omitempty"`
+
+	// Faults inject latency and errors into LocalClient
+	// methods by method name, such as "SetServeConfig".
+	Faults map[string]mockFault `json:",omitempty"`
+	// States are entered in order once their condition is
+	// met, or at any time through the /mock endpoint.
+	States []mockState `json:",omitempty"`
+	// Recording are calls recorded with -record. When a method
+	// has recorded calls, they are replayed in order instead of
+	// answering from the state and the last one is repeated.
+	Recording []mockCall `json:",omitempty"`
+
+	// WaitingFiles are files received with Taildrop.
+	WaitingFiles []mockFile `json:",omitempty"`
+	// LoginProfiles are the accounts to switch between
+	// and CurrentProfile the one that is logged in.
+	LoginProfiles  []mockLoginProfile `json:",omitempty"`
+	CurrentProfile ipn.ProfileID      `json:",omitempty"`
+	// Prefs are the initial prefs. The exit node they
+	// select is reflected in the status.
+	Prefs *ipn.Prefs `json:",omitempty"`
+	// PeerServices are the ports of peers that DialTCP connects to.
+	PeerServices []mockService `json:",omitempty"`
+	// PeerCaps are the capabilities WhoIs reports
+	// that the packet filter grants to peers.
+	PeerCaps map[tailcfg.StableNodeID]tailcfg.PeerCapMap `json:",omitempty"`
+
+	// Ports is a timeline of ports opening and closing
+	// and Processes is the table of processes that own
+	// them. Both are optional.
+	Ports     []mockPort    `json:",omitempty"`
+	Processes []mockProcess `json:",omitempty"`
+}
+
+// mockState is a step of a scenario such as logging in,
+// going offline or getting the funnel capability.
+type mockState struct {
+	Name string
+	// After and AfterCalls enter the state once the given time has
+	// passed or the given number of LocalClient calls were made since
+	// the previous state was entered. States without either are only
+	// entered through the /mock endpoint.
+	After      mockDuration
+	AfterCalls int
+
+	// Status, ServeConfig and Health replace
+	// those of the previous state.
+	Status      *ipnstate.Status
+	ServeConfig *ipn.ServeConfig
+	Health      *health.State
+	// BackendState, Online, AddCapabilities and RemoveCapabilities
+	// patch the status. Capabilities with parameters, such as the
+	// funnel ports, are removed by their name without parameters.
+	BackendState       string
+	Online             *bool
+	AddCapabilities    []tailcfg.NodeCapability
+	RemoveCapabilities []tailcfg.NodeCapability
+
+	MockOffline      bool
+	MockAccessDenied bool
+	// Faults are merged over the faults of the profile.
+	Faults map[string]mockFault
+}
+
+// mockFault slows down or fails calls to a LocalClient method.
+type mockFault struct {
+	Latency mockDuration
+	// Error is "offline", "accessDenied", "conflict",
+	// "peerNotFound" or the message of a generic error.
+	Error string
+}
+
+// NewMockClient returns a mock localClient
+// based on the given json file. The format of the file
+// is described in the profile struct. Note that SET
+// operations update the given input in memory.
+func NewMockClient(file string) (LocalClient, error) {
+	lc, _, _, err := NewMockProfile(file)
+	return lc, err
+}
+
+// NewMockProfile is like NewMockClient but also returns a
+// PortLister and a ProcessTable for the ports and processes
+// of the profile. They are nil if the profile has none, and
+// the ports timeline starts when the profile is loaded. The
+// LocalClient reloads the file when it changes, the ports
+// and processes don't.
+func NewMockProfile(file string) (LocalClient, PortLister, ProcessTable, error) {
+	p, modTime, err := readProfile(file)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	m := &mockClient{file: file}
+	m.useLocked(p, modTime)
+	var pl PortLister
+	if len(m.p.Ports) > 0 {
+		pl = newMockPorts(m.p.Ports)
+	}
+	var pt ProcessTable
+	if len(m.p.Processes) > 0 {
+		pt = newMockProcesses(m.p.Processes)
+	}
+	return m, pl, pt, nil
+}
+
+type mockClient struct {
+	sync.Mutex
+	file    string
+	modTime time.Time
+	checked time.Time
+	p       *profile
+
+	// state is the index of the current state, where 0 is
+	// the top level of the profile and i is p.States[i-1].
+	state   int
+	entered time.Time
+	calls   int
+	status  *ipnstate.Status
+	sc      *ipn.ServeConfig
+	health  *health.State
+	files   []mockFile
+	pushed  []mockPush
+	prefs   *ipn.Prefs
+	// profiles are the login profiles, profile the current
+	// one and browseToURL the URL of a pending login.
+	profiles    []mockLoginProfile
+	profile     ipn.ProfileID
+	browseToURL string
+	// replayed counts the recorded calls replayed per method.
+	replayed map[string]int
+}
+
+// readProfile reads and parses the given profile file.
+func readProfile(file string) (*profile, time.Time, error) {
+	fi, err := os.Stat(file)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	bts, err := os.ReadFile(file)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	var p profile
+	if err := json.Unmarshal(bts, &p); err != nil {
+		return nil, time.Time{}, fmt.Errorf("error parsing profile: %w", err)
+	}
+	return &p, fi.ModTime(), nil
+}
+
+// useLocked starts the scenario of the given profile from the top.
+func (m *mockClient) useLocked(p *profile, modTime time.Time) {
+	m.p = p
+	m.modTime = modTime
+	m.checked = time.Now()
+	m.sc = p.ServeConfig
+	m.files = slices.Clone(p.WaitingFiles)
+	m.profiles = slices.Clone(p.LoginProfiles)
+	m.profile = p.CurrentProfile
+	m.browseToURL = ""
+	m.prefs = p.Prefs.Clone()
+	if m.prefs == nil {
+		m.prefs = new(ipn.Prefs)
+		if p.Status != nil && p.Status.ExitNodeStatus != nil {
+			m.prefs.ExitNodeID = p.Status.ExitNodeStatus.ID
+		}
+	}
+	m.replayed = make(map[string]int)
+	m.enterLocked(0)
+}
+
+// reloadLocked reloads the profile if the file changed and
+// stays in the state with the same name if there still is one.
+// Invalid files are ignored until they change again.
+func (m *mockClient) reloadLocked() {
+	if time.Since(m.checked) < mockReloadInterval {
+		return
+	}
+	m.checked = time.Now()
+	fi, err := os.Stat(m.file)
+	if err != nil || fi.ModTime().Equal(m.modTime) {
+		return
+	}
+	p, modTime, err := readProfile(m.file)
+	if err != nil {
+		m.modTime = fi.ModTime()
+		return
+	}
+	name := m.stateName(m.state)
+	m.useLocked(p, modTime)
+	if i := m.stateIndex(name); i > 0 {
+		m.enterLocked(i)
+	}
+}
+
+func (m *mockClient) stateName(i int) string {
+	if i == 0 {
+		return "initial"
+	}
+	return m.p.States[i-1].Name
+}
+
+func (m *mockClient) stateIndex(name string) int {
+	for i := range len(m.p.States) + 1 {
+		if m.stateName(i) == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// enterLocked makes the i-th state the current one.
+func (m *mockClient) enterLocked(i int) {
+	m.state = i
+	m.entered = time.Now()
+	m.calls = 0
+	m.status = m.p.Status
+	m.health = m.p.Health
+	for _, s := range m.p.States[:i] {
+		m.status = s.patch(m.status)
+		if s.Health != nil {
+			m.health = s.Health
+		}
+	}
+	m.status = withExitNode(m.status, m.prefs)
+	if m.status != nil && m.status.BackendState == ipn.Running.String() {
+		// a pending login finished
+		m.browseToURL = ""
+	}
+	if i > 0 && m.p.States[i-1].ServeConfig != nil {
+		m.sc = m.p.States[i-1].ServeConfig
+	}
+}
+
+// advanceLocked enters the following states whose condition is met.
+func (m *mockClient) advanceLocked() {
+	for m.state < len(m.p.States) {
+		next := m.p.States[m.state]
+		if (next.After > 0 && time.Since(m.entered) >= time.Duration(next.After)) ||
+			(next.AfterCalls > 0 && m.calls >= next.AfterCalls) {
+			m.enterLocked(m.state + 1)
+			continue
+		}
+		return
+	}
+}
+
+// patch returns the status of this state given
+// the status of the previous one.
+func (s *mockState) patch(st *ipnstate.Status) *ipnstate.Status {
+	if s.Status != nil {
+		st = s.Status
+	}
+	if st == nil || (s.BackendState == "" && s.Online == nil &&
+		len(s.AddCapabilities) == 0 && len(s.RemoveCapabilities) == 0) {
+		return st
+	}
+	patched := *st
+	if s.BackendState != "" {
+		patched.BackendState = s.BackendState
+	}
+	if patched.Self == nil {
+		return &patched
+	}
+	self := *patched.Self
+	patched.Self = &self
+	if s.Online != nil {
+		self.Online = *s.Online
+	}
+	removed := func(c tailcfg.NodeCapability) bool {
+		return slices.ContainsFunc(s.RemoveCapabilities, func(r tailcfg.NodeCapability) bool {
+			return c == r || strings.HasPrefix(string(c), string(r)+"?")
+		})
+	}
+	self.Capabilities = slices.DeleteFunc(slices.Clone(self.Capabilities), removed)
+	self.Capabilities = append(self.Capabilities, s.AddCapabilities...)
+	self.CapMap = maps.Clone(self.CapMap)
+	if self.CapMap == nil {
+		self.CapMap = make(tailcfg.NodeCapMap)
+	}
+	maps.DeleteFunc(self.CapMap, func(c tailcfg.NodeCapability, _ []tailcfg.RawMessage) bool {
+		return removed(c)
+	})
+	for _, c := range s.AddCapabilities {
+		self.CapMap[c] = nil
+	}
+	return &patched
+}
+
+// mockSnapshot is what a call sees of the current state.
+type mockSnapshot struct {
+	status       *ipnstate.Status
+	health       *health.State
+	browseToURL  string
+	offline      bool
+	accessDenied bool
+	// replay is the recorded call to answer from, if any.
+	replay *mockCall
+}
+
+// snapshotLocked returns the current state.
+func (m *mockClient) snapshotLocked() mockSnapshot {
+	snap := mockSnapshot{
+		status:       m.status,
+		health:       m.health,
+		browseToURL:  m.browseToURL,
+		offline:      m.p.MockOffline,
+		accessDenied: m.p.MockAccessDenied,
+	}
+	if m.state > 0 {
+		s := m.p.States[m.state-1]
+		snap.offline = s.MockOffline
+		snap.accessDenied = s.MockAccessDenied
+	}
+	return snap
+}
+
+// nextReplayLocked returns the next recorded call of the given method.
+func (m *mockClient) nextReplayLocked(method string) *mockCall {
+	var calls []*mockCall
+	for i := range m.p.Recording {
+		if m.p.Recording[i].Method == method {
+			calls = append(calls, &m.p.Recording[i])
+		}
+	}
+	if len(calls) == 0 {
+		return nil
+	}
+	i := min(m.replayed[method], len(calls)-1)
+	m.replayed[method]++
+	return calls[i]
+}
+
+// call counts a call to the given method, advances the scenario and
+// applies the faults of the current state. It returns the state the
+// call should be answered from.
+func (m *mockClient) call(ctx context.Context, method string) (mockSnapshot, error) {
+	m.Lock()
+	m.reloadLocked()
+	m.calls++
+	m.advanceLocked()
+	snap := m.snapshotLocked()
+	f, ok := m.p.Faults[method]
+	if m.state > 0 {
+		if sf, sok := m.p.States[m.state-1].Faults[method]; sok {
+			f, ok = sf, true
+		}
+	}
+	if snap.replay = m.nextReplayLocked(method); snap.replay != nil {
+		f = mockFault{Latency: snap.replay.Latency, Error: snap.replay.Error}
+		ok = true
+	}
+	m.Unlock()
+	if !ok {
+		return snap, nil
+	}
+	if f.Latency > 0 {
+		t := time.NewTimer(time.Duration(f.Latency))
+		defer t.Stop()
+		select {
+		case <-ctx.Done():
+			return snap, ctx.Err()
+		case <-t.C:
+		}
+	}
+	switch f.Error {
+	case "":
+		return snap, nil
+	case faultOffline:
+		return snap, &net.OpError{Op: "dial", Err: errors.New("mock offline")}
+	case faultAccessDenied:
+		return snap, &local.AccessDeniedError{}
+	case faultConflict:
+		return snap, &local.PreconditionsFailedError{}
+	case faultPeerNotFound:
+		return snap, local.ErrPeerNotFound
+	default:
+		return snap, errors.New(f.Error)
+	}
+}
+
+// serveConfigETag returns the ETag of the given config the way
+// tailscaled computes it, a hash of its JSON encoding.
+func serveConfigETag(sc *ipn.ServeConfig) (string, error) {
+	bts, err := json.Marshal(sc)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(bts)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// GetServeConfig implements localClient.
+func (m *mockClient) GetServeConfig(ctx context.Context) (*ipn.ServeConfig, error) {
+	snap, err := m.call(ctx, "GetServeConfig")
+	if err != nil {
+		return nil, err
+	}
+	if snap.replay != nil {
+		var sc *ipn.ServeConfig
+		if err := json.Unmarshal(snap.replay.Result, &sc); err != nil {
+			return nil, fmt.Errorf("error replaying serve config: %w", err)
+		}
+		if sc != nil {
+			sc.ETag = snap.replay.ETag
+		}
+		return sc, nil
+	}
+	if snap.offline {
+		return nil, &net.OpError{Op: "dial"}
+	}
+	m.Lock()
+	defer m.Unlock()
+	if m.sc == nil {
+		return nil, nil
+	}
+	sc := m.sc.Clone()
+	sc.ETag, err = serveConfigETag(m.sc)
+	return sc, err
+}
+
+// SetServeConfig implements localClient. Configs with an ETag
+// fail if the config was changed since the ETag was handed out.
+func (m *mockClient) SetServeConfig(ctx context.Context, config *ipn.ServeConfig) error {
+	snap, err := m.call(ctx, "SetServeConfig")
+	if err != nil || snap.replay != nil {
+		return err
+	}
+	if snap.accessDenied {
+		return &local.AccessDeniedError{}
+	}
+	m.Lock()
+	defer m.Unlock()
+	if config != nil && config.ETag != "" {
+		etag, err := serveConfigETag(m.sc)
+		if err != nil {
+			return err
+		}
+		if etag != config.ETag {
+			return &local.PreconditionsFailedError{}
+		}
+	}
+	m.sc = config
+	return nil
+}
+
+// Status implements localClient.
+func (m *mockClient) Status(ctx context.Context) (*ipnstate.Status, error) {
+	snap, err := m.call(ctx, "Status")
+	if err != nil {
+		return nil, err
+	}
+	if snap.replay != nil {
+		return replayStatus(snap.replay)
+	}
+	if snap.offline || snap.status == nil {
+		return nil, &net.OpError{Op: "dial"}
+	}
+	return snap.status, nil
+}
+
+// StatusWithoutPeers implements localClient.
+func (m *mockClient) StatusWithoutPeers(ctx context.Context) (*ipnstate.Status, error) {
+	snap, err := m.call(ctx, "StatusWithoutPeers")
+	if err != nil {
+		return nil, err
+	}
+	if snap.replay != nil {
+		return replayStatus(snap.replay)
+	}
+	if snap.offline || snap.status == nil {
+		return nil, &net.OpError{Op: "dial"}
+	}
+	copy := *(snap.status)
+	copy.Peer = nil
+	return &copy, nil
+}
+
+// Ping implements localClient. Replies are made up from the
+// status: disco pings to peers with a CurAddr go direct and
+// others through the DERP region of the peer. Offline peers
+// don't reply.
+func (m *mockClient) Ping(ctx context.Context, ip netip.Addr, pingtype tailcfg.PingType) (*ipnstate.PingResult, error) {
+	snap, err := m.call(ctx, "Ping")
+	if err != nil {
+		return nil, err
+	}
+	if snap.replay != nil {
+		var res *ipnstate.PingResult
+		if err := json.Unmarshal(snap.replay.Result, &res); err != nil {
+			return nil, fmt.Errorf("error replaying ping: %w", err)
+		}
+		return res, nil
+	}
+	if snap.offline || snap.status == nil {
+		return nil, &net.OpError{Op: "dial"}
+	}
+	for _, p := range snap.status.Peer {
+		if !slices.Contains(p.TailscaleIPs, ip) {
+			continue
+		}
+		if !p.Online {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		res := &ipnstate.PingResult{
+			IP:       ip.String(),
+			NodeIP:   ip.String(),
+			NodeName: strings.Split(p.DNSName, ".")[0],
+		}
+		switch {
+		case pingtype != tailcfg.PingDisco:
+			res.LatencySeconds = 0.01
+		case p.CurAddr != "":
+			res.Endpoint = p.CurAddr
+			res.LatencySeconds = 0.005
+		default:
+			res.DERPRegionCode = p.Relay
+			res.LatencySeconds = 0.05
+		}
+		return res, nil
+	}
+	return &ipnstate.PingResult{IP: ip.String(), Err: "no matching peer"}, nil
+}
+
+func replayStatus(call *mockCall) (*ipnstate.Status, error) {
+	var st *ipnstate.Status
+	if err := json.Unmarshal(call.Result, &st); err != nil {
+		return nil, fmt.Errorf("error replaying status: %w", err)
+	}
+	return st, nil
+}
@@ -0,0 +1,262 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/tailscale-dev/vscode-tailscale/tsrelay/logger"
+	"tailscale.com/client/local"
+	"tailscale.com/ipn"
+	"tailscale.com/tailcfg"
+)
+
+const testScenario = `{
+	"Status": {
+		"BackendState": "NeedsLogin",
+		"Self": {"DNSName": "demo.ts.net.", "CapMap": {"https://tailscale.com/cap/warn-funnel-no-https": null}}
+	},
+	"Faults": {"Status": {"Latency": "20ms"}},
+	"States": [
+		{"Name": "loggedIn", "AfterCalls": 2, "BackendState": "Running", "Online": true},
+		{
+			"Name": "funnelGranted",
+			"RemoveCapabilities": ["https://tailscale.com/cap/warn-funnel-no-https"],
+			"AddCapabilities": ["funnel"]
+		},
+		{"Name": "broken", "Faults": {"Status": {"Error": "offline"}, "SetServeConfig": {"Error": "conflict"}}}
+	]
+}`
+
+func newTestMockClient(t *testing.T, profile string) (*mockClient, string) {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "profile.json")
+	if err := os.WriteFile(file, []byte(profile), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	lc, err := NewMockClient(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lc.(*mockClient), file
+}
+
+// testRelay is a handler served over HTTP for tests.
+type testRelay struct {
+	*httptest.Server
+	t *testing.T
+}
+
+// newTestRelay serves a handler for a mock client of profile.
+func newTestRelay(t *testing.T, profile string) (*mockClient, *testRelay) {
+	t.Helper()
+	m, _ := newTestMockClient(t, profile)
+	return m, serveTestHandler(t, &handler{lc: m})
+}
+
+// serveTestHandler serves h with the nonce of the test
+// requests and, unless it has one, a logger that discards.
+func serveTestHandler(t *testing.T, h *handler) *testRelay {
+	h.nonce = "123"
+	if h.l == nil {
+		h.l = logger.Nop
+	}
+	srv := httptest.NewServer(newHandler(h))
+	t.Cleanup(srv.Close)
+	return &testRelay{Server: srv, t: t}
+}
+
+// do sends an authenticated request with body, if not empty, and
+// closes the response body when the test is done.
+func (r *testRelay) do(method, path, body string) *http.Response {
+	r.t.Helper()
+	var b io.Reader
+	if body != "" {
+		b = strings.NewReader(body)
+	}
+	req, err := http.NewRequest(method, r.URL+path, b)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	req.SetBasicAuth("123", "")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	r.t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// dial opens an authenticated websocket to path.
+func (r *testRelay) dial(path string) (*websocket.Conn, *http.Response, error) {
+	headers := http.Header{}
+	headers.Set("Authorization", "Basic "+basicAuth("123", ""))
+	return websocket.DefaultDialer.Dial(strings.Replace(r.URL, "http://", "ws://", 1)+path, headers)
+}
+
+func TestMockClientScenario(t *testing.T) {
+	m, _ := newTestMockClient(t, testScenario)
+	ctx := context.Background()
+
+	start := time.Now()
+	st, err := m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) < 20*time.Millisecond {
+		t.Fatal("expected latency to be injected")
+	}
+	if st.BackendState != "NeedsLogin" || st.Self.Online {
+		t.Fatalf("expected to start logged out but got %q", st.BackendState)
+	}
+	// the second call logs in
+	st, err = m.StatusWithoutPeers(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.BackendState != "Running" || !st.Self.Online {
+		t.Fatalf("expected to be logged in after two calls but got %q", st.BackendState)
+	}
+	if !st.Self.HasCap(tailcfg.CapabilityWarnFunnelNoHTTPS) {
+		t.Fatal("expected the capabilities of the initial state to be kept")
+	}
+
+	// states without a condition are only entered explicitly
+	for range 5 {
+		m.Status(ctx)
+	}
+	if _, err := m.jump(mockJump{Next: true}); err != nil {
+		t.Fatal(err)
+	}
+	st, err = m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.BackendState != "Running" || st.Self.HasCap(tailcfg.CapabilityWarnFunnelNoHTTPS) || !st.Self.HasCap(tailcfg.NodeAttrFunnel) {
+		t.Fatalf("expected patched capabilities but got %v", st.Self.CapMap)
+	}
+	if m.p.Status.Self.HasCap(tailcfg.NodeAttrFunnel) {
+		t.Fatal("patching modified the profile")
+	}
+
+	if _, err := m.jump(mockJump{State: "broken"}); err != nil {
+		t.Fatal(err)
+	}
+	var oe *net.OpError
+	if _, err := m.Status(ctx); !errors.As(err, &oe) || oe.Op != "dial" {
+		t.Fatalf("expected a dial error but got %v", err)
+	}
+	if err := m.SetServeConfig(ctx, &ipn.ServeConfig{}); !local.IsPreconditionsFailedError(err) {
+		t.Fatalf("expected a conflict but got %v", err)
+	}
+	if _, err := m.jump(mockJump{Next: true}); err == nil {
+		t.Fatal("expected no state after the last one")
+	}
+}
+
+func TestMockClientETag(t *testing.T) {
+	m, _ := newTestMockClient(t, `{"ServeConfig": {"TCP": {"443": {"HTTPS": true}}}}`)
+	ctx := context.Background()
+	first, err := m.GetServeConfig(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := m.GetServeConfig(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.ETag == "" || first.ETag != second.ETag {
+		t.Fatalf("expected stable ETags but got %q and %q", first.ETag, second.ETag)
+	}
+	delete(first.TCP, 443)
+	if err := m.SetServeConfig(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+	second.TCP[8443] = &ipn.TCPPortHandler{HTTPS: true}
+	if err := m.SetServeConfig(ctx, second); !local.IsPreconditionsFailedError(err) {
+		t.Fatalf("expected a stale ETag to conflict but got %v", err)
+	}
+	sc, err := m.GetServeConfig(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sc.TCP) != 0 {
+		t.Fatalf("expected the first update to win but got %v", sc.TCP)
+	}
+}
+
+func TestMockClientReload(t *testing.T) {
+	m, file := newTestMockClient(t, testScenario)
+	ctx := context.Background()
+	m.Status(ctx)
+	m.Status(ctx)
+
+	reloaded := strings.Replace(testScenario, `"demo.ts.net."`, `"reloaded.ts.net."`, 1)
+	if err := os.WriteFile(file, []byte(reloaded), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(file, future, future); err != nil {
+		t.Fatal(err)
+	}
+	m.Lock()
+	m.checked = time.Time{}
+	m.Unlock()
+
+	st, err := m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Self.DNSName != "reloaded.ts.net." {
+		t.Fatalf("expected the profile to be reloaded but got %q", st.Self.DNSName)
+	}
+	if st.BackendState != "Running" {
+		t.Fatalf("expected to stay in the loggedIn state but got %q", st.BackendState)
+	}
+}
+
+func TestMockControl(t *testing.T) {
+	m, _ := newTestMockClient(t, testScenario)
+	relay := serveTestHandler(t, &handler{lc: m})
+	do := func(method, body string) (int, mockStatus) {
+		resp := relay.do(method, "/mock", body)
+		var ms mockStatus
+		json.NewDecoder(resp.Body).Decode(&ms)
+		return resp.StatusCode, ms
+	}
+
+	code, ms := do(http.MethodGet, "")
+	if code != http.StatusOK || ms.State != "initial" || len(ms.States) != 4 {
+		t.Fatalf("unexpected status %d %+v", code, ms)
+	}
+	code, ms = do(http.MethodPost, `{"state": "funnelGranted"}`)
+	if code != http.StatusOK || ms.State != "funnelGranted" {
+		t.Fatalf("unexpected status %d %+v", code, ms)
+	}
+	if code, _ = do(http.MethodPost, `{"state": "nope"}`); code != http.StatusNotFound {
+		t.Fatalf("expected unknown states to be rejected but got %d", code)
+	}
+
+	// recording a mock profile keeps it controllable
+	rc := NewRecordingClient(m, filepath.Join(t.TempDir(), "recording.json"), logger.Nop)
+	t.Cleanup(func() { rc.Close() })
+	relay = serveTestHandler(t, &handler{lc: rc})
+	if code, ms = do(http.MethodGet, ""); code != http.StatusOK || ms.State != "funnelGranted" {
+		t.Fatalf("expected the recorded mock to be controllable but got %d %+v", code, ms)
+	}
+
+	relay = serveTestHandler(t, &handler{lc: &local.Client{}})
+	if code, _ = do(http.MethodGet, ""); code != http.StatusNotFound {
+		t.Fatalf("expected no mock control without a mock profile but got %d", code)
+	}
+}
@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// mockStatus is the state of the scenario of a mock client.
+type mockStatus struct {
+	State  string   `json:"state"`
+	States []string `json:"states"`
+	// Calls is the number of LocalClient calls
+	// made since the state was entered.
+	Calls int `json:"calls"`
+}
+
+// mockJump selects the state to jump to by name,
+// or the one after the current state if Next is set.
+type mockJump struct {
+	State string `json:"state,omitempty"`
+	Next  bool   `json:"next,omitempty"`
+}
+
+func (m *mockClient) statusLocked() mockStatus {
+	ms := mockStatus{
+		State: m.stateName(m.state),
+		Calls: m.calls,
+	}
+	for i := range len(m.p.States) + 1 {
+		ms.States = append(ms.States, m.stateName(i))
+	}
+	return ms
+}
+
+// jump enters the given state regardless of its condition. The serve
+// config set through the relay is kept unless the state replaces it.
+func (m *mockClient) jump(j mockJump) (mockStatus, error) {
+	m.Lock()
+	defer m.Unlock()
+	i := m.state + 1
+	if !j.Next {
+		i = m.stateIndex(j.State)
+	}
+	if j.Next && i > len(m.p.States) {
+		return m.statusLocked(), fmt.Errorf("no state after %q", m.stateName(m.state))
+	} else if i < 0 {
+		return m.statusLocked(), fmt.Errorf("no such state %q", j.State)
+	}
+	m.enterLocked(i)
+	return m.statusLocked(), nil
+}
+
+// mockClientOf returns the mockClient of lc, which
+// may be wrapped in a RecordingClient.
+func mockClientOf(lc LocalClient) (*mockClient, bool) {
+	if rc, ok := lc.(*RecordingClient); ok {
+		lc = rc.lc
+	}
+	m, ok := lc.(*mockClient)
+	return m, ok
+}
+
+func (h *handler) getMockHandler(w http.ResponseWriter, r *http.Request) {
+	m, _ := mockClientOf(h.lc)
+	m.Lock()
+	ms := m.statusLocked()
+	m.Unlock()
+	json.NewEncoder(w).Encode(ms)
+}
+
+func (h *handler) setMockHandler(w http.ResponseWriter, r *http.Request) {
+	m, _ := mockClientOf(h.lc)
+	var j mockJump
+	if err := json.NewDecoder(r.Body).Decode(&j); err != nil {
+		http.Error(w, "invalid body", http.StatusBadRequest)
+		return
+	}
+	ms, err := m.jump(j)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	h.l.Printf("mock profile jumped to state %q", ms.State)
+	json.NewEncoder(w).Encode(ms)
+}
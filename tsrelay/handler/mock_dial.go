@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"syscall"
+
+	"tailscale.com/tailcfg"
+)
+
+// mockService is a port a peer accepts connections on.
+type mockService struct {
+	Peer tailcfg.StableNodeID
+	Port uint16
+	// Forward is the address of a local server that connections are
+	// forwarded to. Without it, connections are answered with the
+	// Banner and then stay silent until closed.
+	Forward string `json:",omitempty"`
+	Banner  string `json:",omitempty"`
+}
+
+// DialTCP implements localClient. Connections to online peers are
+// made to their PeerServices, other ports are refused. Dialing an
+// offline peer blocks until ctx is done.
+func (m *mockClient) DialTCP(ctx context.Context, host string, port uint16) (net.Conn, error) {
+	snap, err := m.call(ctx, "DialTCP")
+	if err != nil {
+		return nil, err
+	}
+	if snap.offline || snap.status == nil {
+		return nil, &net.OpError{Op: "dial"}
+	}
+	ip, _ := netip.ParseAddr(host)
+	host = strings.TrimSuffix(host, ".")
+	for _, p := range snap.status.Peer {
+		if strings.TrimSuffix(p.DNSName, ".") != host && (!ip.IsValid() || !slices.Contains(p.TailscaleIPs, ip)) {
+			continue
+		}
+		if !p.Online {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		m.Lock()
+		services := m.p.PeerServices
+		m.Unlock()
+		for _, s := range services {
+			if s.Peer != p.ID || s.Port != port {
+				continue
+			}
+			if s.Forward != "" {
+				var d net.Dialer
+				return d.DialContext(ctx, "tcp", s.Forward)
+			}
+			client, server := net.Pipe()
+			go serveBanner(server, s.Banner)
+			return client, nil
+		}
+		return nil, &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+	}
+	return nil, errors.New("no matching peer")
+}
+
+// serveBanner writes the banner to conn and
+// discards what is sent until conn is closed.
+func serveBanner(conn net.Conn, banner string) {
+	defer conn.Close()
+	if banner != "" {
+		if _, err := io.WriteString(conn, banner); err != nil {
+			return
+		}
+	}
+	io.Copy(io.Discard, conn)
+}
@@ -0,0 +1,185 @@
+package handler
+
+import (
+	"cmp"
+	"encoding/binary"
+	"encoding/json"
+	"hash/fnv"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"tailscale.com/portlist"
+)
+
+// mockDuration is a time.Duration that is
+// written as a string such as "1m30s" in profiles.
+type mockDuration time.Duration
+
+func (d mockDuration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *mockDuration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	*d = mockDuration(v)
+	return err
+}
+
+// mockPort is a port in a scripted timeline. It opens
+// OpenAfter the timeline started and closes CloseAfter
+// it started, or never if CloseAfter is zero.
+type mockPort struct {
+	portlist.Port
+	// Addrs are the addresses the port is bound to.
+	Addrs      []netip.Addr `json:",omitempty"`
+	OpenAfter  mockDuration `json:",omitempty"`
+	CloseAfter mockDuration `json:",omitempty"`
+}
+
+// mockPorts is a PortLister that plays back a timeline of
+// ports opening and closing. The timeline starts when the
+// mockPorts is created.
+type mockPorts struct {
+	mu       sync.Mutex
+	start    time.Time
+	timeline []mockPort
+	last     []portlist.Port
+	polled   bool
+}
+
+func newMockPorts(timeline []mockPort) *mockPorts {
+	return &mockPorts{start: time.Now(), timeline: timeline}
+}
+
+// open adds a port to the timeline that opens right away.
+func (m *mockPorts) open(p portlist.Port) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.timeline = append(m.timeline, mockPort{Port: p, OpenAfter: mockDuration(time.Since(m.start))})
+}
+
+// close closes the given port right away.
+func (m *mockPorts) close(proto string, port uint16) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	el := time.Since(m.start)
+	for i := range m.timeline {
+		mp := &m.timeline[i]
+		if mp.Proto == proto && mp.Port.Port == port && mp.openAt(el) {
+			mp.CloseAfter = mockDuration(el)
+		}
+	}
+}
+
+// replace hands the port of p over to the process of p right away,
+// closing it for its current owner in the same instant.
+func (m *mockPorts) replace(p portlist.Port) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	el := time.Since(m.start)
+	for i := range m.timeline {
+		mp := &m.timeline[i]
+		if mp.Proto == p.Proto && mp.Port.Port == p.Port && mp.openAt(el) {
+			mp.CloseAfter = mockDuration(el)
+		}
+	}
+	m.timeline = append(m.timeline, mockPort{Port: p, OpenAfter: mockDuration(el)})
+}
+
+func (mp *mockPort) openAt(el time.Duration) bool {
+	return time.Duration(mp.OpenAfter) <= el && (mp.CloseAfter == 0 || el < time.Duration(mp.CloseAfter))
+}
+
+// openLocked returns the ports that are currently open.
+// m.mu must be held.
+func (m *mockPorts) openLocked() []portlist.Port {
+	el := time.Since(m.start)
+	var ports []portlist.Port
+	for i := range m.timeline {
+		if m.timeline[i].openAt(el) {
+			ports = append(ports, m.timeline[i].Port)
+		}
+	}
+	slices.SortFunc(ports, func(a, b portlist.Port) int {
+		return cmp.Or(cmp.Compare(a.Port, b.Port), cmp.Compare(a.Proto, b.Proto), cmp.Compare(a.Pid, b.Pid))
+	})
+	return ports
+}
+
+// Poll implements PortLister.
+func (m *mockPorts) Poll() ([]portlist.Port, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ports := m.openLocked()
+	changed := !m.polled || !slices.Equal(ports, m.last)
+	m.polled = true
+	m.last = ports
+	return ports, changed, nil
+}
+
+// fingerprint implements portChangeDetector
+// so that changes are noticed right away.
+func (m *mockPorts) fingerprint() (uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h := fnv.New64a()
+	for _, p := range m.openLocked() {
+		h.Write([]byte(p.Proto))
+		binary.Write(h, binary.BigEndian, p.Port)
+		binary.Write(h, binary.BigEndian, int64(p.Pid))
+	}
+	return h.Sum64(), nil
+}
+
+// bindAddrs implements bindAddrLister.
+func (m *mockPorts) bindAddrs() (map[portKey][]netip.Addr, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	el := time.Since(m.start)
+	addrs := make(map[portKey][]netip.Addr)
+	for _, mp := range m.timeline {
+		if mp.openAt(el) && len(mp.Addrs) > 0 {
+			k := portKey{mp.Proto, mp.Port.Port}
+			addrs[k] = append(addrs[k], mp.Addrs...)
+		}
+	}
+	return addrs, nil
+}
+
+// mockProcess is a process in a mock process table.
+type mockProcess struct {
+	PID  int
+	PPID int
+	Args []string `json:",omitempty"`
+	Cwd  string   `json:",omitempty"`
+	Env  []string `json:",omitempty"`
+}
+
+// mockProcesses is a ProcessTable backed by a fixed list of processes.
+type mockProcesses map[int]mockProcess
+
+func newMockProcesses(procs []mockProcess) mockProcesses {
+	m := make(mockProcesses, len(procs))
+	for _, p := range procs {
+		m[p.PID] = p
+	}
+	return m
+}
+
+// Parent implements ProcessTable.
+func (m mockProcesses) Parent(pid int) (int, error) { return m[pid].PPID, nil }
+
+// Args implements ProcessTable.
+func (m mockProcesses) Args(pid int) []string { return m[pid].Args }
+
+// Cwd implements ProcessTable.
+func (m mockProcesses) Cwd(pid int) string { return m[pid].Cwd }
+
+// Env implements ProcessTable.
+func (m mockProcesses) Env(pid int) []string { return m[pid].Env }
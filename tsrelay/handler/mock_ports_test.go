@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"tailscale.com/portlist"
+)
+
+func TestMockPortsTimeline(t *testing.T) {
+	m := newMockPorts([]mockPort{
+		{Port: portlist.Port{Proto: "tcp", Port: 3000, Pid: 1}},
+		{Port: portlist.Port{Proto: "tcp", Port: 4000, Pid: 2}, CloseAfter: mockDuration(50 * time.Millisecond)},
+		{Port: portlist.Port{Proto: "udp", Port: 5000, Pid: 3}, OpenAfter: mockDuration(50 * time.Millisecond)},
+	})
+	ports, changed, err := m.Poll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed || len(ports) != 2 || ports[0].Port != 3000 || ports[1].Port != 4000 {
+		t.Fatalf("expected ports 3000 and 4000 on the first poll but got %v", ports)
+	}
+	if _, changed, _ = m.Poll(); changed {
+		t.Fatal("expected no change right away")
+	}
+	before, _ := m.fingerprint()
+
+	time.Sleep(60 * time.Millisecond)
+	ports, changed, _ = m.Poll()
+	if !changed || len(ports) != 2 || ports[0].Port != 3000 || ports[1].Port != 5000 {
+		t.Fatalf("expected ports 3000 and 5000 after the timeline moved on but got %v", ports)
+	}
+	if after, _ := m.fingerprint(); after == before {
+		t.Fatal("expected the fingerprint to change")
+	}
+
+	m.close("tcp", 3000)
+	m.open(portlist.Port{Proto: "tcp", Port: 3001, Pid: 4})
+	ports, _, _ = m.Poll()
+	if len(ports) != 2 || ports[0].Port != 3001 || ports[1].Port != 5000 {
+		t.Fatalf("expected ports 3001 and 5000 after scripting but got %v", ports)
+	}
+}
+
+func TestNewMockProfile(t *testing.T) {
+	lc, pl, pt, err := NewMockProfile("../../profiles/portdisco.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lc == nil || pl == nil || pt == nil {
+		t.Fatalf("expected client, ports and processes but got %v, %v, %v", lc, pl, pt)
+	}
+	if ppid, _ := pt.Parent(1001); ppid != 1000 {
+		t.Fatalf("expected parent 1000 but got %d", ppid)
+	}
+	if cwd := pt.Cwd(1001); cwd != "/tmp/demo" {
+		t.Fatalf("expected cwd /tmp/demo but got %q", cwd)
+	}
+	m := pl.(*mockPorts)
+	// move the timeline past the first port opening
+	m.start = m.start.Add(-10 * time.Second)
+	ports, _, _ := pl.Poll()
+	if len(ports) != 1 || ports[0].Port != 5173 || ports[0].Process != "node" || ports[0].Pid != 1001 {
+		t.Fatalf("expected the vite port but got %v", ports)
+	}
+	addrs, _ := m.bindAddrs()
+	if got := addrs[portKey{"tcp", 5173}]; len(got) != 1 || got[0] != netip.MustParseAddr("127.0.0.1") {
+		t.Fatalf("unexpected bind addresses %v", got)
+	}
+
+	_, pl, pt, err = NewMockProfile("../../profiles/offline.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pl != nil || pt != nil {
+		t.Fatal("expected no ports or processes for a profile without them")
+	}
+}
@@ -0,0 +1,142 @@
+package handler
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"net"
+	"net/netip"
+	"strings"
+
+	"tailscale.com/client/local"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+)
+
+// GetPrefs implements localClient.
+func (m *mockClient) GetPrefs(ctx context.Context) (*ipn.Prefs, error) {
+	snap, err := m.call(ctx, "GetPrefs")
+	if err != nil {
+		return nil, err
+	}
+	if snap.replay != nil {
+		return replayPrefs(snap.replay)
+	}
+	if snap.offline {
+		return nil, &net.OpError{Op: "dial"}
+	}
+	m.Lock()
+	defer m.Unlock()
+	return m.prefs.Clone(), nil
+}
+
+// EditPrefs implements localClient.
+func (m *mockClient) EditPrefs(ctx context.Context, mp *ipn.MaskedPrefs) (*ipn.Prefs, error) {
+	snap, err := m.call(ctx, "EditPrefs")
+	if err != nil {
+		return nil, err
+	}
+	if snap.replay != nil {
+		return replayPrefs(snap.replay)
+	}
+	if snap.offline {
+		return nil, &net.OpError{Op: "dial"}
+	}
+	if snap.accessDenied {
+		return nil, &local.AccessDeniedError{}
+	}
+	m.Lock()
+	defer m.Unlock()
+	m.prefs.ApplyEdits(mp)
+	m.status = withExitNode(m.status, m.prefs)
+	return m.prefs.Clone(), nil
+}
+
+// SuggestExitNode implements localClient. Online exit nodes of
+// the tailnet are preferred over Mullvad ones, and Mullvad exit
+// nodes with a higher priority over those with a lower one.
+func (m *mockClient) SuggestExitNode(ctx context.Context) (apitype.ExitNodeSuggestionResponse, error) {
+	var res apitype.ExitNodeSuggestionResponse
+	snap, err := m.call(ctx, "SuggestExitNode")
+	if err != nil {
+		return res, err
+	}
+	if snap.replay != nil {
+		if err := json.Unmarshal(snap.replay.Result, &res); err != nil {
+			return res, fmt.Errorf("error replaying exit node suggestion: %w", err)
+		}
+		return res, nil
+	}
+	if snap.offline || snap.status == nil {
+		return res, &net.OpError{Op: "dial"}
+	}
+	rank := func(p *ipnstate.PeerStatus) int {
+		if !strings.Contains(p.DNSName, "mullvad.ts.net") {
+			return math.MaxInt
+		}
+		if p.Location == nil {
+			return 0
+		}
+		return p.Location.Priority
+	}
+	var best *ipnstate.PeerStatus
+	for _, p := range snap.status.Peer {
+		if !p.Online || !p.ExitNodeOption {
+			continue
+		}
+		if best == nil || cmp.Or(cmp.Compare(rank(p), rank(best)), cmp.Compare(best.ID, p.ID)) > 0 {
+			best = p
+		}
+	}
+	if best == nil {
+		return res, nil
+	}
+	res.ID = best.ID
+	res.Name = best.DNSName
+	if best.Location != nil {
+		res.Location = best.Location.View()
+	}
+	return res, nil
+}
+
+// withExitNode returns the status with the exit node
+// selected by the prefs marked as such.
+func withExitNode(st *ipnstate.Status, prefs *ipn.Prefs) *ipnstate.Status {
+	if st == nil {
+		return st
+	}
+	marked := *st
+	marked.ExitNodeStatus = nil
+	cloned := false
+	for k, p := range st.Peer {
+		if active := isCurrentExitNode(p, prefs); p.ExitNode != active {
+			if !cloned {
+				marked.Peer = maps.Clone(st.Peer)
+				cloned = true
+			}
+			cp := *p
+			cp.ExitNode = active
+			p = &cp
+			marked.Peer[k] = p
+		}
+		if p.ExitNode {
+			marked.ExitNodeStatus = &ipnstate.ExitNodeStatus{ID: p.ID, Online: p.Online}
+			for _, ip := range p.TailscaleIPs {
+				marked.ExitNodeStatus.TailscaleIPs = append(marked.ExitNodeStatus.TailscaleIPs, netip.PrefixFrom(ip, ip.BitLen()))
+			}
+		}
+	}
+	return &marked
+}
+
+func replayPrefs(call *mockCall) (*ipn.Prefs, error) {
+	var p *ipn.Prefs
+	if err := json.Unmarshal(call.Result, &p); err != nil {
+		return nil, fmt.Errorf("error replaying prefs: %w", err)
+	}
+	return p, nil
+}
@@ -0,0 +1,177 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+
+	"tailscale.com/client/local"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+)
+
+// mockLoginURL is where the mock sends users to log in.
+const mockLoginURL = "https://login.tailscale.com/a/mock"
+
+// mockLoginProfile is a login profile. Switching to it replaces
+// the status with its Status until the next state is entered.
+type mockLoginProfile struct {
+	ipn.LoginProfile
+	Status *ipnstate.Status `json:",omitempty"`
+}
+
+// mockProfileStatus is a recorded ProfileStatus result.
+type mockProfileStatus struct {
+	Current ipn.LoginProfile
+	All     []ipn.LoginProfile
+}
+
+// StartLoginInteractive implements localClient. The login URL is
+// announced on the IPN bus and the login finishes once a state
+// with the Running backend state is entered.
+func (m *mockClient) StartLoginInteractive(ctx context.Context) error {
+	snap, err := m.call(ctx, "StartLoginInteractive")
+	if err != nil || snap.replay != nil {
+		return err
+	}
+	if snap.offline {
+		return &net.OpError{Op: "dial"}
+	}
+	if snap.accessDenied {
+		return &local.AccessDeniedError{}
+	}
+	m.Lock()
+	defer m.Unlock()
+	m.browseToURL = mockLoginURL
+	return nil
+}
+
+// Logout implements localClient. Like tailscaled, it
+// deletes the current profile.
+func (m *mockClient) Logout(ctx context.Context) error {
+	snap, err := m.call(ctx, "Logout")
+	if err != nil || snap.replay != nil {
+		return err
+	}
+	if snap.offline {
+		return &net.OpError{Op: "dial"}
+	}
+	if snap.accessDenied {
+		return &local.AccessDeniedError{}
+	}
+	m.Lock()
+	defer m.Unlock()
+	m.profiles = slices.DeleteFunc(m.profiles, func(p mockLoginProfile) bool { return p.ID == m.profile })
+	m.switchLocked("", nil)
+	return nil
+}
+
+// ProfileStatus implements localClient.
+func (m *mockClient) ProfileStatus(ctx context.Context) (ipn.LoginProfile, []ipn.LoginProfile, error) {
+	snap, err := m.call(ctx, "ProfileStatus")
+	if err != nil {
+		return ipn.LoginProfile{}, nil, err
+	}
+	if snap.replay != nil {
+		var ps mockProfileStatus
+		if err := json.Unmarshal(snap.replay.Result, &ps); err != nil {
+			return ipn.LoginProfile{}, nil, fmt.Errorf("error replaying profile status: %w", err)
+		}
+		return ps.Current, ps.All, nil
+	}
+	if snap.offline {
+		return ipn.LoginProfile{}, nil, &net.OpError{Op: "dial"}
+	}
+	m.Lock()
+	defer m.Unlock()
+	var current ipn.LoginProfile
+	all := []ipn.LoginProfile{}
+	for _, p := range m.profiles {
+		if p.ID == m.profile {
+			current = p.LoginProfile
+		}
+		all = append(all, p.LoginProfile)
+	}
+	return current, all, nil
+}
+
+// SwitchProfile implements localClient.
+func (m *mockClient) SwitchProfile(ctx context.Context, profile ipn.ProfileID) error {
+	snap, err := m.call(ctx, "SwitchProfile")
+	if err != nil || snap.replay != nil {
+		return err
+	}
+	if snap.offline {
+		return &net.OpError{Op: "dial"}
+	}
+	if snap.accessDenied {
+		return &local.AccessDeniedError{}
+	}
+	m.Lock()
+	defer m.Unlock()
+	i := slices.IndexFunc(m.profiles, func(p mockLoginProfile) bool { return p.ID == profile })
+	if i < 0 {
+		return errors.New("profile not found")
+	}
+	m.switchLocked(profile, m.profiles[i].Status)
+	return nil
+}
+
+// SwitchToEmptyProfile implements localClient.
+func (m *mockClient) SwitchToEmptyProfile(ctx context.Context) error {
+	snap, err := m.call(ctx, "SwitchToEmptyProfile")
+	if err != nil || snap.replay != nil {
+		return err
+	}
+	if snap.offline {
+		return &net.OpError{Op: "dial"}
+	}
+	if snap.accessDenied {
+		return &local.AccessDeniedError{}
+	}
+	m.Lock()
+	defer m.Unlock()
+	m.switchLocked("", nil)
+	return nil
+}
+
+// DeleteProfile implements localClient.
+func (m *mockClient) DeleteProfile(ctx context.Context, profile ipn.ProfileID) error {
+	snap, err := m.call(ctx, "DeleteProfile")
+	if err != nil || snap.replay != nil {
+		return err
+	}
+	if snap.offline {
+		return &net.OpError{Op: "dial"}
+	}
+	if snap.accessDenied {
+		return &local.AccessDeniedError{}
+	}
+	m.Lock()
+	defer m.Unlock()
+	n := len(m.profiles)
+	m.profiles = slices.DeleteFunc(m.profiles, func(p mockLoginProfile) bool { return p.ID == profile })
+	if len(m.profiles) == n {
+		return errors.New("profile not found")
+	}
+	if profile == m.profile {
+		m.switchLocked("", nil)
+	}
+	return nil
+}
+
+// switchLocked makes the given profile the current one. The
+// empty profile isn't logged in and has no status of its own.
+func (m *mockClient) switchLocked(profile ipn.ProfileID, st *ipnstate.Status) {
+	m.profile = profile
+	m.browseToURL = ""
+	if st == nil && profile == "" {
+		st = &ipnstate.Status{BackendState: ipn.NeedsLogin.String()}
+	}
+	if st != nil {
+		m.status = withExitNode(st, m.prefs)
+	}
+}
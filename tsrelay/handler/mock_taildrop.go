@@ -0,0 +1,161 @@
+package handler
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"slices"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/ptr"
+)
+
+// mockFile is a file received with Taildrop.
+type mockFile struct {
+	Name    string
+	Content string
+}
+
+// mockPush is a file pushed to a peer.
+type mockPush struct {
+	Target tailcfg.StableNodeID
+	Name   string
+	Size   int64
+	Data   []byte
+}
+
+// FileTargets implements localClient. Online untagged
+// peers of the same user as the mock node can receive files.
+func (m *mockClient) FileTargets(ctx context.Context) ([]apitype.FileTarget, error) {
+	snap, err := m.call(ctx, "FileTargets")
+	if err != nil {
+		return nil, err
+	}
+	if snap.replay != nil {
+		var targets []apitype.FileTarget
+		if err := json.Unmarshal(snap.replay.Result, &targets); err != nil {
+			return nil, fmt.Errorf("error replaying file targets: %w", err)
+		}
+		return targets, nil
+	}
+	if snap.offline || snap.status == nil {
+		return nil, &net.OpError{Op: "dial"}
+	}
+	var targets []apitype.FileTarget
+	for _, p := range snap.status.Peer {
+		if !p.Online || p.Tags != nil && p.Tags.Len() > 0 ||
+			snap.status.Self == nil || p.UserID != snap.status.Self.UserID {
+			continue
+		}
+		n := &tailcfg.Node{
+			StableID: p.ID,
+			Name:     p.DNSName,
+			User:     p.UserID,
+			Online:   ptr.To(p.Online),
+			Hostinfo: (&tailcfg.Hostinfo{Hostname: p.HostName, SSH_HostKeys: p.SSH_HostKeys}).View(),
+		}
+		for _, ip := range p.TailscaleIPs {
+			n.Addresses = append(n.Addresses, netip.PrefixFrom(ip, ip.BitLen()))
+		}
+		t := apitype.FileTarget{Node: n}
+		if len(p.TailscaleIPs) > 0 {
+			t.PeerAPIURL = "http://" + netip.AddrPortFrom(p.TailscaleIPs[0], 1).String()
+		}
+		targets = append(targets, t)
+	}
+	slices.SortFunc(targets, func(a, b apitype.FileTarget) int {
+		return cmp.Compare(a.Node.StableID, b.Node.StableID)
+	})
+	return targets, nil
+}
+
+// PushFile implements localClient. The file is
+// read to the end and kept to be inspected by tests.
+func (m *mockClient) PushFile(ctx context.Context, target tailcfg.StableNodeID, size int64, name string, r io.Reader) error {
+	snap, err := m.call(ctx, "PushFile")
+	if err != nil {
+		return err
+	}
+	if snap.offline {
+		return &net.OpError{Op: "dial"}
+	}
+	data, err := io.ReadAll(r)
+	if err != nil || snap.replay != nil {
+		return err
+	}
+	if size >= 0 && int64(len(data)) != size {
+		return fmt.Errorf("declared size %d but got %d bytes", size, len(data))
+	}
+	m.Lock()
+	defer m.Unlock()
+	m.pushed = append(m.pushed, mockPush{Target: target, Name: name, Size: size, Data: data})
+	return nil
+}
+
+// WaitingFiles implements localClient.
+func (m *mockClient) WaitingFiles(ctx context.Context) ([]apitype.WaitingFile, error) {
+	snap, err := m.call(ctx, "WaitingFiles")
+	if err != nil {
+		return nil, err
+	}
+	if snap.replay != nil {
+		var files []apitype.WaitingFile
+		if err := json.Unmarshal(snap.replay.Result, &files); err != nil {
+			return nil, fmt.Errorf("error replaying waiting files: %w", err)
+		}
+		return files, nil
+	}
+	if snap.offline {
+		return nil, &net.OpError{Op: "dial"}
+	}
+	m.Lock()
+	defer m.Unlock()
+	files := []apitype.WaitingFile{}
+	for _, f := range m.files {
+		files = append(files, apitype.WaitingFile{Name: f.Name, Size: int64(len(f.Content))})
+	}
+	return files, nil
+}
+
+// GetWaitingFile implements localClient.
+func (m *mockClient) GetWaitingFile(ctx context.Context, baseName string) (io.ReadCloser, int64, error) {
+	snap, err := m.call(ctx, "GetWaitingFile")
+	if err != nil {
+		return nil, 0, err
+	}
+	if snap.offline {
+		return nil, 0, &net.OpError{Op: "dial"}
+	}
+	m.Lock()
+	defer m.Unlock()
+	i := slices.IndexFunc(m.files, func(f mockFile) bool { return f.Name == baseName })
+	if i < 0 {
+		// tailscaled answers with status 500 and a redacted path
+		return nil, 0, errors.New("HTTP 500 Internal Server Error: open redacted: file does not exist")
+	}
+	content := m.files[i].Content
+	return io.NopCloser(bytes.NewReader([]byte(content))), int64(len(content)), nil
+}
+
+// DeleteWaitingFile implements localClient.
+func (m *mockClient) DeleteWaitingFile(ctx context.Context, baseName string) error {
+	snap, err := m.call(ctx, "DeleteWaitingFile")
+	if err != nil || snap.replay != nil {
+		return err
+	}
+	if snap.offline {
+		return &net.OpError{Op: "dial"}
+	}
+	// like tailscaled, deleting a missing file succeeds
+	m.Lock()
+	defer m.Unlock()
+	m.files = slices.DeleteFunc(m.files, func(f mockFile) bool { return f.Name == baseName })
+	return nil
+}
@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"tailscale.com/client/local"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+)
+
+// WhoIs implements localClient. It answers for the
+// Tailscale IPs of the peers and of the self node.
+func (m *mockClient) WhoIs(ctx context.Context, remoteAddr string) (*apitype.WhoIsResponse, error) {
+	snap, err := m.call(ctx, "WhoIs")
+	if err != nil {
+		return nil, err
+	}
+	if snap.replay != nil {
+		var res *apitype.WhoIsResponse
+		if err := json.Unmarshal(snap.replay.Result, &res); err != nil {
+			return nil, fmt.Errorf("error replaying whois: %w", err)
+		}
+		return res, nil
+	}
+	if snap.offline || snap.status == nil {
+		return nil, &net.OpError{Op: "dial"}
+	}
+	ip, err := netip.ParseAddr(remoteAddr)
+	if err != nil {
+		ap, err := netip.ParseAddrPort(remoteAddr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid remoteAddr %q", remoteAddr)
+		}
+		ip = ap.Addr()
+	}
+	nodes := make([]*ipnstate.PeerStatus, 0, len(snap.status.Peer)+1)
+	if snap.status.Self != nil {
+		nodes = append(nodes, snap.status.Self)
+	}
+	for _, p := range snap.status.Peer {
+		nodes = append(nodes, p)
+	}
+	for _, p := range nodes {
+		if !slices.Contains(p.TailscaleIPs, ip) {
+			continue
+		}
+		n := &tailcfg.Node{
+			StableID:     p.ID,
+			Name:         p.DNSName,
+			ComputedName: strings.Split(p.DNSName, ".")[0],
+			Hostinfo:     (&tailcfg.Hostinfo{Hostname: p.HostName, OS: p.OS}).View(),
+		}
+		if p.Tags != nil {
+			n.Tags = p.Tags.AsSlice()
+		}
+		for _, ip := range p.TailscaleIPs {
+			n.Addresses = append(n.Addresses, netip.PrefixFrom(ip, ip.BitLen()))
+		}
+		up := snap.status.User[p.UserID]
+		if n.IsTagged() {
+			up = tailcfg.UserProfile{LoginName: "tagged-devices", DisplayName: "Tagged Devices"}
+		}
+		m.Lock()
+		caps := m.p.PeerCaps[p.ID]
+		m.Unlock()
+		return &apitype.WhoIsResponse{Node: n, UserProfile: &up, CapMap: caps}, nil
+	}
+	return nil, local.ErrPeerNotFound
+}
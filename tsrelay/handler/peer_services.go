@@ -0,0 +1,431 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"tailscale.com/tailcfg"
+)
+
+const (
+	// scanDialTimeout bounds how long dialing a single port may take.
+	scanDialTimeout = 3 * time.Second
+	// scanProbeTimeout bounds fingerprinting an open port.
+	scanProbeTimeout = 3 * time.Second
+	// bannerTimeout is how long to wait for servers that
+	// speak first, such as SSH, to send their banner.
+	bannerTimeout = 500 * time.Millisecond
+	// maxBanner bounds the banner reported for a service.
+	maxBanner = 200
+	// defaultScanConcurrency and maxScanConcurrency bound
+	// the number of ports dialed at the same time.
+	defaultScanConcurrency = 16
+	maxScanConcurrency     = 64
+	// maxScanPorts bounds the number of ports of a scan.
+	maxScanPorts = 1024
+)
+
+// defaultScanPorts are the ports scanned when none are given,
+// the usual ports of dev servers, SSH and databases.
+var defaultScanPorts = []uint16{
+	21, 22, 25, 80, 443, 1433, 3000, 3001, 3306, 4000, 5000, 5173,
+	5432, 6379, 8000, 8080, 8443, 8888, 9000, 9200, 27017,
+}
+
+// Services that can be recognized by fingerprinting.
+const (
+	serviceHTTP     = "http"
+	serviceHTTPS    = "https"
+	serviceTLS      = "tls"
+	serviceSSH      = "ssh"
+	serviceFTP      = "ftp"
+	serviceSMTP     = "smtp"
+	serviceMySQL    = "mysql"
+	servicePostgres = "postgres"
+	serviceRedis    = "redis"
+	serviceMongoDB  = "mongodb"
+)
+
+// peerService is an open port of a peer.
+type peerService struct {
+	Port uint16
+	// Service is the recognized protocol of the port,
+	// or empty if the port is open but unrecognized.
+	Service string `json:",omitempty"`
+	// Banner is the first line the server sent or, for
+	// HTTP services, the Server header of the response.
+	Banner string `json:",omitempty"`
+	// Title is the title of the page served on / by HTTP services.
+	Title string `json:",omitempty"`
+	// URL opens HTTP services in a browser.
+	URL string `json:",omitempty"`
+}
+
+type getPeerServicesResponse struct {
+	// Services are the open ports by port number.
+	Services []*peerService
+	// Scanned is the number of ports that were scanned.
+	Scanned int
+	Errors  []Error `json:",omitempty"`
+}
+
+func (h *handler) getPeerServicesHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	ports, err := parsePorts(q.Get("ports"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	concurrency := defaultScanConcurrency
+	if c := q.Get("concurrency"); c != "" {
+		concurrency, err = strconv.Atoi(c)
+		if err != nil || concurrency < 1 {
+			http.Error(w, "concurrency must be a positive number", http.StatusBadRequest)
+			return
+		}
+		concurrency = min(concurrency, maxScanConcurrency)
+	}
+
+	s, err := h.getPeerServices(r.Context(), tailcfg.StableNodeID(chi.URLParam(r, "id")), ports, concurrency)
+	if err != nil {
+		if r.Context().Err() != nil {
+			// the client is gone
+			return
+		}
+		var re RelayError
+		if errors.As(err, &re) {
+			w.WriteHeader(re.statusCode)
+			json.NewEncoder(w).Encode(re)
+			return
+		}
+		h.l.Println("error scanning peer:", err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	json.NewEncoder(w).Encode(s)
+}
+
+// getPeerServices dials the given ports of a peer over the tailnet
+// and fingerprints the open ones. At most concurrency ports are
+// dialed at the same time.
+func (h *handler) getPeerServices(ctx context.Context, id tailcfg.StableNodeID, ports []uint16, concurrency int) (*getPeerServicesResponse, error) {
+	peer, err := h.findPeer(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &getPeerServicesResponse{Services: []*peerService{}, Scanned: len(ports)}
+	if !peer.Online {
+		s.Errors = append(s.Errors, Error{Type: PeerOffline})
+		return s, nil
+	}
+	// dial by IP so that scans work without MagicDNS
+	host := peer.Address
+	if len(peer.TailscaleIPs) > 0 {
+		host = peer.TailscaleIPs[0].String()
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency)
+	for _, port := range ports {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return nil, ctx.Err()
+		}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			svc := h.scanPort(ctx, host, port)
+			if svc == nil {
+				return
+			}
+			if svc.Service == serviceHTTP || svc.Service == serviceHTTPS {
+				svc.URL = serviceURL(svc.Service, peer.Address, port)
+			}
+			mu.Lock()
+			s.Services = append(s.Services, svc)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	slices.SortFunc(s.Services, func(a, b *peerService) int { return int(a.Port) - int(b.Port) })
+	return s, nil
+}
+
+// scanPort returns the service on the given port,
+// or nil if the port doesn't accept connections.
+func (h *handler) scanPort(ctx context.Context, host string, port uint16) *peerService {
+	dial := func() (net.Conn, error) {
+		dctx, cancel := context.WithTimeout(ctx, scanDialTimeout)
+		defer cancel()
+		return h.lc.DialTCP(dctx, host, port)
+	}
+	conn, err := dial()
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+	svc := &peerService{Port: port}
+	conn.SetDeadline(time.Now().Add(scanProbeTimeout))
+
+	// servers that speak first identify themselves
+	br := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(bannerTimeout))
+	if banner, _ := br.Peek(1); len(banner) > 0 {
+		b, _ := br.Peek(min(br.Buffered(), maxProbeBody))
+		svc.Service, svc.Banner = identifyBanner(b)
+		return svc
+	}
+
+	// others are asked for / as HTTP is the most
+	// likely, and databases reply with an error
+	conn.SetDeadline(time.Now().Add(scanProbeTimeout))
+	hostPort := net.JoinHostPort(host, strconv.Itoa(int(port)))
+	var hint probeHint
+	svc.Service, svc.Banner, svc.Title, hint = probeHTTP(conn, br, hostPort)
+	if svc.Service != "" || hint == hintNone {
+		return svc
+	}
+
+	if hint == hintClosed {
+		pconn, err := dial()
+		if err != nil {
+			return svc
+		}
+		defer pconn.Close()
+		pconn.SetDeadline(time.Now().Add(bannerTimeout))
+		if probePostgres(pconn) {
+			svc.Service = servicePostgres
+			return svc
+		}
+	}
+
+	tconn, err := dial()
+	if err != nil {
+		return svc
+	}
+	defer tconn.Close()
+	tconn.SetDeadline(time.Now().Add(scanProbeTimeout))
+	tc := tls.Client(tconn, &tls.Config{InsecureSkipVerify: true, ServerName: host})
+	if err := tc.HandshakeContext(ctx); err != nil {
+		return svc
+	}
+	svc.Service = serviceTLS
+	if service, banner, title, _ := probeHTTP(tc, bufio.NewReader(tc), hostPort); service == serviceHTTP {
+		svc.Service, svc.Banner, svc.Title = serviceHTTPS, banner, title
+	}
+	return svc
+}
+
+// identifyBanner recognizes the banner of servers that speak first.
+func identifyBanner(b []byte) (service, banner string) {
+	line := firstLine(b)
+	switch {
+	case strings.HasPrefix(line, "SSH-"):
+		return serviceSSH, line
+	case strings.HasPrefix(line, "220") && strings.Contains(strings.ToUpper(line), "FTP"):
+		return serviceFTP, line
+	case strings.HasPrefix(line, "220"):
+		return serviceSMTP, line
+	}
+	// a MySQL handshake is a packet with a 3 byte length and a
+	// sequence number followed by the protocol version 10 and
+	// the null terminated server version.
+	if len(b) > 5 && b[3] == 0 && b[4] == 10 {
+		if n := int(binary.LittleEndian.Uint32(append(b[:3:3], 0))); n > 1 {
+			if v, _, ok := bytes.Cut(b[5:], []byte{0}); ok {
+				return serviceMySQL, truncate(string(v))
+			}
+		}
+	}
+	return "", printable(line)
+}
+
+var titleRE = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+
+// probeHint is what a server that didn't answer the HTTP
+// probe in a recognizable way tells about its protocol.
+type probeHint int
+
+const (
+	hintNone probeHint = iota
+	// hintTLS means the server waited for a ClientHello
+	// or replied as TLS servers do to plain HTTP.
+	hintTLS
+	// hintClosed means the server closed the connection without
+	// a reply. PostgreSQL rejects the request as a startup packet
+	// of an invalid length that way, and some TLS servers do too.
+	hintClosed
+)
+
+// postgresSSLRequest asks a PostgreSQL server whether
+// it supports TLS, which it answers with 'S' or 'N'.
+var postgresSSLRequest = []byte{0x00, 0x00, 0x00, 0x08, 0x04, 0xd2, 0x16, 0x2f}
+
+// probeHTTP sends a request for / and identifies the reply. If it
+// can't, hint tells what to try next.
+func probeHTTP(conn net.Conn, br *bufio.Reader, hostPort string) (service, banner, title string, hint probeHint) {
+	req, err := http.NewRequest(http.MethodGet, "http://"+hostPort+"/", nil)
+	if err != nil {
+		return "", "", "", hintNone
+	}
+	req.Header.Set("User-Agent", "tsrelay")
+	req.Header.Set("Accept", "text/html,*/*")
+	req.Close = true
+	if err := req.Write(conn); err != nil {
+		return "", "", "", hintNone
+	}
+	b, err := br.Peek(5)
+	switch {
+	case len(b) == 0 && isTimeout(err):
+		// TLS servers wait for a ClientHello
+		return "", "", "", hintTLS
+	case len(b) == 0 && (errors.Is(err, io.EOF) || errors.Is(err, syscall.ECONNRESET)):
+		// closing with the request unread resets the connection
+		return "", "", "", hintClosed
+	case len(b) == 0:
+		return "", "", "", hintNone
+	}
+	switch {
+	case string(b) == "HTTP/":
+		resp, err := http.ReadResponse(br, req)
+		if err != nil {
+			return serviceHTTP, "", "", hintNone
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxProbeBody))
+		if bytes.Contains(body, []byte("trying to access MongoDB over HTTP")) {
+			return serviceMongoDB, "", "", hintNone
+		}
+		if resp.StatusCode == http.StatusBadRequest && bytes.Contains(bytes.ToUpper(body), []byte("HTTPS")) {
+			// "Client sent an HTTP request to an HTTPS server."
+			return "", "", "", hintTLS
+		}
+		if m := titleRE.FindSubmatch(body); m != nil {
+			title = truncate(strings.Join(strings.Fields(html.UnescapeString(string(m[1]))), " "))
+		}
+		return serviceHTTP, truncate(resp.Header.Get("Server")), title, hintNone
+	case b[0] == '-' || b[0] == '+':
+		// RESP errors such as "-ERR wrong number of arguments"
+		line, _ := br.ReadString('\n')
+		return serviceRedis, printable(firstLine([]byte(line))), "", hintNone
+	case len(b) > 1 && b[0] == 0x15 && b[1] == 0x03:
+		// a TLS alert
+		return "", "", "", hintTLS
+	}
+	rest, _ := br.Peek(min(br.Buffered(), maxBanner))
+	return "", printable(firstLine(rest)), "", hintNone
+}
+
+// probePostgres reports whether conn is to a PostgreSQL server.
+func probePostgres(conn net.Conn) bool {
+	if _, err := conn.Write(postgresSSLRequest); err != nil {
+		return false
+	}
+	b := make([]byte, 2)
+	n, _ := io.ReadAtLeast(conn, b, 1)
+	// the server waits for a handshake or startup packet after it
+	return n == 1 && (b[0] == 'S' || b[0] == 'N')
+}
+
+// serviceURL returns the URL to open an HTTP service of a peer in a
+// browser. addr is the MagicDNS name of the peer or its IP.
+func serviceURL(service, addr string, port uint16) string {
+	scheme := "http"
+	if service == serviceHTTPS {
+		scheme = "https"
+	}
+	if (scheme == "http" && port == 80) || (scheme == "https" && port == 443) {
+		return fmt.Sprintf("%s://%s/", scheme, addr)
+	}
+	return fmt.Sprintf("%s://%s/", scheme, net.JoinHostPort(addr, strconv.Itoa(int(port))))
+}
+
+// parsePorts parses a comma separated list of ports and port
+// ranges such as "22,80,8000-8100". It returns the default
+// ports if s is empty.
+func parsePorts(s string) ([]uint16, error) {
+	if s == "" {
+		return defaultScanPorts, nil
+	}
+	var ports []uint16
+	for part := range strings.SplitSeq(s, ",") {
+		part = strings.TrimSpace(part)
+		lo, hi, isRange := strings.Cut(part, "-")
+		first, err := strconv.ParseUint(lo, 10, 16)
+		if err != nil || first == 0 {
+			return nil, fmt.Errorf("invalid port %q", part)
+		}
+		last := first
+		if isRange {
+			last, err = strconv.ParseUint(hi, 10, 16)
+			if err != nil || last < first {
+				return nil, fmt.Errorf("invalid port range %q", part)
+			}
+		}
+		if len(ports)+int(last-first)+1 > maxScanPorts {
+			return nil, fmt.Errorf("at most %d ports can be scanned at once", maxScanPorts)
+		}
+		for p := first; p <= last; p++ {
+			ports = append(ports, uint16(p))
+		}
+	}
+	slices.Sort(ports)
+	return slices.Compact(ports), nil
+}
+
+func firstLine(b []byte) string {
+	line, _, _ := bytes.Cut(b, []byte("\n"))
+	return truncate(strings.TrimSpace(string(line)))
+}
+
+// printable returns s if it is text, as binary
+// protocols make for unhelpful banners.
+func printable(s string) string {
+	for _, r := range s {
+		if r < ' ' && r != '\t' || r == 0x7f || r == 0xfffd {
+			return ""
+		}
+	}
+	return s
+}
+
+func truncate(s string) string {
+	if len(s) > maxBanner {
+		return s[:maxBanner]
+	}
+	return s
+}
+
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
@@ -0,0 +1,193 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tailscale-dev/vscode-tailscale/tsrelay/logger"
+)
+
+func TestPeerServices(t *testing.T) {
+	web := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "vite")
+		w.Write([]byte(`<html><head><title>My &amp; App</title></head></html>`))
+	}))
+	t.Cleanup(web.Close)
+	secure := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<title>Admin</title>`))
+	}))
+	secure.Config.ErrorLog = log.New(io.Discard, "", 0)
+	secure.StartTLS()
+	t.Cleanup(secure.Close)
+	redis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { redis.Close() })
+	go func() {
+		for {
+			c, err := redis.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				c.Read(make([]byte, 1024))
+				c.Write([]byte("-ERR wrong number of arguments for 'get' command\r\n"))
+			}()
+		}
+	}()
+
+	// like PostgreSQL, the fake only replies to an SSLRequest
+	// and silently closes connections that start with anything
+	// other than a startup packet of a valid length
+	postgres, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { postgres.Close() })
+	go func() {
+		for {
+			c, err := postgres.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				b := make([]byte, 8)
+				if _, err := io.ReadFull(c, b[:4]); err != nil || binary.BigEndian.Uint32(b) != 8 {
+					return
+				}
+				if _, err := io.ReadFull(c, b[4:]); err != nil || binary.BigEndian.Uint32(b[4:]) != 80877103 {
+					return
+				}
+				c.Write([]byte("N"))
+				io.Copy(io.Discard, c)
+			}()
+		}
+	}()
+
+	_, relay := newTestRelay(t, fmt.Sprintf(`{
+		"Status": {
+			"BackendState": "Running",
+			"CurrentTailnet": {"MagicDNSSuffix": "example.ts.net"},
+			"Peer": {
+				"nodekey:0000000000000000000000000000000000000000000000000000000000000001": {
+					"ID": "nDev", "DNSName": "dev.example.ts.net.", "Online": true, "TailscaleIPs": ["100.64.0.1"]
+				},
+				"nodekey:0000000000000000000000000000000000000000000000000000000000000002": {
+					"ID": "nAway", "DNSName": "away.example.ts.net.", "TailscaleIPs": ["100.64.0.2"]
+				}
+			}
+		},
+		"PeerServices": [
+			{"Peer": "nDev", "Port": 22, "Banner": "SSH-2.0-OpenSSH_9.6\r\n"},
+			{"Peer": "nDev", "Port": 3000, "Forward": %q},
+			{"Peer": "nDev", "Port": 5432, "Forward": %q},
+			{"Peer": "nDev", "Port": 6379, "Forward": %q},
+			{"Peer": "nDev", "Port": 8443, "Forward": %q}
+		]
+	}`, web.Listener.Addr(), postgres.Addr(), redis.Addr(), secure.Listener.Addr()))
+	get := func(path string) (*http.Response, getPeerServicesResponse) {
+		resp := relay.do(http.MethodGet, path, "")
+		var s getPeerServicesResponse
+		json.NewDecoder(resp.Body).Decode(&s)
+		return resp, s
+	}
+
+	_, s := get("/peers/nDev/services?ports=22,80,3000,5432,6379,8000-8443&concurrency=8")
+	if s.Scanned != 449 {
+		t.Fatalf("expected 449 ports to be scanned but got %d", s.Scanned)
+	}
+	want := []peerService{
+		{Port: 22, Service: serviceSSH, Banner: "SSH-2.0-OpenSSH_9.6"},
+		{Port: 3000, Service: serviceHTTP, Banner: "vite", Title: "My & App", URL: "http://dev.example.ts.net:3000/"},
+		{Port: 5432, Service: servicePostgres},
+		{Port: 6379, Service: serviceRedis, Banner: "-ERR wrong number of arguments for 'get' command"},
+		{Port: 8443, Service: serviceHTTPS, Title: "Admin", URL: "https://dev.example.ts.net:8443/"},
+	}
+	var got []peerService
+	for _, svc := range s.Services {
+		got = append(got, *svc)
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("unexpected services\ngot:  %+v\nwant: %+v", got, want)
+	}
+
+	if _, s := get("/peers/nAway/services"); len(s.Errors) != 1 || s.Errors[0].Type != PeerOffline {
+		t.Fatalf("expected offline peers to be reported but got %+v", s)
+	}
+	if resp, _ := get("/peers/nNope/services"); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected unknown peers to be rejected but got %d", resp.StatusCode)
+	}
+	if resp, _ := get("/peers/nDev/services?ports=22,http"); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected invalid ports to be rejected but got %d", resp.StatusCode)
+	}
+}
+
+func TestPeerServicesClientGone(t *testing.T) {
+	m, _ := newTestMockClient(t, `{
+		"Status": {"BackendState": "Running"},
+		"Faults": {"Status": {"Latency": "1m"}}
+	}`)
+	var logs bytes.Buffer
+	h := newHandler(&handler{nonce: "123", lc: m, l: logger.New(&logs, true)})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req := httptest.NewRequestWithContext(ctx, http.MethodGet, "/peers/nDev/services", nil)
+	req.SetBasicAuth("123", "")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Body.Len() > 0 || logs.Len() > 0 {
+		t.Fatalf("expected nothing to be written once the client is gone but got %q and logged %q", rec.Body, &logs)
+	}
+}
+
+func TestIdentifyBanner(t *testing.T) {
+	mysql := append([]byte{0x4a, 0, 0, 0, 10}, "8.0.36\x00\x08\x00\x00\x00"...)
+	tests := []struct {
+		banner  string
+		service string
+		want    string
+	}{
+		{"SSH-2.0-OpenSSH_9.6p1 Ubuntu-3\r\n", serviceSSH, "SSH-2.0-OpenSSH_9.6p1 Ubuntu-3"},
+		{"220 (vsFTPd 3.0.5)\r\n", serviceFTP, "220 (vsFTPd 3.0.5)"},
+		{"220 mail.example.com ESMTP Postfix\r\n", serviceSMTP, "220 mail.example.com ESMTP Postfix"},
+		{string(mysql), serviceMySQL, "8.0.36"},
+		{"\x00\x01\x02", "", ""},
+	}
+	for _, tt := range tests {
+		service, banner := identifyBanner([]byte(tt.banner))
+		if service != tt.service || banner != tt.want {
+			t.Errorf("identifyBanner(%q) = %q, %q, want %q, %q", tt.banner, service, banner, tt.service, tt.want)
+		}
+	}
+}
+
+func TestParsePorts(t *testing.T) {
+	ports, err := parsePorts("8080, 22,8000-8002,22")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []uint16{22, 8000, 8001, 8002, 8080}; !slices.Equal(ports, want) {
+		t.Fatalf("got %v, want %v", ports, want)
+	}
+	for _, s := range []string{"0", "65536", "9-1", "a", "1-2000"} {
+		if _, err := parsePorts(s); err == nil || !strings.Contains(err.Error(), "port") {
+			t.Errorf("expected %q to be rejected but got %v", s, err)
+		}
+	}
+}
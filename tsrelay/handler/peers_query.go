@@ -0,0 +1,295 @@
+package handler
+
+import (
+	"cmp"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"tailscale.com/ipn/ipnstate"
+)
+
+// Ways peers can be grouped.
+const (
+	// groupDefault is the historical grouping
+	// into own, other and offline machines.
+	groupDefault = ""
+	groupNone    = "none"
+	groupTag     = "tag"
+	groupOwner   = "owner"
+	groupOS      = "os"
+	groupOnline  = "online"
+)
+
+// Fields peers can be sorted by.
+const (
+	sortName     = "name"
+	sortHostName = "hostname"
+	sortOS       = "os"
+	sortOwner    = "owner"
+	sortIP       = "ip"
+	sortCreated  = "created"
+	sortLastSeen = "lastSeen"
+)
+
+// peersQuery is how GET /peers groups, filters,
+// sorts and paginates peers. The zero value is
+// the default grouping of all peers sorted by name.
+type peersQuery struct {
+	Group string
+	// Search is a search expression, see matchPeer.
+	Search []searchTerm
+	Sort   string
+	Desc   bool
+	// Offset and Limit select a page of peers counted across
+	// groups. A Limit of zero means no limit.
+	Offset int
+	Limit  int
+}
+
+// searchTerm is a term of a search expression. A term
+// is either a bare word or a field:value pair, and
+// excludes matching peers if prefixed with a dash.
+type searchTerm struct {
+	Field string
+	Value string
+	Not   bool
+}
+
+// parsePeersQuery parses the query parameters group,
+// q, sort, offset and limit of GET /peers. Prefixing the
+// sort field with a dash sorts in descending order.
+func parsePeersQuery(v url.Values) (peersQuery, error) {
+	q := peersQuery{Group: v.Get("group"), Sort: v.Get("sort")}
+	switch q.Group {
+	case groupDefault, groupNone, groupTag, groupOwner, groupOS, groupOnline:
+	default:
+		return q, fmt.Errorf("unknown group %q", q.Group)
+	}
+
+	q.Sort, q.Desc = strings.CutPrefix(q.Sort, "-")
+	switch q.Sort {
+	case "":
+		q.Sort = sortName
+	case sortName, sortHostName, sortOS, sortOwner, sortIP, sortCreated, sortLastSeen:
+	default:
+		return q, fmt.Errorf("unknown sort field %q", q.Sort)
+	}
+
+	for _, f := range strings.Fields(v.Get("q")) {
+		var t searchTerm
+		f, t.Not = strings.CutPrefix(f, "-")
+		if field, value, ok := strings.Cut(f, ":"); ok {
+			switch field {
+			case "name", "ip", "user", "os", "is":
+				t.Field, t.Value = field, value
+			case "tag":
+				// tags are written as they appear in the policy
+				t.Field, t.Value = field, f
+			default:
+				t.Value = f
+			}
+		} else {
+			t.Value = f
+		}
+		if t.Value == "" {
+			continue
+		}
+		t.Value = strings.ToLower(t.Value)
+		q.Search = append(q.Search, t)
+	}
+
+	for _, p := range []struct {
+		name string
+		dst  *int
+	}{{"offset", &q.Offset}, {"limit", &q.Limit}} {
+		s := v.Get(p.name)
+		if s == "" {
+			continue
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return q, fmt.Errorf("invalid %s %q", p.name, s)
+		}
+		*p.dst = n
+	}
+	return q, nil
+}
+
+// peerEntry is a peer together with the parts
+// of its status that queries look at.
+type peerEntry struct {
+	*peerStatus
+	ps    *ipnstate.PeerStatus
+	owner string
+}
+
+// matchPeer reports whether the peer matches every term of
+// the search expression. Bare words match the name, an IP,
+// a tag or the owner. Fields restrict a term to the name,
+// ip, tag, user or os of the peer. The term is:online or
+// is:offline matches the online state.
+func matchPeer(e peerEntry, terms []searchTerm) bool {
+	for _, t := range terms {
+		if matchTerm(e, t) == t.Not {
+			return false
+		}
+	}
+	return true
+}
+
+func matchTerm(e peerEntry, t searchTerm) bool {
+	contains := func(s string) bool { return strings.Contains(strings.ToLower(s), t.Value) }
+	name := func() bool {
+		return contains(e.ServerName) || contains(e.HostName) || contains(e.DNSName)
+	}
+	ip := func() bool {
+		return slices.ContainsFunc(e.TailscaleIPs, func(a netip.Addr) bool { return strings.HasPrefix(a.String(), t.Value) })
+	}
+	tag := func() bool {
+		return e.ps.Tags != nil && slices.ContainsFunc(e.ps.Tags.AsSlice(), contains)
+	}
+	user := func() bool { return contains(e.owner) }
+
+	switch t.Field {
+	case "name":
+		return name()
+	case "ip":
+		return ip()
+	case "tag":
+		return tag()
+	case "user":
+		return user()
+	case "os":
+		return strings.EqualFold(e.ps.OS, t.Value)
+	case "is":
+		return (t.Value == "online") == e.Online
+	}
+	return name() || ip() || tag() || user()
+}
+
+// comparePeers orders peers by the field of the query,
+// breaking ties by name and then ID so that pages are stable.
+func comparePeers(a, b peerEntry, q peersQuery) int {
+	var c int
+	switch q.Sort {
+	case sortHostName:
+		c = cmp.Compare(a.HostName, b.HostName)
+	case sortOS:
+		c = cmp.Compare(a.ps.OS, b.ps.OS)
+	case sortOwner:
+		c = cmp.Compare(a.owner, b.owner)
+	case sortIP:
+		c = compareFirstIP(a.peerStatus, b.peerStatus)
+	case sortCreated:
+		c = a.ps.Created.Compare(b.ps.Created)
+	case sortLastSeen:
+		c = compareLastSeen(a, b)
+	}
+	if q.Desc {
+		c = -c
+	}
+	return cmp.Or(c, cmp.Compare(a.ServerName, b.ServerName), cmp.Compare(a.ID, b.ID))
+}
+
+// compareLastSeen orders peers by when they were last seen. LastSeen
+// is only set for offline peers, so online peers count as seen right
+// now: after every offline peer and equal to each other, leaving them
+// to the tiebreak.
+func compareLastSeen(a, b peerEntry) int {
+	switch {
+	case a.Online && b.Online:
+		return 0
+	case a.Online:
+		return 1
+	case b.Online:
+		return -1
+	}
+	return a.ps.LastSeen.Compare(b.ps.LastSeen)
+}
+
+func compareFirstIP(a, b *peerStatus) int {
+	if len(a.TailscaleIPs) == 0 || len(b.TailscaleIPs) == 0 {
+		return cmp.Compare(len(a.TailscaleIPs), len(b.TailscaleIPs))
+	}
+	return a.TailscaleIPs[0].Compare(b.TailscaleIPs[0])
+}
+
+// groupNames returns the names of the groups
+// the peer belongs to when grouping by group.
+func groupNames(e peerEntry, group string) []string {
+	switch group {
+	case groupTag:
+		if e.ps.Tags == nil || e.ps.Tags.Len() == 0 {
+			return []string{"Untagged"}
+		}
+		return e.ps.Tags.AsSlice()
+	case groupOwner:
+		return []string{cmp.Or(e.owner, "Unknown")}
+	case groupOS:
+		return []string{cmp.Or(e.ps.OS, "Unknown")}
+	case groupOnline:
+		if e.Online {
+			return []string{"Online"}
+		}
+		return []string{"Offline"}
+	}
+	return []string{"All machines"}
+}
+
+// groupPeers groups sorted peers, keeping their order within
+// groups. Groups are sorted by name, except for the online
+// grouping which lists online peers first. Peers with several
+// tags are in the group of each tag when grouping by tag.
+func groupPeers(peers []peerEntry, group string) []*peerGroup {
+	byName := make(map[string]*peerGroup)
+	var groups []*peerGroup
+	for _, e := range peers {
+		for _, name := range groupNames(e, group) {
+			pg, ok := byName[name]
+			if !ok {
+				pg = &peerGroup{Name: name}
+				byName[name] = pg
+				groups = append(groups, pg)
+			}
+			pg.Peers = append(pg.Peers, e.peerStatus)
+		}
+	}
+	if group != groupOnline {
+		slices.SortFunc(groups, func(a, b *peerGroup) int { return cmp.Compare(a.Name, b.Name) })
+	} else {
+		slices.SortFunc(groups, func(a, b *peerGroup) int { return cmp.Compare(b.Name, a.Name) })
+	}
+	return groups
+}
+
+// paginate returns the page of peers selected by offset and limit,
+// counting peers across groups and dropping groups left empty. A
+// peer in several groups counts once per group, like in Total.
+func paginate(groups []*peerGroup, offset, limit int) []*peerGroup {
+	if offset == 0 && limit == 0 {
+		return groups
+	}
+	var page []*peerGroup
+	for _, pg := range groups {
+		peers := pg.Peers
+		if offset >= len(peers) {
+			offset -= len(peers)
+			continue
+		}
+		peers = peers[offset:]
+		offset = 0
+		if limit > 0 {
+			if limit <= len(peers) {
+				page = append(page, &peerGroup{Name: pg.Name, Peers: peers[:limit]})
+				break
+			}
+			limit -= len(peers)
+		}
+		page = append(page, &peerGroup{Name: pg.Name, Peers: peers})
+	}
+	return page
+}
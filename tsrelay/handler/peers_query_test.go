@@ -0,0 +1,124 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+const peersProfile = `{
+	"Status": {
+		"BackendState": "Running",
+		"CurrentTailnet": {"MagicDNSSuffix": "example.ts.net"},
+		"Self": {"ID": "nSelf", "UserID": 1, "Online": true},
+		"Peer": {
+			"nodekey:0000000000000000000000000000000000000000000000000000000000000001": {"ID": "n1", "DNSName": "laptop.example.ts.net.", "OS": "macOS", "UserID": 1, "Online": true,
+				"TailscaleIPs": ["100.64.0.1"], "Created": "2024-01-01T00:00:00Z"},
+			"nodekey:0000000000000000000000000000000000000000000000000000000000000002": {"ID": "n2", "DNSName": "db.example.ts.net.", "OS": "linux", "UserID": 2, "Online": true,
+				"TailscaleIPs": ["100.64.0.2"], "Tags": ["tag:prod", "tag:db"], "Created": "2024-03-01T00:00:00Z"},
+			"nodekey:0000000000000000000000000000000000000000000000000000000000000003": {"ID": "n3", "DNSName": "web.example.ts.net.", "OS": "linux", "UserID": 2,
+				"TailscaleIPs": ["100.64.1.3"], "Tags": ["tag:prod"], "Created": "2024-02-01T00:00:00Z"},
+			"nodekey:0000000000000000000000000000000000000000000000000000000000000004": {"ID": "n4", "DNSName": "nl-ams-wg-001.mullvad.ts.net.", "Online": true}
+		},
+		"User": {
+			"1": {"ID": 1, "LoginName": "alice@example.org"},
+			"2": {"ID": 2, "LoginName": "tagged-devices"}
+		}
+	}
+}`
+
+func TestGetPeersQuery(t *testing.T) {
+	_, relay := newTestRelay(t, peersProfile)
+	get := func(query url.Values) (int, getPeersResponse) {
+		resp := relay.do(http.MethodGet, "/peers?"+query.Encode(), "")
+		var s getPeersResponse
+		json.NewDecoder(resp.Body).Decode(&s)
+		return resp.StatusCode, s
+	}
+	layout := func(s getPeersResponse) map[string][]string {
+		groups := make(map[string][]string)
+		for _, pg := range s.PeerGroups {
+			for _, p := range pg.Peers {
+				groups[pg.Name] = append(groups[pg.Name], p.ServerName)
+			}
+		}
+		return groups
+	}
+
+	for _, tc := range []struct {
+		name  string
+		query url.Values
+		want  map[string][]string
+	}{
+		{
+			name: "default",
+			want: map[string][]string{
+				"Managed by you":   {"laptop"},
+				"All machines":     {"db"},
+				"Offline machines": {"web"},
+			},
+		},
+		{
+			name:  "by tag",
+			query: url.Values{"group": {"tag"}},
+			want: map[string][]string{
+				"Untagged": {"laptop"},
+				"tag:db":   {"db"},
+				"tag:prod": {"db", "web"},
+			},
+		},
+		{
+			name:  "search",
+			query: url.Values{"group": {"none"}, "q": {"tag:prod -is:offline"}},
+			want:  map[string][]string{"All machines": {"db"}},
+		},
+		{
+			name:  "search by ip and user",
+			query: url.Values{"group": {"owner"}, "q": {"100.64.0 user:alice"}},
+			want:  map[string][]string{"alice@example.org": {"laptop"}},
+		},
+		{
+			// online peers are equal and ordered by name
+			name:  "by last seen",
+			query: url.Values{"group": {"none"}, "sort": {"lastSeen"}},
+			want:  map[string][]string{"All machines": {"web", "db", "laptop"}},
+		},
+		{
+			name:  "sorted and paginated",
+			query: url.Values{"group": {"os"}, "sort": {"-created"}, "offset": {"1"}, "limit": {"1"}},
+			want:  map[string][]string{"linux": {"web"}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			code, s := get(tc.query)
+			if code != http.StatusOK {
+				t.Fatalf("unexpected status %d", code)
+			}
+			got := layout(s)
+			if len(got) != len(tc.want) {
+				t.Fatalf("expected %v but got %v", tc.want, got)
+			}
+			for name, peers := range tc.want {
+				if len(got[name]) != len(peers) {
+					t.Fatalf("expected %v but got %v", tc.want, got)
+				}
+				for i := range peers {
+					if got[name][i] != peers[i] {
+						t.Fatalf("expected %v but got %v", tc.want, got)
+					}
+				}
+			}
+		})
+	}
+
+	if _, s := get(url.Values{"limit": {"1"}}); s.Total != 3 {
+		t.Fatalf("expected the total to ignore pagination but got %d", s.Total)
+	}
+	if _, s := get(url.Values{"group": {"tag"}}); s.Total != 4 {
+		t.Fatalf("expected a peer with two tags to count in both groups but got %d", s.Total)
+	}
+	if code, _ := get(url.Values{"sort": {"color"}}); code != http.StatusBadRequest {
+		t.Fatalf("expected unknown sort fields to be rejected but got %d", code)
+	}
+}
@@ -0,0 +1,209 @@
+package handler
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"tailscale.com/tailcfg"
+)
+
+const (
+	// pingTimeout is how long to wait for a single reply.
+	pingTimeout = 5 * time.Second
+	// maxPingCount bounds the number of pings of a session.
+	maxPingCount = 100
+)
+
+// pingTypes maps the types accepted by /ping to LocalAPI ping types.
+var pingTypes = map[string]tailcfg.PingType{
+	"disco": tailcfg.PingDisco,
+	"tsmp":  tailcfg.PingTSMP,
+	"icmp":  tailcfg.PingICMP,
+}
+
+// pingMessage is a message sent over the /ping websocket. A
+// "pong" message is sent for every reply or timeout and a "done"
+// message once all pings were sent.
+type pingMessage struct {
+	Type string `json:"type"`
+	Seq  int    `json:"seq,omitempty"`
+	// Latency is the round trip time in milliseconds.
+	Latency float64 `json:"latency,omitempty"`
+	// Direct reports whether the reply came over a direct
+	// connection, in which case Endpoint is its address.
+	Direct   bool   `json:"direct,omitempty"`
+	Endpoint string `json:"endpoint,omitempty"`
+	// PeerRelay is the address of the peer relay the reply
+	// went through, if any.
+	PeerRelay string `json:"peerRelay,omitempty"`
+	// DERPRegion is the code of the DERP region the
+	// reply was relayed through, if any.
+	DERPRegion string `json:"derpRegion,omitempty"`
+	NodeName   string `json:"nodeName,omitempty"`
+	Timeout    bool   `json:"timeout,omitempty"`
+	Error      string `json:"error,omitempty"`
+
+	// Sent and Received summarize the session in "done" messages.
+	Sent     int `json:"sent,omitempty"`
+	Received int `json:"received,omitempty"`
+}
+
+// pingHandler pings a peer and streams the results over a
+// websocket. The peer is given by its stable ID or a Tailscale
+// IP. The optional type ("disco", "tsmp" or "icmp"), count and
+// interval (such as "500ms") default to 10 disco pings a second.
+// Closing the websocket stops the pings.
+func (h *handler) pingHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	pingType, ok := pingTypes[strings.ToLower(cmp.Or(q.Get("type"), "disco"))]
+	if !ok {
+		http.Error(w, "unknown ping type", http.StatusBadRequest)
+		return
+	}
+	count := 10
+	if s := q.Get("count"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxPingCount {
+			http.Error(w, "invalid count", http.StatusBadRequest)
+			return
+		}
+		count = n
+	}
+	interval := time.Second
+	if s := q.Get("interval"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 100*time.Millisecond {
+			http.Error(w, "invalid interval", http.StatusBadRequest)
+			return
+		}
+		interval = d
+	}
+
+	ip, err := h.resolvePeerIP(r.Context(), q.Get("peer"))
+	if err != nil {
+		var re RelayError
+		if errors.As(err, &re) {
+			w.WriteHeader(re.statusCode)
+			json.NewEncoder(w).Encode(re)
+			return
+		}
+		h.l.Println("error resolving peer:", err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	c, err := h.u.Upgrade(w, r, nil)
+	if err != nil {
+		h.l.Printf("error upgrading to websocket: %v", err)
+		return
+	}
+	defer c.Close()
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go func() {
+		// the client doesn't send anything, reading
+		// only notices when the connection is closed.
+		defer cancel()
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	if err := h.runPing(ctx, c, ip, pingType, count, interval); err != nil && ctx.Err() == nil {
+		h.l.Printf("error pinging %v: %v", ip, err)
+	}
+}
+
+// resolvePeerIP returns the first Tailscale IP of the
+// peer with the given stable ID, or the given IP.
+func (h *handler) resolvePeerIP(ctx context.Context, peer string) (netip.Addr, error) {
+	if ip, err := netip.ParseAddr(peer); err == nil {
+		return ip, nil
+	}
+	notFound := RelayError{
+		statusCode: http.StatusNotFound,
+		Errors:     []Error{{Type: PeerNotFound}},
+	}
+	if peer == "" {
+		return netip.Addr{}, notFound
+	}
+	st, err := h.lc.Status(ctx)
+	if err != nil {
+		var oe *net.OpError
+		if errors.As(err, &oe) && oe.Op == "dial" {
+			return netip.Addr{}, RelayError{
+				statusCode: http.StatusServiceUnavailable,
+				Errors:     []Error{{Type: NotRunning}},
+			}
+		}
+		return netip.Addr{}, err
+	}
+	for _, p := range st.Peer {
+		if p.ID == tailcfg.StableNodeID(peer) && len(p.TailscaleIPs) > 0 {
+			return p.TailscaleIPs[0], nil
+		}
+	}
+	return netip.Addr{}, notFound
+}
+
+func (h *handler) runPing(ctx context.Context, c *websocket.Conn, ip netip.Addr, pingType tailcfg.PingType, count int, interval time.Duration) error {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	done := pingMessage{Type: "done"}
+	for seq := 1; seq <= count; seq++ {
+		if seq > 1 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-t.C:
+			}
+		}
+		msg := h.ping(ctx, ip, pingType)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		msg.Seq = seq
+		done.Sent++
+		if msg.Error == "" && !msg.Timeout {
+			done.Received++
+		}
+		if err := c.WriteJSON(msg); err != nil {
+			return err
+		}
+	}
+	return c.WriteJSON(done)
+}
+
+// ping sends a single ping and describes its result.
+func (h *handler) ping(ctx context.Context, ip netip.Addr, pingType tailcfg.PingType) pingMessage {
+	ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+	res, err := h.lc.Ping(ctx, ip, pingType)
+	msg := pingMessage{Type: "pong"}
+	switch {
+	case errors.Is(err, context.DeadlineExceeded) || errors.Is(ctx.Err(), context.DeadlineExceeded):
+		msg.Timeout = true
+	case err != nil:
+		msg.Error = err.Error()
+	case res.Err != "":
+		msg.Error = res.Err
+	default:
+		msg.Latency = res.LatencySeconds * 1000
+		msg.Endpoint = res.Endpoint
+		msg.Direct = res.Endpoint != ""
+		msg.PeerRelay = res.PeerRelay
+		msg.DERPRegion = res.DERPRegionCode
+		msg.NodeName = res.NodeName
+	}
+	return msg
+}
@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"net/http"
+	"testing"
+)
+
+const pingProfile = `{
+	"Status": {
+		"BackendState": "Running",
+		"Self": {"ID": "nSelf", "Online": true},
+		"Peer": {
+			"nodekey:0000000000000000000000000000000000000000000000000000000000000001": {
+				"ID": "nDirect", "DNSName": "direct.example.ts.net.", "Online": true,
+				"TailscaleIPs": ["100.64.0.1"], "CurAddr": "192.0.2.1:41641"
+			},
+			"nodekey:0000000000000000000000000000000000000000000000000000000000000002": {
+				"ID": "nRelayed", "DNSName": "relayed.example.ts.net.", "Online": true,
+				"TailscaleIPs": ["100.64.0.2"], "Relay": "fra"
+			}
+		}
+	}
+}`
+
+func TestPing(t *testing.T) {
+	_, relay := newTestRelay(t, pingProfile)
+	ping := func(query string) []pingMessage {
+		conn, _, err := relay.dial("/ping?" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		var msgs []pingMessage
+		for {
+			var msg pingMessage
+			if err := conn.ReadJSON(&msg); err != nil {
+				t.Fatal(err)
+			}
+			msgs = append(msgs, msg)
+			if msg.Type == "done" {
+				return msgs
+			}
+		}
+	}
+
+	msgs := ping("peer=nDirect&count=2&interval=100ms")
+	if len(msgs) != 3 {
+		t.Fatalf("expected two pongs and a summary but got %+v", msgs)
+	}
+	for _, msg := range msgs[:2] {
+		if msg.Type != "pong" || !msg.Direct || msg.Endpoint != "192.0.2.1:41641" || msg.Latency <= 0 {
+			t.Fatalf("expected a direct pong but got %+v", msg)
+		}
+	}
+	if done := msgs[2]; done.Sent != 2 || done.Received != 2 {
+		t.Fatalf("unexpected summary %+v", done)
+	}
+
+	msgs = ping("peer=100.64.0.2&count=1")
+	if msg := msgs[0]; msg.Direct || msg.DERPRegion != "fra" || msg.NodeName != "relayed" {
+		t.Fatalf("expected a relayed pong but got %+v", msg)
+	}
+
+	msgs = ping("peer=100.64.0.3&count=1&type=tsmp")
+	if msg := msgs[0]; msg.Error == "" || msgs[1].Received != 0 {
+		t.Fatalf("expected pinging an unknown IP to fail but got %+v", msgs)
+	}
+
+	for query, code := range map[string]int{
+		"peer=nMissing":             http.StatusNotFound,
+		"peer=nDirect&type=carrier": http.StatusBadRequest,
+		"peer=nDirect&count=1000":   http.StatusBadRequest,
+	} {
+		_, resp, err := relay.dial("/ping?" + query)
+		if err == nil || resp == nil || resp.StatusCode != code {
+			t.Fatalf("expected %q to fail with %d but got %v", query, code, resp)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package handler
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Reasons a port was attributed to the workspace.
+const (
+	// attributedTerminal means the process was started
+	// from a terminal registered by the extension.
+	attributedTerminal = "terminal"
+	// attributedCwd means the working directory of the
+	// process is inside a workspace folder.
+	attributedCwd = "cwd"
+	// attributedCmdline means an argument of the
+	// process is a path inside a workspace folder.
+	attributedCmdline = "cmdline"
+	// attributedEnv means an environment variable of the
+	// owner of the port points inside a workspace folder.
+	attributedEnv = "environment"
+	// attributedContainer means the port is published by a
+	// container that docker compose started in a workspace folder.
+	attributedContainer = "container"
+)
+
+// maxAttributionDepth bounds how many ancestors of a
+// process are looked at when attributing a port.
+const maxAttributionDepth = 32
+
+// attributionEnv are the environment variables that tools set to
+// the project a process was started for. Variables that every child
+// of the editor inherits, such as PWD or PATH, would tie unrelated
+// ports to the workspace and are left out.
+var attributionEnv = []string{
+	"CARGO_MANIFEST_DIR",
+	"INIT_CWD",
+	"VIRTUAL_ENV",
+	"npm_config_local_prefix",
+	"npm_package_json",
+}
+
+// boundaryProcesses are the executables of editors, terminals and
+// multiplexers. Every process started from them is a descendant, so
+// the walk up the parent chain stops before looking at them.
+var boundaryProcesses = []string{
+	"alacritty",
+	"code",
+	"code-insiders",
+	"code-oss",
+	"code-server",
+	"codium",
+	"cursor",
+	"electron",
+	"gnome-terminal-server",
+	"iterm2",
+	"kitty",
+	"konsole",
+	"sshd",
+	"tmux",
+	"tmux: server",
+	"wezterm-gui",
+	"windowsterminal",
+	"xterm",
+}
+
+// portAttribution explains why a port was tied to the workspace.
+type portAttribution struct {
+	// Folder is the matched workspace folder, if known.
+	Folder string `json:"folder,omitempty"`
+	Reason string `json:"reason"`
+	// PID is the process that matched, which is the
+	// owner of the port or one of its ancestors.
+	PID int `json:"pid"`
+	// Detail is the cwd, argument or variable that matched.
+	Detail string `json:"detail,omitempty"`
+}
+
+// attributePort ties the owner of a port to the workspace. It walks
+// up the parent chain of the process looking for a registered
+// terminal or, when the client sent its workspace folders, for a
+// process whose cwd or arguments point inside one of them, or for
+// an owner whose project variables do. The walk stops at the editor
+// or terminal the process was started from, which the owner itself
+// is never taken for. It returns nil if the port is unrelated to the
+// workspace.
+func (h *handler) attributePort(sess *portDiscoSession, pid int) (*portAttribution, error) {
+	for depth := 0; pid > 0 && depth < maxAttributionDepth; depth++ {
+		if _, ok := sess.pids[pid]; ok {
+			return &portAttribution{
+				Folder: workspaceFolder(sess.folders, h.procs.Cwd(pid)),
+				Reason: attributedTerminal,
+				PID:    pid,
+			}, nil
+		}
+		// pid 1 is an ancestor of every process and
+		// tells nothing about where it was started.
+		if pid == 1 || depth > 0 && isBoundaryProcess(h.procs.Args(pid)) {
+			return nil, nil
+		}
+		if len(sess.folders) > 0 {
+			if a := attributeProcess(h.procs, sess.folders, pid, depth == 0); a != nil {
+				return a, nil
+			}
+		}
+		ppid, err := h.procs.Parent(pid)
+		if err != nil {
+			return nil, err
+		} else if ppid == 0 {
+			h.l.VPrintf("proc %d has no parent or could not be found", pid)
+			return nil, nil
+		}
+		pid = ppid
+	}
+	return nil, nil
+}
+
+// attributeProcess matches the cwd and arguments of a single
+// process against the workspace folders, and for the owner of
+// the port the variables in attributionEnv as well.
+func attributeProcess(pt ProcessTable, folders []string, pid int, owner bool) *portAttribution {
+	if cwd := pt.Cwd(pid); cwd != "" {
+		if f := workspaceFolder(folders, cwd); f != "" {
+			return &portAttribution{Folder: f, Reason: attributedCwd, PID: pid, Detail: cwd}
+		}
+	}
+	for _, arg := range pt.Args(pid) {
+		if f := workspaceFolder(folders, arg); f != "" {
+			return &portAttribution{Folder: f, Reason: attributedCmdline, PID: pid, Detail: arg}
+		}
+	}
+	if !owner {
+		return nil
+	}
+	for _, kv := range pt.Env(pid) {
+		k, v, _ := strings.Cut(kv, "=")
+		if !slices.Contains(attributionEnv, k) {
+			continue
+		}
+		if f := workspaceFolder(folders, v); f != "" {
+			return &portAttribution{Folder: f, Reason: attributedEnv, PID: pid, Detail: k}
+		}
+	}
+	return nil
+}
+
+// isBoundaryProcess reports whether the process with the given
+// arguments is an editor or terminal. Besides the executables in
+// boundaryProcesses, these are the helper apps of VS Code and Cursor
+// on macOS and the VS Code server of remote windows.
+func isBoundaryProcess(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	exe := strings.ToLower(strings.TrimSuffix(filepath.Base(args[0]), ".exe"))
+	if slices.Contains(boundaryProcesses, exe) ||
+		strings.HasPrefix(exe, "code helper") || strings.HasPrefix(exe, "cursor helper") {
+		return true
+	}
+	return slices.ContainsFunc(args[1:], func(arg string) bool {
+		return filepath.Base(arg) == "server-main.js"
+	})
+}
+
+// attributeContainer ties a port published by a container to the
+// workspace through the working directory of its compose project.
+func attributeContainer(folders []string, ci *containerInfo) *portAttribution {
+	if f := workspaceFolder(folders, ci.WorkingDir); f != "" {
+		return &portAttribution{Folder: f, Reason: attributedContainer, Detail: ci.Name}
+	}
+	return nil
+}
+
+// workspaceFolder returns the folder that contains the given
+// absolute path, preferring the most specific one.
+func workspaceFolder(folders []string, p string) string {
+	if p == "" || !filepath.IsAbs(p) {
+		return ""
+	}
+	p = filepath.Clean(p)
+	var match string
+	for _, f := range folders {
+		f = filepath.Clean(f)
+		if (p == f || strings.HasPrefix(p, f+string(os.PathSeparator))) && len(f) > len(match) {
+			match = f
+		}
+	}
+	return match
+}
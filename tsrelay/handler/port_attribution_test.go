@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/tailscale-dev/vscode-tailscale/tsrelay/logger"
+)
+
+func TestWorkspaceFolder(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses unix paths")
+	}
+	folders := []string{"/work/app", "/work/app/web", "/work/other/"}
+	for p, want := range map[string]string{
+		"/work/app":               "/work/app",
+		"/work/app/server.js":     "/work/app",
+		"/work/app/web/vite.conf": "/work/app/web",
+		"/work/other/x":           "/work/other",
+		"/work/application":       "",
+		"relative/work/app":       "",
+		"":                        "",
+	} {
+		if got := workspaceFolder(folders, p); got != want {
+			t.Errorf("%q: expected %q but got %q", p, want, got)
+		}
+	}
+}
+
+func TestAttributePortByCwd(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("process cwd is only read on linux")
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &handler{l: logger.Nop, procs: NewProcessTable()}
+	sess := newPortDiscoSession()
+
+	attr, err := h.attributePort(sess, os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attr != nil {
+		t.Fatalf("expected no attribution without workspace folders but got %+v", attr)
+	}
+
+	sess.folders = []string{filepath.Dir(wd)}
+	attr, err = h.attributePort(sess, os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attr == nil || attr.Reason != attributedCwd || attr.Folder != filepath.Dir(wd) || attr.PID != os.Getpid() {
+		t.Fatalf("expected attribution by cwd to %q but got %+v", filepath.Dir(wd), attr)
+	}
+}
+
+func TestAttributePortStopsAtEditor(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses unix paths")
+	}
+	inherited := []string{"PWD=/work/app", "OLDPWD=/work/app/web", "PATH=/work/app/node_modules/.bin:/usr/bin"}
+	h := &handler{l: logger.Nop, procs: newMockProcesses([]mockProcess{
+		{PID: 10, PPID: 1, Args: []string{"/usr/share/code/code"}, Cwd: "/work/app"},
+		{PID: 11, PPID: 10, Args: []string{"/usr/share/code/code", "--type=extensionHost"}, Cwd: "/work/app", Env: inherited},
+		// a language server started by an extension
+		{PID: 20, PPID: 11, Args: []string{"/usr/bin/gopls"}, Cwd: "/tmp", Env: inherited},
+		{PID: 30, PPID: 11, Args: []string{"node", "server.js"}, Cwd: "/tmp", Env: append(inherited, "INIT_CWD=/work/app")},
+		{PID: 40, PPID: 11, Args: []string{"bash"}, Cwd: "/work/app/web", Env: []string{"INIT_CWD=/work/app"}},
+		{PID: 41, PPID: 40, Args: []string{"python3", "-m", "http.server"}, Cwd: "/tmp"},
+		// a dev server with an argument like those of Electron helpers
+		{PID: 50, PPID: 11, Args: []string{"bash"}, Cwd: "/tmp"},
+		{PID: 51, PPID: 50, Args: []string{"node", "server.js", "--type=api"}, Cwd: "/tmp"},
+	})}
+	sess := newPortDiscoSession()
+	sess.folders = []string{"/work/app"}
+	sess.pids[50] = struct{}{}
+
+	for pid, want := range map[int]*portAttribution{
+		20: nil,
+		30: {Folder: "/work/app", Reason: attributedEnv, PID: 30, Detail: "INIT_CWD"},
+		// the environment of ancestors is inherited and not matched
+		41: {Folder: "/work/app", Reason: attributedCwd, PID: 40, Detail: "/work/app/web"},
+		51: {Reason: attributedTerminal, PID: 50},
+	} {
+		got, err := h.attributePort(sess, pid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (got == nil) != (want == nil) || got != nil && *got != *want {
+			t.Errorf("pid %d: expected %+v but got %+v", pid, want, got)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package handler
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+
+	"tailscale.com/net/tsaddr"
+)
+
+// portKey identifies a listening port. TCP and
+// UDP ports with the same number are distinct.
+type portKey struct {
+	proto string
+	port  uint16
+}
+
+// portExposure describes how a listening port can be shared
+// given the protocol and the addresses it is bound to.
+type portExposure struct {
+	// Addrs are the addresses the port is bound to. It is
+	// empty when they could not be determined.
+	Addrs []string `json:"addrs,omitempty"`
+	// Serve and Funnel report whether the port can be
+	// exposed through Tailscale Serve or Funnel.
+	Serve  bool `json:"serve"`
+	Funnel bool `json:"funnel"`
+	// Tailnet reports whether the port is reachable by
+	// other tailnet devices without Serve.
+	Tailnet bool `json:"tailnet"`
+	// Source is the proxy target to use when serving the port.
+	Source string `json:"source,omitempty"`
+	// Reason explains why the port can't be shared.
+	Reason string `json:"reason,omitempty"`
+}
+
+// exposure returns how the given port can be exposed. Ports with
+// unknown addresses are assumed to accept connections on localhost.
+// Funnel is only offered if the node has the funnel capability, and
+// ports bound to an address that isn't one of the local addresses of
+// this machine can't be served at all. Without local addresses, any
+// bind address is assumed to be reachable.
+func exposure(proto string, port uint16, addrs, local []netip.Addr, funnel bool) portExposure {
+	var e portExposure
+	for _, a := range addrs {
+		e.Addrs = append(e.Addrs, a.String())
+		if a.IsUnspecified() || tsaddr.IsTailscaleIP(a) {
+			e.Tailnet = true
+		}
+	}
+	if proto != "tcp" {
+		e.Reason = fmt.Sprintf("%s ports can't be shared with Tailscale Serve or Funnel", strings.ToUpper(proto))
+		return e
+	}
+	target, ok := serveTarget(addrs, local)
+	if !ok {
+		target = netip.AddrFrom4([4]byte{127, 0, 0, 1})
+	}
+	if !reachable(target, local) {
+		e.Reason = fmt.Sprintf("It only accepts connections on %s, which Tailscale Serve can't reach from this machine", strings.Join(e.Addrs, ", "))
+		return e
+	}
+	e.Serve = true
+	e.Funnel = funnel
+	e.Source = "http://" + netip.AddrPortFrom(target, port).String()
+	return e
+}
+
+// reachable reports whether tailscaled can proxy to the given
+// serve target. Link-local addresses lose their zone in the
+// source URL, and addresses of containers or VMs aren't local.
+func reachable(target netip.Addr, local []netip.Addr) bool {
+	switch {
+	case target.IsLoopback() || tsaddr.IsTailscaleIP(target):
+		return true
+	case target.IsLinkLocalUnicast():
+		return false
+	case local == nil:
+		return true
+	}
+	return slices.Contains(local, target.Unmap().WithZone(""))
+}
+
+// interfaceAddrs returns the addresses of
+// the network interfaces of this machine.
+func interfaceAddrs() ([]netip.Addr, error) {
+	ifAddrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+	var addrs []netip.Addr
+	for _, ia := range ifAddrs {
+		if p, err := netip.ParsePrefix(ia.String()); err == nil {
+			addrs = append(addrs, p.Addr().Unmap())
+		}
+	}
+	return addrs, nil
+}
+
+// serveTarget picks the address tailscaled should proxy to,
+// preferring loopback addresses over specific ones and
+// specific ones it can reach over those it can't.
+func serveTarget(addrs, local []netip.Addr) (netip.Addr, bool) {
+	var v6Loopback, specific, unreachable netip.Addr
+	for _, a := range addrs {
+		switch {
+		case a.Is4() && a.IsUnspecified():
+			return netip.AddrFrom4([4]byte{127, 0, 0, 1}), true
+		case a.Is4() && a.IsLoopback():
+			return a, true
+		case a.IsUnspecified() || a.IsLoopback():
+			v6Loopback = netip.IPv6Loopback()
+		case specific.IsValid():
+		case reachable(a, local):
+			specific = a
+		case !unreachable.IsValid():
+			unreachable = a
+		}
+	}
+	if v6Loopback.IsValid() {
+		return v6Loopback, true
+	}
+	if specific.IsValid() {
+		return specific, true
+	}
+	return unreachable, unreachable.IsValid()
+}
+
+// portDescription returns a short human readable
+// description of the port for notifications.
+func portDescription(proto string, port uint16) string {
+	if proto == "tcp" {
+		return "Port " + strconv.Itoa(int(port))
+	}
+	return fmt.Sprintf("%s port %d", strings.ToUpper(proto), port)
+}
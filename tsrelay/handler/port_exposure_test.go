@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestExposure(t *testing.T) {
+	local := []netip.Addr{
+		netip.MustParseAddr("127.0.0.1"),
+		netip.MustParseAddr("::1"),
+		netip.MustParseAddr("192.168.1.10"),
+		netip.MustParseAddr("100.101.102.103"),
+	}
+	for _, tc := range []struct {
+		name        string
+		proto       string
+		addrs       []string
+		noLocal     bool
+		noFunnel    bool
+		wantServe   bool
+		wantFunnel  bool
+		wantTailnet bool
+		wantSource  string
+	}{
+		{name: "unknown", proto: "tcp", wantServe: true, wantFunnel: true, wantSource: "http://127.0.0.1:3000"},
+		{name: "loopback", proto: "tcp", addrs: []string{"127.0.0.1"}, wantServe: true, wantFunnel: true, wantSource: "http://127.0.0.1:3000"},
+		{name: "wildcard", proto: "tcp", addrs: []string{"0.0.0.0", "::"}, wantServe: true, wantFunnel: true, wantTailnet: true, wantSource: "http://127.0.0.1:3000"},
+		{name: "ipv6 loopback", proto: "tcp", addrs: []string{"::1"}, wantServe: true, wantFunnel: true, wantSource: "http://[::1]:3000"},
+		{name: "ipv6 wildcard", proto: "tcp", addrs: []string{"::"}, wantServe: true, wantFunnel: true, wantTailnet: true, wantSource: "http://[::1]:3000"},
+		{name: "specific", proto: "tcp", addrs: []string{"192.168.1.10"}, wantServe: true, wantFunnel: true, wantSource: "http://192.168.1.10:3000"},
+		{name: "tailscale ip", proto: "tcp", addrs: []string{"100.101.102.103"}, wantServe: true, wantFunnel: true, wantTailnet: true, wantSource: "http://100.101.102.103:3000"},
+		{name: "no funnel capability", proto: "tcp", addrs: []string{"127.0.0.1"}, noFunnel: true, wantServe: true, wantSource: "http://127.0.0.1:3000"},
+		{name: "not local", proto: "tcp", addrs: []string{"172.17.0.2"}},
+		{name: "link-local", proto: "tcp", addrs: []string{"fe80::1"}},
+		{name: "reachable of several", proto: "tcp", addrs: []string{"172.17.0.2", "192.168.1.10"}, wantServe: true, wantFunnel: true, wantSource: "http://192.168.1.10:3000"},
+		{name: "unknown local addresses", proto: "tcp", addrs: []string{"172.17.0.2"}, noLocal: true, wantServe: true, wantFunnel: true, wantSource: "http://172.17.0.2:3000"},
+		{name: "udp", proto: "udp", addrs: []string{"0.0.0.0"}, wantTailnet: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var addrs []netip.Addr
+			for _, a := range tc.addrs {
+				addrs = append(addrs, netip.MustParseAddr(a))
+			}
+			l := local
+			if tc.noLocal {
+				l = nil
+			}
+			e := exposure(tc.proto, 3000, addrs, l, !tc.noFunnel)
+			if e.Serve != tc.wantServe || e.Funnel != tc.wantFunnel {
+				t.Errorf("expected serve %v and funnel %v but got %v and %v", tc.wantServe, tc.wantFunnel, e.Serve, e.Funnel)
+			}
+			if e.Tailnet != tc.wantTailnet {
+				t.Errorf("expected tailnet to be %v but got %v", tc.wantTailnet, e.Tailnet)
+			}
+			if e.Source != tc.wantSource {
+				t.Errorf("expected source %q but got %q", tc.wantSource, e.Source)
+			}
+			if !e.Serve && e.Reason == "" {
+				t.Error("expected a reason for a port that can't be served")
+			}
+		})
+	}
+}
@@ -0,0 +1,283 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Actions a port rule can take when a new port is discovered.
+const (
+	// ruleIgnore drops the port silently.
+	ruleIgnore = "ignore"
+	// ruleNotify asks the user whether to share the port.
+	ruleNotify = "notify"
+	// ruleServe shares the port with the tailnet.
+	ruleServe = "serve"
+	// ruleFunnel shares the port over the internet.
+	ruleFunnel = "funnel"
+)
+
+// portRule decides what happens with newly discovered ports.
+// Every condition that is set has to match. Rules are evaluated
+// in order and the first match wins.
+type portRule struct {
+	// Ports is a comma separated list of ports or
+	// port ranges such as "3000,5173,8000-8999".
+	Ports string `json:"ports,omitempty"`
+	// Proto is "tcp" or "udp".
+	Proto string `json:"proto,omitempty"`
+	// Process is a glob matched against the process name.
+	Process string `json:"process,omitempty"`
+	// Cmdline is a regular expression matched
+	// against the command line of the process.
+	Cmdline string `json:"cmdline,omitempty"`
+	Action  string `json:"action"`
+
+	ports   [][2]uint16
+	cmdline *regexp.Regexp
+}
+
+// compileRules validates the given rules and prepares them for matching.
+func compileRules(rules []portRule) ([]portRule, error) {
+	compiled := make([]portRule, 0, len(rules))
+	for i, r := range rules {
+		switch r.Action {
+		case ruleIgnore, ruleNotify, ruleServe, ruleFunnel:
+		default:
+			return nil, fmt.Errorf("rule %d: unknown action %q", i, r.Action)
+		}
+		if r.Ports != "" {
+			for _, pr := range strings.Split(r.Ports, ",") {
+				lo, hi, isRange := strings.Cut(strings.TrimSpace(pr), "-")
+				if !isRange {
+					hi = lo
+				}
+				from, err := strconv.ParseUint(lo, 10, 16)
+				if err != nil {
+					return nil, fmt.Errorf("rule %d: invalid port %q", i, lo)
+				}
+				to, err := strconv.ParseUint(hi, 10, 16)
+				if err != nil || to < from {
+					return nil, fmt.Errorf("rule %d: invalid port range %q", i, pr)
+				}
+				r.ports = append(r.ports, [2]uint16{uint16(from), uint16(to)})
+			}
+		}
+		if r.Process != "" {
+			if _, err := path.Match(r.Process, ""); err != nil {
+				return nil, fmt.Errorf("rule %d: invalid process pattern %q: %w", i, r.Process, err)
+			}
+		}
+		if r.Cmdline != "" {
+			re, err := regexp.Compile(r.Cmdline)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: invalid cmdline pattern: %w", i, err)
+			}
+			r.cmdline = re
+		}
+		compiled = append(compiled, r)
+	}
+	return compiled, nil
+}
+
+func (r *portRule) matches(kp *knownPort) bool {
+	if r.Proto != "" && r.Proto != kp.Proto {
+		return false
+	}
+	if len(r.ports) > 0 {
+		var inRange bool
+		for _, pr := range r.ports {
+			if kp.Port.Port >= pr[0] && kp.Port.Port <= pr[1] {
+				inRange = true
+				break
+			}
+		}
+		if !inRange {
+			return false
+		}
+	}
+	if r.Process != "" {
+		if ok, _ := path.Match(r.Process, kp.Process); !ok {
+			return false
+		}
+	}
+	if r.cmdline != nil && !r.cmdline.MatchString(kp.Cmdline) {
+		return false
+	}
+	return true
+}
+
+// ruleAction returns the action of the first rule that
+// matches the given port, defaulting to notify.
+func ruleAction(rules []portRule, kp *knownPort) string {
+	for i := range rules {
+		if rules[i].matches(kp) {
+			return rules[i].Action
+		}
+	}
+	return ruleNotify
+}
+
+// snooze silences notifications for a port, optionally
+// only while it is owned by the given process.
+type snooze struct {
+	Proto   string    `json:"proto"`
+	Port    uint16    `json:"port"`
+	Process string    `json:"process,omitempty"`
+	Until   time.Time `json:"until,omitzero"`
+}
+
+func (s *snooze) matches(kp *knownPort, now time.Time) bool {
+	if !s.Until.IsZero() && now.After(s.Until) {
+		return false
+	}
+	return s.Proto == kp.Proto && s.Port == kp.Port.Port &&
+		(s.Process == "" || s.Process == kp.Process)
+}
+
+// snoozeStore persists snoozes per workspace
+// so they survive editor and relay restarts.
+type snoozeStore struct {
+	mu   sync.Mutex
+	path string
+}
+
+// defaultSnoozePath returns where snoozes are stored
+// unless the user has no config directory.
+func defaultSnoozePath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "vscode-tailscale", "snoozes.json")
+}
+
+func (s *snoozeStore) readLocked() (map[string][]snooze, error) {
+	all := make(map[string][]snooze)
+	bts, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return all, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return all, json.Unmarshal(bts, &all)
+}
+
+// Get returns the active snoozes of the given workspace.
+func (s *snoozeStore) Get(workspace string) ([]snooze, error) {
+	if s == nil || s.path == "" || workspace == "" {
+		return nil, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	all, err := s.readLocked()
+	if err != nil {
+		return nil, fmt.Errorf("error reading snoozes: %w", err)
+	}
+	return all[workspace], nil
+}
+
+// Set replaces the snoozes of the given workspace, dropping
+// the ones that have expired.
+func (s *snoozeStore) Set(workspace string, snoozes []snooze) error {
+	if s == nil || s.path == "" || workspace == "" {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	all, err := s.readLocked()
+	if err != nil {
+		return fmt.Errorf("error reading snoozes: %w", err)
+	}
+	now := time.Now()
+	active := snoozes[:0:0]
+	for _, sn := range snoozes {
+		if sn.Until.IsZero() || sn.Until.After(now) {
+			active = append(active, sn)
+		}
+	}
+	if len(active) == 0 {
+		delete(all, workspace)
+	} else {
+		all[workspace] = active
+	}
+	bts, err := json.MarshalIndent(all, "", "  ")
+	if err != nil {
+		return err
+	}
+	// relays of other windows write the same file
+	if _, err := writeFileIfChanged(s.path, bts); err != nil {
+		return fmt.Errorf("error writing snoozes: %w", err)
+	}
+	return nil
+}
+
+// autoServe shares the given port because of a serve or funnel
+// rule and returns the URL it can be reached at. Ports are served
+// on the same port number on the tailnet and on the first free
+// Funnel port when funneled. The fingerprint of the port, if any,
+// decides how it is proxied.
+func (h *handler) autoServe(ctx context.Context, kp *knownPort, e portExposure, fp *serviceFingerprint, funnel bool) (string, error) {
+	st, sc, err := h.getConfigs(ctx)
+	if err != nil {
+		return "", fmt.Errorf("error getting configs: %w", err)
+	}
+	if st.Self == nil {
+		return "", errors.New("not logged in")
+	}
+	inUse := func(port uint16) bool {
+		return sc != nil && sc.TCP[port] != nil
+	}
+	port := kp.Port.Port
+	if funnel {
+		ports, err := funnelPorts(st.Self)
+		if err != nil {
+			return "", fmt.Errorf("error getting funnel ports: %w", err)
+		}
+		port = 0
+		for _, p := range ports {
+			if !inUse(uint16(p)) {
+				port = uint16(p)
+				break
+			}
+		}
+		if port == 0 {
+			return "", errors.New("no funnel port available")
+		}
+	} else if inUse(port) {
+		return "", fmt.Errorf("port %d is already served", port)
+	}
+	req := serveRequest{
+		Protocol:   "https",
+		Source:     e.Source,
+		MountPoint: "/",
+	}
+	if fp != nil && fp.Suggested != nil {
+		req = *fp.Suggested
+	}
+	req.Port = port
+	req.Funnel = funnel
+	err = h.addServe(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	scheme := "https://"
+	if req.Protocol == "tcp" {
+		scheme = "tcp://"
+	}
+	u := scheme + strings.TrimSuffix(st.Self.DNSName, ".")
+	if port != 443 || req.Protocol == "tcp" {
+		u += ":" + strconv.Itoa(int(port))
+	}
+	return u, nil
+}
@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"tailscale.com/portlist"
+)
+
+func TestRuleAction(t *testing.T) {
+	rules, err := compileRules([]portRule{
+		{Process: "dlv*", Action: ruleIgnore},
+		{Ports: "5173, 8000-8999", Proto: "tcp", Action: ruleServe},
+		{Cmdline: `next (dev|start)`, Action: ruleFunnel},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		port portlist.Port
+		cmd  string
+		want string
+	}{
+		{port: portlist.Port{Proto: "tcp", Port: 2345, Process: "dlv-dap"}, want: ruleIgnore},
+		{port: portlist.Port{Proto: "tcp", Port: 8080, Process: "python3"}, want: ruleServe},
+		{port: portlist.Port{Proto: "udp", Port: 8080, Process: "python3"}, want: ruleNotify},
+		{port: portlist.Port{Proto: "tcp", Port: 3000, Process: "node"}, cmd: "node node_modules/.bin/next dev", want: ruleFunnel},
+		{port: portlist.Port{Proto: "tcp", Port: 3000, Process: "node"}, cmd: "node server.js", want: ruleNotify},
+	} {
+		got := ruleAction(rules, &knownPort{Port: tc.port, Cmdline: tc.cmd})
+		if got != tc.want {
+			t.Errorf("%s %d (%s): expected %q but got %q", tc.port.Proto, tc.port.Port, tc.port.Process, tc.want, got)
+		}
+	}
+
+	for _, invalid := range []portRule{
+		{Action: "share"},
+		{Ports: "9000-8000", Action: ruleNotify},
+		{Ports: "http", Action: ruleNotify},
+		{Cmdline: "(", Action: ruleNotify},
+	} {
+		if _, err := compileRules([]portRule{invalid}); err == nil {
+			t.Errorf("expected %+v to be invalid", invalid)
+		}
+	}
+}
+
+func TestSnoozeStore(t *testing.T) {
+	s := &snoozeStore{path: filepath.Join(t.TempDir(), "snoozes.json")}
+	err := s.Set("/work/a", []snooze{
+		{Proto: "tcp", Port: 3000},
+		{Proto: "tcp", Port: 4000, Until: time.Now().Add(-time.Minute)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set("/work/b", []snooze{{Proto: "udp", Port: 53}}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.Get("/work/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Port != 3000 {
+		t.Fatalf("expected only the active snooze for port 3000 but got %+v", got)
+	}
+	kp := &knownPort{Port: portlist.Port{Proto: "tcp", Port: 3000, Process: "node"}}
+	if !got[0].matches(kp, time.Now()) {
+		t.Fatal("expected snooze to match")
+	}
+	got, err = s.Get("/work/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Port != 53 {
+		t.Fatalf("expected workspaces to be kept apart but got %+v", got)
+	}
+}
@@ -4,20 +4,32 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
-	"github.com/mitchellh/go-ps"
 	"tailscale.com/portlist"
 )
 
 func (h *handler) portDiscoHandler(w http.ResponseWriter, r *http.Request) {
+	// Clients that attached through /sessions pass their session ID.
+	// Older clients that don't are still served.
+	if id := r.URL.Query().Get("session"); id != "" {
+		if _, ok := h.sessions.get(id); !ok {
+			http.Error(w, "unknown session", http.StatusNotFound)
+			return
+		}
+	}
 	c, err := h.u.Upgrade(w, r, nil)
 	if err != nil {
 		h.l.Printf("error upgrading to websocket: %v", err)
 		return
 	}
-	err = h.runPortDisco(r.Context(), c)
+	err = h.runPortDisco(r.Context(), c, newPortDiscoSession())
 	if err != nil {
 		h.l.Printf("error running port discovery: %v", err)
 		return
@@ -29,10 +41,126 @@ type wsMessage struct {
 	PID     int    `json:"pid"`
 	Port    int    `json:"port"`
 	Message string `json:"message"`
+
+	// Proto, Process and Cmdline describe the port and its owner
+	// in newPort, closedPort and portOwnerChanged messages.
+	Proto   string `json:"proto,omitempty"`
+	Process string `json:"process,omitempty"`
+	Cmdline string `json:"cmdline,omitempty"`
+	// PrevPID is the previous owner in portOwnerChanged messages.
+	PrevPID int `json:"prevPid,omitempty"`
+	// Exposure describes how a new port can be shared.
+	Exposure *portExposure `json:"exposure,omitempty"`
+	// URL is where a port is reachable in servedPort messages.
+	URL string `json:"url,omitempty"`
+
+	// Rules and Workspace configure the session in setRules
+	// messages. Snoozes are persisted per workspace.
+	Rules     []portRule `json:"rules,omitempty"`
+	Workspace string     `json:"workspace,omitempty"`
+	// Duration is how long a snooze message silences
+	// a port for, in seconds. Zero means forever.
+	Duration int `json:"duration,omitempty"`
+	// Folders are the workspace folder paths in
+	// setWorkspaceFolders messages.
+	Folders []string `json:"folders,omitempty"`
+	// Attribution explains why a new port was tied to the workspace.
+	Attribution *portAttribution `json:"attribution,omitempty"`
+	// Fingerprint describes the service behind a port in servedPort
+	// messages and in the portFingerprint messages that follow
+	// newPort messages once the port has been probed.
+	Fingerprint *serviceFingerprint `json:"fingerprint,omitempty"`
+	// Container is the Docker container that publishes the port.
+	Container *containerInfo `json:"container,omitempty"`
+}
+
+// portDiscoSession is the state of a single /portdisco
+// connection. Every connection tracks its own PIDs and
+// ports so that editor windows don't see each other's
+// notifications. Work that makes LocalAPI calls runs in
+// the background without holding the lock, so writes to
+// the connection go through write.
+type portDiscoSession struct {
+	sync.Mutex
+
+	writeMu sync.Mutex
+	// background tracks the work still running
+	// in the background for the connection.
+	background sync.WaitGroup
+
+	// pids are the terminal processes registered by the client.
+	pids map[int]struct{}
+	// prev are the ports that were open on the last poll.
+	prev map[portKey]*knownPort
+
+	// folders are the workspace folders of the client.
+	folders []string
+
+	rules     []portRule
+	workspace string
+	snoozes   []snooze
+}
+
+// knownPort is an open port along with whether
+// the client was notified about it.
+type knownPort struct {
+	portlist.Port
+	Cmdline   string
+	Container *containerInfo
+	notified  bool
+}
+
+func newPortDiscoSession() *portDiscoSession {
+	return &portDiscoSession{
+		pids: make(map[int]struct{}),
+		prev: make(map[portKey]*knownPort),
+	}
+}
+
+func (s *portDiscoSession) close() {
+	s.Lock()
+	defer s.Unlock()
+	clear(s.pids)
+	clear(s.prev)
+	s.folders = nil
+	s.rules = nil
+	s.snoozes = nil
+}
+
+// hasNewPorts reports whether any of the given ports is not
+// known yet or is known to belong to another process.
+func (s *portDiscoSession) hasNewPorts(up []portlist.Port) bool {
+	s.Lock()
+	defer s.Unlock()
+	return slices.ContainsFunc(up, func(p portlist.Port) bool {
+		known, ok := s.prev[portKey{p.Proto, p.Port}]
+		return !ok || known.Pid != p.Pid
+	})
+}
+
+// write sends msg to the client of the session.
+func (s *portDiscoSession) write(c *websocket.Conn, msg *wsMessage) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return c.WriteJSON(msg)
 }
 
-func (h *handler) runPortDisco(ctx context.Context, c *websocket.Conn) error {
+func (s *portDiscoSession) snoozed(kp *knownPort) bool {
+	now := time.Now()
+	for i := range s.snoozes {
+		if s.snoozes[i].matches(kp, now) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *handler) runPortDisco(ctx context.Context, c *websocket.Conn, sess *portDiscoSession) error {
 	defer c.Close()
+	defer sess.close()
+	defer sess.background.Wait()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 	closeCh := make(chan struct{})
 	go func() {
 		defer close(closeCh)
@@ -49,44 +177,64 @@ func (h *handler) runPortDisco(ctx context.Context, c *websocket.Conn) error {
 				}
 				return
 			}
-			h.Lock()
+			sess.Lock()
 			switch msg.Type {
 			case "addPID":
 				h.l.VPrintln("adding pid", msg.PID)
-				h.pids[msg.PID] = struct{}{}
+				sess.pids[msg.PID] = struct{}{}
 				h.onPortUpdate()
 			case "removePID":
 				h.l.VPrintln("removing pid", msg.PID)
-				delete(h.pids, msg.PID)
+				delete(sess.pids, msg.PID)
+				h.onPortUpdate()
+			case "setWorkspaceFolders":
+				h.l.VPrintln("setting workspace folders", msg.Folders)
+				sess.folders = msg.Folders
+				h.onPortUpdate()
+			case "setRules":
+				h.setRules(c, sess, msg)
+				h.onPortUpdate()
+			case "snooze":
+				h.snooze(sess, msg)
+				h.onPortUpdate()
+			case "clearSnoozes":
+				sess.snoozes = nil
+				if err := h.snoozes.Set(sess.workspace, nil); err != nil {
+					h.l.Printf("error clearing snoozes: %v", err)
+				}
 				h.onPortUpdate()
 			default:
 				h.l.Printf("unrecognized websocket message: %q", msg.Type)
 			}
-			h.Unlock()
+			sess.Unlock()
 		}
 	}()
 
-	p := &portlist.Poller{
-		IncludeLocalhost: true,
-	}
-	ticker := time.NewTicker(3 * time.Second)
-	defer ticker.Stop()
-
 	// eagerly load already open ports to avoid spam notifications
-	ports, _, err := p.Poll()
+	ports, updates, unsubscribe, err := h.ports.Subscribe()
 	if err != nil {
 		return fmt.Errorf("error running initial poll: %w", err)
 	}
+	defer unsubscribe()
+	// the host ports are kept to go over again when only
+	// the ports published by containers have changed.
+	hostPorts := ports
+	ports, containers := h.withContainers(ctx, ports)
+	sess.Lock()
 	for _, p := range ports {
-		if p.Proto != "tcp" {
-			continue
-		}
-		h.l.VPrintln("pre-setting", p.Port, p.Pid, p.Process)
-		h.prev[p.Port] = p
+		h.l.VPrintln("pre-setting", p.Proto, p.Port, p.Pid, p.Process)
+		sess.prev[portKey{p.Proto, p.Port}] = &knownPort{Port: p}
 	}
+	sess.Unlock()
 	h.l.Println("initial ports are set")
 	h.onPortUpdate()
 
+	var dockerTick <-chan time.Time
+	if h.docker != nil {
+		t := time.NewTicker(dockerPollInterval)
+		defer t.Stop()
+		dockerTick = t.C
+	}
 	for {
 		select {
 		case <-ctx.Done():
@@ -94,16 +242,24 @@ func (h *handler) runPortDisco(ctx context.Context, c *websocket.Conn) error {
 		case <-closeCh:
 			h.l.Println("portdisco reader is closed")
 			return nil
-		case <-ticker.C:
-			ports, changed, err := p.Poll()
+		case ports := <-updates:
+			hostPorts = ports
+			err = h.handlePortUpdates(ctx, c, sess, ports)
 			if err != nil {
-				h.l.Printf("error receiving portlist update: %v", err)
+				return fmt.Errorf("error handling port updates: %w", err)
+			}
+		case <-dockerTick:
+			published, err := h.docker.publishedPorts(ctx)
+			if err != nil {
+				h.l.VPrintf("error getting container ports: %v", err)
 				continue
 			}
-			if !changed {
+			if sameContainers(published, containers) {
 				continue
 			}
-			err = h.handlePortUpdates(c, ports)
+			containers = published
+			h.l.VPrintln("container ports were updated")
+			err = h.handlePortUpdates(ctx, c, sess, hostPorts)
 			if err != nil {
 				return fmt.Errorf("error handling port updates: %w", err)
 			}
@@ -111,57 +267,315 @@ func (h *handler) runPortDisco(ctx context.Context, c *websocket.Conn) error {
 	}
 }
 
-func (h *handler) handlePortUpdates(c *websocket.Conn, up []portlist.Port) error {
+func (h *handler) handlePortUpdates(ctx context.Context, c *websocket.Conn, sess *portDiscoSession, up []portlist.Port) error {
 	h.l.VPrintln("ports were updated")
-	h.Lock()
+	// Docker and LocalAPI calls must not be made holding the
+	// session, and the status is only needed for new ports.
+	up, containers := h.withContainers(ctx, up)
+	var funnel bool
+	if sess.hasNewPorts(up) {
+		funnel = h.canFunnel(ctx)
+	}
+	sess.Lock()
+	defer sess.Unlock()
 	h.l.VPrintln("up is", len(up))
+	var addrs map[portKey][]netip.Addr
+	var local []netip.Addr
+	var addrsLoaded bool
+	open := make(map[portKey]struct{}, len(up))
 	for _, p := range up {
-		if p.Proto != "tcp" {
-			h.l.VPrintln("skipping", p.Port, "of", p.Proto)
+		k := portKey{p.Proto, p.Port}
+		open[k] = struct{}{}
+		known, ok := sess.prev[k]
+		if ok && known.Pid == p.Pid {
+			h.l.VPrintln("skipping", p.Proto, p.Port, "because it already exists")
 			continue
 		}
-		if _, ok := h.prev[p.Port]; ok {
-			h.l.VPrintln("skipping", p.Port, "because it already exists")
-			continue
-		}
-		ok, err := h.matchesPID(p.Pid)
+		kp := &knownPort{Port: p, Container: containers[k]}
+		sess.prev[k] = kp
+		attr, err := h.attributePort(sess, p.Pid)
 		if err != nil {
 			h.l.Printf("error matching pid: %v", err)
+		}
+		if attr == nil && kp.Container != nil {
+			// published ports are owned by the engine, not
+			// by anything started from the workspace.
+			attr = attributeContainer(sess.folders, kp.Container)
+		}
+		if ok && known.notified {
+			if attr == nil {
+				// to the client, a port taken over by an
+				// unrelated process is gone from the workspace.
+				h.l.VPrintf("%s port %d was taken over by unrelated pid %d", p.Proto, p.Port, p.Pid)
+				if err := sess.write(c, closedPortMessage(k, known)); err != nil {
+					return fmt.Errorf("error notifying client: %w", err)
+				}
+				continue
+			}
+			// keep reporting on a port the client already knows
+			// about even if it was taken over by another process.
+			kp.notified = true
+			kp.Cmdline = processCmdline(h.procs, p.Pid)
+			h.l.VPrintf("%s port %d changed owner from %d to %d", p.Proto, p.Port, known.Pid, p.Pid)
+			err := sess.write(c, &wsMessage{
+				Type:    "portOwnerChanged",
+				PID:     p.Pid,
+				PrevPID: known.Pid,
+				Port:    int(p.Port),
+				Proto:   p.Proto,
+				Process: p.Process,
+				Cmdline: kp.Cmdline,
+				Message: fmt.Sprintf("%s is now used by %q", portDescription(p.Proto, p.Port), p.Process),
+			})
+			if err != nil {
+				return fmt.Errorf("error notifying client: %w", err)
+			}
 			continue
 		}
-		if !ok {
-			h.l.VPrintf("skipping unrelated port %d / %d", p.Port, p.Pid)
+		if attr == nil {
+			h.l.VPrintf("skipping unrelated %s port %d / %d", p.Proto, p.Port, p.Pid)
 			continue
 		}
-		h.l.VPrintf("port %d matches pid %d", p.Port, p.Pid)
-		h.prev[p.Port] = p
-		err = c.WriteJSON(&wsMessage{
-			Type:    "newPort",
-			Port:    int(p.Port),
-			Message: fmt.Sprintf("Port %d was started by %q, would you like to share it over the internet with Tailscale Funnel?", p.Port, p.Process),
+		h.l.VPrintf("%s port %d of pid %d matches by %s of %d", p.Proto, p.Port, p.Pid, attr.Reason, attr.PID)
+		if !addrsLoaded {
+			addrsLoaded = true
+			addrs, err = h.ports.bindAddrs()
+			if err != nil {
+				h.l.Printf("error getting bind addresses: %v", err)
+			}
+			local, err = interfaceAddrs()
+			if err != nil {
+				h.l.Printf("error getting interface addresses: %v", err)
+			}
+		}
+		e := exposure(p.Proto, p.Port, addrs[k], local, funnel)
+		if p.Pid > 0 {
+			kp.Cmdline = processCmdline(h.procs, p.Pid)
+		}
+		if sess.snoozed(kp) {
+			h.l.VPrintf("%s port %d is snoozed", p.Proto, p.Port)
+			continue
+		}
+		action := ruleAction(sess.rules, kp)
+		if action == ruleIgnore {
+			h.l.VPrintf("%s port %d is ignored by a rule", p.Proto, p.Port)
+			continue
+		}
+		kp.notified = true
+		if action == ruleServe && e.Serve || action == ruleFunnel && e.Funnel {
+			// serving makes LocalAPI calls, which must not
+			// hold up other updates of the session.
+			kp := *kp
+			sess.background.Add(1)
+			go func() {
+				defer sess.background.Done()
+				h.servePort(ctx, c, sess, &kp, e, attr, action == ruleFunnel)
+			}()
+			continue
+		}
+		err = sess.write(c, &wsMessage{
+			Type:        "newPort",
+			PID:         p.Pid,
+			Port:        int(p.Port),
+			Proto:       p.Proto,
+			Process:     p.Process,
+			Cmdline:     kp.Cmdline,
+			Exposure:    &e,
+			Attribution: attr,
+			Container:   kp.Container,
+			Message:     newPortMessage(kp, e, nil),
 		})
 		if err != nil {
-			h.Unlock()
+			return fmt.Errorf("error notifying client: %w", err)
+		}
+		if e.Serve && !isDebugger(kp) {
+			// probing takes up to probeTimeout, so the
+			// fingerprint follows in another message.
+			kp := *kp
+			sess.background.Add(1)
+			go func() {
+				defer sess.background.Done()
+				h.sendFingerprint(ctx, c, sess, &kp, e)
+			}()
+		}
+	}
+	for k, known := range sess.prev {
+		if _, ok := open[k]; ok {
+			continue
+		}
+		delete(sess.prev, k)
+		if !known.notified {
+			continue
+		}
+		h.l.VPrintf("%s port %d of pid %d was closed", k.proto, k.port, known.Pid)
+		if err := sess.write(c, closedPortMessage(k, known)); err != nil {
 			return fmt.Errorf("error notifying client: %w", err)
 		}
 	}
-	h.Unlock()
 	return nil
 }
 
-func (h *handler) matchesPID(pid int) (bool, error) {
-	if _, ok := h.pids[pid]; ok {
-		return true, nil
+// canFunnel reports whether ports can be shared over Funnel,
+// which needs the funnel capability. It is assumed not to be
+// given if the status of tailscaled can't be had.
+func (h *handler) canFunnel(ctx context.Context) bool {
+	if h.lc == nil {
+		return false
+	}
+	st, err := h.lc.StatusWithoutPeers(ctx)
+	if err != nil {
+		h.l.VPrintf("error getting status: %v", err)
+		return false
+	}
+	return st.Self != nil && funnelAllowed(st.Self)
+}
+
+// closedPortMessage tells the client that
+// the given port it knew about was closed.
+func closedPortMessage(k portKey, known *knownPort) *wsMessage {
+	return &wsMessage{
+		Type:    "closedPort",
+		PID:     known.Pid,
+		Port:    int(k.port),
+		Proto:   k.proto,
+		Process: known.Process,
+		Cmdline: known.Cmdline,
+		Message: fmt.Sprintf("%s started by %s was closed", portDescription(k.proto, k.port), portOwner(known)),
+	}
+}
+
+// sendFingerprint probes a new port and sends what it found to the
+// client in a portFingerprint message, unless the port was closed or
+// taken over in the meantime.
+func (h *handler) sendFingerprint(ctx context.Context, c *websocket.Conn, sess *portDiscoSession, kp *knownPort, e portExposure) {
+	fp := probePort(ctx, strings.TrimPrefix(e.Source, "http://"))
+	h.l.VPrintf("%s port %d fingerprint: %v", kp.Proto, kp.Port.Port, fp)
+	sess.Lock()
+	cur, ok := sess.prev[portKey{kp.Proto, kp.Port.Port}]
+	sess.Unlock()
+	if !ok || cur.Pid != kp.Pid {
+		return
+	}
+	err := sess.write(c, &wsMessage{
+		Type:        "portFingerprint",
+		PID:         kp.Pid,
+		Port:        int(kp.Port.Port),
+		Proto:       kp.Proto,
+		Process:     kp.Process,
+		Fingerprint: fp,
+		Message:     newPortMessage(kp, e, fp),
+	})
+	if err != nil {
+		h.l.VPrintf("error notifying client: %v", err)
+	}
+}
+
+// servePort fingerprints and serves a new port because of a serve or
+// funnel rule. The client is told about the port either way, with a
+// servedPort message if it could be served and newPort otherwise.
+// Ports of debuggers are served without a fingerprint.
+func (h *handler) servePort(ctx context.Context, c *websocket.Conn, sess *portDiscoSession, kp *knownPort, e portExposure, attr *portAttribution, funnel bool) {
+	var fp *serviceFingerprint
+	if !isDebugger(kp) {
+		fp = probePort(ctx, strings.TrimPrefix(e.Source, "http://"))
+		h.l.VPrintf("%s port %d fingerprint: %v", kp.Proto, kp.Port.Port, fp)
 	}
-	proc, err := ps.FindProcess(pid)
+	msg := &wsMessage{
+		Type:        "newPort",
+		PID:         kp.Pid,
+		Port:        int(kp.Port.Port),
+		Proto:       kp.Proto,
+		Process:     kp.Process,
+		Cmdline:     kp.Cmdline,
+		Exposure:    &e,
+		Attribution: attr,
+		Fingerprint: fp,
+		Container:   kp.Container,
+		Message:     newPortMessage(kp, e, fp),
+	}
+	u, err := h.autoServe(ctx, kp, e, fp, funnel)
+	if err == nil {
+		msg.Type = "servedPort"
+		msg.URL = u
+		msg.Message = fmt.Sprintf("Port %d was started by %s and is now shared at %s", kp.Port.Port, portOwner(kp), u)
+	} else {
+		h.l.Printf("error automatically serving port %d: %v", kp.Port.Port, err)
+	}
+	if err := sess.write(c, msg); err != nil {
+		h.l.VPrintf("error notifying client: %v", err)
+	}
+}
+
+// setRules replaces the rules of the session and loads the
+// snoozes of its workspace. Invalid rules are reported to the client.
+func (h *handler) setRules(c *websocket.Conn, sess *portDiscoSession, msg wsMessage) {
+	rules, err := compileRules(msg.Rules)
+	if err != nil {
+		h.l.Printf("error compiling port rules: %v", err)
+		err = sess.write(c, &wsMessage{
+			Type:    "invalidRules",
+			Message: fmt.Sprintf("Invalid port discovery rules: %v", err),
+		})
+		if err != nil {
+			h.l.Printf("error notifying client: %v", err)
+		}
+		return
+	}
+	h.l.VPrintf("setting %d port rules for workspace %q", len(rules), msg.Workspace)
+	sess.rules = rules
+	sess.workspace = msg.Workspace
+	sess.snoozes, err = h.snoozes.Get(msg.Workspace)
 	if err != nil {
-		return false, fmt.Errorf("error finding process: %w", err)
-	} else if proc == nil {
-		h.l.VPrintf("proc %d could not be found", pid)
-		return false, nil
-	} else if proc.PPid() == 0 {
-		h.l.VPrintf("proc %d has no parent", pid)
-		return false, nil
-	}
-	return h.matchesPID(proc.PPid())
+		h.l.Printf("error loading snoozes: %v", err)
+	}
+}
+
+// snooze silences notifications for the port in the message.
+func (h *handler) snooze(sess *portDiscoSession, msg wsMessage) {
+	sn := snooze{
+		Proto:   msg.Proto,
+		Port:    uint16(msg.Port),
+		Process: msg.Process,
+	}
+	if sn.Proto == "" {
+		sn.Proto = "tcp"
+	}
+	if msg.Duration > 0 {
+		sn.Until = time.Now().Add(time.Duration(msg.Duration) * time.Second)
+	}
+	h.l.VPrintf("snoozing %s port %d", sn.Proto, sn.Port)
+	sess.snoozes = append(sess.snoozes, sn)
+	if err := h.snoozes.Set(sess.workspace, sess.snoozes); err != nil {
+		h.l.Printf("error saving snoozes: %v", err)
+	}
+}
+
+// portOwner describes who opened a port in notifications.
+func portOwner(kp *knownPort) string {
+	if kp.Container != nil {
+		return "container " + kp.Container.String()
+	}
+	return strconv.Quote(kp.Process)
+}
+
+func newPortMessage(kp *knownPort, e portExposure, fp *serviceFingerprint) string {
+	if e.Serve {
+		started := fmt.Sprintf("Port %d was started by %s", kp.Port.Port, portOwner(kp))
+		switch {
+		case fp == nil:
+		case fp.Framework != "":
+			started += fmt.Sprintf(" (%s)", fp.Framework)
+		case !fp.HTTP:
+			started += " and doesn't look like a web server"
+		}
+		if !e.Funnel {
+			return started + ", would you like to share it with your tailnet with Tailscale Serve?"
+		}
+		return started + ", would you like to share it over the internet with Tailscale Funnel?"
+	}
+	msg := fmt.Sprintf("%s was started by %s. %s.", portDescription(kp.Proto, kp.Port.Port), portOwner(kp), e.Reason)
+	if e.Tailnet {
+		msg += " It is reachable from your tailnet."
+	}
+	return msg
 }
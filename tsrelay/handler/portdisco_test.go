@@ -2,10 +2,8 @@ package handler
 
 import (
 	"encoding/base64"
-	"net"
 	"net/http"
 	"net/http/httptest"
-	"os"
 	"strings"
 	"testing"
 	"time"
@@ -15,13 +13,31 @@ import (
 	"tailscale.com/portlist"
 )
 
+// Processes of the fake process table. The dev
+// server was started from the registered terminal.
+const (
+	terminalPID  = 1000
+	devServerPID = 1001
+	watcherPID   = 1002
+	unrelatedPID = 2000
+)
+
+var fakeProcesses = newMockProcesses([]mockProcess{
+	{PID: terminalPID, PPID: 1, Args: []string{"bash"}},
+	{PID: devServerPID, PPID: terminalPID, Args: []string{"node", "server.js"}},
+	{PID: watcherPID, PPID: terminalPID, Args: []string{"node", "watch.js"}},
+	{PID: unrelatedPID, PPID: 1, Args: []string{"nginx"}},
+})
+
 func TestServe(t *testing.T) {
+	ports := newMockPorts(nil)
 	portCh := make(chan struct{}, 2)
 	h := &handler{
 		nonce:        "123",
 		l:            logger.Nop,
-		pids:         make(map[int]struct{}),
-		prev:         make(map[uint16]portlist.Port),
+		sessions:     NewSessions(),
+		ports:        newPortWatcher(logger.Nop, ports),
+		procs:        fakeProcesses,
 		onPortUpdate: func() { portCh <- struct{}{} },
 	}
 	srv := httptest.NewServer(newHandler(h))
@@ -37,7 +53,7 @@ func TestServe(t *testing.T) {
 	t.Cleanup(func() { conn.Close() })
 	err = conn.WriteJSON(&wsMessage{
 		Type: "addPID",
-		PID:  os.Getpid(),
+		PID:  terminalPID,
 	})
 	if err != nil {
 		t.Fatal(err)
@@ -46,14 +62,10 @@ func TestServe(t *testing.T) {
 	<-portCh
 	<-portCh
 
-	lst, err := net.Listen("tcp", "127.0.0.1:4593")
-	if err != nil {
-		t.Fatal(err)
-	}
-	t.Cleanup(func() { lst.Close() })
-	wantPort := lst.Addr().(*net.TCPAddr).Port
+	const wantPort = 4593
+	ports.open(portlist.Port{Proto: "tcp", Port: wantPort, Process: "node", Pid: devServerPID})
 
-	err = conn.SetReadDeadline(time.Now().Add(time.Second * 15))
+	err = conn.SetReadDeadline(time.Now().Add(time.Second * 5))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -68,6 +80,151 @@ func TestServe(t *testing.T) {
 	if msg.Port != wantPort {
 		t.Fatalf("expected port to be %q but got %q", wantPort, msg.Port)
 	}
+	if msg.PID != devServerPID {
+		t.Fatalf("expected pid to be %d but got %d", devServerPID, msg.PID)
+	}
+	if a := msg.Attribution; a == nil || a.Reason != attributedTerminal || a.PID != terminalPID {
+		t.Fatalf("expected attribution to the terminal but got %+v", a)
+	}
+	// the fingerprint follows once the port has been probed
+	err = conn.ReadJSON(&msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != "portFingerprint" || msg.Port != wantPort || msg.Fingerprint == nil {
+		t.Fatalf("expected portFingerprint for %d but got %q for %d", wantPort, msg.Type, msg.Port)
+	}
+
+	// closing the port is reported and reopening
+	// it is announced again.
+	ports.close("tcp", wantPort)
+	err = conn.ReadJSON(&msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != "closedPort" || msg.Port != wantPort {
+		t.Fatalf("expected closedPort for %d but got %q for %d", wantPort, msg.Type, msg.Port)
+	}
+	ports.open(portlist.Port{Proto: "tcp", Port: wantPort, Process: "node", Pid: devServerPID})
+	err = conn.ReadJSON(&msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != "newPort" || msg.Port != wantPort {
+		t.Fatalf("expected newPort for %d but got %q for %d", wantPort, msg.Type, msg.Port)
+	}
+	err = conn.ReadJSON(&msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != "portFingerprint" {
+		t.Fatalf("expected portFingerprint but got %q", msg.Type)
+	}
+
+	// debuggers often take a single client and aren't probed
+	const debugPort = 2345
+	ports.open(portlist.Port{Proto: "tcp", Port: debugPort, Process: "dlv", Pid: devServerPID})
+	err = conn.ReadJSON(&msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != "newPort" || msg.Port != debugPort {
+		t.Fatalf("expected newPort for %d but got %q for %d", debugPort, msg.Type, msg.Port)
+	}
+	ports.close("tcp", debugPort)
+	err = conn.ReadJSON(&msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != "closedPort" || msg.Port != debugPort {
+		t.Fatalf("expected closedPort for %d without a fingerprint but got %q for %d", debugPort, msg.Type, msg.Port)
+	}
+
+	// a port taken over from the terminal stays reported, while
+	// one taken over by an unrelated process is gone from it.
+	ports.replace(portlist.Port{Proto: "tcp", Port: wantPort, Process: "node", Pid: watcherPID})
+	err = conn.ReadJSON(&msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != "portOwnerChanged" || msg.PID != watcherPID || msg.PrevPID != devServerPID {
+		t.Fatalf("expected portOwnerChanged to %d but got %q for %d", watcherPID, msg.Type, msg.PID)
+	}
+	ports.replace(portlist.Port{Proto: "tcp", Port: wantPort, Process: "nginx", Pid: unrelatedPID})
+	err = conn.ReadJSON(&msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != "closedPort" || msg.Port != wantPort || msg.PID != watcherPID {
+		t.Fatalf("expected closedPort for %d of %d but got %q for %d", wantPort, watcherPID, msg.Type, msg.PID)
+	}
+}
+
+func TestPortDiscoSessionsAreIndependent(t *testing.T) {
+	ports := newMockPorts(nil)
+	portCh := make(chan struct{}, 4)
+	h := &handler{
+		nonce:        "123",
+		l:            logger.Nop,
+		sessions:     NewSessions(),
+		ports:        newPortWatcher(logger.Nop, ports),
+		procs:        fakeProcesses,
+		onPortUpdate: func() { portCh <- struct{}{} },
+	}
+	srv := httptest.NewServer(newHandler(h))
+	t.Cleanup(srv.Close)
+
+	headers := http.Header{}
+	headers.Set("Authorization", "Basic "+basicAuth("123", ""))
+	wsUR := strings.Replace(srv.URL, "http://", "ws://", 1)
+	watching, _, err := websocket.DefaultDialer.Dial(wsUR+"/portdisco", headers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { watching.Close() })
+	other, _, err := websocket.DefaultDialer.Dial(wsUR+"/portdisco", headers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { other.Close() })
+	err = watching.WriteJSON(&wsMessage{
+		Type: "addPID",
+		PID:  terminalPID,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for range 3 {
+		<-portCh
+	}
+
+	const wantPort = 4594
+	ports.open(portlist.Port{Proto: "tcp", Port: wantPort, Process: "node", Pid: devServerPID})
+
+	err = watching.SetReadDeadline(time.Now().Add(time.Second * 5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg wsMessage
+	err = watching.ReadJSON(&msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != "newPort" || msg.Port != wantPort {
+		t.Fatalf("expected newPort for %d but got %q for %d", wantPort, msg.Type, msg.Port)
+	}
+
+	// the other connection sees the same update and
+	// must not have inherited the registered pid.
+	err = other.SetReadDeadline(time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = other.ReadJSON(&msg)
+	if err == nil {
+		t.Fatalf("expected no message on the other connection but got %q for %d", msg.Type, msg.Port)
+	}
 }
 
 func basicAuth(username, password string) string {
@@ -0,0 +1,245 @@
+package handler
+
+import (
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/tailscale-dev/vscode-tailscale/tsrelay/logger"
+	"tailscale.com/portlist"
+)
+
+const (
+	// minPollInterval and maxPollInterval bound the adaptive
+	// interval used when no change detector is available. The
+	// interval is reset to the minimum after every change and
+	// doubles for every poll that finds nothing new.
+	minPollInterval = time.Second
+	maxPollInterval = 10 * time.Second
+
+	// detectInterval is how often the change detector is asked
+	// for a fingerprint of the listening sockets. It's a cheap
+	// kernel query compared to a full portlist poll.
+	detectInterval = 250 * time.Millisecond
+	// safetyPollInterval is how often a full poll runs even when
+	// the change detector reports nothing.
+	safetyPollInterval = 30 * time.Second
+	// debounce coalesces bursts of changes, such as a dev server
+	// opening several ports on startup, into a single poll.
+	debounce = 100 * time.Millisecond
+)
+
+// PortLister is an abstraction of portlist.Poller.
+type PortLister interface {
+	// Poll returns the open ports and reports
+	// whether they changed since the last call.
+	Poll() (ports []portlist.Port, changed bool, err error)
+}
+
+// systemPorts lists the open ports of the machine. Where the
+// platform allows it, it also implements portChangeDetector
+// and bindAddrLister.
+type systemPorts struct {
+	*portlist.Poller
+}
+
+// NewPortLister returns a PortLister for the open ports of the machine.
+func NewPortLister() PortLister {
+	return systemPorts{&portlist.Poller{IncludeLocalhost: true}}
+}
+
+// portChangeDetector is implemented by PortListers that can
+// cheaply detect changes in the set of listening sockets
+// without mapping them to processes.
+type portChangeDetector interface {
+	// fingerprint returns a value that changes whenever
+	// a listening socket is opened or closed.
+	fingerprint() (uint64, error)
+}
+
+// bindAddrLister is implemented by PortListers that know
+// the addresses the listening ports are bound to.
+type bindAddrLister interface {
+	bindAddrs() (map[portKey][]netip.Addr, error)
+}
+
+// portWatcher watches the open ports of the machine on behalf
+// of every port discovery connection and the serve status.
+// It only runs while there are subscribers and fans out each
+// change to all of them.
+type portWatcher struct {
+	l logger.Logger
+
+	mu      sync.Mutex
+	lister  PortLister
+	ports   []portlist.Port
+	subs    map[chan []portlist.Port]struct{}
+	stop    chan struct{}
+	running bool
+}
+
+func newPortWatcher(l logger.Logger, lister PortLister) *portWatcher {
+	return &portWatcher{
+		l:      l,
+		lister: lister,
+		subs:   make(map[chan []portlist.Port]struct{}),
+	}
+}
+
+// Ports returns the currently open ports. It returns the latest
+// snapshot while the watcher is running and polls otherwise.
+func (w *portWatcher) Ports() ([]portlist.Port, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.running {
+		if _, err := w.pollLocked(); err != nil {
+			return nil, err
+		}
+	}
+	return w.ports, nil
+}
+
+// pollLocked polls for ports and updates the snapshot.
+// It reports whether the ports changed since the last poll.
+// w.mu must be held.
+func (w *portWatcher) pollLocked() (bool, error) {
+	ports, changed, err := w.lister.Poll()
+	if err != nil {
+		return false, err
+	}
+	if changed {
+		w.ports = ports
+	}
+	return changed, nil
+}
+
+// Subscribe returns the currently open ports and a channel that
+// receives the full port list whenever it changes. Slow subscribers
+// only ever see the latest list. The returned func unsubscribes.
+func (w *portWatcher) Subscribe() ([]portlist.Port, <-chan []portlist.Port, func(), error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.running {
+		if _, err := w.pollLocked(); err != nil {
+			return nil, nil, nil, err
+		}
+		w.stop = make(chan struct{})
+		w.running = true
+		go w.run(w.stop)
+	}
+	ch := make(chan []portlist.Port, 1)
+	w.subs[ch] = struct{}{}
+	unsubscribe := func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		if _, ok := w.subs[ch]; !ok {
+			return
+		}
+		delete(w.subs, ch)
+		if len(w.subs) == 0 {
+			w.running = false
+			close(w.stop)
+		}
+	}
+	return w.ports, ch, unsubscribe, nil
+}
+
+// bindAddrs returns the addresses every listening port is bound to,
+// or nil if the PortLister doesn't know them. Ports with unknown
+// addresses are assumed to be exposable.
+func (w *portWatcher) bindAddrs() (map[portKey][]netip.Addr, error) {
+	if bl, ok := w.lister.(bindAddrLister); ok {
+		return bl.bindAddrs()
+	}
+	return nil, nil
+}
+
+func (w *portWatcher) run(stop chan struct{}) {
+	d, _ := w.lister.(portChangeDetector)
+	var last uint64
+	if d != nil {
+		var err error
+		last, err = d.fingerprint()
+		if err != nil {
+			w.l.Printf("falling back to polling for ports: %v", err)
+			d = nil
+		}
+		// catch up with anything that changed between the
+		// initial poll and taking the first fingerprint.
+		w.poll(stop)
+	}
+
+	interval := minPollInterval
+	if d != nil {
+		interval = safetyPollInterval
+	}
+	pollTimer := time.NewTimer(interval)
+	defer pollTimer.Stop()
+	var detect <-chan time.Time
+	if d != nil {
+		ticker := time.NewTicker(detectInterval)
+		defer ticker.Stop()
+		detect = ticker.C
+	}
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-detect:
+			fp, err := d.fingerprint()
+			if err != nil {
+				w.l.VPrintf("error detecting port changes: %v", err)
+				continue
+			}
+			if fp == last {
+				continue
+			}
+			last = fp
+			select {
+			case <-stop:
+				return
+			case <-time.After(debounce):
+			}
+			w.poll(stop)
+		case <-pollTimer.C:
+			changed := w.poll(stop)
+			if d == nil {
+				if changed {
+					interval = minPollInterval
+				} else {
+					interval = min(interval*2, maxPollInterval)
+				}
+			}
+			pollTimer.Reset(interval)
+		}
+	}
+}
+
+// poll runs a full poll and notifies the subscribers if the
+// list of ports changed. It reports whether it did.
+func (w *portWatcher) poll(stop chan struct{}) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	select {
+	case <-stop:
+		return false
+	default:
+	}
+	changed, err := w.pollLocked()
+	if err != nil {
+		w.l.Printf("error receiving portlist update: %v", err)
+		return false
+	}
+	if !changed {
+		return false
+	}
+	for ch := range w.subs {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- w.ports
+	}
+	return true
+}
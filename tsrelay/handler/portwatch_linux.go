@@ -0,0 +1,175 @@
+package handler
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net/netip"
+	"os"
+	"sync"
+	"syscall"
+)
+
+const (
+	tcpClose         = 7
+	tcpListen        = 10
+	sockDiagByFamily = 20
+
+	// sizes of struct inet_diag_req_v2 and struct inet_diag_msg
+	// from linux/inet_diag.h.
+	sizeofInetDiagReqV2 = 56
+	sizeofInetDiagMsg   = 72
+)
+
+// fingerprint implements portChangeDetector through netlink
+// sock_diag. Only listening sockets are dumped, so unlike reading
+// /proc/net/tcp its cost does not grow with the number of
+// established connections. It combines the port and inode of
+// every listening socket so that a socket that is replaced
+// by another one on the same port is noticed. UDP sockets on
+// ephemeral ports are left out: they are mostly clients, such
+// as resolvers, that come and go all the time, and the safety
+// poll still finds servers among them.
+func (systemPorts) fingerprint() (uint64, error) {
+	socks, err := listeningSockets()
+	if err != nil {
+		return 0, err
+	}
+	lo, hi := ephemeralPorts()
+	var sum uint64
+	var n uint64
+	for _, s := range socks {
+		if s.proto == "udp" && s.port >= lo && s.port <= hi {
+			continue
+		}
+		n++
+		h := fnv.New64a()
+		h.Write([]byte(s.proto))
+		binary.Write(h, binary.BigEndian, s.port)
+		binary.Write(h, binary.BigEndian, s.inode)
+		sum += h.Sum64()
+	}
+	return sum ^ n, nil
+}
+
+// ephemeralPorts returns the range of ports the kernel picks
+// from for sockets that aren't bound to one, read once from
+// ip_local_port_range and defaulting to that of Linux.
+var ephemeralPorts = sync.OnceValues(func() (uint16, uint16) {
+	bts, err := os.ReadFile("/proc/sys/net/ipv4/ip_local_port_range")
+	if err != nil {
+		return 32768, 60999
+	}
+	var lo, hi uint16
+	if _, err := fmt.Sscan(string(bts), &lo, &hi); err != nil || lo > hi {
+		return 32768, 60999
+	}
+	return lo, hi
+})
+
+// listenSocket is a listening socket as reported by sock_diag.
+type listenSocket struct {
+	proto string
+	port  uint16
+	addr  netip.Addr
+	inode uint32
+}
+
+// listeningSockets returns the listening TCP sockets and the
+// unconnected UDP sockets of both address families.
+func listeningSockets() ([]listenSocket, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_INET_DIAG)
+	if err != nil {
+		return nil, fmt.Errorf("error opening netlink socket: %w", err)
+	}
+	defer syscall.Close(fd)
+	var socks []listenSocket
+	for _, family := range []uint8{syscall.AF_INET, syscall.AF_INET6} {
+		for _, q := range []struct {
+			proto    string
+			protocol uint8
+			states   uint32
+		}{
+			{"tcp", syscall.IPPROTO_TCP, 1 << tcpListen},
+			{"udp", syscall.IPPROTO_UDP, 1 << tcpClose},
+		} {
+			err := sockDiagDump(fd, family, q.protocol, q.states, func(msg []byte) {
+				s := listenSocket{
+					proto: q.proto,
+					port:  binary.BigEndian.Uint16(msg[4:6]),
+					inode: binary.NativeEndian.Uint32(msg[68:72]),
+				}
+				if family == syscall.AF_INET {
+					s.addr = netip.AddrFrom4([4]byte(msg[8:12]))
+				} else {
+					s.addr = netip.AddrFrom16([16]byte(msg[8:24]))
+				}
+				socks = append(socks, s)
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return socks, nil
+}
+
+// bindAddrs implements bindAddrLister.
+func (systemPorts) bindAddrs() (map[portKey][]netip.Addr, error) {
+	socks, err := listeningSockets()
+	if err != nil {
+		return nil, err
+	}
+	addrs := make(map[portKey][]netip.Addr)
+	for _, s := range socks {
+		k := portKey{s.proto, s.port}
+		addrs[k] = append(addrs[k], s.addr)
+	}
+	return addrs, nil
+}
+
+// sockDiagDump requests every socket of the given family, protocol
+// and states and calls fn with each inet_diag_msg in the response.
+func sockDiagDump(fd int, family, protocol uint8, states uint32, fn func(msg []byte)) error {
+	req := make([]byte, syscall.NLMSG_HDRLEN+sizeofInetDiagReqV2)
+	binary.NativeEndian.PutUint32(req[0:4], uint32(len(req)))
+	binary.NativeEndian.PutUint16(req[4:6], sockDiagByFamily)
+	binary.NativeEndian.PutUint16(req[6:8], syscall.NLM_F_REQUEST|syscall.NLM_F_DUMP)
+	body := req[syscall.NLMSG_HDRLEN:]
+	body[0] = family
+	body[1] = protocol
+	binary.NativeEndian.PutUint32(body[4:8], states)
+	if err := syscall.Sendto(fd, req, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return fmt.Errorf("error sending sock_diag request: %w", err)
+	}
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return fmt.Errorf("error receiving sock_diag response: %w", err)
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return fmt.Errorf("error parsing sock_diag response: %w", err)
+		}
+		for _, m := range msgs {
+			switch m.Header.Type {
+			case syscall.NLMSG_DONE:
+				return nil
+			case syscall.NLMSG_ERROR:
+				if len(m.Data) >= 4 {
+					if errno := int32(binary.NativeEndian.Uint32(m.Data)); errno != 0 {
+						return syscall.Errno(-errno)
+					}
+				}
+				return errors.New("sock_diag request failed")
+			}
+			if len(m.Data) < sizeofInetDiagMsg {
+				continue
+			}
+			fn(m.Data)
+		}
+	}
+}
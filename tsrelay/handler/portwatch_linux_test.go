@@ -0,0 +1,26 @@
+package handler
+
+import (
+	"net"
+	"testing"
+)
+
+func TestFingerprintIgnoresUDPClients(t *testing.T) {
+	before, err := systemPorts{}.fingerprint()
+	if err != nil {
+		t.Skipf("sock_diag is unavailable: %v", err)
+	}
+	// an unconnected socket on an ephemeral port, like a resolver's
+	c, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	after, err := systemPorts{}.fingerprint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before != after {
+		t.Fatalf("expected a UDP socket on ephemeral port %d to leave the fingerprint alone", c.LocalAddr().(*net.UDPAddr).Port)
+	}
+}
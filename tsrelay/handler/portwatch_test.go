@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/tailscale-dev/vscode-tailscale/tsrelay/logger"
+	"tailscale.com/portlist"
+)
+
+func TestPortWatcherFanOut(t *testing.T) {
+	w := newPortWatcher(logger.Nop, NewPortLister())
+	_, first, unsubscribeFirst, err := w.Subscribe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(unsubscribeFirst)
+	_, second, unsubscribeSecond, err := w.Subscribe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(unsubscribeSecond)
+
+	lst, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lst.Close() })
+	wantPort := uint16(lst.Addr().(*net.TCPAddr).Port)
+
+	for _, ch := range []<-chan []portlist.Port{first, second} {
+		if !waitForPort(t, ch, wantPort) {
+			t.Fatalf("expected port %d to be reported to every subscriber", wantPort)
+		}
+	}
+
+	ports, err := w.Ports()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hasPort(ports, wantPort) {
+		t.Fatalf("expected port %d in the latest snapshot", wantPort)
+	}
+}
+
+func waitForPort(t *testing.T, ch <-chan []portlist.Port, port uint16) bool {
+	t.Helper()
+	timeout := time.After(15 * time.Second)
+	for {
+		select {
+		case ports := <-ch:
+			if hasPort(ports, port) {
+				return true
+			}
+		case <-timeout:
+			return false
+		}
+	}
+}
+
+func hasPort(ports []portlist.Port, port uint16) bool {
+	for _, p := range ports {
+		if p.Port == port {
+			return true
+		}
+	}
+	return false
+}
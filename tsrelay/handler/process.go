@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mitchellh/go-ps"
+)
+
+// ProcessTable looks up the processes of the machine.
+type ProcessTable interface {
+	// Parent returns the parent of the given process. It
+	// returns 0 if the process has no parent or doesn't exist.
+	Parent(pid int) (int, error)
+	// Args returns the command line arguments of the process.
+	Args(pid int) []string
+	// Cwd returns the working directory of the process.
+	Cwd(pid int) string
+	// Env returns the environment of the process.
+	Env(pid int) []string
+}
+
+// systemProcesses looks up processes through the OS. Arguments,
+// working directories and environments are only read on Linux.
+type systemProcesses struct{}
+
+// NewProcessTable returns a ProcessTable for the processes of the machine.
+func NewProcessTable() ProcessTable {
+	return systemProcesses{}
+}
+
+// Parent implements ProcessTable.
+func (systemProcesses) Parent(pid int) (int, error) {
+	proc, err := ps.FindProcess(pid)
+	if err != nil {
+		return 0, fmt.Errorf("error finding process: %w", err)
+	} else if proc == nil {
+		return 0, nil
+	}
+	return proc.PPid(), nil
+}
+
+// Args implements ProcessTable.
+func (systemProcesses) Args(pid int) []string { return processArgs(pid) }
+
+// Cwd implements ProcessTable.
+func (systemProcesses) Cwd(pid int) string { return processCwd(pid) }
+
+// Env implements ProcessTable.
+func (systemProcesses) Env(pid int) []string { return processEnv(pid) }
+
+// processCmdline returns the command line of the given
+// process with its arguments separated by spaces.
+func processCmdline(pt ProcessTable, pid int) string {
+	return strings.Join(pt.Args(pid), " ")
+}
@@ -0,0 +1,40 @@
+package handler
+
+import (
+	"bytes"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// processArgs returns the command line arguments of the given process.
+func processArgs(pid int) []string {
+	return readNulSeparated("/proc/" + strconv.Itoa(pid) + "/cmdline")
+}
+
+// processCwd returns the working directory of the given process.
+func processCwd(pid int) string {
+	cwd, err := os.Readlink("/proc/" + strconv.Itoa(pid) + "/cwd")
+	if err != nil {
+		return ""
+	}
+	return cwd
+}
+
+// processEnv returns the environment of the given process.
+// It's only readable for processes of the same user.
+func processEnv(pid int) []string {
+	return readNulSeparated("/proc/" + strconv.Itoa(pid) + "/environ")
+}
+
+func readNulSeparated(name string) []string {
+	bts, err := os.ReadFile(name)
+	if err != nil {
+		return nil
+	}
+	bts = bytes.TrimRight(bts, "\x00")
+	if len(bts) == 0 {
+		return nil
+	}
+	return strings.Split(string(bts), "\x00")
+}
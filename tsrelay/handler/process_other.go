@@ -0,0 +1,18 @@
+//go:build !linux
+
+package handler
+
+// processArgs is only implemented on Linux.
+func processArgs(pid int) []string {
+	return nil
+}
+
+// processCwd is only implemented on Linux.
+func processCwd(pid int) string {
+	return ""
+}
+
+// processEnv is only implemented on Linux.
+func processEnv(pid int) []string {
+	return nil
+}
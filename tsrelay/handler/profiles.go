@@ -0,0 +1,355 @@
+package handler
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"tailscale.com/client/tailscale"
+	"tailscale.com/ipn"
+)
+
+const (
+	// profileSwitchTimeout bounds how long to wait for
+	// tailscaled to settle on a new login profile.
+	profileSwitchTimeout = 10 * time.Second
+	// profileSwitchPollInterval is how often to check
+	// whether tailscaled settled on the new profile.
+	profileSwitchPollInterval = 100 * time.Millisecond
+)
+
+// loginProfile is an account tailscaled can be logged in with.
+type loginProfile struct {
+	ID ipn.ProfileID
+	// Name is the login name of the user.
+	Name          string
+	DisplayName   string
+	ProfilePicURL string `json:",omitempty"`
+	// Tailnet is the name of the tailnet the
+	// profile is logged in to, if known.
+	Tailnet string `json:",omitempty"`
+	Current bool
+}
+
+type getProfilesResponse struct {
+	// Current is nil when tailscaled isn't
+	// logged in with any profile.
+	Current  *loginProfile `json:",omitempty"`
+	Profiles []*loginProfile
+}
+
+// switchProfileRequest selects the login profile by its ID.
+type switchProfileRequest struct {
+	ID ipn.ProfileID
+}
+
+// loginMessage is a message sent over the /login websocket. A
+// "browseToURL" message is sent whenever the user has to open a
+// URL to log in, followed by "loginFinished" or an "error".
+type loginMessage struct {
+	Type   string  `json:"type"`
+	URL    string  `json:"url,omitempty"`
+	Errors []Error `json:"errors,omitempty"`
+	// Message describes unexpected errors.
+	Message string `json:"message,omitempty"`
+}
+
+// loginHandler starts an interactive login and streams the URLs
+// the user has to visit until the login finished. Closing the
+// websocket stops waiting but doesn't cancel the login.
+func (h *handler) loginHandler(w http.ResponseWriter, r *http.Request) {
+	c, err := h.u.Upgrade(w, r, nil)
+	if err != nil {
+		h.l.Printf("error upgrading to websocket: %v", err)
+		return
+	}
+	defer c.Close()
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	err = h.login(ctx, func(url string) error {
+		return c.WriteJSON(loginMessage{Type: "browseToURL", URL: url})
+	})
+	if ctx.Err() != nil {
+		return
+	}
+	msg := loginMessage{Type: "loginFinished"}
+	if err != nil {
+		msg.Type = "error"
+		var re RelayError
+		if errors.As(err, &re) {
+			msg.Errors = re.Errors
+		} else {
+			h.l.Println("error logging in:", err)
+			msg.Message = err.Error()
+		}
+	}
+	c.WriteJSON(msg)
+}
+
+// login starts an interactive login and calls browseTo with every
+// login URL until the login finished. The IPN bus is watched before
+// the login is started so that no URL is missed.
+func (h *handler) login(ctx context.Context, browseTo func(url string) error) error {
+	w, err := watchIPNBus(ctx, h.lc, ipn.NotifyInitialState)
+	if err != nil {
+		return tailscaledError(err)
+	}
+	defer w.Close()
+	if err := h.lc.StartLoginInteractive(ctx); err != nil {
+		if tailscale.IsAccessDeniedError(err) {
+			return RelayError{
+				statusCode: http.StatusForbidden,
+				Errors:     []Error{{Type: RequiresSudo, Command: "sudo tailscale login"}},
+			}
+		}
+		return tailscaledError(err)
+	}
+
+	var lastURL string
+	var needsLogin bool
+	for {
+		n, err := w.Next()
+		if err != nil {
+			return tailscaledError(err)
+		}
+		if n.ErrMessage != nil {
+			return fmt.Errorf("error logging in: %s", *n.ErrMessage)
+		}
+		if n.BrowseToURL != nil && *n.BrowseToURL != "" && *n.BrowseToURL != lastURL {
+			lastURL = *n.BrowseToURL
+			if err := browseTo(lastURL); err != nil {
+				return err
+			}
+		}
+		if n.LoginFinished != nil {
+			return nil
+		}
+		if n.State != nil {
+			// older versions of tailscaled don't announce
+			// LoginFinished when a login completes.
+			if *n.State == ipn.Running && needsLogin {
+				return nil
+			}
+			needsLogin = needsLogin || *n.State == ipn.NeedsLogin
+		}
+	}
+}
+
+func (h *handler) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	err := h.switchProfile(r.Context(), "sudo tailscale logout", func(ctx context.Context) error {
+		return h.lc.Logout(ctx)
+	})
+	if err != nil {
+		var re RelayError
+		if errors.As(err, &re) {
+			w.WriteHeader(re.statusCode)
+			json.NewEncoder(w).Encode(re)
+			return
+		}
+		h.l.Println("error logging out:", err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.Write([]byte(`{}`))
+}
+
+func (h *handler) getProfilesHandler(w http.ResponseWriter, r *http.Request) {
+	h.writeProfiles(r.Context(), w)
+}
+
+// writeProfiles writes the login profiles, which is also
+// the response of requests that change the current one.
+func (h *handler) writeProfiles(ctx context.Context, w http.ResponseWriter) {
+	s, err := h.getProfiles(ctx)
+	if err != nil {
+		var re RelayError
+		if errors.As(err, &re) {
+			w.WriteHeader(re.statusCode)
+			json.NewEncoder(w).Encode(re)
+			return
+		}
+		h.l.Println("error getting profiles:", err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	json.NewEncoder(w).Encode(s)
+}
+
+func (h *handler) getProfiles(ctx context.Context) (*getProfilesResponse, error) {
+	current, all, err := h.lc.ProfileStatus(ctx)
+	if err != nil {
+		return nil, tailscaledError(err)
+	}
+	s := &getProfilesResponse{Profiles: []*loginProfile{}}
+	for _, p := range all {
+		lp := &loginProfile{
+			ID:            p.ID,
+			Name:          p.Name,
+			DisplayName:   p.UserProfile.DisplayName,
+			ProfilePicURL: p.UserProfile.ProfilePicURL,
+			Tailnet:       cmp.Or(p.NetworkProfile.DisplayName, p.NetworkProfile.DomainName),
+			Current:       p.ID == current.ID,
+		}
+		if lp.Current {
+			s.Current = lp
+		}
+		s.Profiles = append(s.Profiles, lp)
+	}
+	return s, nil
+}
+
+// switchProfileHandler makes the given profile the current one and
+// responds once tailscaled settled on it, so that the peers and
+// serve config the extension loads next are of the new tailnet.
+func (h *handler) switchProfileHandler(w http.ResponseWriter, r *http.Request) {
+	var req switchProfileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("error decoding request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	p, err := h.findProfile(r.Context(), req.ID)
+	if err == nil {
+		cmd := fmt.Sprintf("sudo tailscale switch %s", p.Name)
+		err = h.switchProfile(r.Context(), cmd, func(ctx context.Context) error {
+			return h.lc.SwitchProfile(ctx, p.ID)
+		})
+	}
+	if err != nil {
+		var re RelayError
+		if errors.As(err, &re) {
+			w.WriteHeader(re.statusCode)
+			json.NewEncoder(w).Encode(re)
+			return
+		}
+		h.l.Println("error switching profile:", err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	h.writeProfiles(r.Context(), w)
+}
+
+// addProfileHandler switches to a new empty profile, which
+// is then logged in to through the /login websocket.
+func (h *handler) addProfileHandler(w http.ResponseWriter, r *http.Request) {
+	err := h.switchProfile(r.Context(), "sudo tailscale login", func(ctx context.Context) error {
+		return h.lc.SwitchToEmptyProfile(ctx)
+	})
+	if err != nil {
+		var re RelayError
+		if errors.As(err, &re) {
+			w.WriteHeader(re.statusCode)
+			json.NewEncoder(w).Encode(re)
+			return
+		}
+		h.l.Println("error adding profile:", err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	h.writeProfiles(r.Context(), w)
+}
+
+func (h *handler) deleteProfileHandler(w http.ResponseWriter, r *http.Request) {
+	p, err := h.findProfile(r.Context(), ipn.ProfileID(chi.URLParam(r, "id")))
+	if err == nil {
+		// the CLI has no command to delete a profile, logging
+		// out of the current one is the closest thing.
+		cmd := fmt.Sprintf("sudo tailscale switch %s && sudo tailscale logout", p.Name)
+		err = h.switchProfile(r.Context(), cmd, func(ctx context.Context) error {
+			return h.lc.DeleteProfile(ctx, p.ID)
+		})
+	}
+	if err != nil {
+		var re RelayError
+		if errors.As(err, &re) {
+			w.WriteHeader(re.statusCode)
+			json.NewEncoder(w).Encode(re)
+			return
+		}
+		h.l.Println("error deleting profile:", err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	h.writeProfiles(r.Context(), w)
+}
+
+func (h *handler) findProfile(ctx context.Context, id ipn.ProfileID) (ipn.LoginProfile, error) {
+	_, all, err := h.lc.ProfileStatus(ctx)
+	if err != nil {
+		return ipn.LoginProfile{}, tailscaledError(err)
+	}
+	for _, p := range all {
+		if p.ID == id {
+			return p, nil
+		}
+	}
+	return ipn.LoginProfile{}, RelayError{
+		statusCode: http.StatusNotFound,
+		Errors:     []Error{{Type: ProfileNotFound}},
+	}
+}
+
+// switchProfile runs a change of the current profile and waits
+// for tailscaled to settle on the resulting one. Changes are
+// serialized so that concurrent requests can't interleave. The
+// user is asked to run cmd if tailscaled denies the change.
+func (h *handler) switchProfile(ctx context.Context, cmd string, change func(context.Context) error) error {
+	h.profileMu.Lock()
+	defer h.profileMu.Unlock()
+	if err := change(ctx); err != nil {
+		if tailscale.IsAccessDeniedError(err) {
+			return RelayError{
+				statusCode: http.StatusForbidden,
+				Errors:     []Error{{Type: RequiresSudo, Command: cmd}},
+			}
+		}
+		return tailscaledError(err)
+	}
+	current, _, err := h.lc.ProfileStatus(ctx)
+	if err != nil {
+		return tailscaledError(err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, profileSwitchTimeout)
+	defer cancel()
+	t := time.NewTicker(profileSwitchPollInterval)
+	defer t.Stop()
+	for {
+		st, err := h.lc.StatusWithoutPeers(ctx)
+		if err == nil && settled(st.BackendState) &&
+			(current.NetworkProfile.DomainName == "" || st.CurrentTailnet == nil ||
+				st.CurrentTailnet.Name == current.NetworkProfile.DomainName) {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out waiting for tailscaled to switch to %q", current.Name)
+		case <-t.C:
+		}
+	}
+}
+
+// settled reports whether the backend state is one
+// tailscaled stays in until something changes.
+func settled(backendState string) bool {
+	switch backendState {
+	case ipn.Running.String(), ipn.NeedsLogin.String(),
+		ipn.NeedsMachineAuth.String(), ipn.Stopped.String():
+		return true
+	}
+	return false
+}
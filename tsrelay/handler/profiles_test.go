@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+const accountsProfile = `{
+	"Status": {"BackendState": "NeedsLogin"},
+	"States": [{"Name": "loggedIn", "BackendState": "Running"}],
+	"LoginProfiles": [
+		{
+			"ID": "a1", "Name": "alice@example.com",
+			"NetworkProfile": {"DomainName": "example.com"},
+			"UserProfile": {"DisplayName": "Alice"},
+			"Status": {"BackendState": "Running", "CurrentTailnet": {"Name": "example.com"}}
+		},
+		{
+			"ID": "b2", "Name": "alice@work.example",
+			"NetworkProfile": {"DomainName": "work.example"},
+			"Status": {"BackendState": "Running", "CurrentTailnet": {"Name": "work.example"}}
+		}
+	],
+	"CurrentProfile": "a1"
+}`
+
+func TestLogin(t *testing.T) {
+	m, relay := newTestRelay(t, accountsProfile)
+	conn, _, err := relay.dial("/login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var msg loginMessage
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != "browseToURL" || msg.URL != mockLoginURL {
+		t.Fatalf("expected the login URL but got %+v", msg)
+	}
+	if _, err := m.jump(mockJump{State: "loggedIn"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != "loginFinished" {
+		t.Fatalf("expected the login to finish but got %+v", msg)
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	m, relay := newTestRelay(t, accountsProfile)
+	do := func(method, path, body string) (*http.Response, getProfilesResponse) {
+		resp := relay.do(method, path, body)
+		var s getProfilesResponse
+		json.NewDecoder(resp.Body).Decode(&s)
+		return resp, s
+	}
+
+	_, s := do(http.MethodGet, "/profiles", "")
+	if len(s.Profiles) != 2 || s.Current == nil || s.Current.ID != "a1" || s.Current.DisplayName != "Alice" {
+		t.Fatalf("unexpected profiles %+v", s)
+	}
+
+	_, s = do(http.MethodPut, "/profiles/current", `{"ID": "b2"}`)
+	if s.Current == nil || s.Current.ID != "b2" || s.Current.Tailnet != "work.example" {
+		t.Fatalf("expected to switch to the work profile but got %+v", s.Current)
+	}
+	st, _ := m.Status(t.Context())
+	if st.CurrentTailnet == nil || st.CurrentTailnet.Name != "work.example" {
+		t.Fatalf("expected the status of the new tailnet once switched but got %+v", st.CurrentTailnet)
+	}
+
+	resp, _ := do(http.MethodPut, "/profiles/current", `{"ID": "c3"}`)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected unknown profiles to be rejected but got %d", resp.StatusCode)
+	}
+
+	_, s = do(http.MethodPost, "/profiles", "")
+	if s.Current != nil || len(s.Profiles) != 2 {
+		t.Fatalf("expected an empty profile to be added but got %+v", s)
+	}
+	if st, _ := m.Status(t.Context()); st.BackendState != "NeedsLogin" {
+		t.Fatalf("expected the empty profile to need a login but got %q", st.BackendState)
+	}
+
+	_, s = do(http.MethodDelete, "/profiles/a1", "")
+	if len(s.Profiles) != 1 || s.Profiles[0].ID != "b2" {
+		t.Fatalf("expected the profile to be deleted but got %+v", s.Profiles)
+	}
+}
@@ -0,0 +1,160 @@
+package handler
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+	"sync"
+
+	"github.com/tailscale-dev/vscode-tailscale/tsrelay/logger"
+	"tailscale.com/net/proxymux"
+	"tailscale.com/net/socks5"
+)
+
+// ProxyUsername is the username clients of the
+// proxy authenticate with, along with the nonce.
+const ProxyUsername = "tsrelay"
+
+// Proxy is a local SOCKS5 and HTTP proxy that dials through
+// tailscaled, so that the tailnet can be reached when tailscaled
+// runs with userspace networking. Both protocols are served on
+// the same loopback port and require the proxy credentials.
+type Proxy struct {
+	ln   net.Listener
+	hs   *http.Server
+	wg   sync.WaitGroup
+	once sync.Once
+}
+
+// NewProxy starts a proxy on a loopback port. Clients authenticate
+// with ProxyUsername and password, which is the nonce of the relay.
+func NewProxy(lc LocalClient, password string, l logger.Logger) (*Proxy, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("error listening for proxy: %w", err)
+	}
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if network != "tcp" && network != "tcp4" && network != "tcp6" {
+			return nil, fmt.Errorf("unsupported network %q", network)
+		}
+		host, portStr, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		port, err := strconv.ParseUint(portStr, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %q", portStr)
+		}
+		return lc.DialTCP(ctx, host, uint16(port))
+	}
+
+	sl, hl := proxymux.SplitSOCKSAndHTTP(ln)
+	ss := &socks5.Server{
+		Logf:     l.VPrintf,
+		Dialer:   dial,
+		Username: ProxyUsername,
+		Password: password,
+	}
+	p := &Proxy{
+		ln: ln,
+		hs: &http.Server{Handler: proxyHandler(dial, password)},
+	}
+	p.wg.Add(2)
+	go func() {
+		defer p.wg.Done()
+		ss.Serve(sl)
+	}()
+	go func() {
+		defer p.wg.Done()
+		p.hs.Serve(hl)
+	}()
+	l.Printf("proxy listening on %s", ln.Addr())
+	return p, nil
+}
+
+// Addr returns the address the proxy listens on.
+func (p *Proxy) Addr() string {
+	return p.ln.Addr().String()
+}
+
+// Close stops the proxy from accepting connections.
+func (p *Proxy) Close() error {
+	var err error
+	p.once.Do(func() {
+		err = p.ln.Close()
+		p.hs.Close()
+		p.wg.Wait()
+	})
+	return err
+}
+
+// proxyHandler returns an HTTP proxy that supports CONNECT and
+// requests for absolute URLs, the way tailscaled's does.
+func proxyHandler(dial func(ctx context.Context, network, addr string) (net.Conn, error), password string) http.Handler {
+	rp := &httputil.ReverseProxy{
+		// requests are for absolute URLs already
+		Rewrite:   func(*httputil.ProxyRequest) {},
+		Transport: &http.Transport{DialContext: dial},
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !proxyAuthorized(r, password) {
+			w.Header().Set("Proxy-Authenticate", `Basic realm="tsrelay"`)
+			http.Error(w, "proxy authentication required", http.StatusProxyAuthRequired)
+			return
+		}
+		if r.Method != http.MethodConnect {
+			if !r.URL.IsAbs() {
+				http.Error(w, "request must be for an absolute URL or CONNECT", http.StatusBadRequest)
+				return
+			}
+			rp.ServeHTTP(w, r)
+			return
+		}
+
+		c, err := dial(r.Context(), "tcp", r.RequestURI)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer c.Close()
+		cc, buf, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		defer cc.Close()
+		if _, err := io.WriteString(cc, "HTTP/1.1 200 Connection established\r\n\r\n"); err != nil {
+			return
+		}
+
+		done := make(chan struct{}, 2)
+		go func() {
+			io.Copy(cc, c)
+			done <- struct{}{}
+		}()
+		go func() {
+			// the client may have sent data along with the request
+			io.Copy(c, buf.Reader)
+			done <- struct{}{}
+		}()
+		<-done
+	})
+}
+
+// proxyAuthorized reports whether r has the proxy credentials.
+func proxyAuthorized(r *http.Request, password string) bool {
+	auth := r.Header.Get("Proxy-Authorization")
+	if auth == "" {
+		return false
+	}
+	// reuse the parsing of the Authorization header
+	r2 := &http.Request{Header: http.Header{"Authorization": {auth}}}
+	user, pass, ok := r2.BasicAuth()
+	return ok && user == ProxyUsername &&
+		subtle.ConstantTimeCompare([]byte(pass), []byte(password)) == 1
+}
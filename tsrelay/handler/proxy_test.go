@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/tailscale-dev/vscode-tailscale/tsrelay/logger"
+)
+
+func TestProxy(t *testing.T) {
+	web := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Proxy-Authorization") != "" {
+			t.Error("expected the proxy credentials not to be forwarded")
+		}
+		io.WriteString(w, "hello from the tailnet")
+	}))
+	t.Cleanup(web.Close)
+	m, _ := newTestMockClient(t, fmt.Sprintf(`{
+		"Status": {
+			"BackendState": "Running",
+			"Peer": {
+				"nodekey:0000000000000000000000000000000000000000000000000000000000000001": {
+					"ID": "nDev", "DNSName": "dev.example.ts.net.", "Online": true, "TailscaleIPs": ["100.64.0.1"]
+				}
+			}
+		},
+		"PeerServices": [{"Peer": "nDev", "Port": 80, "Forward": %q}]
+	}`, web.Listener.Addr()))
+	p, err := NewProxy(m, "123", logger.Nop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.Close() })
+
+	get := func(proxy *url.URL) *http.Response {
+		c := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxy)}}
+		resp, err := c.Get("http://dev.example.ts.net/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+	resp := get(&url.URL{Scheme: "http", Host: p.Addr(), User: url.UserPassword(ProxyUsername, "123")})
+	if b, _ := io.ReadAll(resp.Body); resp.StatusCode != http.StatusOK || string(b) != "hello from the tailnet" {
+		t.Fatalf("unexpected response %d %q", resp.StatusCode, b)
+	}
+	resp = get(&url.URL{Scheme: "http", Host: p.Addr(), User: url.UserPassword(ProxyUsername, "wrong")})
+	if resp.StatusCode != http.StatusProxyAuthRequired {
+		t.Fatalf("expected wrong credentials to be rejected but got %d", resp.StatusCode)
+	}
+
+	// CONNECT
+	c, err := net.Dial("tcp", p.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	req, _ := http.NewRequest(http.MethodConnect, "http://100.64.0.1:80", nil)
+	req.Host = "100.64.0.1:80"
+	req.Header.Set("Proxy-Authorization", "Basic "+basicAuth(ProxyUsername, "123"))
+	req.Write(c)
+	br := bufio.NewReader(c)
+	resp, err = http.ReadResponse(br, req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected CONNECT to succeed but got %v %v", resp, err)
+	}
+	assertTunnel(t, c, br)
+
+	// SOCKS5 with username and password authentication
+	c, err = net.Dial("tcp", p.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	br = bufio.NewReader(c)
+	c.Write([]byte{5, 1, 2})
+	expectBytes(t, br, 5, 2)
+	auth := []byte{1, byte(len(ProxyUsername))}
+	auth = append(auth, ProxyUsername...)
+	auth = append(auth, 3)
+	auth = append(auth, "123"...)
+	c.Write(auth)
+	expectBytes(t, br, 1, 0)
+	host := "dev.example.ts.net"
+	connect := append([]byte{5, 1, 0, 3, byte(len(host))}, host...)
+	connect = binary.BigEndian.AppendUint16(connect, 80)
+	c.Write(connect)
+	reply := make([]byte, 4)
+	if _, err := io.ReadFull(br, reply); err != nil || reply[1] != 0 {
+		t.Fatalf("expected the SOCKS5 connect to succeed but got %v %v", reply, err)
+	}
+	// skip the bound address
+	addrLen := map[byte]int{1: 4, 4: 16}[reply[3]]
+	if reply[3] == 3 {
+		n, _ := br.ReadByte()
+		addrLen = int(n)
+	}
+	io.ReadFull(br, make([]byte, addrLen+2))
+	assertTunnel(t, c, br)
+}
+
+func expectBytes(t *testing.T, r io.Reader, want ...byte) {
+	t.Helper()
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(r, got); err != nil || string(got) != string(want) {
+		t.Fatalf("expected %v but got %v %v", want, got, err)
+	}
+}
+
+// assertTunnel checks that c is connected to the web server of the peer.
+func assertTunnel(t *testing.T, c net.Conn, br *bufio.Reader) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, "http://dev.example.ts.net/", nil)
+	req.Write(c)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if b, _ := io.ReadAll(resp.Body); string(b) != "hello from the tailnet" {
+		t.Fatalf("unexpected response through the tunnel %q", b)
+	}
+}
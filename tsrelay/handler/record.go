@@ -0,0 +1,531 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tailscale-dev/vscode-tailscale/tsrelay/logger"
+	"tailscale.com/client/local"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/net/tsaddr"
+	"tailscale.com/tailcfg"
+)
+
+// maxRecordedCalls bounds the size of a recording as the
+// serve panel polls the status for as long as it's open.
+const maxRecordedCalls = 1000
+
+// mockCall is a recorded LocalClient call. Calls are replayed
+// in order per method, since the relay makes some of them
+// concurrently.
+type mockCall struct {
+	Method string
+	// Result is the redacted response of the call.
+	Result json.RawMessage `json:",omitempty"`
+	// ETag is the ETag of a returned serve config.
+	ETag string `json:",omitempty"`
+	// Error is the error of the call in the
+	// same format as the error of a mockFault.
+	Error   string       `json:",omitempty"`
+	Latency mockDuration `json:",omitempty"`
+}
+
+// NewRecordingClient returns a LocalClient that forwards calls to lc
+// and writes every call and its redacted response to the given file.
+// The file is a profile that replays the calls with -mockfile. Calls
+// are written out every recordFlushInterval and on Close. Errors of
+// the recording are logged and don't fail the calls.
+func NewRecordingClient(lc LocalClient, file string, l logger.Logger) *RecordingClient {
+	return &RecordingClient{lc: lc, file: file, l: l}
+}
+
+// recordFlushInterval is how often new calls are written
+// out, rather than rewriting the recording on every call.
+const recordFlushInterval = time.Second
+
+// RecordingClient is a LocalClient that records its calls.
+type RecordingClient struct {
+	lc   LocalClient
+	file string
+	l    logger.Logger
+
+	// writeMu orders writes of the recording.
+	writeMu sync.Mutex
+
+	mu    sync.Mutex
+	p     profile
+	flush *time.Timer // pending write of new calls, or nil
+}
+
+// record adds a call to the recording, to be written out soon.
+func (rc *RecordingClient) record(method string, start time.Time, result any, etag string, err error) {
+	call := mockCall{
+		Method:  method,
+		ETag:    etag,
+		Error:   faultFromError(err),
+		Latency: mockDuration(time.Since(start).Round(time.Millisecond)),
+	}
+	if err == nil && result != nil {
+		bts, rerr := redactJSON(result)
+		if rerr != nil {
+			rc.l.Printf("error recording %s: %v", method, rerr)
+			return
+		}
+		call.Result = bts
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if len(rc.p.Recording) >= maxRecordedCalls {
+		return
+	}
+	rc.p.Recording = append(rc.p.Recording, call)
+	// the first responses double as the static profile
+	// for tools that don't look at the recording.
+	if rc.p.Status == nil && (method == "Status" || method == "StatusWithoutPeers") && call.Result != nil {
+		rc.p.Status = new(ipnstate.Status)
+		json.Unmarshal(call.Result, rc.p.Status)
+	}
+	if rc.p.ServeConfig == nil && method == "GetServeConfig" && call.Result != nil {
+		rc.p.ServeConfig = new(ipn.ServeConfig)
+		json.Unmarshal(call.Result, rc.p.ServeConfig)
+	}
+	if rc.flush == nil {
+		rc.flush = time.AfterFunc(recordFlushInterval, func() {
+			if err := rc.Flush(); err != nil {
+				rc.l.Printf("error writing recording: %v", err)
+			}
+		})
+	}
+}
+
+// Flush writes the calls recorded so far to the file.
+func (rc *RecordingClient) Flush() error {
+	rc.writeMu.Lock()
+	defer rc.writeMu.Unlock()
+	rc.mu.Lock()
+	if rc.flush != nil {
+		rc.flush.Stop()
+		rc.flush = nil
+	}
+	bts, err := json.MarshalIndent(&rc.p, "", "  ")
+	rc.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(rc.file)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".tsrelay-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(bts); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), rc.file)
+}
+
+// Close writes out the recording.
+func (rc *RecordingClient) Close() error {
+	return rc.Flush()
+}
+
+// Status implements LocalClient.
+func (rc *RecordingClient) Status(ctx context.Context) (*ipnstate.Status, error) {
+	start := time.Now()
+	st, err := rc.lc.Status(ctx)
+	rc.record("Status", start, st, "", err)
+	return st, err
+}
+
+// StatusWithoutPeers implements LocalClient.
+func (rc *RecordingClient) StatusWithoutPeers(ctx context.Context) (*ipnstate.Status, error) {
+	start := time.Now()
+	st, err := rc.lc.StatusWithoutPeers(ctx)
+	rc.record("StatusWithoutPeers", start, st, "", err)
+	return st, err
+}
+
+// GetServeConfig implements LocalClient.
+func (rc *RecordingClient) GetServeConfig(ctx context.Context) (*ipn.ServeConfig, error) {
+	start := time.Now()
+	sc, err := rc.lc.GetServeConfig(ctx)
+	var etag string
+	if sc != nil {
+		etag = sc.ETag
+	}
+	rc.record("GetServeConfig", start, sc, etag, err)
+	return sc, err
+}
+
+// SetServeConfig implements LocalClient. Only the
+// outcome of the call is recorded, not the config.
+func (rc *RecordingClient) SetServeConfig(ctx context.Context, config *ipn.ServeConfig) error {
+	start := time.Now()
+	err := rc.lc.SetServeConfig(ctx, config)
+	rc.record("SetServeConfig", start, nil, "", err)
+	return err
+}
+
+// Ping implements LocalClient.
+func (rc *RecordingClient) Ping(ctx context.Context, ip netip.Addr, pingtype tailcfg.PingType) (*ipnstate.PingResult, error) {
+	start := time.Now()
+	res, err := rc.lc.Ping(ctx, ip, pingtype)
+	rc.record("Ping", start, res, "", err)
+	return res, err
+}
+
+// FileTargets implements LocalClient.
+func (rc *RecordingClient) FileTargets(ctx context.Context) ([]apitype.FileTarget, error) {
+	start := time.Now()
+	targets, err := rc.lc.FileTargets(ctx)
+	rc.record("FileTargets", start, targets, "", err)
+	return targets, err
+}
+
+// PushFile implements LocalClient. Only the
+// outcome of the call is recorded, not the file.
+func (rc *RecordingClient) PushFile(ctx context.Context, target tailcfg.StableNodeID, size int64, name string, r io.Reader) error {
+	start := time.Now()
+	err := rc.lc.PushFile(ctx, target, size, name, r)
+	rc.record("PushFile", start, nil, "", err)
+	return err
+}
+
+// WaitingFiles implements LocalClient.
+func (rc *RecordingClient) WaitingFiles(ctx context.Context) ([]apitype.WaitingFile, error) {
+	start := time.Now()
+	files, err := rc.lc.WaitingFiles(ctx)
+	rc.record("WaitingFiles", start, files, "", err)
+	return files, err
+}
+
+// GetWaitingFile implements LocalClient. Only the
+// outcome of the call is recorded, not the file.
+func (rc *RecordingClient) GetWaitingFile(ctx context.Context, baseName string) (io.ReadCloser, int64, error) {
+	start := time.Now()
+	r, size, err := rc.lc.GetWaitingFile(ctx, baseName)
+	rc.record("GetWaitingFile", start, nil, "", err)
+	return r, size, err
+}
+
+// DeleteWaitingFile implements LocalClient.
+func (rc *RecordingClient) DeleteWaitingFile(ctx context.Context, baseName string) error {
+	start := time.Now()
+	err := rc.lc.DeleteWaitingFile(ctx, baseName)
+	rc.record("DeleteWaitingFile", start, nil, "", err)
+	return err
+}
+
+// GetPrefs implements LocalClient.
+func (rc *RecordingClient) GetPrefs(ctx context.Context) (*ipn.Prefs, error) {
+	start := time.Now()
+	prefs, err := rc.lc.GetPrefs(ctx)
+	rc.record("GetPrefs", start, prefs, "", err)
+	return prefs, err
+}
+
+// EditPrefs implements LocalClient.
+func (rc *RecordingClient) EditPrefs(ctx context.Context, mp *ipn.MaskedPrefs) (*ipn.Prefs, error) {
+	start := time.Now()
+	prefs, err := rc.lc.EditPrefs(ctx, mp)
+	rc.record("EditPrefs", start, prefs, "", err)
+	return prefs, err
+}
+
+// SuggestExitNode implements LocalClient.
+func (rc *RecordingClient) SuggestExitNode(ctx context.Context) (apitype.ExitNodeSuggestionResponse, error) {
+	start := time.Now()
+	res, err := rc.lc.SuggestExitNode(ctx)
+	rc.record("SuggestExitNode", start, res, "", err)
+	return res, err
+}
+
+// StartLoginInteractive implements LocalClient.
+func (rc *RecordingClient) StartLoginInteractive(ctx context.Context) error {
+	start := time.Now()
+	err := rc.lc.StartLoginInteractive(ctx)
+	rc.record("StartLoginInteractive", start, nil, "", err)
+	return err
+}
+
+// Logout implements LocalClient.
+func (rc *RecordingClient) Logout(ctx context.Context) error {
+	start := time.Now()
+	err := rc.lc.Logout(ctx)
+	rc.record("Logout", start, nil, "", err)
+	return err
+}
+
+// ProfileStatus implements LocalClient.
+func (rc *RecordingClient) ProfileStatus(ctx context.Context) (ipn.LoginProfile, []ipn.LoginProfile, error) {
+	start := time.Now()
+	current, all, err := rc.lc.ProfileStatus(ctx)
+	res := mockProfileStatus{Current: current, All: all}
+	rc.record("ProfileStatus", start, res, "", err)
+	return current, all, err
+}
+
+// SwitchProfile implements LocalClient.
+func (rc *RecordingClient) SwitchProfile(ctx context.Context, profile ipn.ProfileID) error {
+	start := time.Now()
+	err := rc.lc.SwitchProfile(ctx, profile)
+	rc.record("SwitchProfile", start, nil, "", err)
+	return err
+}
+
+// SwitchToEmptyProfile implements LocalClient.
+func (rc *RecordingClient) SwitchToEmptyProfile(ctx context.Context) error {
+	start := time.Now()
+	err := rc.lc.SwitchToEmptyProfile(ctx)
+	rc.record("SwitchToEmptyProfile", start, nil, "", err)
+	return err
+}
+
+// DeleteProfile implements LocalClient.
+func (rc *RecordingClient) DeleteProfile(ctx context.Context, profile ipn.ProfileID) error {
+	start := time.Now()
+	err := rc.lc.DeleteProfile(ctx, profile)
+	rc.record("DeleteProfile", start, nil, "", err)
+	return err
+}
+
+// DialTCP implements LocalClient. Connections are passed
+// through without being recorded, as a scan would fill the
+// recording with dials that can't be replayed in order.
+func (rc *RecordingClient) DialTCP(ctx context.Context, host string, port uint16) (net.Conn, error) {
+	return rc.lc.DialTCP(ctx, host, port)
+}
+
+// WhoIs implements LocalClient.
+func (rc *RecordingClient) WhoIs(ctx context.Context, remoteAddr string) (*apitype.WhoIsResponse, error) {
+	start := time.Now()
+	res, err := rc.lc.WhoIs(ctx, remoteAddr)
+	rc.record("WhoIs", start, res, "", err)
+	return res, err
+}
+
+// watchIPNBus implements ipnBusSource. Notifications
+// are passed through without being recorded.
+func (rc *RecordingClient) watchIPNBus(ctx context.Context, mask ipn.NotifyWatchOpt) (ipnBusWatcher, error) {
+	return watchIPNBus(ctx, rc.lc, mask)
+}
+
+// faultFromError maps an error of the LocalClient
+// to the error of a mockFault that reproduces it.
+func faultFromError(err error) string {
+	var oe *net.OpError
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &oe) && oe.Op == "dial":
+		return faultOffline
+	case local.IsAccessDeniedError(err):
+		return faultAccessDenied
+	case local.IsPreconditionsFailedError(err):
+		return faultConflict
+	case errors.Is(err, local.ErrPeerNotFound):
+		return faultPeerNotFound
+	default:
+		return err.Error()
+	}
+}
+
+// redactJSON encodes v as JSON without personal data and secrets.
+// Node keys are replaced by fake keys derived from the real ones so
+// that peers can still be told apart, names, logins and tailnet IPs
+// by stable pseudonyms, and endpoints and login URLs are dropped.
+func redactJSON(v any) (json.RawMessage, error) {
+	bts, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(bts))
+	// keep large IDs intact
+	dec.UseNumber()
+	var tree any
+	if err := dec.Decode(&tree); err != nil {
+		return nil, err
+	}
+	return json.Marshal(redactValue("", tree))
+}
+
+// nameFields are the fields with names of hosts, DNS names or
+// tailnet names, which are replaced by pseudonyms of the same shape.
+var nameFields = []string{
+	"CertDomains",
+	"ComputedName",
+	"ComputedNameWithHost",
+	"DNSName",
+	"DomainName",
+	"HostName",
+	"Hostname",
+	"Name",
+	"NodeName",
+}
+
+// domainFields are the fields with the MagicDNS suffix of the tailnet.
+var domainFields = []string{"MagicDNSName", "MagicDNSSuffix"}
+
+func redactValue(field string, v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, child := range v {
+			switch k {
+			case "Addrs", "Endpoints", "TailnetLock", "Persist":
+				out[k] = nil
+			case "AuthURL", "CurAddr", "Endpoint", "PeerRelay", "ProfilePicURL":
+				out[k] = ""
+			default:
+				out[redactKey(field, k)] = redactValue(k, child)
+			}
+		}
+		return out
+	case []any:
+		for i := range v {
+			v[i] = redactValue(field, v[i])
+		}
+		return v
+	case string:
+		return redactString(field, v)
+	}
+	return v
+}
+
+// redactKey redacts the key of a map in the given field. The keys
+// of the web handlers and funnels of a serve config are host:port.
+func redactKey(field, k string) string {
+	if field == "Web" || field == "AllowFunnel" {
+		if host, port, err := net.SplitHostPort(k); err == nil {
+			return net.JoinHostPort(redactName(host), port)
+		}
+	}
+	return redactString("", k)
+}
+
+func redactString(field, s string) string {
+	if s == "" {
+		return s
+	}
+	sum := sha256.Sum256([]byte(s))
+	switch {
+	case strings.HasPrefix(s, "nodekey:"):
+		return "nodekey:" + hex.EncodeToString(sum[:])
+	case strings.HasPrefix(s, "mkey:"), strings.HasPrefix(s, "discokey:"):
+		prefix, _, _ := strings.Cut(s, ":")
+		return prefix + ":" + hex.EncodeToString(sum[:])
+	case field == "LoginName":
+		return redactLogin(s)
+	case field == "DisplayName":
+		return "User " + hex.EncodeToString(sum[:4])
+	case slices.Contains(nameFields, field):
+		return redactName(s)
+	case slices.Contains(domainFields, field):
+		return redactDomain(s)
+	case field == "PeerAPIURL":
+		if u, err := url.Parse(s); err == nil {
+			if host, port, err := net.SplitHostPort(u.Host); err == nil {
+				u.Host = net.JoinHostPort(redactIP(host), port)
+				return u.String()
+			}
+		}
+		return ""
+	}
+	return redactIP(s)
+}
+
+func redactLogin(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return "user-" + hex.EncodeToString(sum[:4]) + "@example.com"
+}
+
+// redactName replaces a host name, a DNS name or a tailnet name by a
+// pseudonym. Each label of a DNS name is replaced on its own so that
+// the names of a tailnet keep sharing the pseudonym of its domain.
+// The names of Mullvad exit nodes are public and kept.
+func redactName(s string) string {
+	if s == "" || strings.HasSuffix(strings.TrimSuffix(s, "."), ".mullvad.ts.net") {
+		return s
+	}
+	if strings.Contains(s, "@") {
+		return redactLogin(s)
+	}
+	name, dot := strings.CutSuffix(s, ".")
+	host, domain, ok := strings.Cut(name, ".")
+	sum := sha256.Sum256([]byte(host))
+	name = "host-" + hex.EncodeToString(sum[:4])
+	if ok {
+		name += "." + redactDomain(domain)
+	}
+	if dot {
+		name += "."
+	}
+	return name
+}
+
+// redactDomain replaces the domain of a tailnet by a pseudonym.
+func redactDomain(s string) string {
+	if s == "" || s == "mullvad.ts.net" {
+		return s
+	}
+	domain, dot := strings.CutSuffix(s, ".")
+	sum := sha256.Sum256([]byte(domain))
+	domain = "tailnet-" + hex.EncodeToString(sum[:4]) + ".ts.net"
+	if dot {
+		domain += "."
+	}
+	return domain
+}
+
+// redactIP replaces tailnet IPs, on their own, as prefix or
+// with a port, by pseudonymous IPs in the same range so that
+// addresses of peers can still be told apart. Other strings
+// are returned as is.
+func redactIP(s string) string {
+	if ip, err := netip.ParseAddr(s); err == nil {
+		return pseudonymousIP(ip).String()
+	}
+	if p, err := netip.ParsePrefix(s); err == nil {
+		return netip.PrefixFrom(pseudonymousIP(p.Addr()), p.Bits()).String()
+	}
+	if ap, err := netip.ParseAddrPort(s); err == nil {
+		return netip.AddrPortFrom(pseudonymousIP(ap.Addr()), ap.Port()).String()
+	}
+	return s
+}
+
+func pseudonymousIP(ip netip.Addr) netip.Addr {
+	if !tsaddr.IsTailscaleIP(ip) || ip == tsaddr.TailscaleServiceIP() || ip == tsaddr.TailscaleServiceIPv6() {
+		return ip
+	}
+	sum := sha256.Sum256(ip.AsSlice())
+	if ip.Is4() {
+		return netip.AddrFrom4([4]byte{100, 64 | sum[0]&0x3f, sum[1], sum[2]})
+	}
+	b := ip.As16()
+	copy(b[6:], sum[:10])
+	return netip.AddrFrom16(b)
+}
@@ -0,0 +1,221 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/tailscale-dev/vscode-tailscale/tsrelay/logger"
+	"tailscale.com/ipn"
+)
+
+const recordProfile = `{
+	"Status": {
+		"BackendState": "Running",
+		"AuthURL": "https://login.tailscale.com/a/secret",
+		"Self": {
+			"ID": "nSelf",
+			"PublicKey": "nodekey:0000000000000000000000000000000000000000000000000000000000000001",
+			"DNSName": "self.ts.net.",
+			"UserID": 123456789012345678,
+			"Addrs": ["203.0.113.1:41641"]
+		},
+		"Peer": {
+			"nodekey:0000000000000000000000000000000000000000000000000000000000000002": {
+				"ID": "nPeer",
+				"PublicKey": "nodekey:0000000000000000000000000000000000000000000000000000000000000002",
+				"DNSName": "peer.ts.net.",
+				"CurAddr": "198.51.100.7:41641"
+			}
+		},
+		"User": {
+			"123456789012345678": {"ID": 123456789012345678, "LoginName": "alice@example.org", "DisplayName": "Alice Smith"}
+		}
+	},
+	"ServeConfig": {"TCP": {"443": {"HTTPS": true}}},
+	"States": [{"Name": "offline", "AfterCalls": 3, "MockOffline": true}]
+}`
+
+func TestRecordAndReplay(t *testing.T) {
+	src, _ := newTestMockClient(t, recordProfile)
+	file := filepath.Join(t.TempDir(), "recording.json")
+	rc := NewRecordingClient(src, file, logger.Nop)
+	ctx := context.Background()
+
+	sc, err := rc.GetServeConfig(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recordedETag := sc.ETag
+	st, err := rc.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.User[123456789012345678].LoginName != "alice@example.org" {
+		t.Fatal("expected the caller to see the real response")
+	}
+	// the third call takes tailscaled down
+	_, recordedErr := rc.Status(ctx)
+	var oe *net.OpError
+	if !errors.As(recordedErr, &oe) {
+		t.Fatalf("expected tailscaled to be offline but got %v", recordedErr)
+	}
+
+	if err := rc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	bts, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"alice@example.org", "Alice Smith", "login.tailscale.com", "203.0.113.1", "198.51.100.7",
+		"0000000000000000000000000000000000000000000000000000000000000002"} {
+		if strings.Contains(string(bts), secret) {
+			t.Errorf("recording contains %q", secret)
+		}
+	}
+
+	replay, err := NewMockClient(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc, err = replay.GetServeConfig(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sc.TCP[443] == nil || sc.ETag != recordedETag {
+		t.Fatalf("expected the recorded serve config but got %v with ETag %q", sc.TCP, sc.ETag)
+	}
+	st, err = replay.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.BackendState != "Running" || st.Self.DNSName != redactName("self.ts.net.") {
+		t.Fatalf("unexpected replayed status %q %q", st.BackendState, st.Self.DNSName)
+	}
+	if len(st.Peer) != 1 {
+		t.Fatalf("expected one peer but got %d", len(st.Peer))
+	}
+	for k, p := range st.Peer {
+		if k != p.PublicKey {
+			t.Fatalf("expected peer keys to stay consistent but got %v and %v", k, p.PublicKey)
+		}
+		if p.CurAddr != "" {
+			t.Fatalf("expected the endpoint to be redacted but got %q", p.CurAddr)
+		}
+	}
+	u := st.User[123456789012345678]
+	if !strings.HasSuffix(u.LoginName, "@example.com") || u.DisplayName == "" {
+		t.Fatalf("expected a pseudonymous user but got %+v", u)
+	}
+	if _, err := replay.Status(ctx); !errors.As(err, &oe) || oe.Op != "dial" {
+		t.Fatalf("expected the recorded error to be replayed but got %v", err)
+	}
+	// the last call is repeated once the recording runs out
+	if _, err := replay.Status(ctx); !errors.As(err, &oe) {
+		t.Fatalf("expected the last call to be repeated but got %v", err)
+	}
+	if err := replay.SetServeConfig(ctx, &ipn.ServeConfig{}); err != nil {
+		t.Fatalf("expected methods without recordings to use the profile but got %v", err)
+	}
+}
+
+func TestRecordingErrorsDontFailCalls(t *testing.T) {
+	src, _ := newTestMockClient(t, recordProfile)
+	notADir := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(notADir, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	rc := NewRecordingClient(src, filepath.Join(notADir, "recording.json"), logger.Nop)
+	if _, err := rc.Status(context.Background()); err != nil {
+		t.Fatalf("expected the call to succeed without a recording but got %v", err)
+	}
+	if err := rc.Close(); err == nil {
+		t.Fatal("expected writing the recording to fail")
+	}
+}
+
+func TestRecordingRedactsNamesAndIPs(t *testing.T) {
+	src, _ := newTestMockClient(t, `{
+		"Status": {
+			"BackendState": "Running",
+			"TailscaleIPs": ["100.101.102.103", "fd7a:115c:a1e0::1234"],
+			"MagicDNSSuffix": "tail1a2b3.ts.net",
+			"CurrentTailnet": {"Name": "alice@example.org", "MagicDNSSuffix": "tail1a2b3.ts.net"},
+			"CertDomains": ["alices-laptop.tail1a2b3.ts.net"],
+			"Self": {
+				"ID": "nSelf", "HostName": "alices-laptop", "DNSName": "alices-laptop.tail1a2b3.ts.net.",
+				"TailscaleIPs": ["100.101.102.103", "fd7a:115c:a1e0::1234"], "Online": true
+			},
+			"Peer": {
+				"nodekey:0000000000000000000000000000000000000000000000000000000000000002": {
+					"ID": "nDev", "HostName": "build-box", "DNSName": "build-box.tail1a2b3.ts.net.",
+					"TailscaleIPs": ["100.90.91.92"], "PrimaryRoutes": ["100.90.91.92/32"], "Online": true
+				}
+			}
+		},
+		"ServeConfig": {
+			"TCP": {"443": {"HTTPS": true}},
+			"Web": {"alices-laptop.tail1a2b3.ts.net:443": {"Handlers": {"/": {"Proxy": "http://127.0.0.1:3000"}}}},
+			"AllowFunnel": {"alices-laptop.tail1a2b3.ts.net:443": true}
+		}
+	}`)
+	file := filepath.Join(t.TempDir(), "recording.json")
+	rc := NewRecordingClient(src, file, logger.Nop)
+	ctx := context.Background()
+	st, err := rc.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rc.GetServeConfig(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rc.FileTargets(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rc.WhoIs(ctx, "100.90.91.92"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	bts, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, original := range []string{"alice", "build-box", "tail1a2b3", "100.101.102.103", "fd7a:115c:a1e0::1234", "100.90.91.92"} {
+		if strings.Contains(string(bts), original) {
+			t.Errorf("recording contains %q", original)
+		}
+	}
+
+	replay, err := NewMockClient(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rst, err := replay.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsc, err := replay.GetServeConfig(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the pseudonyms stay consistent across fields and calls
+	hostPort := strings.TrimSuffix(rst.Self.DNSName, ".") + ":443"
+	if rsc.Web[ipn.HostPort(hostPort)] == nil || !rsc.AllowFunnel[ipn.HostPort(hostPort)] {
+		t.Fatalf("expected the serve config to use the pseudonym %q of the self node but got %v", hostPort, rsc.Web)
+	}
+	if !strings.HasSuffix(rst.Self.DNSName, "."+rst.CurrentTailnet.MagicDNSSuffix+".") {
+		t.Fatalf("expected %q to be in the domain %q", rst.Self.DNSName, rst.CurrentTailnet.MagicDNSSuffix)
+	}
+	if len(rst.Self.TailscaleIPs) != 2 || rst.Self.TailscaleIPs[0] == st.Self.TailscaleIPs[0] ||
+		!slices.Equal(rst.TailscaleIPs, rst.Self.TailscaleIPs) {
+		t.Fatalf("expected pseudonymous tailnet IPs but got %v", rst.Self.TailscaleIPs)
+	}
+}
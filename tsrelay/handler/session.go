@@ -0,0 +1,174 @@
+package handler
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Sessions keeps track of the editor windows that are
+// attached to a single tsrelay process. Each window holds
+// a session for as long as it is open and the relay can
+// shut down once the last one has been released.
+type Sessions struct {
+	mu       sync.Mutex
+	leases   map[string]*lease
+	acquired bool
+	idle     chan struct{}
+}
+
+// NewSessions returns an empty session registry.
+func NewSessions() *Sessions {
+	return &Sessions{
+		leases: make(map[string]*lease),
+		idle:   make(chan struct{}),
+	}
+}
+
+// lease is the hold of a single editor window on the relay. It
+// only keeps the relay running; the state of a window's port
+// discovery is kept on its connection, in portDiscoSession.
+type lease struct {
+	id string
+	// polled is set for the leases of other processes, which
+	// renew them by polling and expire once they stop.
+	polled   bool
+	lastSeen time.Time
+}
+
+// Acquire registers a new session of this process and returns its
+// ID. It is held until released, unlike the sessions of attached
+// relays, which expire.
+func (s *Sessions) Acquire() string {
+	return s.acquire(false)
+}
+
+func (s *Sessions) acquire(polled bool) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := newSessionID()
+	s.leases[id] = &lease{id: id, polled: polled, lastSeen: time.Now()}
+	s.acquired = true
+	return id
+}
+
+// Release removes the given session. It reports false
+// if the session did not exist. Once the last session
+// is released, the channel returned by Idle is closed.
+func (s *Sessions) Release(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.releaseLocked(id)
+}
+
+func (s *Sessions) releaseLocked(id string) bool {
+	if _, ok := s.leases[id]; !ok {
+		return false
+	}
+	delete(s.leases, id)
+	if len(s.leases) == 0 && s.acquired {
+		select {
+		case <-s.idle:
+		default:
+			close(s.idle)
+		}
+	}
+	return true
+}
+
+// Expire releases the polled sessions that haven't been seen for
+// longer than d, such as those of attached relays that crashed
+// without releasing them. It returns the IDs of expired sessions.
+func (s *Sessions) Expire(d time.Duration) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var expired []string
+	for id, l := range s.leases {
+		if l.polled && time.Since(l.lastSeen) > d {
+			expired = append(expired, id)
+		}
+	}
+	for _, id := range expired {
+		s.releaseLocked(id)
+	}
+	return expired
+}
+
+// Len returns the number of active sessions.
+func (s *Sessions) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.leases)
+}
+
+// Idle returns a channel that is closed once every
+// acquired session has been released.
+func (s *Sessions) Idle() <-chan struct{} {
+	return s.idle
+}
+
+func (s *Sessions) get(id string) (*lease, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.leases[id]
+	return l, ok
+}
+
+// renew marks the given session as seen. It
+// reports false if the session did not exist.
+func (s *Sessions) renew(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.leases[id]
+	if ok {
+		l.lastSeen = time.Now()
+	}
+	return ok
+}
+
+func newSessionID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+type sessionResponse struct {
+	ID       string `json:"id,omitempty"`
+	Sessions int    `json:"sessions"`
+}
+
+func (h *handler) getSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(sessionResponse{Sessions: h.sessions.Len()})
+}
+
+func (h *handler) createSessionHandler(w http.ResponseWriter, r *http.Request) {
+	id := h.sessions.acquire(true)
+	h.l.Printf("session %s attached", id)
+	json.NewEncoder(w).Encode(sessionResponse{ID: id, Sessions: h.sessions.Len()})
+}
+
+// getSessionHandler renews the lease of the session,
+// which attached relays poll for as long as they run.
+func (h *handler) getSessionHandler(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if !h.sessions.renew(id) {
+		http.NotFound(w, r)
+		return
+	}
+	json.NewEncoder(w).Encode(sessionResponse{ID: id, Sessions: h.sessions.Len()})
+}
+
+func (h *handler) deleteSessionHandler(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if !h.sessions.Release(id) {
+		http.NotFound(w, r)
+		return
+	}
+	h.l.Printf("session %s detached", id)
+	json.NewEncoder(w).Encode(sessionResponse{Sessions: h.sessions.Len()})
+}
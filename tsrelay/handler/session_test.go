@@ -0,0 +1,99 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tailscale-dev/vscode-tailscale/tsrelay/logger"
+)
+
+func TestSessions(t *testing.T) {
+	sessions := NewSessions()
+	srv := httptest.NewServer(NewHandler(Config{
+		Ports:    NewPortLister(),
+		Procs:    NewProcessTable(),
+		Nonce:    "123",
+		Logger:   logger.Nop,
+		Sessions: sessions,
+		Forwards: NewForwards(logger.Nop),
+	}))
+	t.Cleanup(srv.Close)
+
+	do := func(method, path string, wantCode int) sessionResponse {
+		t.Helper()
+		req, err := http.NewRequest(method, srv.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.SetBasicAuth("123", "")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != wantCode {
+			t.Fatalf("%s %s: expected status %d but got %d", method, path, wantCode, resp.StatusCode)
+		}
+		var sr sessionResponse
+		if wantCode == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(&sr); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return sr
+	}
+
+	first := do(http.MethodPost, "/sessions", http.StatusOK)
+	second := do(http.MethodPost, "/sessions", http.StatusOK)
+	if first.ID == "" || first.ID == second.ID {
+		t.Fatalf("expected distinct session ids but got %q and %q", first.ID, second.ID)
+	}
+	if second.Sessions != 2 {
+		t.Fatalf("expected 2 sessions but got %d", second.Sessions)
+	}
+
+	do(http.MethodDelete, "/sessions/"+first.ID, http.StatusOK)
+	do(http.MethodDelete, "/sessions/"+first.ID, http.StatusNotFound)
+	select {
+	case <-sessions.Idle():
+		t.Fatal("expected sessions to be in use")
+	default:
+	}
+
+	do(http.MethodDelete, "/sessions/"+second.ID, http.StatusOK)
+	select {
+	case <-sessions.Idle():
+	default:
+		t.Fatal("expected sessions to be idle")
+	}
+}
+
+func TestSessionsExpire(t *testing.T) {
+	sessions := NewSessions()
+	own := sessions.Acquire()
+	attached := sessions.acquire(true)
+	crashed := sessions.acquire(true)
+	for _, id := range []string{own, attached, crashed} {
+		l, _ := sessions.get(id)
+		l.lastSeen = time.Now().Add(-time.Hour)
+	}
+	sessions.renew(attached)
+
+	expired := sessions.Expire(time.Minute)
+	if len(expired) != 1 || expired[0] != crashed {
+		t.Fatalf("expected only the session that stopped polling to expire but got %v", expired)
+	}
+	if sessions.Len() != 2 {
+		t.Fatalf("expected 2 sessions but got %d", sessions.Len())
+	}
+	sessions.Release(own)
+	sessions.Expire(0)
+	select {
+	case <-sessions.Idle():
+	default:
+		t.Fatal("expected sessions to be idle once every polled session expired")
+	}
+}
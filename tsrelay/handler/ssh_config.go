@@ -0,0 +1,387 @@
+package handler
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+)
+
+const (
+	// sshIncludeFile and sshKnownHostsFile are the files managed
+	// next to the ssh config, which includes the former.
+	sshIncludeFile    = "tailscale_config"
+	sshKnownHostsFile = "tailscale_known_hosts"
+	// sshBlockBegin and sshBlockEnd delimit the block
+	// of the ssh config that includes sshIncludeFile.
+	sshBlockBegin = "# BEGIN Tailscale (managed by the Tailscale extension)"
+	sshBlockEnd   = "# END Tailscale"
+)
+
+// sshHostKeys are the host keys of a peer that runs Tailscale SSH.
+type sshHostKeys struct {
+	ID         tailcfg.StableNodeID
+	ServerName string
+	DNSName    string
+	// KnownHosts are the lines of a known_hosts file for
+	// the DNS name and the Tailscale IPs of the peer.
+	KnownHosts []string
+}
+
+type getKnownHostsResponse struct {
+	Peers []sshHostKeys
+}
+
+// setSSHConfigRequest writes the managed ssh config.
+type setSSHConfigRequest struct {
+	// ConfigFile is the ssh config to include the
+	// managed config from, ~/.ssh/config by default.
+	ConfigFile string
+	// Users are the users to log in as by peer ID. Peers
+	// without one use the User of the ssh config, if any.
+	Users map[tailcfg.StableNodeID]string
+}
+
+type sshConfigResponse struct {
+	ConfigFile     string
+	IncludeFile    string
+	KnownHostsFile string
+	// Hosts is the number of peers in the managed config.
+	Hosts int
+	// Changed reports whether any file was written.
+	Changed bool
+}
+
+func (h *handler) getKnownHostsHandler(w http.ResponseWriter, r *http.Request) {
+	resp, err := h.getKnownHosts(r.Context(), tailcfg.StableNodeID(r.URL.Query().Get("id")))
+	if err != nil {
+		var re RelayError
+		if errors.As(err, &re) {
+			w.WriteHeader(re.statusCode)
+			json.NewEncoder(w).Encode(re)
+			return
+		}
+		h.l.Println("error getting host keys:", err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (h *handler) setSSHConfigHandler(w http.ResponseWriter, r *http.Request) {
+	var req setSSHConfigRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("error decoding request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	resp, err := h.setSSHConfig(r.Context(), req)
+	if err != nil {
+		var re RelayError
+		if errors.As(err, &re) {
+			w.WriteHeader(re.statusCode)
+			json.NewEncoder(w).Encode(re)
+			return
+		}
+		h.l.Println("error writing ssh config:", err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (h *handler) deleteSSHConfigHandler(w http.ResponseWriter, r *http.Request) {
+	resp, err := removeSSHConfig(r.URL.Query().Get("configFile"))
+	if err != nil {
+		var re RelayError
+		if errors.As(err, &re) {
+			w.WriteHeader(re.statusCode)
+			json.NewEncoder(w).Encode(re)
+			return
+		}
+		h.l.Println("error removing ssh config:", err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// getKnownHosts returns the host keys of the peers that run
+// Tailscale SSH, or only those of the peer with the given id.
+func (h *handler) getKnownHosts(ctx context.Context, id tailcfg.StableNodeID) (*getKnownHostsResponse, error) {
+	st, err := h.lc.Status(ctx)
+	if err != nil {
+		return nil, tailscaledError(err)
+	}
+	peers := sshPeers(st)
+	if id != "" {
+		peers = slices.DeleteFunc(peers, func(p *ipnstate.PeerStatus) bool { return p.ID != id })
+		if len(peers) == 0 {
+			return nil, RelayError{
+				statusCode: http.StatusNotFound,
+				Errors:     []Error{{Type: PeerNotFound}},
+			}
+		}
+	}
+	resp := &getKnownHostsResponse{Peers: []sshHostKeys{}}
+	for _, p := range peers {
+		resp.Peers = append(resp.Peers, sshHostKeys{
+			ID:         p.ID,
+			ServerName: strings.Split(p.DNSName, ".")[0],
+			DNSName:    strings.TrimSuffix(p.DNSName, "."),
+			KnownHosts: knownHosts(p),
+		})
+	}
+	return resp, nil
+}
+
+// setSSHConfig writes the host keys of the peers to the managed
+// known_hosts file, a Host entry per peer to the managed include
+// file and includes it from the ssh config. Files are replaced
+// atomically and only if their content changed.
+func (h *handler) setSSHConfig(ctx context.Context, req setSSHConfigRequest) (*sshConfigResponse, error) {
+	resp, err := newSSHConfigResponse(req.ConfigFile)
+	if err != nil {
+		return nil, err
+	}
+	st, err := h.lc.Status(ctx)
+	if err != nil {
+		return nil, tailscaledError(err)
+	}
+	peers := sshPeers(st)
+	resp.Hosts = len(peers)
+
+	var kh bytes.Buffer
+	for _, p := range peers {
+		for _, line := range knownHosts(p) {
+			kh.WriteString(line + "\n")
+		}
+	}
+	include := sshIncludeConfig(peers, req.Users, resp.KnownHostsFile)
+
+	cur, err := os.ReadFile(resp.ConfigFile)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("error reading ssh config: %w", err)
+	}
+	block := fmt.Sprintf("%s\nInclude %s\n%s\n", sshBlockBegin, quoteSSH(resp.IncludeFile), sshBlockEnd)
+	config := withSSHBlock(cur, block)
+
+	// the config is written last so that it
+	// never includes a file that doesn't exist.
+	for _, f := range []struct {
+		path string
+		data []byte
+	}{
+		{resp.KnownHostsFile, kh.Bytes()},
+		{resp.IncludeFile, include},
+		{resp.ConfigFile, config},
+	} {
+		changed, err := writeFileIfChanged(f.path, f.data)
+		if err != nil {
+			return nil, err
+		}
+		resp.Changed = resp.Changed || changed
+	}
+	return resp, nil
+}
+
+// removeSSHConfig removes the managed block from the
+// ssh config and deletes the files it included.
+func removeSSHConfig(configFile string) (*sshConfigResponse, error) {
+	resp, err := newSSHConfigResponse(configFile)
+	if err != nil {
+		return nil, err
+	}
+	cur, err := os.ReadFile(resp.ConfigFile)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("error reading ssh config: %w", err)
+	}
+	if err == nil {
+		changed, err := writeFileIfChanged(resp.ConfigFile, withSSHBlock(cur, ""))
+		if err != nil {
+			return nil, err
+		}
+		resp.Changed = changed
+	}
+	for _, f := range []string{resp.IncludeFile, resp.KnownHostsFile} {
+		err := os.Remove(f)
+		if err == nil {
+			resp.Changed = true
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+// newSSHConfigResponse returns the paths of the ssh config and the
+// managed files next to it. configFile defaults to ~/.ssh/config.
+func newSSHConfigResponse(configFile string) (*sshConfigResponse, error) {
+	if configFile == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("error finding the ssh config: %w", err)
+		}
+		configFile = filepath.Join(home, ".ssh", "config")
+	}
+	if !filepath.IsAbs(configFile) {
+		return nil, RelayError{
+			statusCode: http.StatusBadRequest,
+			Errors:     []Error{{Type: InvalidSSHConfigPath}},
+		}
+	}
+	dir := filepath.Dir(configFile)
+	return &sshConfigResponse{
+		ConfigFile:     configFile,
+		IncludeFile:    filepath.Join(dir, sshIncludeFile),
+		KnownHostsFile: filepath.Join(dir, sshKnownHostsFile),
+	}, nil
+}
+
+// sshPeers returns the peers with SSH host keys by DNS name.
+func sshPeers(st *ipnstate.Status) []*ipnstate.PeerStatus {
+	var peers []*ipnstate.PeerStatus
+	for _, p := range st.Peer {
+		if len(p.SSH_HostKeys) > 0 && p.DNSName != "" {
+			peers = append(peers, p)
+		}
+	}
+	slices.SortFunc(peers, func(a, b *ipnstate.PeerStatus) int {
+		return cmp.Or(cmp.Compare(a.DNSName, b.DNSName), cmp.Compare(a.ID, b.ID))
+	})
+	return peers
+}
+
+// knownHosts returns the known_hosts lines of a peer, the same
+// ones the tailscale CLI uses for "tailscale ssh". Keys are
+// listed under the DNS name, which is also the HostKeyAlias of
+// the managed config, and the Tailscale IPs.
+func knownHosts(p *ipnstate.PeerStatus) []string {
+	names := []string{strings.TrimSuffix(p.DNSName, ".")}
+	for _, ip := range p.TailscaleIPs {
+		names = append(names, ip.String())
+	}
+	var lines []string
+	for _, hk := range p.SSH_HostKeys {
+		hk = strings.TrimSpace(hk)
+		if hk == "" || strings.ContainsAny(hk, "\n\r") {
+			continue
+		}
+		lines = append(lines, strings.Join(names, ",")+" "+hk)
+	}
+	return lines
+}
+
+// sshIncludeConfig returns a Host entry per peer. Peers are known by
+// their DNS name and by their short name unless another peer shares
+// it. Host keys are pinned through the managed known_hosts file.
+func sshIncludeConfig(peers []*ipnstate.PeerStatus, users map[tailcfg.StableNodeID]string, knownHostsFile string) []byte {
+	short := make(map[string]int)
+	for _, p := range peers {
+		short[strings.Split(p.DNSName, ".")[0]]++
+	}
+	var b bytes.Buffer
+	b.WriteString("# Generated by the Tailscale extension, changes are overwritten.\n")
+	for _, p := range peers {
+		dnsName := strings.TrimSuffix(p.DNSName, ".")
+		aliases := []string{dnsName}
+		if name := strings.Split(dnsName, ".")[0]; short[name] == 1 && name != dnsName {
+			aliases = []string{name, dnsName}
+		}
+		fmt.Fprintf(&b, "\nHost %s\n", strings.Join(aliases, " "))
+		fmt.Fprintf(&b, "  HostName %s\n", dnsName)
+		if u := users[p.ID]; u != "" && !strings.ContainsAny(u, " \t\r\n\"") {
+			fmt.Fprintf(&b, "  User %s\n", u)
+		}
+		fmt.Fprintf(&b, "  HostKeyAlias %s\n", dnsName)
+		fmt.Fprintf(&b, "  UserKnownHostsFile %s\n", quoteSSH(knownHostsFile))
+	}
+	return b.Bytes()
+}
+
+// withSSHBlock replaces the managed block of the ssh config with
+// block, or removes it if block is empty. A new block goes first,
+// as ssh uses the first value it finds for a setting and Include
+// only applies to all hosts outside of Host and Match sections.
+func withSSHBlock(config []byte, block string) []byte {
+	before, rest, found := bytes.Cut(config, []byte(sshBlockBegin))
+	if found {
+		_, after, ok := bytes.Cut(rest, []byte(sshBlockEnd))
+		if !ok {
+			// an unterminated block extends to the end
+			after = nil
+		}
+		after = bytes.TrimPrefix(bytes.TrimPrefix(after, []byte("\r")), []byte("\n"))
+		if block == "" && len(before) == 0 {
+			// drop the blank line a new block is added with
+			after = bytes.TrimPrefix(after, []byte("\n"))
+		}
+		return slices.Concat(before, []byte(block), after)
+	}
+	if block == "" {
+		return config
+	}
+	if len(config) == 0 {
+		return []byte(block)
+	}
+	return slices.Concat([]byte(block+"\n"), config)
+}
+
+// quoteSSH quotes paths with spaces for the ssh config.
+func quoteSSH(s string) string {
+	if strings.ContainsAny(s, " \t") {
+		return `"` + s + `"`
+	}
+	return s
+}
+
+// writeFileIfChanged atomically replaces the file at path with data
+// unless it has that content already. Symlinks are followed so that
+// configs kept elsewhere stay linked, and the mode of an existing
+// file is kept. It reports whether the file was written.
+func writeFileIfChanged(path string, data []byte) (bool, error) {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	mode := fs.FileMode(0o600)
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode().Perm()
+		if cur, err := os.ReadFile(path); err == nil && bytes.Equal(cur, data) {
+			return false, nil
+		}
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return false, fmt.Errorf("error creating %s: %w", dir, err)
+	}
+	f, err := os.CreateTemp(dir, ".tsrelay-*")
+	if err != nil {
+		return false, fmt.Errorf("error writing %s: %w", path, err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return false, fmt.Errorf("error writing %s: %w", path, err)
+	}
+	if err := f.Chmod(mode); err != nil {
+		f.Close()
+		return false, fmt.Errorf("error writing %s: %w", path, err)
+	}
+	if err := f.Close(); err != nil {
+		return false, fmt.Errorf("error writing %s: %w", path, err)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return false, fmt.Errorf("error writing %s: %w", path, err)
+	}
+	return true, nil
+}
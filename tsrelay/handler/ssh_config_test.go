@@ -0,0 +1,124 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSSHConfig(t *testing.T) {
+	_, relay := newTestRelay(t, `{
+		"Status": {
+			"BackendState": "Running",
+			"Peer": {
+				"nodekey:0000000000000000000000000000000000000000000000000000000000000001": {
+					"ID": "nDev", "DNSName": "dev.example.ts.net.", "TailscaleIPs": ["100.64.0.1", "fd7a:115c:a1e0::1"],
+					"sshHostKeys": ["ssh-ed25519 AAAAdev", "bad\nkey"]
+				},
+				"nodekey:0000000000000000000000000000000000000000000000000000000000000002": {
+					"ID": "nProd", "DNSName": "prod.example.ts.net.", "TailscaleIPs": ["100.64.0.2"],
+					"sshHostKeys": ["ssh-ed25519 AAAAprod"]
+				},
+				"nodekey:0000000000000000000000000000000000000000000000000000000000000003": {
+					"ID": "nNoSSH", "DNSName": "nossh.example.ts.net.", "TailscaleIPs": ["100.64.0.3"]
+				}
+			}
+		}
+	}`)
+
+	var kh getKnownHostsResponse
+	if err := json.NewDecoder(relay.do(http.MethodGet, "/ssh/known-hosts?id=nDev", "").Body).Decode(&kh); err != nil {
+		t.Fatal(err)
+	}
+	if len(kh.Peers) != 1 || len(kh.Peers[0].KnownHosts) != 1 ||
+		kh.Peers[0].KnownHosts[0] != "dev.example.ts.net,100.64.0.1,fd7a:115c:a1e0::1 ssh-ed25519 AAAAdev" {
+		t.Fatalf("unexpected host keys %+v", kh)
+	}
+	if resp := relay.do(http.MethodGet, "/ssh/known-hosts?id=nNoSSH", ""); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected a peer without host keys not to be found but got %d", resp.StatusCode)
+	}
+
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config")
+	os.WriteFile(configFile, []byte("Host example\n  User me\n"), 0o644)
+	body := `{"ConfigFile": "` + configFile + `", "Users": {"nProd": "deploy"}}`
+	set := func() sshConfigResponse {
+		var s sshConfigResponse
+		if err := json.NewDecoder(relay.do(http.MethodPut, "/ssh/config", body).Body).Decode(&s); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	s := set()
+	if !s.Changed || s.Hosts != 2 {
+		t.Fatalf("unexpected response %+v", s)
+	}
+	config, _ := os.ReadFile(configFile)
+	wantConfig := sshBlockBegin + "\nInclude " + s.IncludeFile + "\n" + sshBlockEnd + "\n\nHost example\n  User me\n"
+	if string(config) != wantConfig {
+		t.Fatalf("unexpected ssh config:\n%s", config)
+	}
+	if fi, _ := os.Stat(configFile); fi.Mode().Perm() != 0o644 {
+		t.Fatalf("expected the mode of the ssh config to be kept but got %v", fi.Mode())
+	}
+	include, _ := os.ReadFile(s.IncludeFile)
+	for _, want := range []string{
+		"Host dev dev.example.ts.net\n  HostName dev.example.ts.net\n  HostKeyAlias dev.example.ts.net\n",
+		"Host prod prod.example.ts.net\n  HostName prod.example.ts.net\n  User deploy\n",
+		"UserKnownHostsFile " + s.KnownHostsFile,
+	} {
+		if !strings.Contains(string(include), want) {
+			t.Fatalf("expected %q in the managed config:\n%s", want, include)
+		}
+	}
+	if strings.Contains(string(include), "nossh") {
+		t.Fatalf("expected peers without host keys to be left out:\n%s", include)
+	}
+	known, _ := os.ReadFile(s.KnownHostsFile)
+	if strings.Count(string(known), "\n") != 2 {
+		t.Fatalf("unexpected known hosts:\n%s", known)
+	}
+
+	if s := set(); s.Changed {
+		t.Fatal("expected writing the same config again not to change anything")
+	}
+	if config, _ := os.ReadFile(configFile); string(config) != wantConfig {
+		t.Fatalf("expected the block not to be added twice:\n%s", config)
+	}
+
+	var d sshConfigResponse
+	json.NewDecoder(relay.do(http.MethodDelete, "/ssh/config?configFile="+configFile, "").Body).Decode(&d)
+	if !d.Changed {
+		t.Fatal("expected removing the config to change it")
+	}
+	if config, _ := os.ReadFile(configFile); string(config) != "Host example\n  User me\n" {
+		t.Fatalf("expected the block to be removed:\n%q", config)
+	}
+	if _, err := os.Stat(s.IncludeFile); !os.IsNotExist(err) {
+		t.Fatal("expected the managed config to be removed")
+	}
+
+	if resp := relay.do(http.MethodPut, "/ssh/config", `{"ConfigFile": "relative/config"}`); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected a relative path to be rejected but got %d", resp.StatusCode)
+	}
+}
+
+func TestWithSSHBlock(t *testing.T) {
+	block := sshBlockBegin + "\nInclude x\n" + sshBlockEnd + "\n"
+	for _, tt := range []struct {
+		name, config, block, want string
+	}{
+		{"empty", "", block, block},
+		{"prepend", "Host a\n", block, block + "\nHost a\n"},
+		{"replace", "# top\n" + sshBlockBegin + "\nInclude old\n" + sshBlockEnd + "\nHost a\n", block, "# top\n" + block + "Host a\n"},
+		{"remove", sshBlockBegin + "\nInclude old\n" + sshBlockEnd + "\n\nHost a\n", "", "Host a\n"},
+		{"remove missing", "Host a\n", "", "Host a\n"},
+	} {
+		if got := string(withSSHBlock([]byte(tt.config), tt.block)); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
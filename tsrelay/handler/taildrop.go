@@ -0,0 +1,345 @@
+package handler
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/websocket"
+	"tailscale.com/tailcfg"
+)
+
+// taildropProgressInterval is how often progress
+// is reported while pushing a file.
+const taildropProgressInterval = 250 * time.Millisecond
+
+// taildropMessage is a message sent over the /taildrop/push
+// websocket. "progress" messages are sent while the file is
+// pushed, followed by either a "done" or an "error" message.
+type taildropMessage struct {
+	Type string `json:"type"`
+	// Name is the name of the file the peer receives.
+	Name string `json:"name"`
+	// Sent and Size are the bytes sent so far and the total
+	// size, which is -1 for directories as they are sent as
+	// tar archives that are built on the fly.
+	Sent    int64  `json:"sent"`
+	Size    int64  `json:"size"`
+	Message string `json:"message,omitempty"`
+}
+
+// waitingFile is a file received with Taildrop.
+type waitingFile struct {
+	Name string
+	Size int64
+}
+
+func (h *handler) getFileTargetsHandler(w http.ResponseWriter, r *http.Request) {
+	targets, err := h.getFileTargets(r.Context())
+	if err != nil {
+		var re RelayError
+		if errors.As(err, &re) {
+			w.WriteHeader(re.statusCode)
+			json.NewEncoder(w).Encode(re)
+			return
+		}
+		h.l.Println("error getting file targets:", err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	json.NewEncoder(w).Encode(targets)
+}
+
+// getFileTargets returns the peers that can receive files.
+func (h *handler) getFileTargets(ctx context.Context) ([]*peerStatus, error) {
+	targets, err := h.lc.FileTargets(ctx)
+	if err != nil {
+		return nil, tailscaledError(err)
+	}
+	st, err := h.lc.StatusWithoutPeers(ctx)
+	if err != nil {
+		return nil, tailscaledError(err)
+	}
+	var suffix string
+	if st.CurrentTailnet != nil {
+		suffix = st.CurrentTailnet.MagicDNSSuffix
+	}
+	peers := []*peerStatus{}
+	for _, t := range targets {
+		if t.Node != nil {
+			peers = append(peers, peerStatusOfNode(t.Node.View(), suffix))
+		}
+	}
+	return peers, nil
+}
+
+// pushFileHandler pushes the local file or directory at the
+// given path to the given peer and streams the progress over a
+// websocket. Directories are sent as a tar archive. Closing the
+// websocket cancels the push.
+func (h *handler) pushFileHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	peer := tailcfg.StableNodeID(q.Get("peer"))
+	path := q.Get("path")
+	if !filepath.IsAbs(path) {
+		http.Error(w, "path must be absolute", http.StatusBadRequest)
+		return
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := h.checkFileTarget(r.Context(), peer); err != nil {
+		var re RelayError
+		if errors.As(err, &re) {
+			w.WriteHeader(re.statusCode)
+			json.NewEncoder(w).Encode(re)
+			return
+		}
+		h.l.Println("error checking file target:", err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	c, err := h.u.Upgrade(w, r, nil)
+	if err != nil {
+		h.l.Printf("error upgrading to websocket: %v", err)
+		return
+	}
+	defer c.Close()
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	msg := taildropMessage{Type: "done", Name: filepath.Base(path), Size: fi.Size()}
+	if fi.IsDir() {
+		msg.Name += ".tar"
+		msg.Size = -1
+	}
+	sent, err := h.pushFile(ctx, c, peer, path, fi.IsDir(), msg)
+	msg.Sent = sent
+	if err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		h.l.Printf("error pushing %s: %v", path, err)
+		msg.Type = "error"
+		msg.Message = err.Error()
+	}
+	c.WriteJSON(msg)
+}
+
+// checkFileTarget returns an error if the peer can't receive files.
+func (h *handler) checkFileTarget(ctx context.Context, peer tailcfg.StableNodeID) error {
+	targets, err := h.lc.FileTargets(ctx)
+	if err != nil {
+		return tailscaledError(err)
+	}
+	for _, t := range targets {
+		if t.Node != nil && t.Node.StableID == peer {
+			return nil
+		}
+	}
+	return RelayError{
+		statusCode: http.StatusConflict,
+		Errors:     []Error{{Type: NoFileTarget}},
+	}
+}
+
+// pushFile sends the file at path to the peer, reporting
+// progress on c. It returns the number of bytes sent.
+func (h *handler) pushFile(ctx context.Context, c *websocket.Conn, peer tailcfg.StableNodeID, path string, dir bool, msg taildropMessage) (int64, error) {
+	var src io.Reader
+	if dir {
+		pr, pw := io.Pipe()
+		go func() {
+			pw.CloseWithError(writeTar(pw, path))
+		}()
+		defer pr.Close()
+		src = pr
+	} else {
+		f, err := os.Open(path)
+		if err != nil {
+			return 0, err
+		}
+		defer f.Close()
+		src = f
+	}
+
+	cr := &countingReader{r: src}
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		t := time.NewTicker(taildropProgressInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+				msg.Type = "progress"
+				msg.Sent = cr.n.Load()
+				if err := c.WriteJSON(msg); err != nil {
+					return
+				}
+			}
+		}
+	}()
+	err := h.lc.PushFile(ctx, peer, msg.Size, msg.Name, cr)
+	close(done)
+	<-stopped
+	return cr.n.Load(), err
+}
+
+// writeTar writes the directory at root as a tar archive
+// whose entries are relative to the parent of root.
+func writeTar(w io.Writer, root string) error {
+	tw := tar.NewWriter(w)
+	parent := filepath.Dir(root)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() && !fi.IsDir() {
+			// skip symlinks, sockets and the like
+			return nil
+		}
+		hdr, err := tar.FileInfoHeader(fi, "")
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(parent, path)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if fi.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+type countingReader struct {
+	r io.Reader
+	n atomic.Int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n.Add(int64(n))
+	return n, err
+}
+
+func (h *handler) getWaitingFilesHandler(w http.ResponseWriter, r *http.Request) {
+	files, err := h.lc.WaitingFiles(r.Context())
+	if err != nil {
+		err = tailscaledError(err)
+		var re RelayError
+		if errors.As(err, &re) {
+			w.WriteHeader(re.statusCode)
+			json.NewEncoder(w).Encode(re)
+			return
+		}
+		h.l.Println("error getting waiting files:", err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	resp := []waitingFile{}
+	for _, f := range files {
+		resp = append(resp, waitingFile{Name: f.Name, Size: f.Size})
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// getWaitingFileHandler streams the content of a received file.
+func (h *handler) getWaitingFileHandler(w http.ResponseWriter, r *http.Request) {
+	rc, size, err := h.lc.GetWaitingFile(r.Context(), chi.URLParam(r, "name"))
+	if err != nil {
+		err = waitingFileError(err)
+		var re RelayError
+		if errors.As(err, &re) {
+			w.WriteHeader(re.statusCode)
+			json.NewEncoder(w).Encode(re)
+			return
+		}
+		h.l.Println("error getting waiting file:", err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rc.Close()
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	if _, err := io.Copy(w, rc); err != nil {
+		h.l.Println("error copying waiting file:", err)
+	}
+}
+
+func (h *handler) deleteWaitingFileHandler(w http.ResponseWriter, r *http.Request) {
+	if err := h.lc.DeleteWaitingFile(r.Context(), chi.URLParam(r, "name")); err != nil {
+		err = waitingFileError(err)
+		var re RelayError
+		if errors.As(err, &re) {
+			w.WriteHeader(re.statusCode)
+			json.NewEncoder(w).Encode(re)
+			return
+		}
+		h.l.Println("error deleting waiting file:", err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// waitingFileError maps a missing received file to a RelayError
+// and other errors like tailscaledError. tailscaled reports missing
+// files with status 500 and the text of fs.ErrNotExist.
+func waitingFileError(err error) error {
+	if errors.Is(err, fs.ErrNotExist) || strings.Contains(err.Error(), fs.ErrNotExist.Error()) {
+		return RelayError{
+			statusCode: http.StatusNotFound,
+			Errors:     []Error{{Type: FileNotFound}},
+		}
+	}
+	return tailscaledError(err)
+}
@@ -0,0 +1,139 @@
+package handler
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const taildropProfile = `{
+	"Status": {
+		"BackendState": "Running",
+		"CurrentTailnet": {"MagicDNSSuffix": "example.ts.net"},
+		"Self": {"ID": "nSelf", "UserID": 1, "Online": true},
+		"Peer": {
+			"nodekey:0000000000000000000000000000000000000000000000000000000000000001": {
+				"ID": "nLaptop", "DNSName": "laptop.example.ts.net.", "UserID": 1, "Online": true, "TailscaleIPs": ["100.64.0.1"]
+			},
+			"nodekey:0000000000000000000000000000000000000000000000000000000000000002": {
+				"ID": "nServer", "DNSName": "server.example.ts.net.", "UserID": 1, "Online": true, "Tags": ["tag:prod"]
+			},
+			"nodekey:0000000000000000000000000000000000000000000000000000000000000003": {
+				"ID": "nFriend", "DNSName": "friend.example.ts.net.", "UserID": 2, "Online": true
+			}
+		}
+	},
+	"WaitingFiles": [{"Name": "build.log", "Content": "ok"}]
+}`
+
+func TestTaildrop(t *testing.T) {
+	m, relay := newTestRelay(t, taildropProfile)
+
+	var targets []*peerStatus
+	json.NewDecoder(relay.do(http.MethodGet, "/taildrop/targets", "").Body).Decode(&targets)
+	if len(targets) != 1 || targets[0].ID != "nLaptop" || targets[0].ServerName != "laptop" {
+		t.Fatalf("expected only the own untagged peer to be a target but got %+v", targets)
+	}
+
+	push := func(peer, path string) (taildropMessage, *http.Response) {
+		q := url.Values{"peer": {peer}, "path": {path}}
+		conn, resp, err := relay.dial("/taildrop/push?" + q.Encode())
+		if err != nil {
+			return taildropMessage{}, resp
+		}
+		defer conn.Close()
+		for {
+			var msg taildropMessage
+			if err := conn.ReadJSON(&msg); err != nil {
+				t.Fatal(err)
+			}
+			if msg.Type != "progress" {
+				return msg, resp
+			}
+		}
+	}
+
+	dir := t.TempDir()
+	logs := filepath.Join(dir, "logs")
+	if err := os.MkdirAll(logs, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(logs, "app.log"), []byte("hello"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, _ := push("nLaptop", filepath.Join(logs, "app.log"))
+	if msg.Type != "done" || msg.Name != "app.log" || msg.Sent != 5 || msg.Size != 5 {
+		t.Fatalf("unexpected result %+v", msg)
+	}
+	msg, _ = push("nLaptop", logs)
+	if msg.Type != "done" || msg.Name != "logs.tar" {
+		t.Fatalf("unexpected result %+v", msg)
+	}
+	m.Lock()
+	pushed := m.pushed
+	m.Unlock()
+	if len(pushed) != 2 || string(pushed[0].Data) != "hello" {
+		t.Fatalf("unexpected pushes %+v", pushed)
+	}
+	tr := tar.NewReader(bytes.NewReader(pushed[1].Data))
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+	}
+	if strings.Join(names, ",") != "logs/,logs/app.log" {
+		t.Fatalf("unexpected archive entries %v", names)
+	}
+	if _, resp := push("nFriend", logs); resp == nil || resp.StatusCode != http.StatusConflict {
+		t.Fatalf("expected peers that can't receive files to be rejected but got %v", resp)
+	}
+
+	var files []waitingFile
+	json.NewDecoder(relay.do(http.MethodGet, "/taildrop/files", "").Body).Decode(&files)
+	if len(files) != 1 || files[0].Name != "build.log" || files[0].Size != 2 {
+		t.Fatalf("unexpected waiting files %+v", files)
+	}
+	content, _ := io.ReadAll(relay.do(http.MethodGet, "/taildrop/files/build.log", "").Body)
+	if string(content) != "ok" {
+		t.Fatalf("unexpected content %q", content)
+	}
+	if resp := relay.do(http.MethodDelete, "/taildrop/files/build.log", ""); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("unexpected status %d", resp.StatusCode)
+	}
+	resp := relay.do(http.MethodGet, "/taildrop/files/build.log", "")
+	var re RelayError
+	json.NewDecoder(resp.Body).Decode(&re)
+	if resp.StatusCode != http.StatusNotFound || len(re.Errors) != 1 || re.Errors[0].Type != FileNotFound {
+		t.Fatalf("expected the file to be deleted but got %d %+v", resp.StatusCode, re)
+	}
+}
+
+func TestWaitingFileTailscaledNotRunning(t *testing.T) {
+	_, relay := newTestRelay(t, `{
+		"Status": {"BackendState": "Running", "Self": {"ID": "nSelf", "Online": true}},
+		"WaitingFiles": [{"Name": "build.log", "Content": "ok"}],
+		"Faults": {"GetWaitingFile": {"Error": "offline"}, "DeleteWaitingFile": {"Error": "offline"}}
+	}`)
+	for _, method := range []string{http.MethodGet, http.MethodDelete} {
+		resp := relay.do(method, "/taildrop/files/build.log", "")
+		var re RelayError
+		json.NewDecoder(resp.Body).Decode(&re)
+		if resp.StatusCode != http.StatusServiceUnavailable || len(re.Errors) != 1 || re.Errors[0].Type != NotRunning {
+			t.Fatalf("%s: expected tailscaled not running but got %d %+v", method, resp.StatusCode, re)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/client/local"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/tailcfg"
+)
+
+const (
+	// whoisCacheTTL is how long lookups are reused. The UI annotates
+	// the same few addresses over and over while requests come in.
+	whoisCacheTTL = 10 * time.Second
+	// maxWhoisAddrs limits the addresses of a batch lookup.
+	maxWhoisAddrs = 256
+)
+
+// whoisResponse is the user and the device behind a tailnet address.
+type whoisResponse struct {
+	Addr          string
+	NodeID        tailcfg.StableNodeID `json:",omitempty"`
+	NodeName      string               `json:",omitempty"`
+	DNSName       string               `json:",omitempty"`
+	Tags          []string             `json:",omitempty"`
+	LoginName     string               `json:",omitempty"`
+	DisplayName   string               `json:",omitempty"`
+	ProfilePicURL string               `json:",omitempty"`
+	// Capabilities are the peer capabilities that
+	// the packet filter grants to the device.
+	Capabilities []tailcfg.PeerCapability `json:",omitempty"`
+	// Errors has PeerNotFound for addresses outside the tailnet
+	// in batch lookups, which don't fail for a single address.
+	Errors []Error `json:",omitempty"`
+}
+
+type whoisRequest struct {
+	Addrs []string
+}
+
+type whoisBatchResponse struct {
+	// Results are in the order of the requested addresses.
+	Results []whoisResponse
+}
+
+func (h *handler) whoisHandler(w http.ResponseWriter, r *http.Request) {
+	addr := r.URL.Query().Get("addr")
+	if err := validWhoisAddr(addr); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	res, err := h.whois(r.Context(), addr)
+	if err != nil {
+		var re RelayError
+		if errors.As(err, &re) {
+			w.WriteHeader(re.statusCode)
+			json.NewEncoder(w).Encode(re)
+			return
+		}
+		h.l.Println("error looking up address:", err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
+func (h *handler) whoisBatchHandler(w http.ResponseWriter, r *http.Request) {
+	var req whoisRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("error decoding request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	if len(req.Addrs) > maxWhoisAddrs {
+		http.Error(w, fmt.Sprintf("at most %d addresses can be looked up at once", maxWhoisAddrs), http.StatusBadRequest)
+		return
+	}
+	for _, addr := range req.Addrs {
+		if err := validWhoisAddr(addr); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	resp := whoisBatchResponse{Results: make([]whoisResponse, 0, len(req.Addrs))}
+	for _, addr := range req.Addrs {
+		res, err := h.whois(r.Context(), addr)
+		var re RelayError
+		switch {
+		case errors.As(err, &re) && re.statusCode == http.StatusNotFound:
+			res = &whoisResponse{Addr: addr, Errors: re.Errors}
+		case errors.As(err, &re):
+			w.WriteHeader(re.statusCode)
+			json.NewEncoder(w).Encode(re)
+			return
+		case err != nil:
+			h.l.Println("error looking up addresses:", err)
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		resp.Results = append(resp.Results, *res)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// whois looks up addr, an IP or IP:port, with tailscaled unless it
+// was looked up recently. Addresses outside the tailnet are cached
+// as well, so that annotating local requests doesn't hit tailscaled.
+func (h *handler) whois(ctx context.Context, addr string) (*whoisResponse, error) {
+	if res, err, ok := h.whoisCache.get(addr); ok {
+		return res, err
+	}
+	wr, err := h.lc.WhoIs(ctx, addr)
+	if errors.Is(err, local.ErrPeerNotFound) {
+		err = RelayError{
+			statusCode: http.StatusNotFound,
+			Errors:     []Error{{Type: PeerNotFound}},
+		}
+		h.whoisCache.set(addr, nil, err)
+		return nil, err
+	}
+	if err != nil {
+		return nil, tailscaledError(err)
+	}
+	res := newWhoisResponse(addr, wr)
+	h.whoisCache.set(addr, res, nil)
+	return res, nil
+}
+
+func newWhoisResponse(addr string, wr *apitype.WhoIsResponse) *whoisResponse {
+	res := &whoisResponse{Addr: addr}
+	if n := wr.Node; n != nil {
+		res.NodeID = n.StableID
+		res.NodeName = n.ComputedName
+		res.DNSName = strings.TrimSuffix(n.Name, ".")
+		if res.NodeName == "" {
+			res.NodeName = strings.Split(res.DNSName, ".")[0]
+		}
+		res.Tags = n.Tags
+	}
+	// tagged devices belong to the tags rather than to a user
+	if up := wr.UserProfile; up != nil && len(res.Tags) == 0 {
+		res.LoginName = up.LoginName
+		res.DisplayName = up.DisplayName
+		res.ProfilePicURL = up.ProfilePicURL
+	}
+	for c := range wr.CapMap {
+		res.Capabilities = append(res.Capabilities, c)
+	}
+	slices.Sort(res.Capabilities)
+	return res
+}
+
+// validWhoisAddr checks that addr is an IP or IP:port,
+// the addresses tailscaled can look up.
+func validWhoisAddr(addr string) error {
+	if _, err := netip.ParseAddr(addr); err == nil {
+		return nil
+	}
+	if _, err := netip.ParseAddrPort(addr); err == nil {
+		return nil
+	}
+	return fmt.Errorf("invalid address %q, must be an IP or IP:port", addr)
+}
+
+// whoisCache keeps lookups for whoisCacheTTL.
+// The zero value is ready to use.
+type whoisCache struct {
+	mu      sync.Mutex
+	entries map[string]whoisEntry
+}
+
+type whoisEntry struct {
+	res     *whoisResponse
+	err     error
+	expires time.Time
+}
+
+func (c *whoisCache) get(addr string) (*whoisResponse, error, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[addr]
+	if !ok || time.Now().After(e.expires) {
+		return nil, nil, false
+	}
+	return e.res, e.err, true
+}
+
+func (c *whoisCache) set(addr string, res *whoisResponse, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if c.entries == nil {
+		c.entries = make(map[string]whoisEntry)
+	}
+	// expired entries are dropped as new ones come in
+	for k, e := range c.entries {
+		if now.After(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[addr] = whoisEntry{res: res, err: err, expires: now.Add(whoisCacheTTL)}
+}
@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"testing"
+
+	"tailscale.com/tailcfg"
+)
+
+func TestWhoIs(t *testing.T) {
+	m, relay := newTestRelay(t, `{
+		"Status": {
+			"BackendState": "Running",
+			"Self": {"ID": "nSelf", "DNSName": "laptop.example.ts.net.", "TailscaleIPs": ["100.64.0.10"], "UserID": 1},
+			"Peer": {
+				"nodekey:0000000000000000000000000000000000000000000000000000000000000001": {
+					"ID": "nDev", "DNSName": "dev.example.ts.net.", "TailscaleIPs": ["100.64.0.1"], "UserID": 2
+				},
+				"nodekey:0000000000000000000000000000000000000000000000000000000000000002": {
+					"ID": "nCI", "DNSName": "ci.example.ts.net.", "TailscaleIPs": ["100.64.0.2"], "UserID": 2, "Tags": ["tag:ci"]
+				}
+			},
+			"User": {
+				"1": {"ID": 1, "LoginName": "me@example.com", "DisplayName": "Me"},
+				"2": {"ID": 2, "LoginName": "amelie@example.com", "DisplayName": "Amelie", "ProfilePicURL": "https://example.com/a.png"}
+			}
+		},
+		"PeerCaps": {"nDev": {"example.com/cap/deploy": null, "example.com/cap/admin": null}}
+	}`)
+
+	var w whoisResponse
+	if err := json.NewDecoder(relay.do(http.MethodGet, "/whois?addr=100.64.0.1:51234", "").Body).Decode(&w); err != nil {
+		t.Fatal(err)
+	}
+	want := whoisResponse{
+		Addr:          "100.64.0.1:51234",
+		NodeID:        "nDev",
+		NodeName:      "dev",
+		DNSName:       "dev.example.ts.net",
+		LoginName:     "amelie@example.com",
+		DisplayName:   "Amelie",
+		ProfilePicURL: "https://example.com/a.png",
+		Capabilities:  []tailcfg.PeerCapability{"example.com/cap/admin", "example.com/cap/deploy"},
+	}
+	if w.Addr != want.Addr || w.NodeID != want.NodeID || w.NodeName != want.NodeName || w.DNSName != want.DNSName ||
+		w.LoginName != want.LoginName || w.DisplayName != want.DisplayName || w.ProfilePicURL != want.ProfilePicURL ||
+		!slices.Equal(w.Capabilities, want.Capabilities) {
+		t.Fatalf("got %+v, want %+v", w, want)
+	}
+
+	calls := func() int {
+		m.Lock()
+		defer m.Unlock()
+		return m.calls
+	}
+	before := calls()
+	relay.do(http.MethodGet, "/whois?addr=100.64.0.1:51234", "")
+	if calls() != before {
+		t.Fatal("expected a repeated lookup to be cached")
+	}
+
+	if resp := relay.do(http.MethodGet, "/whois?addr=127.0.0.1", ""); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected an address outside the tailnet not to be found but got %d", resp.StatusCode)
+	}
+	if resp := relay.do(http.MethodGet, "/whois?addr=dev", ""); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected a name to be rejected but got %d", resp.StatusCode)
+	}
+
+	var b whoisBatchResponse
+	err := json.NewDecoder(relay.do(http.MethodPost, "/whois", `{"Addrs": ["100.64.0.10", "100.64.0.2:80", "100.64.0.99"]}`).Body).Decode(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b.Results) != 3 {
+		t.Fatalf("expected three results but got %+v", b)
+	}
+	if r := b.Results[0]; r.NodeID != "nSelf" || r.LoginName != "me@example.com" {
+		t.Fatalf("unexpected self %+v", r)
+	}
+	if r := b.Results[1]; r.NodeID != "nCI" || r.LoginName != "" || !slices.Equal(r.Tags, []string{"tag:ci"}) {
+		t.Fatalf("expected a tagged device without a user but got %+v", r)
+	}
+	if r := b.Results[2]; r.Addr != "100.64.0.99" || len(r.Errors) != 1 || r.Errors[0].Type != PeerNotFound {
+		t.Fatalf("expected an unknown address to be reported but got %+v", r)
+	}
+}
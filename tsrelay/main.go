@@ -15,6 +15,7 @@ import (
 	"os/exec"
 	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/tailscale-dev/vscode-tailscale/tsrelay/handler"
@@ -23,12 +24,17 @@ import (
 )
 
 var (
-	logfile  = flag.String("logfile", "", "send logs to a file instead of stderr")
-	verbose  = flag.Bool("v", false, "verbose logging")
-	port     = flag.Int("port", 0, "port for http server. If 0, one will be chosen")
-	nonce    = flag.String("nonce", "", "nonce for the http server")
-	socket   = flag.String("socket", "", "alternative path for local api socket")
-	mockFile = flag.String("mockfile", "", "a profile file to mock LocalClient responses")
+	logfile    = flag.String("logfile", "", "send logs to a file instead of stderr")
+	verbose    = flag.Bool("v", false, "verbose logging")
+	port       = flag.Int("port", 0, "port for http server. If 0, one will be chosen")
+	nonce      = flag.String("nonce", "", "nonce for the http server")
+	socket     = flag.String("socket", "", "alternative path for local api socket")
+	mockFile   = flag.String("mockfile", "", "a profile file to mock LocalClient responses, open ports and processes")
+	record     = flag.String("record", "", "record LocalClient calls and responses to a profile file that can be replayed with -mockfile")
+	shared     = flag.Bool("shared", false, "attach to a relay shared between editor windows, or start one")
+	docker     = flag.Bool("docker", false, "report ports published by Docker containers")
+	dockerSock = flag.String("docker-socket", "", "path to the Docker Engine socket. Defaults to DOCKER_HOST or the standard locations")
+	proxy      = flag.Bool("proxy", false, "serve an authenticated SOCKS5 and HTTP proxy that reaches the tailnet through tailscaled, for userspace networking")
 )
 
 var requiresRestart bool
@@ -63,12 +69,46 @@ func run() error {
 		lggr.Printf("requires restart: %v", requiresRestart)
 	}
 
-	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
+	if *shared && *mockFile == "" {
+		// a shared relay can outlive the window that started it,
+		// so losing stdout/stderr must not bring it down.
+		signal.Ignore(syscall.SIGPIPE)
+		attached, err := attachRelay(ctx, lggr, discoveryPath(), flagOptions(), *proxy)
+		if attached || err != nil {
+			return err
+		}
+	}
+
 	return runHTTPServer(ctx, lggr, *port, *nonce)
 }
 
+// flagOptions returns the options of a shared relay
+// started with the flags of this process.
+func flagOptions() relayOptions {
+	return relayOptions{
+		Socket:  *socket,
+		Docker:  dockerSocketPath(),
+		Record:  *record,
+		Verbose: *verbose,
+		Logfile: *logfile,
+	}
+}
+
+// dockerSocketPath returns the Docker Engine socket
+// to use, or an empty string if Docker discovery is off.
+func dockerSocketPath() string {
+	if !*docker {
+		return ""
+	}
+	if *dockerSock != "" {
+		return *dockerSock
+	}
+	return handler.DockerSocketPath()
+}
+
 func ensureTailscaledAccessible(lggr logger.Logger, flatpakID string) (bool, error) {
 	_, err := os.Stat("/run/tailscale")
 	if err == nil {
@@ -99,6 +139,11 @@ type serverDetails struct {
 	Address string `json:"address,omitempty"`
 	Nonce   string `json:"nonce,omitempty"`
 	Port    string `json:"port,omitempty"`
+	Session string `json:"session,omitempty"`
+	// Proxy is the address of the SOCKS5 and HTTP proxy to the
+	// tailnet, if enabled. Clients authenticate with the username
+	// "tsrelay" and the nonce as the password.
+	Proxy string `json:"proxy,omitempty"`
 }
 
 func runHTTPServer(ctx context.Context, lggr logger.Logger, port int, nonce string) error {
@@ -118,19 +163,148 @@ func runHTTPServer(ctx context.Context, lggr logger.Logger, port int, nonce stri
 		Port:    u.Port(),
 		Nonce:   nonce,
 	}
-	json.NewEncoder(os.Stdout).Encode(sd)
 	var lc handler.LocalClient = &tailscale.LocalClient{
 		Socket: *socket,
 	}
+	pl, pt := handler.NewPortLister(), handler.NewProcessTable()
 	if *mockFile != "" {
-		lc, err = handler.NewMockClient(*mockFile)
+		var mockPorts handler.PortLister
+		var mockProcs handler.ProcessTable
+		lc, mockPorts, mockProcs, err = handler.NewMockProfile(*mockFile)
 		if err != nil {
 			return fmt.Errorf("error creating mock client: %w", err)
 		}
+		if mockPorts != nil {
+			pl = mockPorts
+		}
+		if mockProcs != nil {
+			pt = mockProcs
+		}
+	}
+	if *record != "" {
+		lggr.Printf("recording LocalClient calls to %s", *record)
+		rc := handler.NewRecordingClient(lc, *record, lggr)
+		defer rc.Close()
+		lc = rc
+	}
+	if *proxy {
+		p, err := handler.NewProxy(lc, nonce, lggr)
+		if err != nil {
+			return err
+		}
+		defer p.Close()
+		sd.Proxy = p.Addr()
 	}
-	h := handler.NewHandler(lc, nonce, lggr, requiresRestart)
+	sessions := handler.NewSessions()
+	serveCtx := ctx
+	if *shared && *mockFile == "" {
+		sd.Session = sessions.Acquire()
+		sharedCtx, err := shareRelay(ctx, lggr, sessions, sd)
+		if err != nil {
+			// another window started at the same time, or the
+			// discovery dir can't be trusted with the relay.
+			lggr.Printf("%v, serving only this window", err)
+			sessions.Release(sd.Session)
+			sd.Session = ""
+		} else {
+			serveCtx = sharedCtx
+		}
+	}
+	json.NewEncoder(os.Stdout).Encode(sd)
+	dockerSocket := dockerSocketPath()
+	if dockerSocket != "" {
+		lggr.Printf("reporting Docker ports from %s", dockerSocket)
+	}
+	forwards := handler.NewForwards(lggr)
+	defer forwards.Close()
+	h := handler.NewHandler(handler.Config{
+		LocalClient:     lc,
+		Ports:           pl,
+		Procs:           pt,
+		Nonce:           nonce,
+		Logger:          lggr,
+		RequiresRestart: requiresRestart,
+		Sessions:        sessions,
+		Forwards:        forwards,
+		DockerSocket:    dockerSocket,
+	})
 	s := &http.Server{Handler: h}
-	return serve(ctx, lggr, l, s, time.Second)
+	return serve(serveCtx, lggr, l, s, time.Second)
+}
+
+const (
+	// sessionPollInterval is how often attached relays poll
+	// their session, which renews its lease.
+	sessionPollInterval = 10 * time.Second
+	// sessionLease is how long a shared relay keeps the session of
+	// an attached relay that stopped polling, such as one that
+	// crashed without releasing it.
+	sessionLease = 3*sessionPollInterval + 5*time.Second
+	// sharedShutdownTimeout bounds how long a shared relay that was
+	// asked to exit keeps serving the windows still attached to it.
+	sharedShutdownTimeout = time.Hour
+)
+
+// shareRelay publishes the relay in the discovery file and returns
+// a context that is done once the window that started the relay has
+// gone away and every other attached window has released its session,
+// or once it is asked to exit a second time or sharedShutdownTimeout
+// after the first. It returns errRelayExists if another window has
+// published its relay first.
+func shareRelay(ctx context.Context, lggr logger.Logger, sessions *handler.Sessions, sd serverDetails) (context.Context, error) {
+	path := discoveryPath()
+	pid := os.Getpid()
+	err := writeDiscovery(ctx, path, &discovery{
+		serverDetails: serverDetails{Address: sd.Address, Nonce: sd.Nonce, Port: sd.Port, Proxy: sd.Proxy},
+		relayOptions:  flagOptions(),
+		PID:           pid,
+	})
+	if err != nil {
+		return nil, err
+	}
+	lggr.Printf("shared relay published at %s", path)
+	serveCtx, cancel := context.WithCancel(context.Background())
+	go func() {
+		ticker := time.NewTicker(sessionPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-serveCtx.Done():
+				return
+			case <-ticker.C:
+				for _, id := range sessions.Expire(sessionLease) {
+					lggr.Printf("session %s expired", id)
+				}
+			}
+		}
+	}()
+	go func() {
+		defer cancel()
+		select {
+		case <-ctx.Done():
+			sessions.Release(sd.Session)
+		case <-sessions.Idle():
+		}
+		if n := sessions.Len(); n > 0 {
+			lggr.Printf("waiting for %d attached sessions", n)
+		}
+		// ctx has stopped taking the signals, so another one
+		// would otherwise be ignored rather than end the relay
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(sig)
+		timeout := time.NewTimer(sharedShutdownTimeout)
+		defer timeout.Stop()
+		select {
+		case <-sessions.Idle():
+		case <-sig:
+			lggr.Printf("exiting with %d attached sessions", sessions.Len())
+		case <-timeout.C:
+			lggr.Printf("exiting with %d attached sessions after %v", sessions.Len(), sharedShutdownTimeout)
+		}
+		removeDiscovery(path, pid)
+	}()
+	return serveCtx, nil
 }
 
 func serve(ctx context.Context, lggr logger.Logger, l net.Listener, s *http.Server, timeout time.Duration) error {